@@ -6,9 +6,19 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/cors"
 	_ "github.com/joho/godotenv/autoload"
+	"luxsuv-backend/config"
+	"luxsuv-backend/dispatch"
+	"luxsuv-backend/fieldcrypt"
+	"luxsuv-backend/geo"
 	"luxsuv-backend/handlers"
+	"luxsuv-backend/jwtkeys"
 	"luxsuv-backend/logger"
+	"luxsuv-backend/pricing"
+	"luxsuv-backend/ratelimit"
+	"luxsuv-backend/realtime"
 	"luxsuv-backend/repository"
+	"luxsuv-backend/retention"
+	"luxsuv-backend/schedule"
 	"net/http"
 	"os"
 	"os/signal"
@@ -17,6 +27,24 @@ import (
 )
 
 func main() {
+	// Subcommands
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "useradd":
+			if err := runUserAdd(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "useradd: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		case "reencrypt":
+			if err := runReencrypt(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "reencrypt: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
 	// Initialize logger
 	logger := logger.NewLogger()
 
@@ -37,9 +65,108 @@ func main() {
 	}
 	defer repo.Close()
 
-	// Set up routers
-	riderRouter := handlers.SetupRiderRouter(repo)
-	driverRouter := handlers.SetupDriverRouter(repo)
+	// Load the keys that encrypt rider PII at rest
+	keyring, err := fieldcrypt.LoadFromEnv()
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to load field encryption keys: %v", err))
+		return
+	}
+	repo.SetKeyring(keyring)
+
+	// Load JWT signing and verification keys
+	keys, err := jwtkeys.LoadFromEnv()
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to load JWT keys: %v", err))
+		return
+	}
+
+	// Load application config
+	cfg, err := config.Load()
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to load config: %v", err))
+		return
+	}
+
+	// Set up rate limiting for public endpoints
+	var limitStore ratelimit.Store = repo
+	if cfg.RateLimit.Backend == "memory" {
+		limitStore = ratelimit.NewMemoryStore()
+	}
+	limiter, err := handlers.NewRouteLimiter(limitStore, cfg.RateLimit)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to configure rate limits: %v", err))
+		return
+	}
+
+	// Set up geocoding of booking addresses
+	var geocoder geo.Geocoder
+	if cfg.Geocoding.Provider == "offline" {
+		offline, err := geo.LoadOffline(cfg.Geocoding.FixtureFile)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Failed to load geocoder: %v", err))
+			return
+		}
+		cacheTTL, _ := time.ParseDuration(cfg.Geocoding.CacheTTL)
+		negativeTTL, _ := time.ParseDuration(cfg.Geocoding.NegativeCacheTTL)
+		geocoder = geo.NewCached(offline, repo, cacheTTL, negativeTTL)
+	}
+
+	// Set up trip distance and duration estimates
+	var router geo.Router
+	if cfg.Routing.Provider == "haversine" {
+		router = geo.NewHaversine(cfg.Routing.DetourFactor, cfg.Routing.AverageSpeedKPH)
+	}
+
+	// Set up driver scheduling
+	scheduler, err := schedule.New(cfg.Scheduling)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to configure scheduling: %v", err))
+		return
+	}
+
+	// Set up fares
+	pricer, err := pricing.New(cfg.Pricing, scheduler)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to configure pricing: %v", err))
+		return
+	}
+
+	// Start background jobs; they stop when the server shuts down
+	jobCtx, stopJobs := context.WithCancel(ctx)
+	defer stopJobs()
+	retentionJob, err := retention.New(repo, cfg.Retention, logger)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to configure retention job: %v", err))
+		return
+	}
+	go retentionJob.Run(jobCtx)
+	if cfg.RateLimit.Backend != "memory" {
+		go deleteIdleRateLimitBuckets(jobCtx, repo, logger)
+	}
+	dispatcher, err := dispatch.New(repo, scheduler, cfg.Dispatch, logger)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to configure dispatch: %v", err))
+		return
+	}
+	go dispatcher.Run(jobCtx)
+	go dispatcher.RunExpiry(jobCtx)
+	events, err := realtime.New(repo, cfg.Realtime, logger)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to configure realtime events: %v", err))
+		return
+	}
+	go events.Run(jobCtx)
+
+	// Set up versioned API router
+	apiV1 := handlers.SetupV1Router(repo, keys, limiter, handlers.Services{
+		Geocoder:  geocoder,
+		Router:    router,
+		Scheduler: scheduler,
+		Dispatch:  dispatcher,
+		Events:    events,
+		Tracking:  cfg.Tracking,
+		Pricing:   pricer,
+	})
 
 	// Mount routers
 	mux := chi.NewRouter()
@@ -48,14 +175,27 @@ func main() {
 	corsHandler := cors.New(cors.Options{
 		AllowedOrigins:   []string{"http://localhost:5173", "https://luxsuv-backend.fly.dev", "*"},
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-Booking-Token", "Last-Event-ID"},
+		ExposedHeaders:   []string{"Deprecation", "Sunset", "Link", "Retry-After", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "RateLimit-Policy"},
 		AllowCredentials: true,
 		MaxAge:           300, // 5 minutes
 	}).Handler
 
 	mux.Use(corsHandler)
-	mux.Handle("/rider/", http.StripPrefix("/rider", riderRouter))
-	mux.Handle("/driver/", http.StripPrefix("/driver", driverRouter))
+	if cfg.RateLimit.TrustFlyClientIP {
+		mux.Use(ratelimit.TrustFlyClientIP)
+	}
+	mux.Mount("/v1", apiV1)
+
+	// Unversioned legacy paths, kept as deprecated aliases of /v1
+	mux.Handle("/rider/*", handlers.DeprecatedAlias("/v1", apiV1))
+	mux.Handle("/driver/*", handlers.DeprecatedAlias("/v1", apiV1))
+
+	// API documentation
+	mux.Get("/openapi.json", handlers.OpenAPIHandler())
+	mux.Get("/docs", handlers.SwaggerUIHandler())
+	mux.Get("/docs/{file}", handlers.SwaggerUIAssetsHandler())
+	mux.Get("/.well-known/jwks.json", handlers.JWKSHandler(keys))
 
 	// Create HTTP server with graceful shutdown
 	server := &http.Server{
@@ -77,6 +217,7 @@ func main() {
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 	logger.Info("Shutting down server...")
+	stopJobs()
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -86,3 +227,21 @@ func main() {
 	}
 	logger.Info("Server stopped")
 }
+
+// deleteIdleRateLimitBuckets hourly drops the rate limit buckets idle for a
+// day, which have fully refilled, so the table stays small. It returns when
+// ctx is done.
+func deleteIdleRateLimitBuckets(ctx context.Context, repo *repository.BookingRepository, log *logger.Logger) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if _, err := repo.DeleteIdleRateLimitBuckets(ctx, 24*time.Hour); err != nil && ctx.Err() == nil {
+			log.Error(fmt.Sprintf("Failed to delete idle rate limit buckets: %v", err))
+		}
+	}
+}
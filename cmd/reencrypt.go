@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"luxsuv-backend/fieldcrypt"
+	"luxsuv-backend/repository"
+	"os"
+)
+
+// runReencrypt implements `luxsuv-backend reencrypt`, which encrypts bookings
+// stored before field encryption was enabled and re-wraps data keys under
+// PII_KEY_ID. Run it after switching PII_KEY_ID to a new key; the old key can
+// be removed from PII_KEYS once it reports no remaining rows.
+func runReencrypt(args []string) error {
+	fs := flag.NewFlagSet("reencrypt", flag.ContinueOnError)
+	batchSize := fs.Int("batch", 500, "number of rows updated per transaction")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *batchSize <= 0 {
+		return fmt.Errorf("-batch must be positive")
+	}
+
+	keyring, err := fieldcrypt.LoadFromEnv()
+	if err != nil {
+		return err
+	}
+	connString := os.Getenv("DATABASE_URL")
+	if connString == "" {
+		return fmt.Errorf("DATABASE_URL environment variable not set")
+	}
+	ctx := context.Background()
+	repo, err := repository.NewBookingRepository(ctx, connString)
+	if err != nil {
+		return fmt.Errorf("failed to initialize repository: %w", err)
+	}
+	defer repo.Close()
+	repo.SetKeyring(keyring)
+
+	n, err := repo.ReencryptBookRides(ctx, *batchSize)
+	fmt.Printf("Re-encrypted %d book rides under key %q\n", n, keyring.PrimaryID())
+	return err
+}
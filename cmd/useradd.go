@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"luxsuv-backend/data"
+	"luxsuv-backend/repository"
+	"os"
+	"strings"
+)
+
+// runUserAdd implements `luxsuv-backend useradd`, used to bootstrap the first
+// admin (or any user) without going through the API. The password is read
+// from -password or, if omitted, from the first line of stdin.
+func runUserAdd(args []string) error {
+	fs := flag.NewFlagSet("useradd", flag.ContinueOnError)
+	username := fs.String("username", "", "username of the new user (required)")
+	password := fs.String("password", "", "password of the new user (read from stdin if empty)")
+	role := fs.String("role", data.RoleAdmin, "role of the new user: admin or driver")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *username == "" {
+		fs.Usage()
+		return fmt.Errorf("-username is required")
+	}
+
+	if *password == "" {
+		fmt.Fprint(os.Stderr, "Password: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return fmt.Errorf("failed to read password: %w", err)
+		}
+		*password = strings.TrimRight(line, "\r\n")
+	}
+	if len(*password) < 8 {
+		return fmt.Errorf("password must be at least 8 characters")
+	}
+
+	connString := os.Getenv("DATABASE_URL")
+	if connString == "" {
+		return fmt.Errorf("DATABASE_URL environment variable not set")
+	}
+	ctx := context.Background()
+	repo, err := repository.NewBookingRepository(ctx, connString)
+	if err != nil {
+		return fmt.Errorf("failed to initialize repository: %w", err)
+	}
+	defer repo.Close()
+
+	user, err := repo.CreateUser(ctx, *username, *password, *role)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Created %s %q with ID %d\n", user.Role, user.Username, user.ID)
+	return nil
+}
@@ -0,0 +1,419 @@
+// Package config loads application settings that are too structured for
+// individual environment variables. Values come from built-in defaults,
+// overlaid by the JSON file named in CONFIG_FILE (if set), overlaid by the
+// environment variables documented on each field.
+//
+// Secrets (DATABASE_URL, JWT keys, PII encryption keys) stay in the
+// environment and are not part of Config.
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Config struct {
+	RateLimit  RateLimitConfig  `json:"rate_limit"`
+	Retention  RetentionConfig  `json:"retention"`
+	Geocoding  GeocodingConfig  `json:"geocoding"`
+	Routing    RoutingConfig    `json:"routing"`
+	Scheduling SchedulingConfig `json:"scheduling"`
+	Dispatch   DispatchConfig   `json:"dispatch"`
+	Realtime   RealtimeConfig   `json:"realtime"`
+	Tracking   TrackingConfig   `json:"tracking"`
+	Pricing    PricingConfig    `json:"pricing"`
+}
+
+// RateLimitConfig configures the rate limiter for public endpoints.
+type RateLimitConfig struct {
+	// Backend is "memory" (per machine) or "postgres" (shared by all
+	// machines). Env: RATE_LIMIT_BACKEND.
+	Backend string `json:"backend"`
+	// TrustFlyClientIP takes client addresses from the Fly-Client-IP header
+	// set by the Fly.io proxy, for rate limits and login lockouts. Leave it
+	// off unless every request comes through that proxy: clients can send
+	// the header themselves. Env: TRUST_FLY_CLIENT_IP.
+	TrustFlyClientIP bool `json:"trust_fly_client_ip"`
+	// Routes maps a route name to its limits. A limit is written "N/duration",
+	// e.g. "10/1h" allows bursts of 10 refilled evenly over an hour. An empty
+	// limit disables that key for the route.
+	Routes map[string]RouteLimits `json:"routes"`
+}
+
+// RouteLimits are the limits for one route, per client IP and per email address.
+type RouteLimits struct {
+	PerIP    string `json:"per_ip"`
+	PerEmail string `json:"per_email"`
+}
+
+// Retention data classes.
+const (
+	// RetentionBookingPII is rider PII on bookings, counted from the ride date.
+	RetentionBookingPII = "booking_pii"
+	// RetentionLoginAudit is the login audit log, counted from the attempt.
+	RetentionLoginAudit = "login_audit"
+)
+
+// RetentionConfig configures the background job that purges old data.
+// Expired refresh tokens and token revocations are always deleted.
+type RetentionConfig struct {
+	// Interval between runs, e.g. "24h"; "0" disables the job.
+	// Env: RETENTION_INTERVAL.
+	Interval string `json:"interval"`
+	// DryRun only counts and reports what would be purged.
+	// Env: RETENTION_DRY_RUN.
+	DryRun bool `json:"dry_run"`
+	// KeepMonths maps a data class to the number of months it is kept; 0
+	// keeps it forever.
+	KeepMonths map[string]int `json:"keep_months"`
+}
+
+// GeocodingConfig selects the geocoder used to add coordinates to bookings.
+type GeocodingConfig struct {
+	// Provider is "none" or "offline" (places from a fixture file, for
+	// development and tests). Env: GEOCODER.
+	Provider string `json:"provider"`
+	// FixtureFile is the offline geocoder's fixture; empty uses the built-in
+	// Los Angeles places. Env: GEOCODER_FIXTURE.
+	FixtureFile string `json:"fixture_file"`
+	// CacheTTL and NegativeCacheTTL are how long results and misses are
+	// cached in Postgres, e.g. "720h".
+	CacheTTL         string `json:"cache_ttl"`
+	NegativeCacheTTL string `json:"negative_cache_ttl"`
+}
+
+// RoutingConfig selects how trip distance and duration are estimated.
+type RoutingConfig struct {
+	// Provider is "none" or "haversine" (straight-line distance with a
+	// detour factor, driven at an average speed). Env: ROUTER.
+	Provider string `json:"provider"`
+	// DetourFactor is the ratio of road to straight-line distance.
+	DetourFactor float64 `json:"detour_factor"`
+	// AverageSpeedKPH is the average driving speed including traffic.
+	AverageSpeedKPH float64 `json:"average_speed_kph"`
+}
+
+// SchedulingConfig sets how long bookings keep drivers busy.
+type SchedulingConfig struct {
+	// TimeZone is the IANA zone booking dates, times and driver working
+	// hours are in. Env: SCHEDULE_TIMEZONE.
+	TimeZone string `json:"time_zone"`
+	// DefaultRideDuration is assumed for per_ride trips without a route
+	// estimate, e.g. "1h".
+	DefaultRideDuration string `json:"default_ride_duration"`
+	// Turnaround is added after every trip before the driver is free again.
+	Turnaround string `json:"turnaround"`
+	// MinimumHours is the shortest hourly booking.
+	MinimumHours int `json:"minimum_hours"`
+	// SlotInterval is the spacing of the pickup times offered to riders,
+	// e.g. "30m"; it must divide a day evenly.
+	SlotInterval string `json:"slot_interval"`
+}
+
+// Dispatch modes.
+const (
+	DispatchOff     = "off"
+	DispatchPropose = "propose"
+	DispatchAuto    = "auto"
+)
+
+// DispatchConfig configures the background job that finds drivers for
+// confirmed bookings.
+type DispatchConfig struct {
+	// Mode is DispatchOff, DispatchPropose (the best driver is offered the
+	// booking and the offer must be accepted) or DispatchAuto (the best
+	// driver is assigned straight away). Env: DISPATCH_MODE.
+	Mode string `json:"mode"`
+	// Interval between runs, e.g. "30s".
+	Interval string `json:"interval"`
+	// OfferTimeout is how long an offer stands before it goes to the next
+	// driver, e.g. "10m".
+	OfferTimeout string `json:"offer_timeout"`
+	// ExpiryInterval is how often expired offers are closed and their
+	// bookings dispatched again, e.g. "10s".
+	ExpiryInterval string `json:"expiry_interval"`
+	// Lookahead is how far ahead bookings are dispatched, e.g. "72h".
+	Lookahead string `json:"lookahead"`
+	// Weights are the relative importance of the scoring factors.
+	Weights DispatchWeights `json:"weights"`
+}
+
+// DispatchWeights weigh the factors drivers are scored on. They must not be
+// negative and need not add up to 1.
+type DispatchWeights struct {
+	// Distance favours drivers whose previous drop-off is close to the pickup.
+	Distance float64 `json:"distance"`
+	// VehicleFit favours the smallest vehicle that fits the party.
+	VehicleFit float64 `json:"vehicle_fit"`
+	// Workload favours drivers with fewer bookings that day.
+	Workload float64 `json:"workload"`
+	// Preference favours the rider's preferred driver and amenities.
+	Preference float64 `json:"preference"`
+}
+
+// RealtimeConfig configures the streams of booking events.
+type RealtimeConfig struct {
+	// Heartbeat is how often an idle stream sends a comment so proxies keep
+	// it open, e.g. "15s".
+	Heartbeat string `json:"heartbeat"`
+}
+
+// TrackingConfig configures the driver locations recorded during rides.
+type TrackingConfig struct {
+	// TrailLength is how many of the latest positions are kept per booking.
+	TrailLength int `json:"trail_length"`
+	// MaxAccuracyMeters is the least accurate fix stored; worse ones are
+	// ignored.
+	MaxAccuracyMeters float64 `json:"max_accuracy_meters"`
+}
+
+// PricingConfig sets the rates fares are calculated at. Amounts are in
+// cents of Currency.
+type PricingConfig struct {
+	// Currency is the ISO 4217 code fares are charged in, e.g. "USD".
+	Currency string `json:"currency"`
+	// HourlyRateCents is charged per hour of an hourly ride.
+	HourlyRateCents int64 `json:"hourly_rate_cents"`
+	// Rounding is the step the time used on an hourly ride is rounded up
+	// to, e.g. "15m". The booked hours are always charged.
+	Rounding string `json:"rounding"`
+	// BaseFareCents and PerKmCents make up a per_ride fare with the route
+	// estimate's distance, which is at least MinimumFareCents.
+	BaseFareCents    int64 `json:"base_fare_cents"`
+	PerKmCents       int64 `json:"per_km_cents"`
+	MinimumFareCents int64 `json:"minimum_fare_cents"`
+	// FreeWaiting is how long the driver of a per_ride trip waits at the
+	// pickup before WaitingPerMinuteCents is charged, e.g. "15m".
+	FreeWaiting           string `json:"free_waiting"`
+	WaitingPerMinuteCents int64  `json:"waiting_per_minute_cents"`
+}
+
+// Defaults returns the configuration used when nothing is overridden.
+func Defaults() *Config {
+	return &Config{
+		RateLimit: RateLimitConfig{
+			Backend: "postgres",
+			Routes: map[string]RouteLimits{
+				"create_book_ride": {PerIP: "10/1h", PerEmail: "5/1h"},
+				"update_book_ride": {PerIP: "20/1h"},
+				"list_book_rides":  {PerIP: "60/1m", PerEmail: "20/1m"},
+				"availability":     {PerIP: "60/1m"},
+			},
+		},
+		Retention: RetentionConfig{
+			Interval: "24h",
+			KeepMonths: map[string]int{
+				RetentionBookingPII: 24,
+				RetentionLoginAudit: 12,
+			},
+		},
+		Geocoding: GeocodingConfig{
+			Provider:         "none",
+			CacheTTL:         "720h",
+			NegativeCacheTTL: "1h",
+		},
+		Routing: RoutingConfig{
+			Provider:        "haversine",
+			DetourFactor:    1.3,
+			AverageSpeedKPH: 40,
+		},
+		Scheduling: SchedulingConfig{
+			TimeZone:            "America/Los_Angeles",
+			DefaultRideDuration: "1h",
+			Turnaround:          "30m",
+			MinimumHours:        2,
+			SlotInterval:        "30m",
+		},
+		Dispatch: DispatchConfig{
+			Mode:           DispatchOff,
+			Interval:       "30s",
+			OfferTimeout:   "10m",
+			ExpiryInterval: "10s",
+			Lookahead:      "72h",
+			Weights: DispatchWeights{
+				Distance:   0.4,
+				VehicleFit: 0.2,
+				Workload:   0.2,
+				Preference: 0.2,
+			},
+		},
+		Realtime: RealtimeConfig{
+			Heartbeat: "15s",
+		},
+		Tracking: TrackingConfig{
+			TrailLength:       500,
+			MaxAccuracyMeters: 100,
+		},
+		Pricing: PricingConfig{
+			Currency:              "USD",
+			HourlyRateCents:       12500,
+			Rounding:              "15m",
+			BaseFareCents:         4500,
+			PerKmCents:            275,
+			MinimumFareCents:      8500,
+			FreeWaiting:           "15m",
+			WaitingPerMinuteCents: 150,
+		},
+	}
+}
+
+// Load returns the defaults overlaid by CONFIG_FILE and the environment.
+func Load() (*Config, error) {
+	cfg := Defaults()
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+		if err := json.Unmarshal(raw, cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+	}
+
+	if v := os.Getenv("RATE_LIMIT_BACKEND"); v != "" {
+		cfg.RateLimit.Backend = v
+	}
+	if v := os.Getenv("TRUST_FLY_CLIENT_IP"); v != "" {
+		trust, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TRUST_FLY_CLIENT_IP %q: %w", v, err)
+		}
+		cfg.RateLimit.TrustFlyClientIP = trust
+	}
+	if v := os.Getenv("RETENTION_INTERVAL"); v != "" {
+		cfg.Retention.Interval = v
+	}
+	if v := os.Getenv("GEOCODER"); v != "" {
+		cfg.Geocoding.Provider = v
+	}
+	if v := os.Getenv("GEOCODER_FIXTURE"); v != "" {
+		cfg.Geocoding.FixtureFile = v
+	}
+	if v := os.Getenv("ROUTER"); v != "" {
+		cfg.Routing.Provider = v
+	}
+	if v := os.Getenv("SCHEDULE_TIMEZONE"); v != "" {
+		cfg.Scheduling.TimeZone = v
+	}
+	if v := os.Getenv("DISPATCH_MODE"); v != "" {
+		cfg.Dispatch.Mode = v
+	}
+	if v := os.Getenv("RETENTION_DRY_RUN"); v != "" {
+		dryRun, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RETENTION_DRY_RUN %q: %w", v, err)
+		}
+		cfg.Retention.DryRun = dryRun
+	}
+
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (c *Config) validate() error {
+	if c.RateLimit.Backend != "memory" && c.RateLimit.Backend != "postgres" {
+		return fmt.Errorf("rate_limit.backend must be 'memory' or 'postgres', got %q", c.RateLimit.Backend)
+	}
+	if d, err := time.ParseDuration(c.Retention.Interval); err != nil || d < 0 {
+		return fmt.Errorf("retention.interval must be a duration such as 24h, got %q", c.Retention.Interval)
+	}
+	if c.Geocoding.Provider != "none" && c.Geocoding.Provider != "offline" {
+		return fmt.Errorf("geocoding.provider must be 'none' or 'offline', got %q", c.Geocoding.Provider)
+	}
+	for name, v := range map[string]string{"cache_ttl": c.Geocoding.CacheTTL, "negative_cache_ttl": c.Geocoding.NegativeCacheTTL} {
+		if d, err := time.ParseDuration(v); err != nil || d <= 0 {
+			return fmt.Errorf("geocoding.%s must be a positive duration, got %q", name, v)
+		}
+	}
+	if c.Routing.Provider != "none" && c.Routing.Provider != "haversine" {
+		return fmt.Errorf("routing.provider must be 'none' or 'haversine', got %q", c.Routing.Provider)
+	}
+	if c.Routing.DetourFactor < 1 {
+		return fmt.Errorf("routing.detour_factor must be at least 1, got %v", c.Routing.DetourFactor)
+	}
+	if c.Routing.AverageSpeedKPH <= 0 {
+		return fmt.Errorf("routing.average_speed_kph must be positive, got %v", c.Routing.AverageSpeedKPH)
+	}
+	if _, err := time.LoadLocation(c.Scheduling.TimeZone); err != nil {
+		return fmt.Errorf("scheduling.time_zone: %w", err)
+	}
+	if d, err := time.ParseDuration(c.Scheduling.DefaultRideDuration); err != nil || d <= 0 {
+		return fmt.Errorf("scheduling.default_ride_duration must be a positive duration, got %q", c.Scheduling.DefaultRideDuration)
+	}
+	if d, err := time.ParseDuration(c.Scheduling.Turnaround); err != nil || d < 0 {
+		return fmt.Errorf("scheduling.turnaround must be a duration such as 30m, got %q", c.Scheduling.Turnaround)
+	}
+	if c.Scheduling.MinimumHours < 1 {
+		return fmt.Errorf("scheduling.minimum_hours must be at least 1, got %d", c.Scheduling.MinimumHours)
+	}
+	if d, err := time.ParseDuration(c.Scheduling.SlotInterval); err != nil || d < time.Minute || d%time.Minute != 0 || (24*time.Hour)%d != 0 {
+		return fmt.Errorf("scheduling.slot_interval must be a whole number of minutes dividing a day, such as 30m, got %q", c.Scheduling.SlotInterval)
+	}
+	if c.Dispatch.Mode != DispatchOff && c.Dispatch.Mode != DispatchPropose && c.Dispatch.Mode != DispatchAuto {
+		return fmt.Errorf("dispatch.mode must be 'off', 'propose' or 'auto', got %q", c.Dispatch.Mode)
+	}
+	for name, v := range map[string]string{
+		"interval":        c.Dispatch.Interval,
+		"offer_timeout":   c.Dispatch.OfferTimeout,
+		"expiry_interval": c.Dispatch.ExpiryInterval,
+		"lookahead":       c.Dispatch.Lookahead,
+	} {
+		if d, err := time.ParseDuration(v); err != nil || d <= 0 {
+			return fmt.Errorf("dispatch.%s must be a positive duration, got %q", name, v)
+		}
+	}
+	w := c.Dispatch.Weights
+	for name, v := range map[string]float64{"distance": w.Distance, "vehicle_fit": w.VehicleFit, "workload": w.Workload, "preference": w.Preference} {
+		if v < 0 {
+			return fmt.Errorf("dispatch.weights.%s must not be negative, got %v", name, v)
+		}
+	}
+	if w.Distance+w.VehicleFit+w.Workload+w.Preference == 0 {
+		return fmt.Errorf("dispatch.weights must not all be zero")
+	}
+	if d, err := time.ParseDuration(c.Realtime.Heartbeat); err != nil || d <= 0 {
+		return fmt.Errorf("realtime.heartbeat must be a positive duration, got %q", c.Realtime.Heartbeat)
+	}
+	if c.Tracking.TrailLength <= 0 {
+		return fmt.Errorf("tracking.trail_length must be positive, got %d", c.Tracking.TrailLength)
+	}
+	if c.Tracking.MaxAccuracyMeters <= 0 {
+		return fmt.Errorf("tracking.max_accuracy_meters must be positive, got %v", c.Tracking.MaxAccuracyMeters)
+	}
+	p := c.Pricing
+	if len(p.Currency) != 3 || strings.ToUpper(p.Currency) != p.Currency {
+		return fmt.Errorf("pricing.currency must be a three-letter code such as USD, got %q", p.Currency)
+	}
+	for name, v := range map[string]int64{
+		"hourly_rate_cents":        p.HourlyRateCents,
+		"base_fare_cents":          p.BaseFareCents,
+		"per_km_cents":             p.PerKmCents,
+		"minimum_fare_cents":       p.MinimumFareCents,
+		"waiting_per_minute_cents": p.WaitingPerMinuteCents,
+	} {
+		if v < 0 {
+			return fmt.Errorf("pricing.%s must not be negative, got %d", name, v)
+		}
+	}
+	if d, err := time.ParseDuration(p.Rounding); err != nil || d < time.Minute || d%time.Minute != 0 {
+		return fmt.Errorf("pricing.rounding must be a whole number of minutes such as 15m, got %q", p.Rounding)
+	}
+	if d, err := time.ParseDuration(p.FreeWaiting); err != nil || d < 0 {
+		return fmt.Errorf("pricing.free_waiting must be a duration such as 15m, got %q", p.FreeWaiting)
+	}
+	for class, months := range c.Retention.KeepMonths {
+		if class != RetentionBookingPII && class != RetentionLoginAudit {
+			return fmt.Errorf("retention.keep_months: unknown data class %q", class)
+		}
+		if months < 0 {
+			return fmt.Errorf("retention.keep_months.%s must not be negative", class)
+		}
+	}
+	return nil
+}
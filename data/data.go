@@ -1,28 +1,499 @@
 package data
 
-import "time"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// User roles.
+const (
+	RoleDriver = "driver"
+	RoleAdmin  = "admin"
+)
 
 // User represents a user entity (e.g., driver) in the system.
 type User struct {
-	ID        int64     `json:"id"`
-	Username  string    `json:"username"`
-	Password  string    `json:"password"` // Store hashed passwords in production
-	Role      string    `json:"role"`     // e.g., "driver"
-	CreatedAt time.Time `json:"created_at"`
+	ID         int64      `json:"id"`
+	Username   string     `json:"username"`
+	Password   string     `json:"-"`    // bcrypt hash, never serialized
+	Role       string     `json:"role"` // RoleDriver or RoleAdmin
+	CreatedAt  time.Time  `json:"created_at"`
+	DisabledAt *time.Time `json:"disabled_at,omitempty"`
+	// TokenVersion is embedded in access tokens; bumping it invalidates every
+	// outstanding token for the user.
+	TokenVersion int `json:"-"`
+	// MFASecret is the base32 TOTP secret; it is set during enrolment and
+	// only in effect once MFAEnabledAt is set.
+	MFASecret    string     `json:"-"`
+	MFAEnabledAt *time.Time `json:"mfa_enabled_at,omitempty"`
+	// DefaultVehicleID is the vehicle a driver normally drives.
+	DefaultVehicleID *int64 `json:"default_vehicle_id,omitempty"`
 }
 
 // BookRide represents a ride booking entity.
 type BookRide struct {
-	ID                 int64  `json:"id"` // Generated by database
-	YourName           string `json:"your_name"`
-	Email              string `json:"email"`
-	PhoneNumber        string `json:"phone_number"`
-	RideType           string `json:"ride_type"`
-	PickupLocation     string `json:"pickup_location"`
-	DropoffLocation    string `json:"dropoff_location"`
-	Date               string `json:"date"` // Consider using time.Time later for better type safety
-	Time               string `json:"time"` // Consider using time.Time later for better type safety
-	NumberOfPassengers int    `json:"number_of_passengers"`
-	NumberOfLuggage    int    `json:"number_of_luggage"`
-	AdditionalNotes    string `json:"additional_notes"`
+	ID                 int64    `json:"id"` // Generated by database
+	YourName           string   `json:"your_name"`
+	Email              string   `json:"email"`
+	PhoneNumber        string   `json:"phone_number"`
+	RideType           string   `json:"ride_type"`
+	PickupLocation     Location `json:"pickup_location"`
+	DropoffLocation    Location `json:"dropoff_location"`
+	Date               string   `json:"date"` // Consider using time.Time later for better type safety
+	Time               string   `json:"time"` // Consider using time.Time later for better type safety
+	NumberOfPassengers int      `json:"number_of_passengers"`
+	NumberOfLuggage    int      `json:"number_of_luggage"`
+	AdditionalNotes    string   `json:"additional_notes"`
+	// GeocodeStatus is GeocodePending until the addresses have been looked up
+	// in the background; empty for bookings made before geocoding.
+	GeocodeStatus string `json:"geocode_status"`
+	// ZoneID is the service zone containing the pickup; nil if no zones
+	// were configured or the pickup could not be located.
+	ZoneID *int64 `json:"zone_id,omitempty"`
+	// RouteEstimate is set once both locations have coordinates.
+	RouteEstimate *RouteEstimate `json:"route_estimate,omitempty"`
+	// VehicleID is the vehicle assigned to the booking, if any.
+	VehicleID *int64 `json:"vehicle_id,omitempty"`
+	// Hours is the number of hours booked for hourly rides.
+	Hours int `json:"hours,omitempty"`
+	// DriverID is the driver assigned to the booking, if any.
+	DriverID *int64 `json:"driver_id,omitempty"`
+	// Occupied is when the booking keeps its driver busy: from pickup to the
+	// estimated drop-off plus turnaround. Nil for bookings without a valid
+	// pickup time and for cancelled bookings.
+	Occupied *TimeRange `json:"occupied,omitempty"`
+	// Status is BookingPending until an admin confirms the booking; the
+	// assigned driver then moves it along as the ride happens.
+	Status string `json:"status"`
+	// Preferences are the rider's wishes used to rank drivers; nil if none.
+	Preferences *RidePreferences `json:"preferences,omitempty"`
+}
+
+// Booking statuses. Only confirmed bookings are dispatched.
+const (
+	BookingPending    = "pending"
+	BookingConfirmed  = "confirmed"
+	BookingEnRoute    = "en_route"    // the driver is on the way to the pickup
+	BookingArrived    = "arrived"     // the driver is waiting at the pickup
+	BookingInProgress = "in_progress" // the rider is on board
+	BookingCompleted  = "completed"
+	BookingCancelled  = "cancelled"
+)
+
+// ErrInvalidStatusTransition is returned when a booking cannot move from its
+// current status to the requested one.
+var ErrInvalidStatusTransition = errors.New("invalid booking status transition")
+
+// bookingTransitions lists the statuses a booking can move to from each
+// status.
+var bookingTransitions = map[string][]string{
+	BookingPending:    {BookingConfirmed, BookingCancelled},
+	BookingConfirmed:  {BookingEnRoute, BookingCancelled},
+	BookingEnRoute:    {BookingArrived, BookingInProgress, BookingCancelled},
+	BookingArrived:    {BookingInProgress, BookingCancelled},
+	BookingInProgress: {BookingCompleted},
+}
+
+// CanTransition reports whether a booking can move from status from to to.
+func CanTransition(from, to string) bool {
+	for _, next := range bookingTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// IsDriverStatus reports whether status is one the assigned driver sets as
+// the ride happens, rather than an admin.
+func IsDriverStatus(status string) bool {
+	return status == BookingEnRoute || status == BookingArrived || status == BookingInProgress || status == BookingCompleted
+}
+
+// IsTracked reports whether the driver's location is tracked, and shown to
+// the rider, while a booking has status.
+func IsTracked(status string) bool {
+	return status == BookingEnRoute || status == BookingArrived || status == BookingInProgress
+}
+
+// ErrNoDriver is returned when a booking needs an assigned driver for a
+// change but has none.
+var ErrNoDriver = errors.New("booking has no driver assigned")
+
+// ErrNotTracked is returned when reporting or asking for the driver's
+// location of a booking that is not en route, arrived or in progress.
+var ErrNotTracked = errors.New("booking is not en route, arrived or in progress")
+
+// ErrInvalidOdometer is returned when an odometer reading is given for a
+// status that does not take one, or is below the reading at the start.
+var ErrInvalidOdometer = errors.New("invalid odometer reading")
+
+// StatusChange moves a booking to Status. If DriverID is set the booking
+// must be assigned to that driver. Odometer is the vehicle's reading when
+// the trip starts (BookingInProgress) or ends (BookingCompleted).
+type StatusChange struct {
+	Status   string
+	DriverID *int64
+	Odometer *float64
+}
+
+// Trip is what actually happened on a ride, as recorded by the driver's
+// status changes, with the fare charged for it. ArrivedAt is when the
+// driver reached the pickup, StartedAt when the rider got in and EndedAt
+// the drop-off.
+type Trip struct {
+	BookingID     int64      `json:"booking_id"`
+	ArrivedAt     *time.Time `json:"arrived_at,omitempty"`
+	StartedAt     *time.Time `json:"started_at,omitempty"`
+	EndedAt       *time.Time `json:"ended_at,omitempty"`
+	StartOdometer *float64   `json:"start_odometer,omitempty"`
+	EndOdometer   *float64   `json:"end_odometer,omitempty"`
+	// Quote is the fare the booking was expected to cost and Fare what it
+	// did; both are set once the trip is completed.
+	Quote *Fare `json:"quote,omitempty"`
+	Fare  *Fare `json:"fare,omitempty"`
+}
+
+// Fare line kinds; approved trip charges add lines of their own kind.
+const (
+	FareHourly   = "hourly"
+	FareBase     = "base"
+	FareDistance = "distance"
+	FareMinimum  = "minimum" // tops a per_ride fare up to the minimum
+	FareWaiting  = "waiting"
+)
+
+// FareLine is one charge making up a fare.
+type FareLine struct {
+	Kind        string `json:"kind"`
+	Description string `json:"description"`
+	AmountCents int64  `json:"amount_cents"`
+}
+
+// Fare is the price of a ride, itemised.
+type Fare struct {
+	Currency   string     `json:"currency"`
+	Lines      []FareLine `json:"lines"`
+	TotalCents int64      `json:"total_cents"`
+}
+
+// Add appends a line and adds it to the total.
+func (f *Fare) Add(kind, description string, amountCents int64) {
+	f.Lines = append(f.Lines, FareLine{Kind: kind, Description: description, AmountCents: amountCents})
+	f.TotalCents += amountCents
+}
+
+// Trip charge kinds, which are also the kinds of the fare lines approved
+// charges add.
+const (
+	ChargeToll      = "toll"
+	ChargeParking   = "parking"
+	ChargeExtraStop = "extra_stop"
+	ChargeCleaning  = "cleaning"
+	ChargeGratuity  = "gratuity"
+)
+
+// IsChargeKind reports whether kind is a trip charge kind.
+func IsChargeKind(kind string) bool {
+	switch kind {
+	case ChargeToll, ChargeParking, ChargeExtraStop, ChargeCleaning, ChargeGratuity:
+		return true
+	}
+	return false
+}
+
+// Trip charge statuses.
+const (
+	ChargePending  = "pending"
+	ChargeApproved = "approved"
+	ChargeRejected = "rejected"
+)
+
+// ErrChargeClosed is returned when adding a charge to a booking that is not
+// under way or completed, or changing one that has been reviewed.
+var ErrChargeClosed = errors.New("charge can no longer be changed")
+
+// TripCharge is an add-on charge the driver attached to a ride, such as a
+// toll they paid. Approved charges are added to the fare.
+type TripCharge struct {
+	ID          int64  `json:"id"`
+	BookingID   int64  `json:"booking_id"`
+	DriverID    int64  `json:"driver_id"`
+	Kind        string `json:"kind"`
+	Description string `json:"description"`
+	AmountCents int64  `json:"amount_cents"`
+	// HasReceipt is set once the driver uploaded a photo of the receipt.
+	HasReceipt bool       `json:"has_receipt"`
+	Status     string     `json:"status"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ReviewedBy *int64     `json:"reviewed_by,omitempty"`
+	ReviewedAt *time.Time `json:"reviewed_at,omitempty"`
+	ReviewNote string     `json:"review_note,omitempty"`
+}
+
+// ChargeReview is an admin's decision on a pending trip charge.
+type ChargeReview struct {
+	Approve    bool
+	Note       string // why the charge was rejected, or a remark
+	ReviewedBy int64
+}
+
+// Receipt is a photo of the receipt for a trip charge.
+type Receipt struct {
+	ContentType string
+	Image       []byte
+}
+
+// RidePreferences are a rider's wishes for the driver and vehicle. They are
+// honoured where possible but never stop a booking from being dispatched.
+type RidePreferences struct {
+	Amenities         []string `json:"amenities,omitempty"` // e.g. "wifi", "child_seat"
+	PreferredDriverID *int64   `json:"preferred_driver_id,omitempty"`
+}
+
+// Dispatch offer statuses.
+const (
+	OfferPending   = "pending"
+	OfferAccepted  = "accepted"
+	OfferDeclined  = "declined"
+	OfferExpired   = "expired"
+	OfferWithdrawn = "withdrawn" // the booking was assigned, cancelled or changed otherwise
+)
+
+// ErrOfferClosed is returned when responding to an offer that is no longer
+// pending, or whose booking no longer needs a driver.
+var ErrOfferClosed = errors.New("offer is no longer open")
+
+// DispatchOffer is a booking offered to a driver by the dispatcher.
+type DispatchOffer struct {
+	ID          int64      `json:"id"`
+	BookingID   int64      `json:"booking_id"`
+	DriverID    int64      `json:"driver_id"`
+	Score       float64    `json:"score"`
+	Status      string     `json:"status"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	RespondedAt *time.Time `json:"responded_at,omitempty"`
+	// RespondedBy is the driver, or an admin on their behalf; nil if the
+	// dispatcher accepted the offer itself.
+	RespondedBy   *int64 `json:"responded_by,omitempty"`
+	DeclineReason string `json:"decline_reason,omitempty"`
+}
+
+// OfferResponse is an answer to a dispatch offer.
+type OfferResponse struct {
+	Accept bool
+	Reason string // why the offer was declined
+	// DriverID, if set, only lets the offers made to that driver be answered.
+	DriverID *int64
+	// RespondedBy is the user answering; nil for the dispatcher.
+	RespondedBy *int64
+	// CanTake, if set, is asked on acceptance whether the driver can still
+	// take the booking, with the vehicle they would drive, their working
+	// hours and their time off as they are when the offer is accepted.
+	CanTake func(ride *BookRide, d DriverSchedule) (bool, error)
+}
+
+// OfferResponseStats summarise how a driver answered dispatch offers.
+// Offers that were withdrawn or accepted by the dispatcher are left out.
+type OfferResponseStats struct {
+	DriverID       int64   `json:"driver_id"`
+	Offers         int     `json:"offers"`
+	Accepted       int     `json:"accepted"`
+	Declined       int     `json:"declined"`
+	Expired        int     `json:"expired"`
+	AcceptanceRate float64 `json:"acceptance_rate"` // Accepted / Offers
+	// MeanResponseSeconds and MedianResponseSeconds are how long the driver
+	// took to answer the offers they answered themselves; nil if none.
+	MeanResponseSeconds   *float64 `json:"mean_response_seconds,omitempty"`
+	MedianResponseSeconds *float64 `json:"median_response_seconds,omitempty"`
+}
+
+// LocationPing is a position reported by a driver's device.
+type LocationPing struct {
+	Lat        float64   `json:"lat"`
+	Lng        float64   `json:"lng"`
+	Accuracy   float64   `json:"accuracy"`          // radius in meters
+	Heading    *float64  `json:"heading,omitempty"` // degrees clockwise from north
+	Speed      *float64  `json:"speed,omitempty"`   // meters per second
+	RecordedAt time.Time `json:"recorded_at"`       // when the device took the fix
+}
+
+// DriverLocation is the latest position of the driver of a booking.
+type DriverLocation struct {
+	BookingID  int64     `json:"booking_id"`
+	DriverID   int64     `json:"driver_id"`
+	Lat        float64   `json:"lat"`
+	Lng        float64   `json:"lng"`
+	Accuracy   float64   `json:"accuracy"`
+	Heading    *float64  `json:"heading,omitempty"`
+	Speed      *float64  `json:"speed,omitempty"`
+	RecordedAt time.Time `json:"recorded_at"`
+}
+
+// Booking event types. Offers made for a booking are announced as
+// BookingEventOffered and, once no longer pending, BookingEventOfferClosed.
+const (
+	BookingEventCreated     = "created"
+	BookingEventUpdated     = "updated"
+	BookingEventDeleted     = "deleted"
+	BookingEventOffered     = "offered"
+	BookingEventOfferClosed = "offer_closed"
+)
+
+// BookingEvent is a change to a booking, streamed to riders and drivers. It
+// carries no rider PII; Status, DriverID and VehicleID are as of the change.
+// For offer events DriverID is the driver the offer was made to.
+type BookingEvent struct {
+	ID        int64     `json:"id"`
+	BookingID int64     `json:"booking_id"`
+	Type      string    `json:"type"`
+	Status    string    `json:"status"`
+	DriverID  *int64    `json:"driver_id,omitempty"`
+	VehicleID *int64    `json:"vehicle_id,omitempty"`
+	OfferID   *int64    `json:"offer_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// IsOffer reports whether e announces an offer rather than a change to the
+// booking itself.
+func (e BookingEvent) IsOffer() bool {
+	return e.Type == BookingEventOffered || e.Type == BookingEventOfferClosed
+}
+
+// VisibleTo reports whether the user with ID userID may see e: offer events
+// are only for the driver the offer was made to.
+func (e BookingEvent) VisibleTo(userID int64) bool {
+	return !e.IsOffer() || (e.DriverID != nil && *e.DriverID == userID)
+}
+
+// TimeRange is the half-open interval [Start, End).
+type TimeRange struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// Overlaps reports whether r and o share any instant.
+func (r TimeRange) Overlaps(o TimeRange) bool {
+	return r.Start.Before(o.End) && o.Start.Before(r.End)
+}
+
+// RouteEstimate is the estimated driving distance and duration of a trip.
+type RouteEstimate struct {
+	DistanceMeters  int `json:"distance_meters"`
+	DurationSeconds int `json:"duration_seconds"`
+}
+
+// Geocode statuses of a booking.
+const (
+	GeocodePending    = "pending"
+	GeocodeResolved   = "resolved"
+	GeocodeUnresolved = "unresolved" // an address could not be found
+)
+
+// Location is a pickup or dropoff point. Bookings made before locations were
+// structured only have an Address.
+type Location struct {
+	Address     string   `json:"address"` // formatted address
+	Lat         *float64 `json:"lat,omitempty"`
+	Lng         *float64 `json:"lng,omitempty"`
+	PlaceID     string   `json:"place_id,omitempty"`     // geocoder place ID
+	AirportCode string   `json:"airport_code,omitempty"` // IATA code when the location is an airport
+	Terminal    string   `json:"terminal,omitempty"`
+}
+
+// HasCoordinates reports whether the location has a latitude and longitude.
+func (l Location) HasCoordinates() bool {
+	return l.Lat != nil && l.Lng != nil
+}
+
+// Zone is a service area bookings can be picked up in.
+type Zone struct {
+	ID        int64           `json:"id"`
+	Name      string          `json:"name"`
+	Boundary  json.RawMessage `json:"boundary"` // GeoJSON Polygon or MultiPolygon
+	Active    bool            `json:"active"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// Vehicle statuses. Only active vehicles can be assigned to bookings.
+const (
+	VehicleActive      = "active"
+	VehicleMaintenance = "maintenance"
+	VehicleRetired     = "retired"
+)
+
+// ErrVehicleCapacity is returned when a booking does not fit in a vehicle.
+var ErrVehicleCapacity = errors.New("booking exceeds vehicle capacity")
+
+// Vehicle is a car in the fleet.
+type Vehicle struct {
+	ID              int64     `json:"id"`
+	Make            string    `json:"make"`
+	Model           string    `json:"model"`
+	Plate           string    `json:"plate"`
+	Seats           int       `json:"seats"` // passenger seats, excluding the driver
+	LuggageCapacity int       `json:"luggage_capacity"`
+	Amenities       []string  `json:"amenities"` // e.g. "wifi", "child_seat"
+	Status          string    `json:"status"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// CheckCapacity returns an error wrapping ErrVehicleCapacity if the vehicle
+// cannot take the given number of passengers and pieces of luggage.
+func (v *Vehicle) CheckCapacity(passengers, luggage int) error {
+	if passengers > v.Seats {
+		return fmt.Errorf("%w: %d passengers but %d seats", ErrVehicleCapacity, passengers, v.Seats)
+	}
+	if luggage > v.LuggageCapacity {
+		return fmt.Errorf("%w: %d pieces of luggage but room for %d", ErrVehicleCapacity, luggage, v.LuggageCapacity)
+	}
+	return nil
+}
+
+// WorkingHours is a weekly recurring window in which a driver works, in the
+// scheduling time zone.
+type WorkingHours struct {
+	Weekday int    `json:"weekday"` // 0 is Sunday
+	Start   string `json:"start"`   // HH:MM
+	End     string `json:"end"`     // HH:MM, after Start; 24:00 is midnight
+}
+
+// TimeOff is a period in which a driver is unavailable.
+type TimeOff struct {
+	ID        int64     `json:"id"`
+	DriverID  int64     `json:"driver_id"`
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// DriverSchedule is what decides whether a driver can take a booking: their
+// default vehicle (nil without an active one), working hours, time off and
+// the occupied intervals of the bookings already assigned to them.
+// DefaultVehicleID is set even if the vehicle is not active.
+type DriverSchedule struct {
+	DriverID         int64
+	DefaultVehicleID *int64
+	Vehicle          *Vehicle
+	WorkingHours     []WorkingHours
+	TimeOff          []TimeOff
+	Busy             []TimeRange
+}
+
+// LoginAttempt is an audit record of a single login attempt.
+type LoginAttempt struct {
+	ID        int64     `json:"id"`
+	Username  string    `json:"username"`
+	UserID    *int64    `json:"user_id,omitempty"`
+	IPAddress string    `json:"ip_address"`
+	UserAgent string    `json:"user_agent"`
+	Success   bool      `json:"success"`
+	Reason    string    `json:"reason"` // e.g. "success", "invalid_credentials", "locked_out"
+	CreatedAt time.Time `json:"created_at"`
 }
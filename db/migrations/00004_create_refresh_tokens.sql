@@ -0,0 +1,29 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE users ADD COLUMN token_version INTEGER NOT NULL DEFAULT 0;
+
+CREATE TABLE refresh_tokens (
+                                id BIGSERIAL PRIMARY KEY,
+                                user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+                                family_id TEXT NOT NULL,
+                                token_hash TEXT NOT NULL UNIQUE,
+                                expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+                                created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                                used_at TIMESTAMP WITH TIME ZONE,
+                                revoked_at TIMESTAMP WITH TIME ZONE
+);
+CREATE INDEX refresh_tokens_family_id_idx ON refresh_tokens (family_id);
+CREATE INDEX refresh_tokens_user_id_idx ON refresh_tokens (user_id);
+
+CREATE TABLE revoked_access_tokens (
+                                       jti TEXT PRIMARY KEY,
+                                       expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE revoked_access_tokens;
+DROP TABLE refresh_tokens;
+ALTER TABLE users DROP COLUMN token_version;
+-- +goose StatementEnd
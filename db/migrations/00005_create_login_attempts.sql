@@ -0,0 +1,28 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE login_lockouts (
+                                key TEXT PRIMARY KEY, -- 'user:<username>' or 'ip:<address>'
+                                failures INTEGER NOT NULL DEFAULT 0,
+                                last_failure_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                                locked_until TIMESTAMP WITH TIME ZONE
+);
+
+CREATE TABLE login_audit (
+                             id BIGSERIAL PRIMARY KEY,
+                             username TEXT NOT NULL,
+                             user_id BIGINT REFERENCES users(id) ON DELETE SET NULL,
+                             ip_address TEXT NOT NULL,
+                             user_agent TEXT,
+                             success BOOLEAN NOT NULL,
+                             reason TEXT NOT NULL,
+                             created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX login_audit_username_idx ON login_audit (username, created_at DESC);
+CREATE INDEX login_audit_created_at_idx ON login_audit (created_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE login_audit;
+DROP TABLE login_lockouts;
+-- +goose StatementEnd
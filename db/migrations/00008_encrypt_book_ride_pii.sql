@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Rows with a NULL pii_key_id still hold plaintext; `luxsuv-backend reencrypt`
+-- encrypts them in place.
+ALTER TABLE book_rides ADD COLUMN pii_key_id TEXT;
+ALTER TABLE book_rides ADD COLUMN pii_data_key BYTEA;
+ALTER TABLE book_rides ADD COLUMN email_index TEXT;
+CREATE INDEX book_rides_email_index_idx ON book_rides (email_index);
+CREATE INDEX book_rides_pii_key_id_idx ON book_rides (pii_key_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+-- Encrypted rows are not decrypted; they stay unreadable without the keys.
+DROP INDEX book_rides_pii_key_id_idx;
+DROP INDEX book_rides_email_index_idx;
+ALTER TABLE book_rides DROP COLUMN email_index;
+ALTER TABLE book_rides DROP COLUMN pii_data_key;
+ALTER TABLE book_rides DROP COLUMN pii_key_id;
+-- +goose StatementEnd
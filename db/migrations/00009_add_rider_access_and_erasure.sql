@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+-- access_token_hash authenticates the rider who made the booking (SHA-256 of
+-- the token returned on creation). erased_at marks bookings whose PII was
+-- erased on request; the rows are kept for financial records.
+ALTER TABLE book_rides ADD COLUMN access_token_hash TEXT UNIQUE;
+ALTER TABLE book_rides ADD COLUMN erased_at TIMESTAMP WITH TIME ZONE;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE book_rides DROP COLUMN erased_at;
+ALTER TABLE book_rides DROP COLUMN access_token_hash;
+-- +goose StatementEnd
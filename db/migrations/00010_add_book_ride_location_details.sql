@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Structured pickup/dropoff data (coordinates, place ID, airport code and
+-- terminal) as JSON encrypted like the other PII columns. pickup_location and
+-- dropoff_location keep the formatted address; NULL details mean the booking
+-- only has an address.
+ALTER TABLE book_rides ADD COLUMN pickup_details TEXT;
+ALTER TABLE book_rides ADD COLUMN dropoff_details TEXT;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE book_rides DROP COLUMN dropoff_details;
+ALTER TABLE book_rides DROP COLUMN pickup_details;
+-- +goose StatementEnd
@@ -0,0 +1,18 @@
+-- +goose Up
+-- +goose StatementBegin
+-- key is a hash of the normalized query; a NULL result caches a miss.
+CREATE TABLE geocode_cache (
+                               key TEXT PRIMARY KEY,
+                               result JSONB,
+                               expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+CREATE INDEX geocode_cache_expires_at_idx ON geocode_cache (expires_at);
+
+ALTER TABLE book_rides ADD COLUMN geocode_status TEXT;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE book_rides DROP COLUMN geocode_status;
+DROP TABLE geocode_cache;
+-- +goose StatementEnd
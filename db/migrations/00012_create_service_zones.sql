@@ -0,0 +1,22 @@
+-- +goose Up
+-- +goose StatementBegin
+-- boundary is a GeoJSON Polygon or MultiPolygon.
+CREATE TABLE service_zones (
+                               id BIGSERIAL PRIMARY KEY,
+                               name TEXT NOT NULL UNIQUE,
+                               boundary JSONB NOT NULL,
+                               active BOOLEAN NOT NULL DEFAULT TRUE,
+                               created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+                               updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE book_rides ADD COLUMN zone_id BIGINT REFERENCES service_zones (id) ON DELETE SET NULL;
+CREATE INDEX book_rides_zone_id_idx ON book_rides (zone_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX book_rides_zone_id_idx;
+ALTER TABLE book_rides DROP COLUMN zone_id;
+DROP TABLE service_zones;
+-- +goose StatementEnd
@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Set once both locations have coordinates; NULL until then.
+ALTER TABLE book_rides ADD COLUMN route_distance_meters INTEGER;
+ALTER TABLE book_rides ADD COLUMN route_duration_seconds INTEGER;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE book_rides DROP COLUMN route_duration_seconds;
+ALTER TABLE book_rides DROP COLUMN route_distance_meters;
+-- +goose StatementEnd
@@ -0,0 +1,27 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE vehicles (
+                          id BIGSERIAL PRIMARY KEY,
+                          make TEXT NOT NULL,
+                          model TEXT NOT NULL,
+                          plate TEXT NOT NULL UNIQUE,
+                          seats INTEGER NOT NULL CHECK (seats > 0),
+                          luggage_capacity INTEGER NOT NULL CHECK (luggage_capacity >= 0),
+                          amenities TEXT[] NOT NULL DEFAULT '{}',
+                          status TEXT NOT NULL DEFAULT 'active' CHECK (status IN ('active', 'maintenance', 'retired')),
+                          created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+                          updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE users ADD COLUMN default_vehicle_id BIGINT REFERENCES vehicles (id) ON DELETE SET NULL;
+ALTER TABLE book_rides ADD COLUMN vehicle_id BIGINT REFERENCES vehicles (id) ON DELETE SET NULL;
+CREATE INDEX book_rides_vehicle_id_idx ON book_rides (vehicle_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX book_rides_vehicle_id_idx;
+ALTER TABLE book_rides DROP COLUMN vehicle_id;
+ALTER TABLE users DROP COLUMN default_vehicle_id;
+DROP TABLE vehicles;
+-- +goose StatementEnd
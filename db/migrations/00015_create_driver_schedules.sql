@@ -0,0 +1,42 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE EXTENSION IF NOT EXISTS btree_gist;
+
+ALTER TABLE book_rides ADD COLUMN hours INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE book_rides ADD COLUMN driver_id BIGINT REFERENCES users (id) ON DELETE SET NULL;
+-- occupied runs from pickup to the estimated drop-off plus turnaround. A
+-- driver can never hold two bookings whose occupied ranges overlap.
+ALTER TABLE book_rides ADD COLUMN occupied TSTZRANGE;
+ALTER TABLE book_rides ADD CONSTRAINT book_rides_driver_occupied_excl
+    EXCLUDE USING gist (driver_id WITH =, occupied WITH &&) WHERE (driver_id IS NOT NULL);
+
+-- Weekly working hours, as wall-clock times in the scheduling time zone.
+CREATE TABLE driver_working_hours (
+                                      id BIGSERIAL PRIMARY KEY,
+                                      driver_id BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+                                      weekday SMALLINT NOT NULL CHECK (weekday BETWEEN 0 AND 6),
+                                      start_time TIME NOT NULL,
+                                      end_time TIME NOT NULL,
+                                      CHECK (start_time < end_time)
+);
+CREATE INDEX driver_working_hours_driver_id_idx ON driver_working_hours (driver_id);
+
+CREATE TABLE driver_time_off (
+                                 id BIGSERIAL PRIMARY KEY,
+                                 driver_id BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+                                 period TSTZRANGE NOT NULL,
+                                 reason TEXT NOT NULL DEFAULT '',
+                                 created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX driver_time_off_period_idx ON driver_time_off USING gist (driver_id, period);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE driver_time_off;
+DROP TABLE driver_working_hours;
+ALTER TABLE book_rides DROP CONSTRAINT book_rides_driver_occupied_excl;
+ALTER TABLE book_rides DROP COLUMN occupied;
+ALTER TABLE book_rides DROP COLUMN driver_id;
+ALTER TABLE book_rides DROP COLUMN hours;
+-- +goose StatementEnd
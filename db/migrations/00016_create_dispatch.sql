@@ -0,0 +1,38 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Bookings are dispatched once an admin has confirmed them. Existing bookings
+-- start out pending.
+ALTER TABLE book_rides ADD COLUMN status TEXT NOT NULL DEFAULT 'pending';
+ALTER TABLE book_rides ADD CONSTRAINT book_rides_status_check
+    CHECK (status IN ('pending', 'confirmed', 'cancelled'));
+-- Rider preferences used to rank drivers, e.g. {"amenities":["wifi"]}.
+ALTER TABLE book_rides ADD COLUMN preferences JSONB;
+CREATE INDEX book_rides_dispatch_idx ON book_rides (lower(occupied))
+    WHERE status = 'confirmed' AND driver_id IS NULL;
+
+-- A booking offered to a driver by the dispatcher. Each booking has at most
+-- one pending offer; a driver is offered a booking at most once.
+CREATE TABLE dispatch_offers (
+                                 id BIGSERIAL PRIMARY KEY,
+                                 booking_id BIGINT NOT NULL REFERENCES book_rides (id) ON DELETE CASCADE,
+                                 driver_id BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+                                 score DOUBLE PRECISION NOT NULL,
+                                 status TEXT NOT NULL DEFAULT 'pending'
+                                     CHECK (status IN ('pending', 'accepted', 'declined', 'expired', 'withdrawn')),
+                                 created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                                 expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+                                 responded_at TIMESTAMP WITH TIME ZONE,
+                                 UNIQUE (booking_id, driver_id)
+);
+CREATE UNIQUE INDEX dispatch_offers_pending_idx ON dispatch_offers (booking_id) WHERE status = 'pending';
+CREATE INDEX dispatch_offers_driver_id_idx ON dispatch_offers (driver_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE dispatch_offers;
+DROP INDEX book_rides_dispatch_idx;
+ALTER TABLE book_rides DROP COLUMN preferences;
+ALTER TABLE book_rides DROP CONSTRAINT book_rides_status_check;
+ALTER TABLE book_rides DROP COLUMN status;
+-- +goose StatementEnd
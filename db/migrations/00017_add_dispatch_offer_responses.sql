@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+-- responded_by is the user who answered: the driver, or an admin on their
+-- behalf; NULL for offers accepted by the dispatcher in auto mode.
+ALTER TABLE dispatch_offers ADD COLUMN responded_by BIGINT REFERENCES users (id) ON DELETE SET NULL;
+ALTER TABLE dispatch_offers ADD COLUMN decline_reason TEXT NOT NULL DEFAULT '';
+CREATE INDEX dispatch_offers_created_at_idx ON dispatch_offers (created_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX dispatch_offers_created_at_idx;
+ALTER TABLE dispatch_offers DROP COLUMN decline_reason;
+ALTER TABLE dispatch_offers DROP COLUMN responded_by;
+-- +goose StatementEnd
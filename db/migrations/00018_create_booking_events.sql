@@ -0,0 +1,66 @@
+-- +goose Up
+-- +goose StatementBegin
+-- A change to a booking, streamed to riders and drivers. Events carry no rider
+-- PII; clients fetch the booking for its details. booking_id has no foreign
+-- key so the event of a deleted booking outlives it.
+CREATE TABLE booking_events (
+                                id BIGSERIAL PRIMARY KEY,
+                                booking_id BIGINT NOT NULL,
+                                type TEXT NOT NULL CHECK (type IN ('created', 'updated', 'deleted')),
+                                status TEXT NOT NULL,
+                                driver_id BIGINT,
+                                vehicle_id BIGINT,
+                                created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX booking_events_booking_id_idx ON booking_events (booking_id, id);
+CREATE INDEX booking_events_created_at_idx ON booking_events (created_at);
+
+-- record_booking_event stores an event for the changed booking and announces
+-- it on the booking_events channel, once the transaction commits, to every
+-- server listening.
+CREATE FUNCTION record_booking_event() RETURNS trigger AS $$
+DECLARE
+    ride book_rides;
+    event booking_events;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        ride := OLD;
+    ELSE
+        ride := NEW;
+    END IF;
+    INSERT INTO booking_events (booking_id, type, status, driver_id, vehicle_id)
+    VALUES (ride.id,
+            CASE TG_OP WHEN 'INSERT' THEN 'created' WHEN 'UPDATE' THEN 'updated' ELSE 'deleted' END,
+            ride.status, ride.driver_id, ride.vehicle_id)
+    RETURNING * INTO event;
+    PERFORM pg_notify('booking_events', row_to_json(event)::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER book_rides_events_insert_delete
+    AFTER INSERT OR DELETE ON book_rides
+    FOR EACH ROW EXECUTE FUNCTION record_booking_event();
+-- Encrypted columns are rewritten whenever keys are rotated, so only changes
+-- to the plaintext columns count: an edit of the rider's contact details or
+-- notes alone is not announced.
+CREATE TRIGGER book_rides_events_update
+    AFTER UPDATE ON book_rides
+    FOR EACH ROW
+    WHEN ((OLD.ride_type, OLD.date, OLD.time, OLD.number_of_passengers, OLD.number_of_luggage, OLD.geocode_status,
+           OLD.zone_id, OLD.route_distance_meters, OLD.vehicle_id, OLD.hours, OLD.driver_id, OLD.occupied,
+           OLD.status, OLD.preferences, OLD.erased_at)
+          IS DISTINCT FROM
+          (NEW.ride_type, NEW.date, NEW.time, NEW.number_of_passengers, NEW.number_of_luggage, NEW.geocode_status,
+           NEW.zone_id, NEW.route_distance_meters, NEW.vehicle_id, NEW.hours, NEW.driver_id, NEW.occupied,
+           NEW.status, NEW.preferences, NEW.erased_at))
+    EXECUTE FUNCTION record_booking_event();
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TRIGGER book_rides_events_update ON book_rides;
+DROP TRIGGER book_rides_events_insert_delete ON book_rides;
+DROP FUNCTION record_booking_event();
+DROP TABLE booking_events;
+-- +goose StatementEnd
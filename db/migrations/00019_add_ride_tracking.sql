@@ -0,0 +1,46 @@
+-- +goose Up
+-- +goose StatementBegin
+-- The assigned driver moves a confirmed booking through en_route (driving to
+-- the pickup) and in_progress (rider on board) to completed.
+ALTER TABLE book_rides DROP CONSTRAINT book_rides_status_check;
+ALTER TABLE book_rides ADD CONSTRAINT book_rides_status_check
+    CHECK (status IN ('pending', 'confirmed', 'en_route', 'in_progress', 'completed', 'cancelled'));
+
+-- The latest position reported by the driver of a booking.
+CREATE TABLE booking_locations (
+                                   booking_id BIGINT PRIMARY KEY REFERENCES book_rides (id) ON DELETE CASCADE,
+                                   driver_id BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+                                   lat DOUBLE PRECISION NOT NULL,
+                                   lng DOUBLE PRECISION NOT NULL,
+                                   accuracy_meters DOUBLE PRECISION NOT NULL,
+                                   heading DOUBLE PRECISION,
+                                   speed DOUBLE PRECISION,
+                                   recorded_at TIMESTAMP WITH TIME ZONE NOT NULL,
+                                   updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- The breadcrumb trail of a booking, trimmed to the most recent positions.
+CREATE TABLE booking_location_trail (
+                                        id BIGSERIAL PRIMARY KEY,
+                                        booking_id BIGINT NOT NULL REFERENCES book_rides (id) ON DELETE CASCADE,
+                                        driver_id BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+                                        lat DOUBLE PRECISION NOT NULL,
+                                        lng DOUBLE PRECISION NOT NULL,
+                                        accuracy_meters DOUBLE PRECISION NOT NULL,
+                                        heading DOUBLE PRECISION,
+                                        speed DOUBLE PRECISION,
+                                        recorded_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+CREATE INDEX booking_location_trail_booking_id_idx ON booking_location_trail (booking_id, recorded_at);
+CREATE INDEX booking_location_trail_recorded_at_idx ON booking_location_trail (recorded_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE booking_location_trail;
+DROP TABLE booking_locations;
+UPDATE book_rides SET status = 'confirmed' WHERE status IN ('en_route', 'in_progress', 'completed');
+ALTER TABLE book_rides DROP CONSTRAINT book_rides_status_check;
+ALTER TABLE book_rides ADD CONSTRAINT book_rides_status_check
+    CHECK (status IN ('pending', 'confirmed', 'cancelled'));
+-- +goose StatementEnd
@@ -0,0 +1,48 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Offers are announced as booking events too, so the driver app hears about
+-- them without polling: offered when one is made and offer_closed when it is
+-- answered, expires or is withdrawn. driver_id of these events is the driver
+-- the offer was made to.
+ALTER TABLE booking_events ADD COLUMN offer_id BIGINT;
+ALTER TABLE booking_events DROP CONSTRAINT booking_events_type_check;
+ALTER TABLE booking_events ADD CONSTRAINT booking_events_type_check
+    CHECK (type IN ('created', 'updated', 'deleted', 'offered', 'offer_closed'));
+
+CREATE FUNCTION record_offer_event() RETURNS trigger AS $$
+DECLARE
+    ride book_rides;
+    event booking_events;
+BEGIN
+    SELECT * INTO ride FROM book_rides WHERE id = NEW.booking_id;
+    INSERT INTO booking_events (booking_id, type, status, driver_id, vehicle_id, offer_id)
+    VALUES (NEW.booking_id,
+            CASE TG_OP WHEN 'INSERT' THEN 'offered' ELSE 'offer_closed' END,
+            COALESCE(ride.status, 'cancelled'), NEW.driver_id, ride.vehicle_id, NEW.id)
+    RETURNING * INTO event;
+    PERFORM pg_notify('booking_events', row_to_json(event)::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER dispatch_offers_events_insert
+    AFTER INSERT ON dispatch_offers
+    FOR EACH ROW EXECUTE FUNCTION record_offer_event();
+CREATE TRIGGER dispatch_offers_events_close
+    AFTER UPDATE OF status ON dispatch_offers
+    FOR EACH ROW
+    WHEN (OLD.status = 'pending' AND NEW.status <> 'pending')
+    EXECUTE FUNCTION record_offer_event();
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TRIGGER dispatch_offers_events_close ON dispatch_offers;
+DROP TRIGGER dispatch_offers_events_insert ON dispatch_offers;
+DROP FUNCTION record_offer_event();
+DELETE FROM booking_events WHERE type IN ('offered', 'offer_closed');
+ALTER TABLE booking_events DROP CONSTRAINT booking_events_type_check;
+ALTER TABLE booking_events ADD CONSTRAINT booking_events_type_check
+    CHECK (type IN ('created', 'updated', 'deleted'));
+ALTER TABLE booking_events DROP COLUMN offer_id;
+-- +goose StatementEnd
@@ -0,0 +1,30 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Drivers mark arriving at the pickup, so waiting time can be charged.
+ALTER TABLE book_rides DROP CONSTRAINT book_rides_status_check;
+ALTER TABLE book_rides ADD CONSTRAINT book_rides_status_check
+    CHECK (status IN ('pending', 'confirmed', 'en_route', 'arrived', 'in_progress', 'completed', 'cancelled'));
+
+-- What actually happened on a ride, recorded as the driver moves it along,
+-- and the fare charged for it next to the quote once completed.
+CREATE TABLE booking_trips (
+                               booking_id BIGINT PRIMARY KEY REFERENCES book_rides (id) ON DELETE CASCADE,
+                               arrived_at TIMESTAMP WITH TIME ZONE,
+                               started_at TIMESTAMP WITH TIME ZONE,
+                               ended_at TIMESTAMP WITH TIME ZONE,
+                               start_odometer DOUBLE PRECISION,
+                               end_odometer DOUBLE PRECISION,
+                               quote JSONB,
+                               fare JSONB,
+                               updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE booking_trips;
+UPDATE book_rides SET status = 'en_route' WHERE status = 'arrived';
+ALTER TABLE book_rides DROP CONSTRAINT book_rides_status_check;
+ALTER TABLE book_rides ADD CONSTRAINT book_rides_status_check
+    CHECK (status IN ('pending', 'confirmed', 'en_route', 'in_progress', 'completed', 'cancelled'));
+-- +goose StatementEnd
@@ -0,0 +1,28 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Add-on charges drivers attach to a ride, such as tolls they paid, with an
+-- optional photo of the receipt. Approved charges are added to the fare.
+CREATE TABLE trip_charges (
+                              id BIGSERIAL PRIMARY KEY,
+                              booking_id BIGINT NOT NULL REFERENCES book_rides (id) ON DELETE CASCADE,
+                              driver_id BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+                              kind TEXT NOT NULL CHECK (kind IN ('toll', 'parking', 'extra_stop', 'cleaning', 'gratuity')),
+                              description TEXT NOT NULL DEFAULT '',
+                              amount_cents BIGINT NOT NULL CHECK (amount_cents > 0),
+                              receipt BYTEA,
+                              receipt_content_type TEXT,
+                              status TEXT NOT NULL DEFAULT 'pending'
+                                  CHECK (status IN ('pending', 'approved', 'rejected')),
+                              created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                              reviewed_by BIGINT REFERENCES users (id) ON DELETE SET NULL,
+                              reviewed_at TIMESTAMP WITH TIME ZONE,
+                              review_note TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX trip_charges_booking_id_idx ON trip_charges (booking_id);
+CREATE INDEX trip_charges_pending_idx ON trip_charges (created_at) WHERE status = 'pending';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE trip_charges;
+-- +goose StatementEnd
@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+-- When the booking was made. Retention falls back to it for bookings whose
+-- ride date is not YYYY-MM-DD. Existing bookings take their oldest event, or
+-- the time of this migration once their events have been purged.
+ALTER TABLE book_rides ADD COLUMN created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP;
+UPDATE book_rides SET created_at = e.created_at
+FROM (SELECT booking_id, min(created_at) AS created_at FROM booking_events GROUP BY booking_id) e
+WHERE e.booking_id = book_rides.id;
+CREATE INDEX book_rides_created_at_idx ON book_rides (created_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE book_rides DROP COLUMN created_at;
+-- +goose StatementEnd
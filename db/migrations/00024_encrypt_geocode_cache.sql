@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Entries reveal the places riders looked up: key becomes a keyed HMAC of the
+-- query hash and result is encrypted under a data key wrapped by key_id, like
+-- rider PII in book_rides. The unprotected entries are dropped; they are
+-- looked up again on demand.
+DELETE FROM geocode_cache;
+ALTER TABLE geocode_cache ALTER COLUMN result TYPE TEXT;
+ALTER TABLE geocode_cache ADD COLUMN key_id TEXT;
+ALTER TABLE geocode_cache ADD COLUMN data_key BYTEA;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM geocode_cache;
+ALTER TABLE geocode_cache DROP COLUMN data_key;
+ALTER TABLE geocode_cache DROP COLUMN key_id;
+ALTER TABLE geocode_cache ALTER COLUMN result TYPE JSONB USING result::jsonb;
+-- +goose StatementEnd
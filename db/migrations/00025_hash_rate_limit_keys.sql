@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Bucket keys are now a keyed HMAC; the existing keys hold client IPs and
+-- email addresses in the clear. Dropping them only resets the buckets.
+DELETE FROM rate_limit_buckets;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM rate_limit_buckets;
+-- +goose StatementEnd
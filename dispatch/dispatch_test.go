@@ -0,0 +1,260 @@
+package dispatch
+
+import (
+	"context"
+	"errors"
+	"luxsuv-backend/config"
+	"luxsuv-backend/data"
+	"luxsuv-backend/logger"
+	"luxsuv-backend/schedule"
+	"testing"
+	"time"
+)
+
+func newTestScheduler(t *testing.T) *schedule.Scheduler {
+	t.Helper()
+	s, err := schedule.New(config.Defaults().Scheduling)
+	if err != nil {
+		t.Fatalf("schedule.New failed: %v", err)
+	}
+	return s
+}
+
+func vehicle(id int64, seats int, amenities ...string) *data.Vehicle {
+	return &data.Vehicle{ID: id, Seats: seats, LuggageCapacity: 4, Amenities: amenities, Status: data.VehicleActive}
+}
+
+func point(lat, lng float64) data.Location {
+	return data.Location{Address: "somewhere", Lat: &lat, Lng: &lng}
+}
+
+// confirmedRide returns a confirmed per_ride booking picked up at pickup and
+// occupying its driver for 90 minutes.
+func confirmedRide(pickup time.Time, passengers int) *data.BookRide {
+	return &data.BookRide{
+		RideType:           "per_ride",
+		Date:               pickup.Format(schedule.DateLayout),
+		Time:               pickup.Format("15:04"),
+		NumberOfPassengers: passengers,
+		Status:             data.BookingConfirmed,
+		Occupied:           &data.TimeRange{Start: pickup, End: pickup.Add(90 * time.Minute)},
+	}
+}
+
+func TestRank(t *testing.T) {
+	sched := newTestScheduler(t)
+	la := sched.Location()
+	pickup := time.Date(2026, 3, 6, 15, 0, 0, 0, la)
+	ranker := NewRanker(sched, config.Defaults().Dispatch.Weights)
+
+	ride := confirmedRide(pickup, 4)
+	ride.PickupLocation = point(34.05, -118.25)
+	drivers := []data.DriverSchedule{
+		{DriverID: 1, Vehicle: vehicle(1, 7)},
+		{DriverID: 2, Vehicle: vehicle(2, 4)},
+		{DriverID: 3, Vehicle: vehicle(3, 3)}, // too small
+		{DriverID: 4, Vehicle: vehicle(4, 4), Busy: []data.TimeRange{{Start: pickup.Add(time.Hour), End: pickup.Add(2 * time.Hour)}}},
+	}
+	got := ranker.Rank(ride, drivers, nil)
+	if len(got) != 2 || got[0].DriverID != 2 || got[1].DriverID != 1 {
+		t.Fatalf("Expected drivers 2 then 1, got %+v", got)
+	}
+
+	// A previous drop-off next to the pickup outweighs the better fit.
+	driver1 := int64(1)
+	earlier := confirmedRide(pickup.Add(-3*time.Hour), 2)
+	earlier.DriverID = &driver1
+	earlier.DropoffLocation = point(34.051, -118.251)
+	got = ranker.Rank(ride, drivers, []*data.BookRide{earlier})
+	if got[0].DriverID != 1 || got[0].Factors.Distance < 0.9 {
+		t.Errorf("Expected driver 1 first after a nearby drop-off, got %+v", got)
+	}
+	if got[0].Factors.Workload != 0.5 || got[1].Factors.Workload != 1 {
+		t.Errorf("Expected workload to count the earlier booking, got %+v", got)
+	}
+
+	// Preferences: the preferred driver and requested amenities count.
+	driver1 = 1
+	ride.Preferences = &data.RidePreferences{Amenities: []string{"wifi"}, PreferredDriverID: &driver1}
+	drivers[0].Vehicle = vehicle(1, 7, "wifi")
+	got = ranker.Rank(ride, drivers, nil)
+	if got[0].DriverID != 1 || got[0].Factors.Preference != 1 || got[1].Factors.Preference != 0 {
+		t.Errorf("Expected the preferred driver with wifi first, got %+v", got)
+	}
+}
+
+func TestSimulatedOffers(t *testing.T) {
+	sched := newTestScheduler(t)
+	la := sched.Location()
+	start := time.Date(2026, 3, 6, 8, 0, 0, 0, la)
+	cfg := config.Defaults().Dispatch
+	cfg.Mode = config.DispatchPropose
+	ctx := context.Background()
+
+	sim := NewSimulation(start)
+	sim.AddDriver(data.DriverSchedule{DriverID: 1, Vehicle: vehicle(1, 4)}, Decline)
+	sim.AddDriver(data.DriverSchedule{DriverID: 2, Vehicle: vehicle(2, 5)}, Ignore)
+	sim.AddDriver(data.DriverSchedule{DriverID: 3, Vehicle: vehicle(3, 7)}, Accept)
+	id := sim.AddBookRide(confirmedRide(start.Add(4*time.Hour), 4))
+	pending := confirmedRide(start.Add(4*time.Hour), 4)
+	pending.Status = data.BookingPending
+	sim.AddBookRide(pending)
+
+	engine, err := NewSimulated(sim, sched, cfg, logger.NewLogger())
+	if err != nil {
+		t.Fatalf("NewSimulated failed: %v", err)
+	}
+	step := func() Result {
+		t.Helper()
+		result, err := engine.RunOnce(ctx)
+		if err != nil {
+			t.Fatalf("RunOnce failed: %v", err)
+		}
+		if err := sim.Respond(ctx); err != nil {
+			t.Fatalf("Respond failed: %v", err)
+		}
+		return result
+	}
+
+	// The best fit declines, the next ignores the offer until it expires,
+	// and the last accepts.
+	if r := step(); r.Offered != 1 {
+		t.Fatalf("Expected one offer, got %+v", r)
+	}
+	sim.Advance(time.Minute)
+	if r := step(); r.Offered != 1 {
+		t.Fatalf("Expected an offer to the next driver, got %+v", r)
+	}
+	sim.Advance(time.Minute)
+	if r := step(); r.Offered != 0 {
+		t.Fatalf("Expected the pending offer to be left alone, got %+v", r)
+	}
+	sim.Advance(10 * time.Minute)
+	if r := step(); r.Expired != 1 || r.Offered != 1 {
+		t.Fatalf("Expected the offer to expire and go to the last driver, got %+v", r)
+	}
+
+	var statuses []string
+	for _, o := range sim.Offers() {
+		statuses = append(statuses, o.Status)
+	}
+	want := []string{data.OfferDeclined, data.OfferExpired, data.OfferAccepted}
+	if len(statuses) != len(want) {
+		t.Fatalf("Expected offers %v, got %v", want, statuses)
+	}
+	for i := range want {
+		if statuses[i] != want[i] || sim.Offers()[i].DriverID != int64(i+1) {
+			t.Errorf("Offer %d: expected %s to driver %d, got %+v", i, want[i], i+1, sim.Offers()[i])
+		}
+	}
+	if o := sim.Offers()[0]; o.DeclineReason == "" || o.RespondedBy == nil || *o.RespondedBy != 1 {
+		t.Errorf("Expected the decline to record driver 1 and a reason, got %+v", o)
+	}
+	if o := sim.Offers()[1]; o.RespondedBy != nil || o.RespondedAt != nil {
+		t.Errorf("Expected the expired offer to have no response, got %+v", o)
+	}
+	if ride := sim.BookRide(id); ride.DriverID == nil || *ride.DriverID != 3 || ride.VehicleID == nil || *ride.VehicleID != 3 {
+		t.Errorf("Expected driver 3 and their vehicle assigned, got %+v", ride)
+	}
+
+	// Once every driver has been tried nothing more happens.
+	if r := step(); r.Offered != 0 || r.Unmatched != 0 {
+		t.Errorf("Expected nothing to dispatch, got %+v", r)
+	}
+}
+
+func TestSimulatedAutoAssign(t *testing.T) {
+	sched := newTestScheduler(t)
+	la := sched.Location()
+	start := time.Date(2026, 3, 6, 8, 0, 0, 0, la)
+	cfg := config.Defaults().Dispatch
+	cfg.Mode = config.DispatchAuto
+
+	sim := NewSimulation(start)
+	sim.AddDriver(data.DriverSchedule{DriverID: 1, Vehicle: vehicle(1, 4)}, Ignore)
+	sim.AddDriver(data.DriverSchedule{DriverID: 2, Vehicle: vehicle(2, 4)}, Ignore)
+	var ids []int64
+	for i := 0; i < 3; i++ {
+		ids = append(ids, sim.AddBookRide(confirmedRide(start.Add(2*time.Hour+time.Duration(i)*10*time.Minute), 2)))
+	}
+	// Beyond the lookahead.
+	far := sim.AddBookRide(confirmedRide(start.Add(100*time.Hour), 2))
+
+	engine, err := NewSimulated(sim, sched, cfg, logger.NewLogger())
+	if err != nil {
+		t.Fatalf("NewSimulated failed: %v", err)
+	}
+	result, err := engine.RunOnce(context.Background())
+	if err != nil {
+		t.Fatalf("RunOnce failed: %v", err)
+	}
+	if result.Assigned != 2 || result.Unmatched != 1 {
+		t.Errorf("Expected 2 assigned and 1 unmatched, got %+v", result)
+	}
+	first, second := sim.BookRide(ids[0]), sim.BookRide(ids[1])
+	if first.DriverID == nil || second.DriverID == nil || *first.DriverID == *second.DriverID {
+		t.Errorf("Expected overlapping bookings to go to different drivers, got %v and %v", first.DriverID, second.DriverID)
+	}
+	if sim.BookRide(ids[2]).DriverID != nil || sim.BookRide(far).DriverID != nil {
+		t.Error("Expected the third and the distant booking to stay unassigned")
+	}
+}
+
+func TestSimulatedAcceptRechecksDriver(t *testing.T) {
+	sched := newTestScheduler(t)
+	start := time.Date(2026, 3, 6, 8, 0, 0, 0, sched.Location())
+	cfg := config.Defaults().Dispatch
+	cfg.Mode = config.DispatchPropose
+
+	sim := NewSimulation(start)
+	van := vehicle(1, 4)
+	sim.AddDriver(data.DriverSchedule{DriverID: 1, Vehicle: van}, Ignore)
+	id := sim.AddBookRide(confirmedRide(start.Add(2*time.Hour), 2))
+	engine, err := NewSimulated(sim, sched, cfg, logger.NewLogger())
+	if err != nil {
+		t.Fatalf("NewSimulated failed: %v", err)
+	}
+	if result, err := engine.RunOnce(context.Background()); err != nil || result.Offered != 1 {
+		t.Fatalf("Expected the booking to be offered, got %+v, %v", result, err)
+	}
+
+	// The vehicle goes into the garage before the driver accepts.
+	van.Status = data.VehicleMaintenance
+	driverID := int64(1)
+	accept := data.OfferResponse{Accept: true, DriverID: &driverID, CanTake: sched.CanTake}
+	if _, err := sim.RespondDispatchOffer(context.Background(), 1, accept); !errors.Is(err, data.ErrOfferClosed) {
+		t.Errorf("Expected ErrOfferClosed, got %v", err)
+	}
+	if sim.BookRide(id).DriverID != nil {
+		t.Error("Expected the booking to stay unassigned")
+	}
+
+	van.Status = data.VehicleActive
+	if _, err := sim.RespondDispatchOffer(context.Background(), 1, accept); err != nil || sim.BookRide(id).DriverID == nil {
+		t.Errorf("Expected the offer to be accepted, got %v", err)
+	}
+}
+
+func TestTrigger(t *testing.T) {
+	var nilEngine *Engine
+	nilEngine.Trigger()
+
+	cfg := config.Defaults().Dispatch
+	engine, err := NewSimulated(NewSimulation(time.Now()), newTestScheduler(t), cfg, logger.NewLogger())
+	if err != nil {
+		t.Fatalf("NewSimulated failed: %v", err)
+	}
+	// Triggers coalesce rather than block while a run is pending.
+	engine.Trigger()
+	engine.Trigger()
+	select {
+	case <-engine.trigger:
+	default:
+		t.Fatal("Expected a pending trigger")
+	}
+	select {
+	case <-engine.trigger:
+		t.Fatal("Expected triggers to coalesce")
+	default:
+	}
+}
@@ -0,0 +1,366 @@
+// Package dispatch finds drivers for confirmed bookings. Every run it expires
+// stale offers, ranks the drivers who can take each booking still waiting for
+// one, and either offers the booking to the best driver not yet tried or, in
+// auto mode, assigns them straight away. A booking whose offer is declined or
+// expires goes to the next driver on the following run, which Trigger and the
+// expiry worker started by RunExpiry bring forward.
+//
+// Runs are published with expvar:
+//
+//	dispatch_runs        completed runs
+//	dispatch_offers      offers made
+//	dispatch_assigned    bookings assigned automatically
+//	dispatch_unmatched   bookings a run found no driver for
+//	dispatch_expired     offers that expired unanswered
+//	dispatch_failures    runs and expiry sweeps that failed
+package dispatch
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"luxsuv-backend/config"
+	"luxsuv-backend/data"
+	"luxsuv-backend/logger"
+	"luxsuv-backend/schedule"
+	"time"
+)
+
+var (
+	runs      = expvar.NewInt("dispatch_runs")
+	offers    = expvar.NewInt("dispatch_offers")
+	assigned  = expvar.NewInt("dispatch_assigned")
+	unmatched = expvar.NewInt("dispatch_unmatched")
+	expired   = expvar.NewInt("dispatch_expired")
+	failures  = expvar.NewInt("dispatch_failures")
+)
+
+// historyWindow is how far around the dispatched bookings assigned bookings
+// are loaded, for previous drop-offs and daily workloads.
+const historyWindow = 24 * time.Hour
+
+// Store is the data the dispatcher works on.
+type Store interface {
+	// ListDispatchableBookRides returns the confirmed bookings without a
+	// driver whose pickup falls in window, ordered by pickup.
+	ListDispatchableBookRides(ctx context.Context, window data.TimeRange) ([]*data.BookRide, error)
+	ListAssignedBookRides(ctx context.Context, window data.TimeRange) ([]*data.BookRide, error)
+	ListDriverSchedules(ctx context.Context, window data.TimeRange) ([]data.DriverSchedule, error)
+	ListDispatchOffers(ctx context.Context, status string, bookingIDs []int64) ([]*data.DispatchOffer, error)
+	CreateDispatchOffer(ctx context.Context, offer *data.DispatchOffer) (*data.DispatchOffer, error)
+	// RespondDispatchOffer accepts or declines a pending offer; accepting
+	// assigns the driver.
+	RespondDispatchOffer(ctx context.Context, id int64, resp data.OfferResponse) (*data.DispatchOffer, error)
+	// ExpireDispatchOffers expires the pending offers that expired by now.
+	ExpireDispatchOffers(ctx context.Context, now time.Time) (int64, error)
+}
+
+// Result counts what a run did.
+type Result struct {
+	Expired   int64
+	Offered   int
+	Assigned  int
+	Unmatched int
+}
+
+// Engine dispatches bookings every interval, and as soon as possible after
+// Trigger.
+type Engine struct {
+	store          Store
+	ranker         *Ranker
+	logger         *logger.Logger
+	mode           string
+	interval       time.Duration
+	offerTimeout   time.Duration
+	expiryInterval time.Duration
+	lookahead      time.Duration
+	now            func() time.Time
+	trigger        chan struct{}
+}
+
+// New returns an Engine for cfg, which must have been validated by
+// config.Load.
+func New(store Store, sched *schedule.Scheduler, cfg config.DispatchConfig, logger *logger.Logger) (*Engine, error) {
+	interval, err := time.ParseDuration(cfg.Interval)
+	if err != nil {
+		return nil, fmt.Errorf("invalid dispatch interval: %w", err)
+	}
+	offerTimeout, err := time.ParseDuration(cfg.OfferTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("invalid offer timeout: %w", err)
+	}
+	expiryInterval, err := time.ParseDuration(cfg.ExpiryInterval)
+	if err != nil {
+		return nil, fmt.Errorf("invalid offer expiry interval: %w", err)
+	}
+	lookahead, err := time.ParseDuration(cfg.Lookahead)
+	if err != nil {
+		return nil, fmt.Errorf("invalid dispatch lookahead: %w", err)
+	}
+	return &Engine{
+		store:          store,
+		ranker:         NewRanker(sched, cfg.Weights),
+		logger:         logger,
+		mode:           cfg.Mode,
+		interval:       interval,
+		offerTimeout:   offerTimeout,
+		expiryInterval: expiryInterval,
+		lookahead:      lookahead,
+		now:            time.Now,
+		trigger:        make(chan struct{}, 1),
+	}, nil
+}
+
+// Run dispatches immediately and then every interval, or sooner when
+// triggered, until ctx is cancelled. It returns at once if dispatch is off.
+func (e *Engine) Run(ctx context.Context) {
+	if e.mode == config.DispatchOff {
+		e.logger.Info("Dispatch disabled")
+		return
+	}
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for {
+		if _, err := e.RunOnce(ctx); err != nil {
+			failures.Add(1)
+			e.logger.Error(fmt.Sprintf("Dispatch: %v", err))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-e.trigger:
+		}
+	}
+}
+
+// RunExpiry closes the offers that have expired every expiry interval until
+// ctx is cancelled, triggering a run if it closed any so their bookings go to
+// the next driver without waiting for the next interval. It returns at once
+// if dispatch is off.
+func (e *Engine) RunExpiry(ctx context.Context) {
+	if e.mode == config.DispatchOff {
+		return
+	}
+	ticker := time.NewTicker(e.expiryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		n, err := e.ExpireOffers(ctx)
+		if err != nil {
+			failures.Add(1)
+			e.logger.Error(fmt.Sprintf("Dispatch: failed to expire offers: %v", err))
+			continue
+		}
+		if n > 0 {
+			e.logger.Info(fmt.Sprintf("Dispatch: %d offers expired", n))
+			e.Trigger()
+		}
+	}
+}
+
+// ExpireOffers closes the pending offers that have expired and returns how
+// many there were.
+func (e *Engine) ExpireOffers(ctx context.Context) (int64, error) {
+	n, err := e.store.ExpireDispatchOffers(ctx, e.now())
+	if err != nil {
+		return 0, err
+	}
+	expired.Add(n)
+	return n, nil
+}
+
+// Trigger asks Run to dispatch as soon as possible, e.g. because an offer
+// was declined. It never blocks and does nothing on a nil Engine.
+func (e *Engine) Trigger() {
+	if e == nil {
+		return
+	}
+	select {
+	case e.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// RunOnce expires stale offers and dispatches every booking waiting for a
+// driver whose pickup is within the lookahead. Failing to offer or assign
+// one booking is logged and does not stop the others.
+func (e *Engine) RunOnce(ctx context.Context) (Result, error) {
+	var result Result
+	now := e.now()
+	n, err := e.ExpireOffers(ctx)
+	if err != nil {
+		return result, err
+	}
+	result.Expired = n
+
+	window := data.TimeRange{Start: now, End: now.Add(e.lookahead)}
+	rides, err := e.store.ListDispatchableBookRides(ctx, window)
+	if err != nil {
+		return result, err
+	}
+	if len(rides) > 0 {
+		if err := e.dispatch(ctx, now, window, rides, &result); err != nil {
+			return result, err
+		}
+	}
+
+	runs.Add(1)
+	offers.Add(int64(result.Offered))
+	assigned.Add(int64(result.Assigned))
+	unmatched.Add(int64(result.Unmatched))
+	if result.Expired+int64(result.Offered+result.Assigned+result.Unmatched) > 0 {
+		e.logger.Info(fmt.Sprintf("Dispatch: %d offers expired, %d offered, %d assigned, %d without a driver",
+			result.Expired, result.Offered, result.Assigned, result.Unmatched))
+	}
+	return result, nil
+}
+
+func (e *Engine) dispatch(ctx context.Context, now time.Time, window data.TimeRange, rides []*data.BookRide, result *Result) error {
+	around := data.TimeRange{Start: window.Start.Add(-historyWindow), End: window.End.Add(historyWindow)}
+	drivers, err := e.store.ListDriverSchedules(ctx, around)
+	if err != nil {
+		return err
+	}
+	taken, err := e.store.ListAssignedBookRides(ctx, around)
+	if err != nil {
+		return err
+	}
+	ids := make([]int64, len(rides))
+	for i, ride := range rides {
+		ids[i] = ride.ID
+	}
+	history, err := e.store.ListDispatchOffers(ctx, "", ids)
+	if err != nil {
+		return err
+	}
+
+	// Drivers are offered a booking at most once, and one with a pending
+	// offer is held for it until they answer.
+	byID := map[int64]*data.BookRide{}
+	for _, ride := range rides {
+		byID[ride.ID] = ride
+	}
+	tried := map[int64]map[int64]bool{}
+	pending := map[int64]bool{}
+	for _, o := range history {
+		if tried[o.BookingID] == nil {
+			tried[o.BookingID] = map[int64]bool{}
+		}
+		tried[o.BookingID][o.DriverID] = true
+		if o.Status == data.OfferPending {
+			pending[o.BookingID] = true
+			hold(drivers, o.DriverID, byID[o.BookingID])
+		}
+	}
+
+	for _, ride := range rides {
+		if pending[ride.ID] {
+			continue
+		}
+		chosen, ok := e.choose(ctx, now, ride, drivers, taken, tried[ride.ID], result)
+		if !ok {
+			result.Unmatched++
+			continue
+		}
+		hold(drivers, chosen, ride)
+		if e.mode == config.DispatchAuto {
+			driverID := chosen
+			ride.DriverID = &driverID
+			taken = append(taken, ride)
+		}
+	}
+	return nil
+}
+
+// choose offers ride to the best driver not yet tried, or in auto mode
+// assigns them, moving down the ranking if that fails. It returns the
+// driver and whether one was found.
+func (e *Engine) choose(ctx context.Context, now time.Time, ride *data.BookRide, drivers []data.DriverSchedule,
+	taken []*data.BookRide, tried map[int64]bool, result *Result) (int64, bool) {
+	for _, c := range e.ranker.Rank(ride, drivers, taken) {
+		if tried[c.DriverID] {
+			continue
+		}
+		offer, err := e.store.CreateDispatchOffer(ctx, &data.DispatchOffer{
+			BookingID: ride.ID,
+			DriverID:  c.DriverID,
+			Score:     c.Score,
+			ExpiresAt: now.Add(e.offerTimeout),
+		})
+		if err != nil {
+			e.logger.Error(fmt.Sprintf("Dispatch: failed to offer booking %d to driver %d: %v", ride.ID, c.DriverID, err))
+			return 0, false
+		}
+		if e.mode != config.DispatchAuto {
+			result.Offered++
+			return c.DriverID, true
+		}
+		if _, err := e.store.RespondDispatchOffer(ctx, offer.ID, data.OfferResponse{Accept: true, CanTake: e.ranker.sched.CanTake}); err != nil {
+			e.logger.Error(fmt.Sprintf("Dispatch: failed to assign booking %d to driver %d: %v", ride.ID, c.DriverID, err))
+			// Close the offer so the booking can go to the next driver.
+			if _, err := e.store.RespondDispatchOffer(ctx, offer.ID, data.OfferResponse{Reason: "assignment failed"}); err != nil && !errors.Is(err, data.ErrOfferClosed) {
+				return 0, false
+			}
+			continue
+		}
+		result.Assigned++
+		return c.DriverID, true
+	}
+	return 0, false
+}
+
+// Candidates ranks the drivers for a booking as the dispatcher would, for
+// admins choosing a driver by hand. Offers already made are not taken into
+// account.
+func (e *Engine) Candidates(ctx context.Context, ride *data.BookRide) ([]Candidate, error) {
+	if ride.Occupied == nil {
+		return []Candidate{}, nil
+	}
+	around := data.TimeRange{Start: ride.Occupied.Start.Add(-historyWindow), End: ride.Occupied.End.Add(historyWindow)}
+	drivers, err := e.store.ListDriverSchedules(ctx, around)
+	if err != nil {
+		return nil, err
+	}
+	taken, err := e.store.ListAssignedBookRides(ctx, around)
+	if err != nil {
+		return nil, err
+	}
+	// A booking's own driver is busy with it, not with another booking.
+	if ride.DriverID != nil {
+		for i := range drivers {
+			if drivers[i].DriverID == *ride.DriverID {
+				drivers[i].Busy = without(drivers[i].Busy, *ride.Occupied)
+			}
+		}
+	}
+	return e.ranker.Rank(ride, drivers, taken), nil
+}
+
+// hold marks a driver busy for a booking.
+func hold(drivers []data.DriverSchedule, driverID int64, ride *data.BookRide) {
+	if ride == nil || ride.Occupied == nil {
+		return
+	}
+	for i := range drivers {
+		if drivers[i].DriverID == driverID {
+			drivers[i].Busy = append(drivers[i].Busy, *ride.Occupied)
+		}
+	}
+}
+
+// without returns busy without the interval r.
+func without(busy []data.TimeRange, r data.TimeRange) []data.TimeRange {
+	var out []data.TimeRange
+	for _, b := range busy {
+		if !b.Start.Equal(r.Start) || !b.End.Equal(r.End) {
+			out = append(out, b)
+		}
+	}
+	return out
+}
@@ -0,0 +1,165 @@
+package dispatch
+
+import (
+	"luxsuv-backend/config"
+	"luxsuv-backend/data"
+	"luxsuv-backend/geo"
+	"luxsuv-backend/schedule"
+	"sort"
+)
+
+// distanceScaleMeters is the distance from the previous drop-off at which
+// the distance factor drops to one half.
+const distanceScaleMeters = 10000
+
+// unknownDistance is the distance factor of drivers without a previous
+// drop-off with coordinates.
+const unknownDistance = 0.5
+
+// Factors are the scores of a driver for a booking, each between 0 and 1.
+type Factors struct {
+	Distance   float64 `json:"distance"`
+	VehicleFit float64 `json:"vehicle_fit"`
+	Workload   float64 `json:"workload"`
+	Preference float64 `json:"preference"`
+}
+
+// Candidate is a driver who can take a booking and how well they suit it.
+type Candidate struct {
+	DriverID int64   `json:"driver_id"`
+	Score    float64 `json:"score"` // weighted mean of the factors
+	Factors  Factors `json:"factors"`
+}
+
+// Ranker orders the drivers who can take a booking.
+type Ranker struct {
+	sched   *schedule.Scheduler
+	weights config.DispatchWeights
+}
+
+// NewRanker returns a Ranker with the given weights, which must have been
+// validated by config.Load.
+func NewRanker(sched *schedule.Scheduler, weights config.DispatchWeights) *Ranker {
+	return &Ranker{sched: sched, weights: weights}
+}
+
+// Rank returns the drivers who can take ride, best first; ties go to the
+// lower driver ID so the order is deterministic. assigned are the bookings
+// already assigned to drivers around the ride's pickup; they give each
+// driver's previous drop-off and workload that day.
+func (k *Ranker) Rank(ride *data.BookRide, drivers []data.DriverSchedule, assigned []*data.BookRide) []Candidate {
+	candidates := []Candidate{}
+	for _, d := range drivers {
+		if ok, err := k.sched.CanTake(ride, d); err != nil || !ok {
+			continue
+		}
+		f := Factors{
+			Distance:   k.distance(ride, d.DriverID, assigned),
+			VehicleFit: vehicleFit(ride, d.Vehicle),
+			Workload:   k.workload(ride, d.DriverID, assigned),
+			Preference: preference(ride, d),
+		}
+		candidates = append(candidates, Candidate{DriverID: d.DriverID, Score: k.score(f), Factors: f})
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].Score != candidates[j].Score {
+			return candidates[i].Score > candidates[j].Score
+		}
+		return candidates[i].DriverID < candidates[j].DriverID
+	})
+	return candidates
+}
+
+func (k *Ranker) score(f Factors) float64 {
+	w := k.weights
+	total := w.Distance + w.VehicleFit + w.Workload + w.Preference
+	return (w.Distance*f.Distance + w.VehicleFit*f.VehicleFit + w.Workload*f.Workload + w.Preference*f.Preference) / total
+}
+
+// distance scores how close the driver's previous drop-off, the latest one
+// ending before the pickup, is to the pickup.
+func (k *Ranker) distance(ride *data.BookRide, driverID int64, assigned []*data.BookRide) float64 {
+	pickup := ride.PickupLocation
+	if ride.Occupied == nil || !pickup.HasCoordinates() {
+		return unknownDistance
+	}
+	var previous *data.BookRide
+	for _, a := range assigned {
+		if a.DriverID == nil || *a.DriverID != driverID || a.Occupied == nil || a.Occupied.End.After(ride.Occupied.Start) {
+			continue
+		}
+		if previous == nil || a.Occupied.End.After(previous.Occupied.End) {
+			previous = a
+		}
+	}
+	if previous == nil || !previous.DropoffLocation.HasCoordinates() {
+		return unknownDistance
+	}
+	dropoff := previous.DropoffLocation
+	meters := geo.Distance(geo.Point{Lat: *dropoff.Lat, Lng: *dropoff.Lng}, geo.Point{Lat: *pickup.Lat, Lng: *pickup.Lng})
+	return 1 / (1 + meters/distanceScaleMeters)
+}
+
+// vehicleFit scores how fully the party uses the vehicle, so large vehicles
+// stay free for large parties.
+func vehicleFit(ride *data.BookRide, v *data.Vehicle) float64 {
+	if v == nil || v.Seats == 0 {
+		return 0
+	}
+	seats := float64(ride.NumberOfPassengers) / float64(v.Seats)
+	luggage := 1.0
+	if v.LuggageCapacity > 0 {
+		luggage = float64(ride.NumberOfLuggage) / float64(v.LuggageCapacity)
+	}
+	return (seats + luggage) / 2
+}
+
+// workload scores how few bookings the driver already has on the day of the
+// pickup.
+func (k *Ranker) workload(ride *data.BookRide, driverID int64, assigned []*data.BookRide) float64 {
+	if ride.Occupied == nil {
+		return 1
+	}
+	loc := k.sched.Location()
+	day := ride.Occupied.Start.In(loc).Format(schedule.DateLayout)
+	n := 0
+	for _, a := range assigned {
+		if a.DriverID != nil && *a.DriverID == driverID && a.Occupied != nil &&
+			a.Occupied.Start.In(loc).Format(schedule.DateLayout) == day {
+			n++
+		}
+	}
+	return 1 / float64(1+n)
+}
+
+// preference scores the share of the rider's amenities the vehicle has and,
+// if the rider named a preferred driver, whether this is them.
+func preference(ride *data.BookRide, d data.DriverSchedule) float64 {
+	prefs := ride.Preferences
+	if prefs == nil {
+		return 1
+	}
+	amenities := 1.0
+	if len(prefs.Amenities) > 0 {
+		has := map[string]bool{}
+		if d.Vehicle != nil {
+			for _, a := range d.Vehicle.Amenities {
+				has[a] = true
+			}
+		}
+		matched := 0
+		for _, a := range prefs.Amenities {
+			if has[a] {
+				matched++
+			}
+		}
+		amenities = float64(matched) / float64(len(prefs.Amenities))
+	}
+	if prefs.PreferredDriverID == nil {
+		return amenities
+	}
+	if *prefs.PreferredDriverID == d.DriverID {
+		return (amenities + 1) / 2
+	}
+	return amenities / 2
+}
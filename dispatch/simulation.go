@@ -0,0 +1,269 @@
+package dispatch
+
+import (
+	"context"
+	"fmt"
+	"luxsuv-backend/config"
+	"luxsuv-backend/data"
+	"luxsuv-backend/logger"
+	"luxsuv-backend/schedule"
+	"sort"
+	"time"
+)
+
+// Response is how a simulated driver answers offers.
+type Response int
+
+const (
+	Accept Response = iota
+	Decline
+	Ignore // the offer is left to expire
+)
+
+// Simulation is an in-memory Store with a manual clock. With an Engine from
+// NewSimulated it replays dispatch deterministically: nothing depends on the
+// wall clock, the database or map iteration order. It is not safe for
+// concurrent use.
+type Simulation struct {
+	now       time.Time
+	rides     []*data.BookRide
+	drivers   []data.DriverSchedule
+	responses map[int64]Response
+	offers    []*data.DispatchOffer
+}
+
+var _ Store = (*Simulation)(nil)
+
+// NewSimulation returns an empty simulation whose clock starts at start.
+func NewSimulation(start time.Time) *Simulation {
+	return &Simulation{now: start, responses: map[int64]Response{}}
+}
+
+// NewSimulated returns an Engine that dispatches sim on its clock.
+func NewSimulated(sim *Simulation, sched *schedule.Scheduler, cfg config.DispatchConfig, logger *logger.Logger) (*Engine, error) {
+	e, err := New(sim, sched, cfg, logger)
+	if err != nil {
+		return nil, err
+	}
+	e.now = sim.Now
+	return e, nil
+}
+
+// Now returns the simulated time.
+func (s *Simulation) Now() time.Time {
+	return s.now
+}
+
+// Advance moves the clock forward by d.
+func (s *Simulation) Advance(d time.Duration) {
+	s.now = s.now.Add(d)
+}
+
+// AddDriver adds a driver who answers every offer with r. Their Busy
+// intervals are in addition to the bookings assigned to them.
+func (s *Simulation) AddDriver(d data.DriverSchedule, r Response) {
+	s.drivers = append(s.drivers, d)
+	s.responses[d.DriverID] = r
+}
+
+// AddBookRide adds a booking, numbering it if it has no ID, and returns its ID.
+func (s *Simulation) AddBookRide(ride *data.BookRide) int64 {
+	if ride.ID == 0 {
+		ride.ID = int64(len(s.rides) + 1)
+	}
+	c := *ride
+	s.rides = append(s.rides, &c)
+	return c.ID
+}
+
+// BookRide returns a copy of a booking, or nil if there is none with id.
+func (s *Simulation) BookRide(id int64) *data.BookRide {
+	for _, ride := range s.rides {
+		if ride.ID == id {
+			c := *ride
+			return &c
+		}
+	}
+	return nil
+}
+
+// Offers returns copies of all offers made, in order.
+func (s *Simulation) Offers() []data.DispatchOffer {
+	out := make([]data.DispatchOffer, len(s.offers))
+	for i, o := range s.offers {
+		out[i] = *o
+	}
+	return out
+}
+
+// Respond has every driver answer their pending offers.
+func (s *Simulation) Respond(ctx context.Context) error {
+	for _, o := range s.offers {
+		if o.Status != data.OfferPending || !o.ExpiresAt.After(s.now) {
+			continue
+		}
+		driverID := o.DriverID
+		resp := data.OfferResponse{DriverID: &driverID, RespondedBy: &driverID}
+		switch s.responses[o.DriverID] {
+		case Accept:
+			resp.Accept = true
+		case Decline:
+			resp.Reason = "not available"
+		default:
+			continue
+		}
+		if _, err := s.RespondDispatchOffer(ctx, o.ID, resp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Simulation) ListDispatchableBookRides(_ context.Context, window data.TimeRange) ([]*data.BookRide, error) {
+	var out []*data.BookRide
+	for _, ride := range s.rides {
+		if ride.Status == data.BookingConfirmed && ride.DriverID == nil && ride.Occupied != nil &&
+			!ride.Occupied.Start.Before(window.Start) && ride.Occupied.Start.Before(window.End) {
+			c := *ride
+			out = append(out, &c)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Occupied.Start.Before(out[j].Occupied.Start) })
+	return out, nil
+}
+
+func (s *Simulation) ListAssignedBookRides(_ context.Context, window data.TimeRange) ([]*data.BookRide, error) {
+	var out []*data.BookRide
+	for _, ride := range s.rides {
+		if ride.DriverID != nil && ride.Occupied != nil && ride.Occupied.Overlaps(window) {
+			c := *ride
+			out = append(out, &c)
+		}
+	}
+	return out, nil
+}
+
+func (s *Simulation) ListDriverSchedules(_ context.Context, window data.TimeRange) ([]data.DriverSchedule, error) {
+	out := make([]data.DriverSchedule, len(s.drivers))
+	for i, d := range s.drivers {
+		d.Busy = append([]data.TimeRange(nil), d.Busy...)
+		for _, ride := range s.rides {
+			if ride.DriverID != nil && *ride.DriverID == d.DriverID && ride.Occupied != nil && ride.Occupied.Overlaps(window) {
+				d.Busy = append(d.Busy, *ride.Occupied)
+			}
+		}
+		out[i] = d
+	}
+	return out, nil
+}
+
+func (s *Simulation) ListDispatchOffers(_ context.Context, status string, bookingIDs []int64) ([]*data.DispatchOffer, error) {
+	out := []*data.DispatchOffer{}
+	for _, o := range s.offers {
+		if status != "" && o.Status != status {
+			continue
+		}
+		if bookingIDs != nil && !contains(bookingIDs, o.BookingID) {
+			continue
+		}
+		c := *o
+		out = append(out, &c)
+	}
+	return out, nil
+}
+
+func (s *Simulation) CreateDispatchOffer(_ context.Context, offer *data.DispatchOffer) (*data.DispatchOffer, error) {
+	for _, o := range s.offers {
+		if o.BookingID == offer.BookingID && (o.DriverID == offer.DriverID || o.Status == data.OfferPending) {
+			return nil, data.ErrOfferClosed
+		}
+	}
+	c := *offer
+	c.ID = int64(len(s.offers) + 1)
+	c.Status = data.OfferPending
+	c.CreatedAt = s.now
+	s.offers = append(s.offers, &c)
+	created := c
+	return &created, nil
+}
+
+func (s *Simulation) RespondDispatchOffer(_ context.Context, id int64, resp data.OfferResponse) (*data.DispatchOffer, error) {
+	if id < 1 || id > int64(len(s.offers)) {
+		return nil, fmt.Errorf("offer %d not found", id)
+	}
+	offer := s.offers[id-1]
+	if resp.DriverID != nil && *resp.DriverID != offer.DriverID {
+		return nil, fmt.Errorf("offer %d not found", id)
+	}
+	if offer.Status != data.OfferPending || !offer.ExpiresAt.After(s.now) {
+		return nil, data.ErrOfferClosed
+	}
+	status, reason := data.OfferDeclined, resp.Reason
+	if resp.Accept {
+		status, reason = data.OfferAccepted, ""
+		var ride *data.BookRide
+		for _, r := range s.rides {
+			if r.ID == offer.BookingID {
+				ride = r
+			}
+		}
+		if ride == nil || ride.DriverID != nil || ride.Status != data.BookingConfirmed {
+			return nil, data.ErrOfferClosed
+		}
+		if resp.CanTake != nil {
+			for _, d := range s.drivers {
+				if d.DriverID != offer.DriverID {
+					continue
+				}
+				ok, err := resp.CanTake(ride, d)
+				if err != nil {
+					return nil, err
+				}
+				if !ok {
+					return nil, data.ErrOfferClosed
+				}
+			}
+		}
+		for _, r := range s.rides {
+			if r.DriverID != nil && *r.DriverID == offer.DriverID && r.Occupied != nil && ride.Occupied != nil &&
+				r.Occupied.Overlaps(*ride.Occupied) {
+				return nil, fmt.Errorf("driver %d already has booking %d at that time", offer.DriverID, r.ID)
+			}
+		}
+		driverID := offer.DriverID
+		ride.DriverID = &driverID
+		for _, d := range s.drivers {
+			if d.DriverID == driverID && d.Vehicle != nil && ride.VehicleID == nil {
+				vehicleID := d.Vehicle.ID
+				ride.VehicleID = &vehicleID
+			}
+		}
+	}
+	now := s.now
+	offer.Status = status
+	offer.RespondedAt = &now
+	offer.RespondedBy = resp.RespondedBy
+	offer.DeclineReason = reason
+	c := *offer
+	return &c, nil
+}
+
+func (s *Simulation) ExpireDispatchOffers(_ context.Context, now time.Time) (int64, error) {
+	var n int64
+	for _, o := range s.offers {
+		if o.Status == data.OfferPending && !o.ExpiresAt.After(now) {
+			o.Status = data.OfferExpired
+			n++
+		}
+	}
+	return n, nil
+}
+
+func contains(ids []int64, id int64) bool {
+	for _, x := range ids {
+		if x == id {
+			return true
+		}
+	}
+	return false
+}
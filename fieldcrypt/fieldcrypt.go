@@ -0,0 +1,235 @@
+// Package fieldcrypt encrypts individual database fields with envelope
+// encryption.
+//
+// Every row gets its own random data key. Fields are sealed with AES-256-GCM
+// under that data key, and the data key is stored next to them wrapped by a
+// master key from the Keyring, together with the master key's ID. Rotating
+// master keys therefore only means re-wrapping data keys, not re-encrypting
+// every field.
+//
+// Equality lookups on encrypted fields use a blind index: a keyed HMAC of the
+// normalized value that can be stored and compared without revealing it.
+package fieldcrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// KeySize is the required length of master, data and blind index keys.
+const KeySize = 32
+
+// Keyring holds the master keys that wrap data keys and the blind index key.
+type Keyring struct {
+	primary  string
+	keys     map[string]cipher.AEAD
+	indexKey []byte
+}
+
+// New builds a Keyring from base64 encoded 32-byte keys. New data keys are
+// wrapped with the key identified by primaryID; the others are only used to
+// unwrap existing rows until they have been re-encrypted.
+func New(primaryID string, keys map[string]string, indexKey string) (*Keyring, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no field encryption keys configured")
+	}
+	k := &Keyring{primary: primaryID, keys: make(map[string]cipher.AEAD, len(keys))}
+	for id, encoded := range keys {
+		raw, err := decodeKey(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("field encryption key %s: %w", id, err)
+		}
+		aead, err := newAEAD(raw)
+		if err != nil {
+			return nil, fmt.Errorf("field encryption key %s: %w", id, err)
+		}
+		k.keys[id] = aead
+	}
+	if _, ok := k.keys[primaryID]; !ok {
+		return nil, fmt.Errorf("primary field encryption key %q not found", primaryID)
+	}
+
+	var err error
+	if k.indexKey, err = decodeKey(indexKey); err != nil {
+		return nil, fmt.Errorf("blind index key: %w", err)
+	}
+	return k, nil
+}
+
+// LoadFromEnv builds the Keyring from the environment:
+//
+//	PII_KEYS             comma-separated kid=base64-key pairs
+//	PII_KEY_ID           kid of the key that wraps new data keys
+//	PII_BLIND_INDEX_KEY  base64 key for blind indexes; never rotated, as
+//	                     existing indexes would stop matching
+//
+// Keys are 32 random bytes, e.g. from `openssl rand -base64 32`.
+func LoadFromEnv() (*Keyring, error) {
+	keys := map[string]string{}
+	if spec := strings.TrimSpace(os.Getenv("PII_KEYS")); spec != "" {
+		for _, entry := range strings.Split(spec, ",") {
+			id, key, ok := strings.Cut(strings.TrimSpace(entry), "=")
+			if !ok || id == "" || key == "" {
+				return nil, errors.New("invalid PII_KEYS entry: expected kid=base64-key")
+			}
+			keys[id] = key
+		}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no field encryption keys configured: set PII_KEYS")
+	}
+	primaryID := os.Getenv("PII_KEY_ID")
+	if primaryID == "" {
+		if len(keys) != 1 {
+			return nil, errors.New("PII_KEY_ID must be set when more than one key is configured")
+		}
+		for id := range keys {
+			primaryID = id
+		}
+	}
+	indexKey := os.Getenv("PII_BLIND_INDEX_KEY")
+	if indexKey == "" {
+		return nil, errors.New("PII_BLIND_INDEX_KEY must be set")
+	}
+	return New(primaryID, keys, indexKey)
+}
+
+func decodeKey(encoded string) ([]byte, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64: %w", err)
+	}
+	if len(raw) != KeySize {
+		return nil, fmt.Errorf("must be %d bytes, got %d", KeySize, len(raw))
+	}
+	return raw, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// PrimaryID returns the ID of the key that wraps new data keys.
+func (k *Keyring) PrimaryID() string {
+	return k.primary
+}
+
+// BlindIndex returns the blind index of an email address. Addresses are
+// compared case-insensitively, so the value is trimmed and lowercased first.
+func (k *Keyring) BlindIndex(email string) string {
+	return k.MAC(strings.ToLower(strings.TrimSpace(email)))
+}
+
+// MAC returns the keyed HMAC of value under the blind index key, for values
+// that are looked up as they are, such as cache keys.
+func (k *Keyring) MAC(value string) string {
+	mac := hmac.New(sha256.New, k.indexKey)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Envelope is an unwrapped data key together with its wrapped form, as
+// stored in a row.
+type Envelope struct {
+	KeyID      string // ID of the master key that wrapped the data key
+	WrappedKey []byte
+	aead       cipher.AEAD
+	dataKey    []byte
+}
+
+// NewEnvelope generates a fresh data key wrapped by the primary key.
+func (k *Keyring) NewEnvelope() (*Envelope, error) {
+	dataKey := make([]byte, KeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, fmt.Errorf("failed to generate data key: %w", err)
+	}
+	return k.wrap(dataKey)
+}
+
+// OpenEnvelope unwraps a stored data key.
+func (k *Keyring) OpenEnvelope(keyID string, wrapped []byte) (*Envelope, error) {
+	master, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown field encryption key %q", keyID)
+	}
+	dataKey, err := open(master, wrapped, []byte(keyID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+	return newEnvelope(keyID, wrapped, dataKey)
+}
+
+// Rewrap returns env's data key wrapped by the primary key. Fields encrypted
+// under env stay readable with the result.
+func (k *Keyring) Rewrap(env *Envelope) (*Envelope, error) {
+	return k.wrap(env.dataKey)
+}
+
+func (k *Keyring) wrap(dataKey []byte) (*Envelope, error) {
+	wrapped, err := seal(k.keys[k.primary], dataKey, []byte(k.primary))
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap data key: %w", err)
+	}
+	return newEnvelope(k.primary, wrapped, dataKey)
+}
+
+func newEnvelope(keyID string, wrapped, dataKey []byte) (*Envelope, error) {
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid data key: %w", err)
+	}
+	return &Envelope{KeyID: keyID, WrappedKey: wrapped, aead: aead, dataKey: dataKey}, nil
+}
+
+// Encrypt seals plaintext and returns it base64 encoded. field is bound to
+// the ciphertext so values cannot be swapped between columns.
+func (e *Envelope) Encrypt(field, plaintext string) (string, error) {
+	sealed, err := seal(e.aead, []byte(plaintext), []byte(field))
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt %s: %w", field, err)
+	}
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt reverses Encrypt.
+func (e *Envelope) Decrypt(field, ciphertext string) (string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode %s: %w", field, err)
+	}
+	plaintext, err := open(e.aead, sealed, []byte(field))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt %s: %w", field, err)
+	}
+	return string(plaintext), nil
+}
+
+// seal returns nonce || ciphertext.
+func seal(aead cipher.AEAD, plaintext, additional []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additional), nil
+}
+
+func open(aead cipher.AEAD, sealed, additional []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, additional)
+}
@@ -0,0 +1,109 @@
+package fieldcrypt
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func testKey(b byte) string {
+	return base64.StdEncoding.EncodeToString([]byte(strings.Repeat(string(rune(b)), KeySize)))
+}
+
+func TestEnvelopeRoundTripAndRotation(t *testing.T) {
+	old, err := New("k1", map[string]string{"k1": testKey('a')}, testKey('i'))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	env, err := old.NewEnvelope()
+	if err != nil {
+		t.Fatalf("NewEnvelope failed: %v", err)
+	}
+	ciphertext, err := env.Encrypt("email", "john@example.com")
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+	if strings.Contains(ciphertext, "john") {
+		t.Fatalf("Ciphertext leaks plaintext: %s", ciphertext)
+	}
+	if _, err := env.Decrypt("phone_number", ciphertext); err == nil {
+		t.Errorf("Expected ciphertext to be bound to its column")
+	}
+
+	// Rotate: k2 becomes primary, k1 stays for unwrapping.
+	rotated, err := New("k2", map[string]string{"k1": testKey('a'), "k2": testKey('b')}, testKey('i'))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	opened, err := rotated.OpenEnvelope(env.KeyID, env.WrappedKey)
+	if err != nil {
+		t.Fatalf("OpenEnvelope failed: %v", err)
+	}
+	rewrapped, err := rotated.Rewrap(opened)
+	if err != nil {
+		t.Fatalf("Rewrap failed: %v", err)
+	}
+	if rewrapped.KeyID != "k2" {
+		t.Errorf("Expected rewrapped key ID k2, got %s", rewrapped.KeyID)
+	}
+
+	// The re-wrapped data key still decrypts, with only k2 configured.
+	onlyNew, err := New("k2", map[string]string{"k2": testKey('b')}, testKey('i'))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	reopened, err := onlyNew.OpenEnvelope(rewrapped.KeyID, rewrapped.WrappedKey)
+	if err != nil {
+		t.Fatalf("OpenEnvelope after rewrap failed: %v", err)
+	}
+	if got, err := reopened.Decrypt("email", ciphertext); err != nil || got != "john@example.com" {
+		t.Errorf("Expected john@example.com, got %q (%v)", got, err)
+	}
+	if _, err := onlyNew.OpenEnvelope(env.KeyID, env.WrappedKey); err == nil {
+		t.Errorf("Expected envelope wrapped by a removed key to fail")
+	}
+}
+
+func TestBlindIndexNormalizesEmail(t *testing.T) {
+	k, err := New("k1", map[string]string{"k1": testKey('a')}, testKey('i'))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if k.BlindIndex(" John@Example.com") != k.BlindIndex("john@example.com") {
+		t.Errorf("Expected blind index to ignore case and surrounding space")
+	}
+	if k.BlindIndex("john@example.com") == k.BlindIndex("jane@example.com") {
+		t.Errorf("Expected different emails to have different indexes")
+	}
+	other, _ := New("k1", map[string]string{"k1": testKey('a')}, testKey('j'))
+	if other.BlindIndex("john@example.com") == k.BlindIndex("john@example.com") {
+		t.Errorf("Expected blind index to depend on the index key")
+	}
+}
+
+func TestMAC(t *testing.T) {
+	k, err := New("k1", map[string]string{"k1": testKey('a')}, testKey('i'))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if k.MAC("geocode:union station") == k.MAC("geocode:Union Station") {
+		t.Errorf("Expected MAC not to normalize its input")
+	}
+	other, _ := New("k1", map[string]string{"k1": testKey('a')}, testKey('j'))
+	if other.MAC("geocode:union station") == k.MAC("geocode:union station") {
+		t.Errorf("Expected MAC to depend on the index key")
+	}
+}
+
+func TestNewRejectsBadKeys(t *testing.T) {
+	short := base64.StdEncoding.EncodeToString([]byte("too short"))
+	if _, err := New("k1", map[string]string{"k1": short}, testKey('i')); err == nil {
+		t.Errorf("Expected short key to be rejected")
+	}
+	if _, err := New("k2", map[string]string{"k1": testKey('a')}, testKey('i')); err == nil {
+		t.Errorf("Expected missing primary key to be rejected")
+	}
+	if _, err := New("k1", map[string]string{"k1": testKey('a')}, ""); err == nil {
+		t.Errorf("Expected missing blind index key to be rejected")
+	}
+}
@@ -0,0 +1,114 @@
+package geo
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+// CacheStore persists geocoding results. A nil value records that the query
+// had no result. Keys and values reveal the places riders looked up, and a
+// key's query is easily guessed, so a store that keeps them should hash keys
+// with a secret and encrypt values.
+type CacheStore interface {
+	// GetGeocode returns the cached value for key and whether an unexpired
+	// entry exists.
+	GetGeocode(ctx context.Context, key string) (value []byte, ok bool, err error)
+	PutGeocode(ctx context.Context, key string, value []byte, expiresAt time.Time) error
+}
+
+// Cached decorates a Geocoder with a CacheStore. Queries are cached under a
+// SHA-256 of the normalized query, so rider-typed addresses are not handed to
+// the store in the clear. Cache failures are logged and fall through to the
+// next Geocoder.
+type Cached struct {
+	next        Geocoder
+	store       CacheStore
+	ttl         time.Duration
+	negativeTTL time.Duration
+	now         func() time.Time
+}
+
+// NewCached caches results of next for ttl, and misses for negativeTTL.
+func NewCached(next Geocoder, store CacheStore, ttl, negativeTTL time.Duration) *Cached {
+	return &Cached{next: next, store: store, ttl: ttl, negativeTTL: negativeTTL, now: time.Now}
+}
+
+func (c *Cached) Geocode(ctx context.Context, address string) (*Place, error) {
+	var place *Place
+	err := c.cached(ctx, "geocode", NormalizeAddress(address), &place, func() (interface{}, error) {
+		return c.next.Geocode(ctx, address)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return place, nil
+}
+
+func (c *Cached) ReverseGeocode(ctx context.Context, p Point) (*Place, error) {
+	// Five decimals is about a meter, well within any geocoder's precision.
+	query := fmt.Sprintf("%.5f,%.5f", p.Lat, p.Lng)
+	var place *Place
+	err := c.cached(ctx, "reverse", query, &place, func() (interface{}, error) {
+		return c.next.ReverseGeocode(ctx, p)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return place, nil
+}
+
+func (c *Cached) Autocomplete(ctx context.Context, input string, limit int) ([]Place, error) {
+	query := fmt.Sprintf("%d:%s", limit, NormalizeAddress(input))
+	var places []Place
+	err := c.cached(ctx, "autocomplete", query, &places, func() (interface{}, error) {
+		return c.next.Autocomplete(ctx, input, limit)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return places, nil
+}
+
+// cached decodes the cached result for kind and query into out, or calls
+// fetch and caches its result. ErrNotFound is cached as a miss.
+func (c *Cached) cached(ctx context.Context, kind, query string, out interface{}, fetch func() (interface{}, error)) error {
+	sum := sha256.Sum256([]byte(query))
+	key := kind + ":" + hex.EncodeToString(sum[:])
+
+	value, ok, err := c.store.GetGeocode(ctx, key)
+	if err != nil {
+		log.Printf("Geocode cache read failed: %v", err)
+	} else if ok {
+		if value == nil {
+			return ErrNotFound
+		}
+		if err := json.Unmarshal(value, out); err == nil {
+			return nil
+		}
+	}
+
+	result, err := fetch()
+	ttl := c.ttl
+	switch {
+	case err == ErrNotFound:
+		value, ttl = nil, c.negativeTTL
+	case err != nil:
+		return err
+	default:
+		if value, err = json.Marshal(result); err != nil {
+			return err
+		}
+	}
+	if err := c.store.PutGeocode(ctx, key, value, c.now().Add(ttl)); err != nil {
+		log.Printf("Geocode cache write failed: %v", err)
+	}
+	if value == nil {
+		return ErrNotFound
+	}
+	return json.Unmarshal(value, out)
+}
@@ -0,0 +1,76 @@
+// Package geo resolves addresses to coordinates and back, and estimates
+// routes between them.
+//
+// Geocoder is implemented by Offline, which answers from a fixture file for
+// development and tests, and decorated by Cached, which stores results in
+// Postgres so repeated lookups do not hit the provider. Router is implemented
+// by Haversine.
+package geo
+
+import (
+	"context"
+	"errors"
+	"luxsuv-backend/data"
+	"math"
+	"strings"
+	"unicode"
+)
+
+// ErrNotFound is returned when an address or point cannot be resolved.
+var ErrNotFound = errors.New("geo: no matching place")
+
+// Point is a WGS84 coordinate.
+type Point struct {
+	Lat float64 `json:"lat"`
+	Lng float64 `json:"lng"`
+}
+
+// Place is a geocoding result.
+type Place struct {
+	Address     string `json:"address"` // formatted address
+	Point       Point  `json:"point"`
+	PlaceID     string `json:"place_id"`
+	AirportCode string `json:"airport_code,omitempty"`
+}
+
+// Location returns the place as a booking location, keeping address as
+// typed by the rider.
+func (p *Place) Location(address string) data.Location {
+	lat, lng := p.Point.Lat, p.Point.Lng
+	return data.Location{
+		Address:     address,
+		Lat:         &lat,
+		Lng:         &lng,
+		PlaceID:     p.PlaceID,
+		AirportCode: p.AirportCode,
+	}
+}
+
+// Geocoder turns addresses into places and back.
+type Geocoder interface {
+	// Geocode resolves a free-text address, or returns ErrNotFound.
+	Geocode(ctx context.Context, address string) (*Place, error)
+	// ReverseGeocode returns the place at or nearest to p, or ErrNotFound.
+	ReverseGeocode(ctx context.Context, p Point) (*Place, error)
+	// Autocomplete suggests up to limit places for partially typed input.
+	Autocomplete(ctx context.Context, input string, limit int) ([]Place, error)
+}
+
+const earthRadiusMeters = 6371008.8
+
+// Distance returns the great-circle distance between a and b in meters.
+func Distance(a, b Point) float64 {
+	lat1, lat2 := a.Lat*math.Pi/180, b.Lat*math.Pi/180
+	dLat := lat2 - lat1
+	dLng := (b.Lng - a.Lng) * math.Pi / 180
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadiusMeters * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// NormalizeAddress lowercases s and reduces punctuation and runs of spaces to
+// single spaces, so trivially different spellings of an address compare equal.
+func NormalizeAddress(s string) string {
+	return strings.Join(strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}), " ")
+}
@@ -0,0 +1,171 @@
+package geo
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestDistance(t *testing.T) {
+	lax := Point{Lat: 33.9416, Lng: -118.4085}
+	union := Point{Lat: 34.0562, Lng: -118.2365}
+	// About 20.4 km as the crow flies.
+	if d := Distance(lax, union); math.Abs(d-20400) > 300 {
+		t.Errorf("Expected ~20.4km, got %.0fm", d)
+	}
+	if d := Distance(lax, lax); d != 0 {
+		t.Errorf("Expected 0, got %f", d)
+	}
+}
+
+func TestOfflineGeocoder(t *testing.T) {
+	g, err := LoadOffline("")
+	if err != nil {
+		t.Fatalf("LoadOffline failed: %v", err)
+	}
+	ctx := context.Background()
+
+	place, err := g.Geocode(ctx, "  los angeles international AIRPORT ")
+	if err != nil {
+		t.Fatalf("Geocode failed: %v", err)
+	}
+	if place.AirportCode != "LAX" || place.PlaceID != "offline:lax" {
+		t.Errorf("Unexpected place: %+v", place)
+	}
+	if _, err := g.Geocode(ctx, "42 Nowhere Lane"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	near, err := g.ReverseGeocode(ctx, Point{Lat: 34.1185, Lng: -118.3005})
+	if err != nil || near.PlaceID != "offline:griffith-observatory" {
+		t.Errorf("Expected Griffith Observatory, got %+v (%v)", near, err)
+	}
+	if _, err := g.ReverseGeocode(ctx, Point{Lat: 40.7, Lng: -74}); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound far from any place, got %v", err)
+	}
+
+	suggestions, err := g.Autocomplete(ctx, "airport", 2)
+	if err != nil || len(suggestions) != 2 {
+		t.Errorf("Expected 2 airport suggestions, got %v (%v)", suggestions, err)
+	}
+}
+
+type memoryCache struct {
+	entries map[string][]byte
+	puts    int
+}
+
+func (m *memoryCache) GetGeocode(_ context.Context, key string) ([]byte, bool, error) {
+	v, ok := m.entries[key]
+	return v, ok, nil
+}
+
+func (m *memoryCache) PutGeocode(_ context.Context, key string, value []byte, _ time.Time) error {
+	m.entries[key] = value
+	m.puts++
+	return nil
+}
+
+type countingGeocoder struct {
+	Geocoder
+	calls int
+}
+
+func (c *countingGeocoder) Geocode(ctx context.Context, address string) (*Place, error) {
+	c.calls++
+	return c.Geocoder.Geocode(ctx, address)
+}
+
+func TestCachedGeocoder(t *testing.T) {
+	offline, err := LoadOffline("")
+	if err != nil {
+		t.Fatalf("LoadOffline failed: %v", err)
+	}
+	next := &countingGeocoder{Geocoder: offline}
+	store := &memoryCache{entries: map[string][]byte{}}
+	g := NewCached(next, store, time.Hour, time.Minute)
+	ctx := context.Background()
+
+	for _, address := range []string{"Union Station", "union station!"} {
+		place, err := g.Geocode(ctx, address)
+		if err != nil || place.PlaceID != "offline:union-station" {
+			t.Fatalf("Expected Union Station, got %+v (%v)", place, err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := g.Geocode(ctx, "42 Nowhere Lane"); err != ErrNotFound {
+			t.Fatalf("Expected ErrNotFound, got %v", err)
+		}
+	}
+	if next.calls != 2 {
+		t.Errorf("Expected one lookup per distinct address, got %d", next.calls)
+	}
+	for key := range store.entries {
+		if len(key) < 64 {
+			t.Errorf("Expected hashed cache keys, got %q", key)
+		}
+	}
+}
+
+func TestParseArea(t *testing.T) {
+	// A square around downtown LA with a hole over the civic center, and a
+	// second square around LAX.
+	area, err := ParseArea([]byte(`{"type": "MultiPolygon", "coordinates": [
+		[[[-118.30, 34.00], [-118.20, 34.00], [-118.20, 34.10], [-118.30, 34.10], [-118.30, 34.00]],
+		 [[-118.25, 34.05], [-118.24, 34.05], [-118.24, 34.06], [-118.25, 34.06], [-118.25, 34.05]]],
+		[[[-118.45, 33.90], [-118.35, 33.90], [-118.35, 33.98], [-118.45, 33.98], [-118.45, 33.90]]]
+	]}`))
+	if err != nil {
+		t.Fatalf("ParseArea failed: %v", err)
+	}
+	for _, tc := range []struct {
+		name string
+		p    Point
+		want bool
+	}{
+		{"downtown", Point{Lat: 34.02, Lng: -118.28}, true},
+		{"in hole", Point{Lat: 34.055, Lng: -118.245}, false},
+		{"LAX", Point{Lat: 33.9416, Lng: -118.4085}, true},
+		{"Pasadena", Point{Lat: 34.1478, Lng: -118.1445}, false},
+	} {
+		if got := area.Contains(tc.p); got != tc.want {
+			t.Errorf("%s: Contains = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+
+	feature, err := ParseArea([]byte(`{"type": "Feature", "properties": {}, "geometry": {"type": "Polygon",
+		"coordinates": [[[-118.30, 34.00], [-118.20, 34.00], [-118.20, 34.10], [-118.30, 34.00]]]}}`))
+	if err != nil || !feature.Contains(Point{Lat: 34.01, Lng: -118.21}) {
+		t.Errorf("Expected Feature polygon to contain point (%v)", err)
+	}
+
+	for _, invalid := range []string{
+		`{"type": "Point", "coordinates": [-118.3, 34.0]}`,
+		`{"type": "Polygon", "coordinates": [[[-118.3, 34.0], [-118.2, 34.0], [-118.2, 34.1]]]}`,
+		`{"type": "Polygon", "coordinates": [[[-118.3, 34.0], [-118.2, 34.0], [-118.2, 34.1], [-118.3, 34.1]]]}`,
+		`{"type": "Polygon", "coordinates": [[[34.0, -218.3], [34.0, -118.2], [34.1, -118.2], [34.0, -218.3]]]}`,
+		`{"type": "MultiPolygon", "coordinates": []}`,
+		`not json`,
+	} {
+		if _, err := ParseArea([]byte(invalid)); err == nil {
+			t.Errorf("Expected error for %s", invalid)
+		}
+	}
+}
+
+func TestHaversineRouter(t *testing.T) {
+	lax := Point{Lat: 33.9416, Lng: -118.4085}
+	union := Point{Lat: 34.0562, Lng: -118.2365}
+	route, err := NewHaversine(1.5, 36).Route(context.Background(), lax, union)
+	if err != nil {
+		t.Fatalf("Route failed: %v", err)
+	}
+	// ~20.4 km straight, 1.5x for roads, at 10 m/s.
+	if math.Abs(route.DistanceMeters-30600) > 450 {
+		t.Errorf("Expected ~30.6km, got %.0fm", route.DistanceMeters)
+	}
+	if want := time.Duration(route.DistanceMeters/10) * time.Second; (route.Duration - want).Abs() > time.Second {
+		t.Errorf("Expected duration %v, got %v", want, route.Duration)
+	}
+}
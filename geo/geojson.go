@@ -0,0 +1,114 @@
+package geo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Area is a region parsed from a GeoJSON Polygon or MultiPolygon geometry.
+type Area struct {
+	// polygons are lists of rings: the outer boundary first, then holes.
+	polygons [][][]Point
+}
+
+type geoJSONGeometry struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
+}
+
+// ParseArea parses a GeoJSON Polygon or MultiPolygon geometry (RFC 7946,
+// [longitude, latitude] positions). A Feature wrapping one is accepted too.
+func ParseArea(raw []byte) (*Area, error) {
+	var g struct {
+		geoJSONGeometry
+		Geometry *geoJSONGeometry `json:"geometry"`
+	}
+	if err := json.Unmarshal(raw, &g); err != nil {
+		return nil, fmt.Errorf("invalid GeoJSON: %w", err)
+	}
+	geometry := g.geoJSONGeometry
+	if g.Type == "Feature" {
+		if g.Geometry == nil {
+			return nil, errors.New("invalid GeoJSON: feature has no geometry")
+		}
+		geometry = *g.Geometry
+	}
+
+	var polygons [][][][2]float64
+	switch geometry.Type {
+	case "Polygon":
+		var polygon [][][2]float64
+		if err := json.Unmarshal(geometry.Coordinates, &polygon); err != nil {
+			return nil, fmt.Errorf("invalid Polygon coordinates: %w", err)
+		}
+		polygons = append(polygons, polygon)
+	case "MultiPolygon":
+		if err := json.Unmarshal(geometry.Coordinates, &polygons); err != nil {
+			return nil, fmt.Errorf("invalid MultiPolygon coordinates: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported GeoJSON type %q: must be Polygon or MultiPolygon", geometry.Type)
+	}
+	if len(polygons) == 0 {
+		return nil, errors.New("invalid GeoJSON: no polygons")
+	}
+
+	area := &Area{}
+	for _, polygon := range polygons {
+		if len(polygon) == 0 {
+			return nil, errors.New("invalid GeoJSON: polygon has no rings")
+		}
+		var rings [][]Point
+		for _, ring := range polygon {
+			if len(ring) < 4 || ring[0] != ring[len(ring)-1] {
+				return nil, errors.New("invalid GeoJSON: rings must be closed and have at least 4 positions")
+			}
+			points := make([]Point, len(ring))
+			for i, pos := range ring {
+				if pos[0] < -180 || pos[0] > 180 || pos[1] < -90 || pos[1] > 90 {
+					return nil, fmt.Errorf("invalid GeoJSON: position %v out of range", pos)
+				}
+				points[i] = Point{Lat: pos[1], Lng: pos[0]}
+			}
+			rings = append(rings, points)
+		}
+		area.polygons = append(area.polygons, rings)
+	}
+	return area, nil
+}
+
+// Contains reports whether p lies inside the area: inside the outer ring of
+// one of its polygons and outside that polygon's holes.
+func (a *Area) Contains(p Point) bool {
+	for _, rings := range a.polygons {
+		if !ringContains(rings[0], p) {
+			continue
+		}
+		inHole := false
+		for _, hole := range rings[1:] {
+			if ringContains(hole, p) {
+				inHole = true
+				break
+			}
+		}
+		if !inHole {
+			return true
+		}
+	}
+	return false
+}
+
+// ringContains is the even-odd ray casting test, treating coordinates as
+// planar, which is accurate enough for metro-sized areas.
+func ringContains(ring []Point, p Point) bool {
+	inside := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		a, b := ring[i], ring[j]
+		if (a.Lat > p.Lat) != (b.Lat > p.Lat) &&
+			p.Lng < (b.Lng-a.Lng)*(p.Lat-a.Lat)/(b.Lat-a.Lat)+a.Lng {
+			inside = !inside
+		}
+	}
+	return inside
+}
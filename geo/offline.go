@@ -0,0 +1,103 @@
+package geo
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+//go:embed fixtures/places.json
+var defaultFixture []byte
+
+// reverseRadiusMeters is how far from a fixture place a point may be for
+// ReverseGeocode to return it.
+const reverseRadiusMeters = 500
+
+// fixturePlace is an entry of the fixture file.
+type fixturePlace struct {
+	Place
+	// Aliases are other spellings that geocode to the place.
+	Aliases []string `json:"aliases"`
+}
+
+// Offline is a Geocoder answering from a fixed list of places. Geocode only
+// matches an address (or alias) exactly, after NormalizeAddress.
+type Offline struct {
+	places []fixturePlace
+	index  map[string]int
+}
+
+// NewOffline builds an Offline geocoder from fixture JSON: an array of places
+// with address, point, place_id, optional airport_code and aliases.
+func NewOffline(fixture []byte) (*Offline, error) {
+	var places []fixturePlace
+	if err := json.Unmarshal(fixture, &places); err != nil {
+		return nil, fmt.Errorf("failed to parse geocoding fixture: %w", err)
+	}
+	o := &Offline{places: places, index: map[string]int{}}
+	for i, p := range places {
+		for _, name := range append([]string{p.Address}, p.Aliases...) {
+			o.index[NormalizeAddress(name)] = i
+		}
+	}
+	return o, nil
+}
+
+// LoadOffline reads the fixture at path, or uses the built-in Los Angeles
+// fixture when path is empty.
+func LoadOffline(path string) (*Offline, error) {
+	if path == "" {
+		return NewOffline(defaultFixture)
+	}
+	fixture, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read geocoding fixture: %w", err)
+	}
+	return NewOffline(fixture)
+}
+
+func (o *Offline) Geocode(_ context.Context, address string) (*Place, error) {
+	i, ok := o.index[NormalizeAddress(address)]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	place := o.places[i].Place
+	return &place, nil
+}
+
+func (o *Offline) ReverseGeocode(_ context.Context, p Point) (*Place, error) {
+	best, bestDistance := -1, float64(reverseRadiusMeters)
+	for i, place := range o.places {
+		if d := Distance(p, place.Point); d <= bestDistance {
+			best, bestDistance = i, d
+		}
+	}
+	if best < 0 {
+		return nil, ErrNotFound
+	}
+	place := o.places[best].Place
+	return &place, nil
+}
+
+func (o *Offline) Autocomplete(_ context.Context, input string, limit int) ([]Place, error) {
+	input = NormalizeAddress(input)
+	if input == "" {
+		return nil, nil
+	}
+	var out []Place
+	for _, p := range o.places {
+		if len(out) == limit {
+			break
+		}
+		for _, name := range append([]string{p.Address}, p.Aliases...) {
+			if strings.Contains(NormalizeAddress(name), input) {
+				out = append(out, p.Place)
+				break
+			}
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,38 @@
+package geo
+
+import (
+	"context"
+	"time"
+)
+
+// Route is the estimated driving distance and duration between two points.
+type Route struct {
+	DistanceMeters float64
+	Duration       time.Duration
+}
+
+// Router estimates driving routes. Haversine works offline; a routing engine
+// with road data can be plugged in by implementing Router.
+type Router interface {
+	Route(ctx context.Context, from, to Point) (*Route, error)
+}
+
+// Haversine estimates routes from the great-circle distance, stretched by a
+// detour factor for the road network and driven at a constant average speed.
+type Haversine struct {
+	detourFactor    float64
+	metersPerSecond float64
+}
+
+// NewHaversine returns a Haversine router. detourFactor is the ratio of road
+// to straight-line distance (about 1.3 in cities) and averageSpeedKPH the
+// average driving speed including traffic.
+func NewHaversine(detourFactor, averageSpeedKPH float64) *Haversine {
+	return &Haversine{detourFactor: detourFactor, metersPerSecond: averageSpeedKPH * 1000 / 3600}
+}
+
+func (h *Haversine) Route(_ context.Context, from, to Point) (*Route, error) {
+	meters := Distance(from, to) * h.detourFactor
+	seconds := meters / h.metersPerSecond
+	return &Route{DistanceMeters: meters, Duration: time.Duration(seconds * float64(time.Second))}, nil
+}
@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"io"
+	"luxsuv-backend/data"
+	"luxsuv-backend/repository"
+	"net/http"
+	"strconv"
+)
+
+func SetupAdminRouter(repo *repository.BookingRepository, auth *AuthMiddleware, svc Services) *chi.Mux {
+	r := chi.NewRouter()
+
+	r.Post("/login", loginHandler(repo, auth))
+	r.Post("/login/mfa", loginMFAHandler(repo, auth))
+	r.Post("/refresh", refreshHandler(repo, auth))
+
+	r.Group(func(r chi.Router) {
+		r.Use(auth.requireToken([]string{data.RoleAdmin}, purposeAccess, purposeMFAEnroll))
+
+		r.Post("/mfa/enroll", enrollMFA(repo))
+		r.Post("/mfa/enroll/confirm", confirmMFA(repo, auth))
+	})
+
+	// Admin-only account management
+	r.Group(func(r chi.Router) {
+		r.Use(auth.RequireRoles(data.RoleAdmin))
+
+		r.Post("/logout", logoutHandler(repo))
+		r.Get("/drivers", listDrivers(repo))
+		r.Post("/drivers", createDriver(repo))
+		r.Post("/drivers/{id}/disable", setDriverDisabled(repo, true))
+		r.Post("/drivers/{id}/enable", setDriverDisabled(repo, false))
+		r.Post("/drivers/{id}/reset-password", resetDriverPassword(repo))
+		r.Get("/login-audit", listLoginAudit(repo))
+		r.Post("/users/{id}/reset-mfa", resetUserMFA(repo))
+		r.Get("/riders/export", exportRiderData(repo))
+		r.Post("/riders/erase", eraseRiderData(repo))
+		r.Get("/metrics", expvar.Handler().ServeHTTP)
+		r.Get("/zones", listZones(repo))
+		r.Post("/zones", createZone(repo))
+		r.Put("/zones/{id}", updateZone(repo))
+		r.Delete("/zones/{id}", deleteZone(repo))
+		r.Get("/vehicles", listVehicles(repo))
+		r.Post("/vehicles", createVehicle(repo))
+		r.Get("/vehicles/{id}", getVehicle(repo))
+		r.Put("/vehicles/{id}", updateVehicle(repo))
+		r.Delete("/vehicles/{id}", deleteVehicle(repo))
+		r.Put("/drivers/{id}/vehicle", setDriverDefaultVehicle(repo))
+		r.Put("/book-rides/{id}/vehicle", assignBookRideVehicle(repo))
+		r.Put("/book-rides/{id}/driver", assignBookRideDriver(repo, svc.Scheduler))
+		r.Get("/drivers/{id}/working-hours", getDriverWorkingHours(repo))
+		r.Put("/drivers/{id}/working-hours", setDriverWorkingHours(repo))
+		r.Get("/drivers/{id}/time-off", listDriverTimeOff(repo))
+		r.Post("/drivers/{id}/time-off", createDriverTimeOff(repo))
+		r.Delete("/drivers/{id}/time-off/{timeOffID}", deleteDriverTimeOff(repo))
+		r.Put("/book-rides/{id}/status", setBookRideStatus(repo, svc.Pricing))
+		r.Put("/book-rides/{id}/trip", updateBookRideTrip(repo, svc.Pricing))
+		r.Get("/charges", listTripCharges(repo))
+		r.Post("/charges/{id}/approve", reviewTripCharge(repo, svc.Pricing, true))
+		r.Post("/charges/{id}/reject", reviewTripCharge(repo, svc.Pricing, false))
+		r.Get("/book-rides/{id}/trail", getBookRideTrail(repo))
+		r.Get("/book-rides/{id}/candidates", listBookRideCandidates(repo, svc.Scheduler, svc.Dispatch))
+		r.Get("/dispatch/offers", listDispatchOffers(repo))
+		r.Post("/dispatch/offers/{id}/accept", respondDispatchOffer(repo, svc.Dispatch, svc.Scheduler, true))
+		r.Post("/dispatch/offers/{id}/decline", respondDispatchOffer(repo, svc.Dispatch, svc.Scheduler, false))
+		r.Get("/dispatch/response-times", getOfferResponseStats(repo, svc.Scheduler))
+	})
+
+	return r
+}
+
+func listDrivers(repo *repository.BookingRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		drivers, err := repo.ListUsersByRole(r.Context(), data.RoleDriver)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to list drivers: %w", err))
+			return
+		}
+		if drivers == nil {
+			drivers = []*data.User{}
+		}
+		respondJSON(w, http.StatusOK, drivers)
+	}
+}
+
+func createDriver(repo *repository.BookingRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Username string `json:"username"`
+			Password string `json:"password"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+			return
+		}
+		if req.Username == "" {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("username is required"))
+			return
+		}
+		if err := validatePassword(req.Password); err != nil {
+			respondError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		user, err := repo.CreateUser(r.Context(), req.Username, req.Password, data.RoleDriver)
+		if err != nil {
+			if errors.Is(err, repository.ErrUsernameTaken) {
+				respondError(w, http.StatusConflict, err)
+				return
+			}
+			respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to create driver: %w", err))
+			return
+		}
+
+		respondJSON(w, http.StatusCreated, user)
+	}
+}
+
+func setDriverDisabled(repo *repository.BookingRepository, disabled bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := parseIDParam(r, "id")
+		if err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid driver ID: %w", err))
+			return
+		}
+
+		if err := repo.SetUserDisabled(r.Context(), id, data.RoleDriver, disabled); err != nil {
+			if err == pgx.ErrNoRows {
+				respondError(w, http.StatusNotFound, fmt.Errorf("driver not found: %d", id))
+				return
+			}
+			respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to update driver: %w", err))
+			return
+		}
+
+		message := "Driver enabled successfully"
+		if disabled {
+			message = "Driver disabled successfully"
+		}
+		respondJSON(w, http.StatusOK, map[string]string{"message": message})
+	}
+}
+
+// resetDriverPassword sets a driver's password. If the request omits a
+// password, a random temporary one is generated and returned once.
+func resetDriverPassword(repo *repository.BookingRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		id, err := parseIDParam(r, "id")
+		if err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid driver ID: %w", err))
+			return
+		}
+
+		var req struct {
+			Password string `json:"password"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+			return
+		}
+		generated := req.Password == ""
+		if generated {
+			if req.Password, err = generatePassword(); err != nil {
+				respondError(w, http.StatusInternalServerError, err)
+				return
+			}
+		}
+		if err := validatePassword(req.Password); err != nil {
+			respondError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		user, err := repo.GetUserByID(ctx, id)
+		if err != nil || user.Role != data.RoleDriver {
+			if err == nil || err == pgx.ErrNoRows {
+				respondError(w, http.StatusNotFound, fmt.Errorf("driver not found: %d", id))
+				return
+			}
+			respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to get driver: %w", err))
+			return
+		}
+		if err := repo.UpdateUserPassword(ctx, id, req.Password); err != nil {
+			respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to reset password: %w", err))
+			return
+		}
+		// A reset is how admins unlock a driver who locked themselves out.
+		if err := repo.ClearLoginFailures(ctx, userLockoutKey(user.Username)); err != nil {
+			respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to clear lockout: %w", err))
+			return
+		}
+
+		resp := map[string]string{"message": "Password reset successfully"}
+		if generated {
+			resp["temporary_password"] = req.Password
+		}
+		respondJSON(w, http.StatusOK, resp)
+	}
+}
+
+func listLoginAudit(repo *repository.BookingRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit := 100
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 || n > 1000 {
+				respondError(w, http.StatusBadRequest, fmt.Errorf("limit must be between 1 and 1000"))
+				return
+			}
+			limit = n
+		}
+
+		attempts, err := repo.ListLoginAttempts(r.Context(), r.URL.Query().Get("username"), limit)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to list login attempts: %w", err))
+			return
+		}
+		respondJSON(w, http.StatusOK, attempts)
+	}
+}
+
+func generatePassword() (string, error) {
+	return randomToken(12)
+}
@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jackc/pgx/v5"
+	"log"
+	"luxsuv-backend/jwtkeys"
+	"luxsuv-backend/repository"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+type contextKey string
+
+const claimsKey contextKey = "claims"
+
+// Default token lifetimes, overridable with ACCESS_TOKEN_TTL and REFRESH_TOKEN_TTL.
+const (
+	defaultAccessTokenTTL  = 15 * time.Minute
+	defaultRefreshTokenTTL = 30 * 24 * time.Hour
+)
+
+// Token purposes. Access tokens carry no purpose claim; the others are
+// short-lived tokens that only unlock one step of the login flow.
+const (
+	purposeAccess    = ""
+	purposeMFA       = "mfa"
+	purposeMFAEnroll = "mfa_enroll"
+)
+
+// Token audiences. Access tokens are for the API; the login-flow tokens are
+// signed with the same published keys, so they get their own audience that
+// anyone verifying access tokens against the JWKS must reject.
+const (
+	audienceAccess = "luxsuv-api"
+	audienceMFA    = "luxsuv-mfa"
+)
+
+// tokenAudience returns the audience of tokens issued for purpose.
+func tokenAudience(purpose string) string {
+	if purpose == purposeAccess {
+		return audienceAccess
+	}
+	return audienceMFA
+}
+
+// authClaims is the subset of a validated token kept in the request context.
+type authClaims struct {
+	userID    int64
+	role      string
+	jti       string
+	expiresAt time.Time
+	purpose   string
+}
+
+// AuthMiddleware issues and validates JWT access tokens and restricts access by role.
+type AuthMiddleware struct {
+	repo       *repository.BookingRepository
+	keys       *jwtkeys.KeySet
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+	logins     *loginGuard
+	// mfaRequiredRoles must complete TOTP enrolment before receiving tokens.
+	mfaRequiredRoles map[string]bool
+}
+
+func NewAuthMiddleware(repo *repository.BookingRepository, keys *jwtkeys.KeySet) *AuthMiddleware {
+	return &AuthMiddleware{
+		repo:       repo,
+		keys:       keys,
+		accessTTL:  durationFromEnv("ACCESS_TOKEN_TTL", defaultAccessTokenTTL),
+		refreshTTL: durationFromEnv("REFRESH_TOKEN_TTL", defaultRefreshTokenTTL),
+		logins:     newLoginGuard(repo),
+
+		mfaRequiredRoles: mfaRequiredRolesFromEnv(),
+	}
+}
+
+func durationFromEnv(name string, fallback time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		log.Fatalf("invalid %s %q: must be a positive duration such as 15m", name, value)
+	}
+	return d
+}
+
+// RequireRoles returns middleware that only lets through requests carrying a
+// valid, unrevoked access token whose role is one of roles.
+func (a *AuthMiddleware) RequireRoles(roles ...string) func(http.Handler) http.Handler {
+	return a.requireToken(roles, purposeAccess)
+}
+
+// requireToken is RequireRoles for endpoints that also accept special-purpose
+// tokens, such as the MFA enrolment token.
+func (a *AuthMiddleware) requireToken(roles []string, purposes ...string) func(http.Handler) http.Handler {
+	allowed := make(map[string]bool, len(roles))
+	for _, role := range roles {
+		allowed[role] = true
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authHeader := r.Header.Get("Authorization")
+			if authHeader == "" {
+				http.Error(w, "Authorization header required", http.StatusUnauthorized)
+				return
+			}
+
+			claims, status, msg := a.authenticate(r.Context(), strings.TrimPrefix(authHeader, "Bearer "), purposes...)
+			if claims == nil {
+				http.Error(w, msg, status)
+				return
+			}
+
+			if !allowed[claims.role] {
+				http.Error(w, fmt.Sprintf("Access denied: %s role required", strings.Join(roles, " or ")), http.StatusForbidden)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), claimsKey, claims)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// authenticate verifies tokenStr and checks that its purpose is one of
+// purposes and that it has not been revoked. On failure it returns nil claims
+// with the HTTP status and message to respond with.
+func (a *AuthMiddleware) authenticate(ctx context.Context, tokenStr string, purposes ...string) (*authClaims, int, string) {
+	token, err := a.keys.Parse(tokenStr)
+	if err != nil || !token.Valid {
+		return nil, http.StatusUnauthorized, "Invalid token"
+	}
+
+	mapClaims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, http.StatusUnauthorized, "Invalid token claims"
+	}
+	claims, version, ok := parseAuthClaims(mapClaims)
+	if !ok {
+		return nil, http.StatusUnauthorized, "Invalid token claims"
+	}
+	if !slices.Contains(purposes, claims.purpose) {
+		return nil, http.StatusUnauthorized, "Invalid token type"
+	}
+	if aud, err := mapClaims.GetAudience(); err != nil || !slices.Contains(aud, tokenAudience(claims.purpose)) {
+		return nil, http.StatusUnauthorized, "Invalid token type"
+	}
+
+	// Tokens are short-lived but must still die immediately when the user is
+	// disabled, changes password or logs out.
+	state, err := a.repo.GetAccessTokenState(ctx, claims.userID, claims.jti)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, http.StatusUnauthorized, "Token revoked"
+		}
+		return nil, http.StatusInternalServerError, "Failed to validate token"
+	}
+	if state.Disabled || state.Revoked || state.TokenVersion != version {
+		return nil, http.StatusUnauthorized, "Token revoked"
+	}
+	return claims, 0, ""
+}
+
+func parseAuthClaims(mapClaims jwt.MapClaims) (*authClaims, int, bool) {
+	id, ok := mapClaims["id"].(float64)
+	if !ok {
+		return nil, 0, false
+	}
+	role, ok := mapClaims["role"].(string)
+	if !ok {
+		return nil, 0, false
+	}
+	jti, ok := mapClaims["jti"].(string)
+	if !ok || jti == "" {
+		return nil, 0, false
+	}
+	version, ok := mapClaims["ver"].(float64)
+	if !ok {
+		return nil, 0, false
+	}
+	exp, err := mapClaims.GetExpirationTime()
+	if err != nil || exp == nil {
+		return nil, 0, false
+	}
+	purpose, _ := mapClaims["purpose"].(string)
+	return &authClaims{userID: int64(id), role: role, jti: jti, expiresAt: exp.Time, purpose: purpose}, int(version), true
+}
+
+func claimsFromContext(ctx context.Context) (*authClaims, bool) {
+	claims, ok := ctx.Value(claimsKey).(*authClaims)
+	return claims, ok
+}
+
+// userIDFromContext returns the authenticated user's ID set by RequireRoles.
+func userIDFromContext(ctx context.Context) (int64, bool) {
+	claims, ok := claimsFromContext(ctx)
+	if !ok {
+		return 0, false
+	}
+	return claims.userID, true
+}
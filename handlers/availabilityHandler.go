@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"luxsuv-backend/data"
+	"luxsuv-backend/repository"
+	"luxsuv-backend/schedule"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// errSlotUnavailable is returned when a booking's pickup time filled up after
+// the rider looked at the available slots.
+var errSlotUnavailable = errors.New("the requested pickup time is no longer available, please choose another")
+
+// availabilityResponse lists the bookable pickup times on a date.
+type availabilityResponse struct {
+	Date       string          `json:"date"`
+	RideType   string          `json:"ride_type"`
+	Passengers int             `json:"passengers"`
+	TimeZone   string          `json:"time_zone"`
+	Slots      []schedule.Slot `json:"slots"`
+}
+
+// parseAvailabilityQuery returns the date and a booking like the one the
+// rider wants to make from the availability query parameters.
+func parseAvailabilityQuery(q url.Values) (string, *data.BookRide, error) {
+	date := q.Get("date")
+	if date == "" {
+		return "", nil, fmt.Errorf("date query parameter is required")
+	}
+	if _, err := schedule.ParsePickup(date, "00:00", time.UTC); err != nil {
+		return "", nil, err
+	}
+	ride := &data.BookRide{RideType: q.Get("ride_type")}
+	if ride.RideType != "hourly" && ride.RideType != "per_ride" {
+		return "", nil, fmt.Errorf("ride_type must be 'hourly' or 'per_ride', got %q", ride.RideType)
+	}
+	for _, p := range []struct {
+		name     string
+		dst      *int
+		required bool
+	}{
+		{"passengers", &ride.NumberOfPassengers, true},
+		{"luggage", &ride.NumberOfLuggage, false},
+		{"hours", &ride.Hours, false},
+	} {
+		v := q.Get(p.name)
+		if v == "" {
+			if p.required {
+				return "", nil, fmt.Errorf("%s query parameter is required", p.name)
+			}
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return "", nil, fmt.Errorf("%s must be a non-negative integer", p.name)
+		}
+		*p.dst = n
+	}
+	if ride.NumberOfPassengers == 0 {
+		return "", nil, fmt.Errorf("passengers must be positive")
+	}
+	if ride.Hours > 0 && ride.RideType != "hourly" {
+		return "", nil, fmt.Errorf("hours only applies to hourly rides")
+	}
+	return date, ride, nil
+}
+
+// getAvailability lists the pickup times on a date that a booking could be
+// made for, given the drivers' working hours, time off and assigned
+// bookings, their vehicles' capacity and the bookings not yet assigned.
+// per_ride trips are assumed to take the default ride duration. Until a
+// driver has a default vehicle every future pickup time is listed.
+func getAvailability(repo *repository.BookingRepository, sched *schedule.Scheduler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		date, ride, err := parseAvailabilityQuery(r.URL.Query())
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		window, err := sched.SlotWindow(date, ride)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err)
+			return
+		}
+		drivers, unassigned, err := loadCapacity(ctx, repo, window)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to load availability: %w", err))
+			return
+		}
+		slots, err := sched.Slots(date, ride, drivers, unassigned, time.Now())
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		respondJSON(w, http.StatusOK, availabilityResponse{
+			Date:       date,
+			RideType:   ride.RideType,
+			Passengers: ride.NumberOfPassengers,
+			TimeZone:   sched.Location().String(),
+			Slots:      slots,
+		})
+	}
+}
+
+// checkSlotAvailable returns errSlotUnavailable if no driver is left for a
+// scheduled booking at its pickup time. Unscheduled bookings are not checked.
+// own is the interval the booking already holds unassigned when it is being
+// changed, nil for a new booking; it is not counted against the booking.
+func checkSlotAvailable(ctx context.Context, repo *repository.BookingRepository, sched *schedule.Scheduler, ride *data.BookRide, own *data.TimeRange) error {
+	if ride.Occupied == nil {
+		return nil
+	}
+	drivers, unassigned, err := loadCapacity(ctx, repo, *ride.Occupied)
+	if err != nil {
+		return err
+	}
+	return slotAvailable(sched, ride, drivers, withoutRange(unassigned, own))
+}
+
+// withoutRange returns ranges with one occurrence of r removed, if r is not
+// nil.
+func withoutRange(ranges []data.TimeRange, r *data.TimeRange) []data.TimeRange {
+	if r == nil {
+		return ranges
+	}
+	for i, other := range ranges {
+		if other.Start.Equal(r.Start) && other.End.Equal(r.End) {
+			return append(ranges[:i:i], ranges[i+1:]...)
+		}
+	}
+	return ranges
+}
+
+// slotAvailable returns errSlotUnavailable if none of drivers is left for
+// ride once the unassigned bookings are covered. Before any driver has a
+// default vehicle every pickup time is available.
+func slotAvailable(sched *schedule.Scheduler, ride *data.BookRide, drivers []data.DriverSchedule, unassigned []data.TimeRange) error {
+	ok, err := sched.HasCapacity(ride, drivers, unassigned)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errSlotUnavailable
+	}
+	return nil
+}
+
+// loadCapacity loads the driver schedules and unassigned bookings in window.
+func loadCapacity(ctx context.Context, repo *repository.BookingRepository, window data.TimeRange) ([]data.DriverSchedule, []data.TimeRange, error) {
+	drivers, err := repo.ListDriverSchedules(ctx, window)
+	if err != nil {
+		return nil, nil, err
+	}
+	unassigned, err := repo.ListUnassignedOccupied(ctx, window)
+	if err != nil {
+		return nil, nil, err
+	}
+	return drivers, unassigned, nil
+}
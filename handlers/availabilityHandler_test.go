@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"errors"
+	"luxsuv-backend/config"
+	"luxsuv-backend/data"
+	"luxsuv-backend/schedule"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestParseAvailabilityQuery(t *testing.T) {
+	date, ride, err := parseAvailabilityQuery(url.Values{
+		"date": {"2026-11-02"}, "ride_type": {"hourly"}, "passengers": {"4"}, "hours": {"3"},
+	})
+	if err != nil {
+		t.Fatalf("parseAvailabilityQuery failed: %v", err)
+	}
+	if date != "2026-11-02" || ride.RideType != "hourly" || ride.NumberOfPassengers != 4 || ride.Hours != 3 {
+		t.Errorf("Unexpected result %s %+v", date, ride)
+	}
+
+	for name, q := range map[string]url.Values{
+		"missing date":       {"ride_type": {"per_ride"}, "passengers": {"1"}},
+		"bad date":           {"date": {"11/02/2026"}, "ride_type": {"per_ride"}, "passengers": {"1"}},
+		"bad ride type":      {"date": {"2026-11-02"}, "ride_type": {"daily"}, "passengers": {"1"}},
+		"missing passengers": {"date": {"2026-11-02"}, "ride_type": {"per_ride"}},
+		"zero passengers":    {"date": {"2026-11-02"}, "ride_type": {"per_ride"}, "passengers": {"0"}},
+		"negative luggage":   {"date": {"2026-11-02"}, "ride_type": {"per_ride"}, "passengers": {"1"}, "luggage": {"-1"}},
+		"hours for per_ride": {"date": {"2026-11-02"}, "ride_type": {"per_ride"}, "passengers": {"1"}, "hours": {"3"}},
+		"non-numeric seats":  {"date": {"2026-11-02"}, "ride_type": {"per_ride"}, "passengers": {"two"}},
+	} {
+		if _, _, err := parseAvailabilityQuery(q); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestSlotAvailableEmptyFleet(t *testing.T) {
+	sched, err := schedule.New(config.Defaults().Scheduling)
+	if err != nil {
+		t.Fatalf("schedule.New failed: %v", err)
+	}
+	ride := &data.BookRide{RideType: "per_ride", Date: "2026-11-02", Time: "09:00", NumberOfPassengers: 2}
+	pickup := time.Date(2026, 11, 2, 9, 0, 0, 0, sched.Location())
+	unassigned := []data.TimeRange{{Start: pickup, End: pickup.Add(time.Hour)}}
+
+	for name, drivers := range map[string][]data.DriverSchedule{
+		"no drivers":              nil,
+		"drivers without a fleet": {{DriverID: 1}, {DriverID: 2}},
+	} {
+		if err := slotAvailable(sched, ride, drivers, unassigned); err != nil {
+			t.Errorf("%s: expected the booking to be accepted, got %v", name, err)
+		}
+	}
+
+	vehicleID := int64(3)
+	retired := []data.DriverSchedule{{DriverID: 1, DefaultVehicleID: &vehicleID}}
+	if err := slotAvailable(sched, ride, retired, nil); !errors.Is(err, errSlotUnavailable) {
+		t.Errorf("Expected errSlotUnavailable once the fleet is configured, got %v", err)
+	}
+}
+
+func TestSlotAvailableLeavesOwnIntervalOut(t *testing.T) {
+	sched, err := schedule.New(config.Defaults().Scheduling)
+	if err != nil {
+		t.Fatalf("schedule.New failed: %v", err)
+	}
+	ride := &data.BookRide{RideType: "per_ride", Date: "2026-11-02", Time: "09:30", NumberOfPassengers: 2}
+	pickup := time.Date(2026, 11, 2, 9, 0, 0, 0, sched.Location())
+	own := data.TimeRange{Start: pickup, End: pickup.Add(90 * time.Minute)}
+	vehicleID := int64(3)
+	drivers := []data.DriverSchedule{{DriverID: 1, DefaultVehicleID: &vehicleID,
+		Vehicle: &data.Vehicle{ID: vehicleID, Seats: 4, LuggageCapacity: 4, Status: data.VehicleActive}}}
+
+	// The only driver is already counted against the booking's old time.
+	if err := slotAvailable(sched, ride, drivers, []data.TimeRange{own}); !errors.Is(err, errSlotUnavailable) {
+		t.Errorf("Expected errSlotUnavailable counting the booking against itself, got %v", err)
+	}
+	if err := slotAvailable(sched, ride, drivers, withoutRange([]data.TimeRange{own}, &own)); err != nil {
+		t.Errorf("Expected the booking to move within its own slot, got %v", err)
+	}
+	other := own
+	if got := withoutRange([]data.TimeRange{own, other}, &own); len(got) != 1 {
+		t.Errorf("Expected only one matching interval to be removed, got %v", got)
+	}
+}
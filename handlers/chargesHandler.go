@@ -0,0 +1,353 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"io"
+	"luxsuv-backend/data"
+	"luxsuv-backend/pricing"
+	"luxsuv-backend/repository"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// maxChargeCents bounds a single add-on charge, to catch amounts entered
+	// in dollars as cents and the like.
+	maxChargeCents = 100000
+	// maxReceiptSize is the largest receipt photo accepted, in bytes.
+	maxReceiptSize = 5 << 20
+)
+
+// receiptTypes are the accepted receipt photo formats, as sniffed from the
+// upload.
+var receiptTypes = map[string]bool{"image/jpeg": true, "image/png": true, "image/webp": true}
+
+// tripChargeRequest is the body of the driver endpoint adding a charge.
+type tripChargeRequest struct {
+	Kind        string `json:"kind"`
+	Description string `json:"description"`
+	AmountCents int64  `json:"amount_cents"`
+}
+
+// chargeReviewRequest is the body of the admin endpoints reviewing a
+// charge; the note is required when rejecting.
+type chargeReviewRequest struct {
+	Note string `json:"note"`
+}
+
+// rideReceipt is what a rider was charged for a completed ride.
+type rideReceipt struct {
+	BookingID      int64      `json:"booking_id"`
+	RideType       string     `json:"ride_type"`
+	Date           string     `json:"date"`
+	Time           string     `json:"time"`
+	PickupAddress  string     `json:"pickup_address"`
+	DropoffAddress string     `json:"dropoff_address"`
+	StartedAt      *time.Time `json:"started_at,omitempty"`
+	EndedAt        *time.Time `json:"ended_at,omitempty"`
+	Fare           *data.Fare `json:"fare"`
+	// Charges are the approved add-on charges included in the fare.
+	Charges []*data.TripCharge `json:"charges"`
+}
+
+// validateTripCharge checks a charge a driver is adding.
+func validateTripCharge(req tripChargeRequest) error {
+	if !data.IsChargeKind(req.Kind) {
+		return fmt.Errorf("kind must be one of %s, %s, %s, %s or %s",
+			data.ChargeToll, data.ChargeParking, data.ChargeExtraStop, data.ChargeCleaning, data.ChargeGratuity)
+	}
+	if req.AmountCents <= 0 || req.AmountCents > maxChargeCents {
+		return fmt.Errorf("amount_cents must be between 1 and %d", maxChargeCents)
+	}
+	if len(req.Description) > 200 {
+		return fmt.Errorf("description must be at most 200 characters")
+	}
+	return nil
+}
+
+// createTripCharge lets the assigned driver attach an add-on charge, such as
+// a toll they paid, to a ride under way or completed. It waits for an
+// admin's approval before it is added to the fare.
+func createTripCharge(repo *repository.BookingRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := parseIDParam(r, "id")
+		if err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid booking ID: %w", err))
+			return
+		}
+		driverID, ok := userIDFromContext(r.Context())
+		if !ok {
+			respondError(w, http.StatusUnauthorized, fmt.Errorf("missing user"))
+			return
+		}
+		var req tripChargeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+			return
+		}
+		req.Description = strings.TrimSpace(req.Description)
+		if err := validateTripCharge(req); err != nil {
+			respondError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		charge, err := repo.CreateTripCharge(r.Context(), &data.TripCharge{BookingID: id, DriverID: driverID,
+			Kind: req.Kind, Description: req.Description, AmountCents: req.AmountCents})
+		if err != nil {
+			switch {
+			case err == pgx.ErrNoRows:
+				respondError(w, http.StatusNotFound, fmt.Errorf("ride booking not found or not assigned to you: %d", id))
+			case errors.Is(err, data.ErrChargeClosed):
+				respondError(w, http.StatusConflict, err)
+			default:
+				respondError(w, http.StatusInternalServerError, err)
+			}
+			return
+		}
+		respondJSON(w, http.StatusCreated, charge)
+	}
+}
+
+// listBookRideCharges lists the charges attached to a booking.
+func listBookRideCharges(repo *repository.BookingRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := parseIDParam(r, "id")
+		if err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid booking ID: %w", err))
+			return
+		}
+		charges, err := repo.ListTripCharges(r.Context(), "", &id)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err)
+			return
+		}
+		respondJSON(w, http.StatusOK, charges)
+	}
+}
+
+// deleteTripCharge lets a driver withdraw one of their charges before it is
+// reviewed.
+func deleteTripCharge(repo *repository.BookingRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := parseIDParam(r, "id")
+		if err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid charge ID: %w", err))
+			return
+		}
+		driverID, ok := userIDFromContext(r.Context())
+		if !ok {
+			respondError(w, http.StatusUnauthorized, fmt.Errorf("missing user"))
+			return
+		}
+		if err := repo.DeleteTripCharge(r.Context(), id, driverID); err != nil {
+			respondChargeError(w, id, err)
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]string{"message": "Charge deleted successfully"})
+	}
+}
+
+// uploadChargeReceipt stores the photo of a receipt for one of the driver's
+// pending charges. The body is the JPEG, PNG or WebP image itself.
+func uploadChargeReceipt(repo *repository.BookingRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := parseIDParam(r, "id")
+		if err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid charge ID: %w", err))
+			return
+		}
+		driverID, ok := userIDFromContext(r.Context())
+		if !ok {
+			respondError(w, http.StatusUnauthorized, fmt.Errorf("missing user"))
+			return
+		}
+		image, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxReceiptSize))
+		if err != nil {
+			var tooBig *http.MaxBytesError
+			if errors.As(err, &tooBig) {
+				respondError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("receipt must be at most %d MiB", maxReceiptSize>>20))
+				return
+			}
+			respondError(w, http.StatusBadRequest, fmt.Errorf("failed to read receipt: %w", err))
+			return
+		}
+		contentType := http.DetectContentType(image)
+		if !receiptTypes[contentType] {
+			respondError(w, http.StatusUnsupportedMediaType, fmt.Errorf("receipt must be a JPEG, PNG or WebP image"))
+			return
+		}
+
+		if err := repo.SetTripChargeReceipt(r.Context(), id, driverID, data.Receipt{ContentType: contentType, Image: image}); err != nil {
+			respondChargeError(w, id, err)
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]string{"message": "Receipt uploaded successfully"})
+	}
+}
+
+// getChargeReceipt returns the receipt photo of the charge whose ID is the
+// URL parameter param. Riders authenticated by a booking token only see
+// those of approved charges on their own booking.
+func getChargeReceipt(repo *repository.BookingRepository, param string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		id, err := parseIDParam(r, param)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid charge ID: %w", err))
+			return
+		}
+		if ride := bookRideFromContext(ctx); ride != nil {
+			if bookingID, err := parseIDParam(r, "id"); err != nil || bookingID != ride.ID {
+				respondError(w, http.StatusForbidden, fmt.Errorf("booking token is for another booking"))
+				return
+			}
+			charge, err := repo.GetTripCharge(ctx, id)
+			if err != nil && err != pgx.ErrNoRows {
+				respondError(w, http.StatusInternalServerError, err)
+				return
+			}
+			if charge == nil || charge.BookingID != ride.ID || charge.Status != data.ChargeApproved {
+				respondError(w, http.StatusNotFound, fmt.Errorf("receipt not found for charge %d", id))
+				return
+			}
+		}
+
+		receipt, err := repo.GetTripChargeReceipt(ctx, id)
+		if err != nil {
+			if err == pgx.ErrNoRows {
+				respondError(w, http.StatusNotFound, fmt.Errorf("receipt not found for charge %d", id))
+				return
+			}
+			respondError(w, http.StatusInternalServerError, err)
+			return
+		}
+		w.Header().Set("Content-Type", receipt.ContentType)
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Cache-Control", "private, max-age=3600")
+		w.Write(receipt.Image)
+	}
+}
+
+// listTripCharges lists charges across bookings, oldest first, optionally
+// only those with the status given in the query, e.g. pending ones awaiting
+// review.
+func listTripCharges(repo *repository.BookingRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		charges, err := repo.ListTripCharges(r.Context(), r.URL.Query().Get("status"), nil)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err)
+			return
+		}
+		respondJSON(w, http.StatusOK, charges)
+	}
+}
+
+// reviewTripCharge approves or rejects a pending charge. A completed
+// booking's fare is calculated again to include an approved one.
+func reviewTripCharge(repo *repository.BookingRepository, pricer *pricing.Pricer, approve bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		id, err := parseIDParam(r, "id")
+		if err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid charge ID: %w", err))
+			return
+		}
+		adminID, ok := userIDFromContext(ctx)
+		if !ok {
+			respondError(w, http.StatusUnauthorized, fmt.Errorf("missing user"))
+			return
+		}
+		// The body, and so the note, is optional when approving.
+		var req chargeReviewRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+			return
+		}
+		review := data.ChargeReview{Approve: approve, Note: strings.TrimSpace(req.Note), ReviewedBy: adminID}
+		if !approve && review.Note == "" {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("a note is required to reject a charge"))
+			return
+		}
+
+		charge, err := repo.ReviewTripCharge(ctx, id, review)
+		if err != nil {
+			respondChargeError(w, id, err)
+			return
+		}
+		if approve {
+			ride, err := repo.GetBookRideByID(ctx, charge.BookingID)
+			if err != nil && err != pgx.ErrNoRows {
+				respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to get ride booking: %w", err))
+				return
+			}
+			if ride != nil && ride.Status == data.BookingCompleted {
+				if err := settleTrip(ctx, repo, pricer, ride.ID); err != nil {
+					respondError(w, http.StatusInternalServerError, fmt.Errorf("charge approved but the fare could not be calculated: %w", err))
+					return
+				}
+			}
+		}
+		respondJSON(w, http.StatusOK, charge)
+	}
+}
+
+// respondChargeError reports a failure to change charge id.
+func respondChargeError(w http.ResponseWriter, id int64, err error) {
+	switch {
+	case err == pgx.ErrNoRows:
+		respondError(w, http.StatusNotFound, fmt.Errorf("charge not found: %d", id))
+	case errors.Is(err, data.ErrChargeClosed):
+		respondError(w, http.StatusConflict, err)
+	default:
+		respondError(w, http.StatusInternalServerError, err)
+	}
+}
+
+// getBookRideReceipt returns the rider's receipt for their completed ride,
+// with the approved add-on charges.
+func getBookRideReceipt(repo *repository.BookingRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		id, err := parseIDParam(r, "id")
+		if err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid booking ID: %w", err))
+			return
+		}
+		ride := bookRideFromContext(ctx)
+		if ride.ID != id {
+			respondError(w, http.StatusForbidden, fmt.Errorf("booking token is for another booking"))
+			return
+		}
+
+		trip, err := repo.GetTrip(ctx, id)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if ride.Status != data.BookingCompleted || trip.Fare == nil {
+			respondError(w, http.StatusConflict, fmt.Errorf("the receipt is available once the ride is completed"))
+			return
+		}
+		charges, err := repo.ListTripCharges(ctx, data.ChargeApproved, &id)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err)
+			return
+		}
+		respondJSON(w, http.StatusOK, rideReceipt{
+			BookingID:      ride.ID,
+			RideType:       ride.RideType,
+			Date:           ride.Date,
+			Time:           ride.Time,
+			PickupAddress:  ride.PickupLocation.Address,
+			DropoffAddress: ride.DropoffLocation.Address,
+			StartedAt:      trip.StartedAt,
+			EndedAt:        trip.EndedAt,
+			Fare:           trip.Fare,
+			Charges:        charges,
+		})
+	}
+}
@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"luxsuv-backend/data"
+	"strings"
+	"testing"
+)
+
+func TestValidateTripCharge(t *testing.T) {
+	valid := tripChargeRequest{Kind: data.ChargeToll, Description: "I-405 Express Lanes", AmountCents: 725}
+	if err := validateTripCharge(valid); err != nil {
+		t.Errorf("Expected a toll to be valid, got %v", err)
+	}
+
+	for name, mutate := range map[string]func(*tripChargeRequest){
+		"unknown kind":     func(r *tripChargeRequest) { r.Kind = "snacks" },
+		"zero amount":      func(r *tripChargeRequest) { r.AmountCents = 0 },
+		"negative amount":  func(r *tripChargeRequest) { r.AmountCents = -500 },
+		"huge amount":      func(r *tripChargeRequest) { r.AmountCents = maxChargeCents + 1 },
+		"long description": func(r *tripChargeRequest) { r.Description = strings.Repeat("x", 201) },
+	} {
+		req := valid
+		mutate(&req)
+		if err := validateTripCharge(req); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
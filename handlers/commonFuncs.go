@@ -3,7 +3,9 @@ package handlers
 import (
 	"encoding/json"
 	"fmt"
+	"github.com/go-chi/chi/v5"
 	"net/http"
+	"strconv"
 )
 
 func respondJSON(w http.ResponseWriter, status int, data interface{}) {
@@ -17,3 +19,17 @@ func respondJSON(w http.ResponseWriter, status int, data interface{}) {
 func respondError(w http.ResponseWriter, status int, err error) {
 	respondJSON(w, status, map[string]string{"error": err.Error()})
 }
+
+const minPasswordLength = 8
+
+func validatePassword(password string) error {
+	if len(password) < minPasswordLength {
+		return fmt.Errorf("password must be at least %d characters", minPasswordLength)
+	}
+	return nil
+}
+
+// parseIDParam parses the named chi URL parameter as an int64 ID.
+func parseIDParam(r *http.Request, name string) (int64, error) {
+	return strconv.ParseInt(chi.URLParam(r, name), 10, 64)
+}
@@ -0,0 +1,298 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"io"
+	"luxsuv-backend/data"
+	"luxsuv-backend/dispatch"
+	"luxsuv-backend/pricing"
+	"luxsuv-backend/repository"
+	"luxsuv-backend/schedule"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// bookRideStatusRequest is the body of the booking status endpoints.
+// Odometer is the vehicle's reading when the ride starts or is completed.
+type bookRideStatusRequest struct {
+	Status   string   `json:"status"`
+	Odometer *float64 `json:"odometer,omitempty"`
+}
+
+// setBookRideStatus moves a booking to any status its current one allows.
+// Confirmed bookings are picked up by the dispatcher; cancelling releases the
+// driver and completing settles the fare.
+func setBookRideStatus(repo *repository.BookingRepository, pricer *pricing.Pricer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := parseIDParam(r, "id")
+		if err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid booking ID: %w", err))
+			return
+		}
+		var req bookRideStatusRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+			return
+		}
+
+		change := data.StatusChange{Status: req.Status, Odometer: req.Odometer}
+		if err := repo.SetBookRideStatus(r.Context(), id, change); err != nil {
+			switch {
+			case err == pgx.ErrNoRows:
+				respondError(w, http.StatusNotFound, fmt.Errorf("ride booking not found: %d", id))
+			case errors.Is(err, data.ErrInvalidOdometer):
+				respondError(w, http.StatusBadRequest, err)
+			case errors.Is(err, data.ErrInvalidStatusTransition), errors.Is(err, data.ErrNoDriver):
+				respondError(w, http.StatusConflict, err)
+			default:
+				respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to set ride booking status: %w", err))
+			}
+			return
+		}
+		if req.Status == data.BookingCompleted {
+			if err := settleTrip(r.Context(), repo, pricer, id); err != nil {
+				respondError(w, http.StatusInternalServerError, fmt.Errorf("ride booking completed but its fare could not be calculated: %w", err))
+				return
+			}
+		}
+		respondJSON(w, http.StatusOK, map[string]string{"message": "Ride booking status updated successfully"})
+	}
+}
+
+// listBookRideCandidates ranks the drivers who could take a booking, best
+// first, as the dispatcher would.
+func listBookRideCandidates(repo *repository.BookingRepository, sched *schedule.Scheduler, engine *dispatch.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		id, err := parseIDParam(r, "id")
+		if err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid booking ID: %w", err))
+			return
+		}
+		ride, err := repo.GetBookRideByID(ctx, id)
+		if err != nil {
+			if err == pgx.ErrNoRows {
+				respondError(w, http.StatusNotFound, fmt.Errorf("ride booking not found: %d", id))
+				return
+			}
+			respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to get ride booking: %w", err))
+			return
+		}
+		if err := scheduleBookRide(sched, ride); err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("ride booking cannot be scheduled: %w", err))
+			return
+		}
+
+		candidates, err := engine.Candidates(ctx, ride)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to rank drivers: %w", err))
+			return
+		}
+		respondJSON(w, http.StatusOK, candidates)
+	}
+}
+
+// listDispatchOffers lists dispatch offers, optionally only those with the
+// status given in the query.
+func listDispatchOffers(repo *repository.BookingRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		offers, err := repo.ListDispatchOffers(r.Context(), r.URL.Query().Get("status"), nil)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to list dispatch offers: %w", err))
+			return
+		}
+		respondJSON(w, http.StatusOK, offers)
+	}
+}
+
+// declineRequest is the optional body of the offer decline endpoints.
+type declineRequest struct {
+	Reason string `json:"reason"`
+}
+
+// driverOfferV1 is an offer made to the calling driver with the booking it
+// is for.
+type driverOfferV1 struct {
+	*data.DispatchOffer
+	BookRide *bookRideV1 `json:"book_ride,omitempty"`
+}
+
+// respondDispatchOffer accepts or declines a pending offer on the driver's
+// behalf. A declined booking goes to the next driver straight away.
+func respondDispatchOffer(repo *repository.BookingRepository, engine *dispatch.Engine, sched *schedule.Scheduler, accept bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := data.OfferResponse{Accept: accept}
+		if adminID, ok := userIDFromContext(r.Context()); ok {
+			resp.RespondedBy = &adminID
+		}
+		if !accept {
+			// The body, and so the reason, is optional for admins.
+			var req declineRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+				respondError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+				return
+			}
+			resp.Reason = strings.TrimSpace(req.Reason)
+		}
+		answerDispatchOffer(w, r, repo, engine, sched, resp)
+	}
+}
+
+// listDriverOffers lists the offers made to the calling driver, newest
+// first, optionally only those with the status given in the query.
+func listDriverOffers(repo *repository.BookingRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		driverID, ok := userIDFromContext(ctx)
+		if !ok {
+			respondError(w, http.StatusUnauthorized, fmt.Errorf("missing user"))
+			return
+		}
+		offers, err := repo.ListDriverDispatchOffers(ctx, driverID, r.URL.Query().Get("status"))
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to list offers: %w", err))
+			return
+		}
+
+		out, err := driverOffersV1(ctx, repo, offers)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err)
+			return
+		}
+		respondJSON(w, http.StatusOK, out)
+	}
+}
+
+// driverOffersV1 converts offers for a driver, with the booking of the
+// pending ones.
+func driverOffersV1(ctx context.Context, repo *repository.BookingRepository, offers []*data.DispatchOffer) ([]driverOfferV1, error) {
+	out := make([]driverOfferV1, len(offers))
+	for i, o := range offers {
+		out[i] = driverOfferV1{DispatchOffer: o}
+		// Only open offers need the booking; it may have changed or been
+		// erased since the others were made.
+		if o.Status != data.OfferPending {
+			continue
+		}
+		ride, err := repo.GetBookRideByID(ctx, o.BookingID)
+		if err != nil && err != pgx.ErrNoRows {
+			return nil, fmt.Errorf("failed to get ride booking: %w", err)
+		}
+		if ride != nil {
+			v := bookRideV1FromModel(ride)
+			out[i].BookRide = &v
+		}
+	}
+	return out, nil
+}
+
+// respondDriverOffer accepts or declines an offer made to the calling
+// driver. Declining requires a reason.
+func respondDriverOffer(repo *repository.BookingRepository, engine *dispatch.Engine, sched *schedule.Scheduler, accept bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		driverID, ok := userIDFromContext(r.Context())
+		if !ok {
+			respondError(w, http.StatusUnauthorized, fmt.Errorf("missing user"))
+			return
+		}
+		resp := data.OfferResponse{Accept: accept, DriverID: &driverID, RespondedBy: &driverID}
+		if !accept {
+			var req declineRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				respondError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+				return
+			}
+			resp.Reason = strings.TrimSpace(req.Reason)
+			if resp.Reason == "" {
+				respondError(w, http.StatusBadRequest, fmt.Errorf("reason is required"))
+				return
+			}
+		}
+		answerDispatchOffer(w, r, repo, engine, sched, resp)
+	}
+}
+
+// answerDispatchOffer stores a response to the offer in the id URL
+// parameter and writes the updated offer. An offer is only accepted if the
+// driver can still take the booking by the rules of driver assignment. A
+// decline triggers a dispatch run.
+func answerDispatchOffer(w http.ResponseWriter, r *http.Request, repo *repository.BookingRepository, engine *dispatch.Engine,
+	sched *schedule.Scheduler, resp data.OfferResponse) {
+	id, err := parseIDParam(r, "id")
+	if err != nil {
+		respondError(w, http.StatusBadRequest, fmt.Errorf("invalid offer ID: %w", err))
+		return
+	}
+	if resp.Accept {
+		resp.CanTake = sched.CanTake
+	}
+
+	offer, err := repo.RespondDispatchOffer(r.Context(), id, resp)
+	if err != nil {
+		var conflict *repository.ScheduleConflictError
+		switch {
+		case err == pgx.ErrNoRows:
+			respondError(w, http.StatusNotFound, fmt.Errorf("offer not found: %d", id))
+		case errors.Is(err, data.ErrOfferClosed):
+			respondError(w, http.StatusConflict, err)
+		case errors.As(err, &conflict):
+			body := scheduleConflictResponse{Error: conflict.Error()}
+			if conflict.BookingID != 0 {
+				body.ConflictingBookingID = conflict.BookingID
+				body.ConflictingInterval = &conflict.Occupied
+			}
+			respondJSON(w, http.StatusConflict, body)
+		default:
+			respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to respond to offer: %w", err))
+		}
+		return
+	}
+	if !resp.Accept {
+		engine.Trigger()
+	}
+	respondJSON(w, http.StatusOK, offer)
+}
+
+// getOfferResponseStats reports per driver how they answered the offers
+// made between the from and to dates in the query, both inclusive and in
+// the scheduling time zone. It defaults to the last 30 days.
+func getOfferResponseStats(repo *repository.BookingRepository, sched *schedule.Scheduler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		loc := sched.Location()
+		now := time.Now().In(loc)
+		to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+		if v := r.URL.Query().Get("to"); v != "" {
+			d, err := time.ParseInLocation(schedule.DateLayout, v, loc)
+			if err != nil {
+				respondError(w, http.StatusBadRequest, fmt.Errorf("invalid to date: %w", err))
+				return
+			}
+			to = d
+		}
+		from := to.AddDate(0, 0, -29)
+		if v := r.URL.Query().Get("from"); v != "" {
+			d, err := time.ParseInLocation(schedule.DateLayout, v, loc)
+			if err != nil {
+				respondError(w, http.StatusBadRequest, fmt.Errorf("invalid from date: %w", err))
+				return
+			}
+			from = d
+		}
+		if to.Before(from) {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("from must not be after to"))
+			return
+		}
+
+		stats, err := repo.ListOfferResponseStats(r.Context(), from, to.AddDate(0, 0, 1))
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to report offer responses: %w", err))
+			return
+		}
+		respondJSON(w, http.StatusOK, stats)
+	}
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"embed"
+	"github.com/go-chi/chi/v5"
+	"luxsuv-backend/jwtkeys"
+	"net/http"
+)
+
+//go:embed openapi.json
+var openAPISpec []byte
+
+//go:embed swagger.html
+var swaggerUIPage []byte
+
+// swaggerUIAssets is the vendored Swagger UI the docs page loads; see
+// swaggerui/README.md.
+//
+//go:embed swaggerui/swagger-ui.css swaggerui/swagger-ui-bundle.js
+var swaggerUIAssets embed.FS
+
+// OpenAPIHandler serves the OpenAPI 3.1 document describing the rider and driver APIs.
+func OpenAPIHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(openAPISpec)
+	}
+}
+
+// SwaggerUIHandler serves a Swagger UI page that renders /openapi.json.
+func SwaggerUIHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write(swaggerUIPage)
+	}
+}
+
+// SwaggerUIAssetsHandler serves the vendored Swagger UI file named by the
+// file URL parameter.
+func SwaggerUIAssetsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+		http.ServeFileFS(w, r, swaggerUIAssets, "swaggerui/"+chi.URLParam(r, "file"))
+	}
+}
+
+// JWKSHandler publishes the public access token verification keys so other
+// services can verify tokens without sharing a secret.
+func JWKSHandler(keys *jwtkeys.KeySet) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		respondJSON(w, http.StatusOK, keys.JWKS())
+	}
+}
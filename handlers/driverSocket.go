@@ -0,0 +1,299 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"luxsuv-backend/config"
+	"luxsuv-backend/data"
+	"luxsuv-backend/pricing"
+	"luxsuv-backend/realtime"
+	"luxsuv-backend/repository"
+	"luxsuv-backend/websocket"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// socketWriteTimeout is how long a message may take to send before the
+	// client is considered stuck and dropped.
+	socketWriteTimeout = 10 * time.Second
+	// socketQueue is how many answers can wait to be sent; a client sending
+	// faster than that is dropped.
+	socketQueue = 16
+)
+
+// Driver socket message types. The server sends hello, booking, offer,
+// reset, ack and error; the client sends location and status.
+const (
+	socketHello    = "hello"
+	socketBooking  = "booking"
+	socketOffer    = "offer"
+	socketReset    = "reset"
+	socketAck      = "ack"
+	socketError    = "error"
+	socketLocation = "location"
+	socketStatus   = "status"
+)
+
+// socketRequest is a message from the driver app. Ref is chosen by the app
+// and echoed in the ack or error answering the message.
+type socketRequest struct {
+	Type      string              `json:"type"`
+	Ref       string              `json:"ref,omitempty"`
+	BookingID int64               `json:"booking_id"`
+	Status    string              `json:"status,omitempty"`
+	Odometer  *float64            `json:"odometer,omitempty"`
+	Pings     []data.LocationPing `json:"pings,omitempty"`
+}
+
+// socketMessage is a message to the driver app; which fields are set
+// depends on its type.
+type socketMessage struct {
+	Type        string                 `json:"type"`
+	Ref         string                 `json:"ref,omitempty"`
+	DriverID    int64                  `json:"driver_id,omitempty"`
+	LastEventID int64                  `json:"last_event_id,omitempty"`
+	Offers      []driverOfferV1        `json:"offers,omitempty"`
+	Event       *data.BookingEvent     `json:"event,omitempty"`
+	Offer       *driverOfferV1         `json:"offer,omitempty"`
+	Location    *locationPingsResponse `json:"location,omitempty"`
+	Code        int                    `json:"code,omitempty"`
+	Error       string                 `json:"error,omitempty"`
+}
+
+// driverSocket is the driver app's WebSocket. The access token is sent in
+// the Authorization header or, for clients that cannot set headers, the
+// access_token query parameter. The server says hello with the driver's
+// pending offers, then pushes booking events, with the offer for those
+// announcing one to the driver, and answers the locations and status
+// changes the app sends. Like the event stream, a client reconnecting with
+// last_event_id first gets the events it missed.
+func driverSocket(repo *repository.BookingRepository, auth *AuthMiddleware, svc Services) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" {
+			token = r.URL.Query().Get("access_token")
+		}
+		if token == "" {
+			http.Error(w, "Authorization header or access_token required", http.StatusUnauthorized)
+			return
+		}
+		claims, status, msg := auth.authenticate(ctx, token, purposeAccess)
+		if claims == nil {
+			http.Error(w, msg, status)
+			return
+		}
+		if claims.role != data.RoleDriver && claims.role != data.RoleAdmin {
+			http.Error(w, "Access denied: driver or admin role required", http.StatusForbidden)
+			return
+		}
+		lastID, err := parseLastEventID(r.URL.Query().Get("last_event_id"))
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		sub, missed, reset, err := subscribeEvents(ctx, svc.Events, 0, lastID)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to replay events: %w", err))
+			return
+		}
+		defer sub.Close()
+		pending, err := repo.ListDriverDispatchOffers(ctx, claims.userID, data.OfferPending)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to list offers: %w", err))
+			return
+		}
+		offers, err := driverOffersV1(ctx, repo, pending)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		heartbeat := svc.Events.Heartbeat()
+		conn, err := websocket.Upgrade(w, r, websocket.Options{
+			IdleTimeout:  2*heartbeat + socketWriteTimeout,
+			WriteTimeout: socketWriteTimeout,
+		})
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		s := &driverSession{
+			conn:     conn,
+			repo:     repo,
+			pricer:   svc.Pricing,
+			tracking: svc.Tracking,
+			driverID: claims.userID,
+			out:      make(chan socketMessage, socketQueue),
+		}
+		s.serve(ctx, sub, socketMessage{Type: socketHello, DriverID: claims.userID, LastEventID: svc.Events.Last(), Offers: offers},
+			missed, reset, heartbeat, claims.expiresAt)
+	}
+}
+
+// driverSession is one connected driver app.
+type driverSession struct {
+	conn     *websocket.Conn
+	repo     *repository.BookingRepository
+	pricer   *pricing.Pricer
+	tracking config.TrackingConfig
+	driverID int64
+	// out holds the answers to the app's messages until they are sent.
+	out chan socketMessage
+}
+
+// serve sends hello and the missed events, then pushes events and answers
+// until the app goes away, falls behind or its access token expires. Every
+// message is written by serve; reading happens on another goroutine.
+func (s *driverSession) serve(ctx context.Context, sub *realtime.Subscription, hello socketMessage, missed []data.BookingEvent, reset bool, heartbeat time.Duration, expiresAt time.Time) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		defer cancel()
+		s.read(ctx)
+	}()
+
+	if err := s.send(hello); err != nil {
+		return
+	}
+	if reset {
+		if err := s.send(socketMessage{Type: socketReset, LastEventID: hello.LastEventID}); err != nil {
+			return
+		}
+	}
+	sent := make(map[int64]bool, len(missed))
+	for _, e := range missed {
+		sent[e.ID] = true
+		if err := s.sendEvent(ctx, e); err != nil {
+			return
+		}
+	}
+
+	ping := time.NewTicker(heartbeat)
+	defer ping.Stop()
+	expired := time.NewTimer(time.Until(expiresAt))
+	defer expired.Stop()
+	for {
+		var err error
+		select {
+		case <-ctx.Done():
+			return
+		case e, ok := <-sub.Events():
+			if !ok {
+				s.conn.WriteClose(websocket.CloseTryAgainLater, "reconnect with last_event_id")
+				return
+			}
+			if sent[e.ID] {
+				continue
+			}
+			err = s.sendEvent(ctx, e)
+		case m := <-s.out:
+			err = s.send(m)
+		case <-ping.C:
+			err = s.conn.Ping()
+		case <-expired.C:
+			s.conn.WriteClose(websocket.ClosePolicyViolation, "access token expired")
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// read handles the app's messages until the connection fails or closes.
+func (s *driverSession) read(ctx context.Context) {
+	for {
+		msgType, payload, err := s.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var answer socketMessage
+		var req socketRequest
+		if msgType != websocket.TextMessage {
+			answer = socketMessage{Type: socketError, Code: http.StatusBadRequest, Error: "messages must be JSON text"}
+		} else if err := json.Unmarshal(payload, &req); err != nil {
+			answer = socketMessage{Type: socketError, Code: http.StatusBadRequest, Error: fmt.Sprintf("invalid message: %v", err)}
+		} else {
+			answer = s.handle(ctx, req)
+		}
+
+		select {
+		case s.out <- answer:
+		default:
+			s.conn.WriteClose(websocket.CloseTryAgainLater, "too many messages")
+			return
+		}
+	}
+}
+
+// handle answers one message from the app.
+func (s *driverSession) handle(ctx context.Context, req socketRequest) socketMessage {
+	var status int
+	var err error
+	answer := socketMessage{Type: socketAck, Ref: req.Ref}
+	switch req.Type {
+	case socketLocation:
+		var resp locationPingsResponse
+		resp, status, err = recordLocations(ctx, s.repo, s.tracking, req.BookingID, s.driverID, req.Pings)
+		answer.Location = &resp
+	case socketStatus:
+		change := data.StatusChange{Status: req.Status, DriverID: &s.driverID, Odometer: req.Odometer}
+		status, err = setDriverStatus(ctx, s.repo, s.pricer, req.BookingID, change)
+	default:
+		status, err = http.StatusBadRequest, fmt.Errorf("unknown message type %q", req.Type)
+	}
+	if err != nil {
+		return socketMessage{Type: socketError, Ref: req.Ref, Code: status, Error: err.Error()}
+	}
+	return answer
+}
+
+// sendEvent sends a booking event the driver may see, with the offer if it
+// announces one to them.
+func (s *driverSession) sendEvent(ctx context.Context, e data.BookingEvent) error {
+	if !e.VisibleTo(s.driverID) {
+		return nil
+	}
+	msg := socketMessage{Type: socketBooking, Event: &e}
+	if e.Type == data.BookingEventOffered && e.OfferID != nil {
+		// Without the offer, because it failed to load or has already closed
+		// again, the app gets the plain event and can list its offers.
+		if offer, err := s.pendingOffer(ctx, *e.OfferID); err == nil && offer != nil {
+			msg.Type, msg.Offer = socketOffer, offer
+		}
+	}
+	return s.send(msg)
+}
+
+// pendingOffer returns the driver's pending offer with ID id, nil if it is
+// no longer pending.
+func (s *driverSession) pendingOffer(ctx context.Context, id int64) (*driverOfferV1, error) {
+	pending, err := s.repo.ListDriverDispatchOffers(ctx, s.driverID, data.OfferPending)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list offers: %w", err)
+	}
+	for _, o := range pending {
+		if o.ID != id {
+			continue
+		}
+		offers, err := driverOffersV1(ctx, s.repo, []*data.DispatchOffer{o})
+		if err != nil {
+			return nil, err
+		}
+		return &offers[0], nil
+	}
+	return nil, nil
+}
+
+func (s *driverSession) send(m socketMessage) error {
+	payload, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return s.conn.WriteMessage(websocket.TextMessage, payload)
+}
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"luxsuv-backend/config"
+	"luxsuv-backend/data"
+	"luxsuv-backend/logger"
+	"luxsuv-backend/realtime"
+	"luxsuv-backend/websocket"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// socketClient is the app end of a driver socket.
+type socketClient struct {
+	t    *testing.T
+	conn net.Conn
+	br   *bufio.Reader
+}
+
+func dialSocket(t *testing.T, srv *httptest.Server) *socketClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(srv.URL, "http://"))
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	io.WriteString(conn, "GET /ws HTTP/1.1\r\nHost: test\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n")
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil || resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("Handshake failed: %v %v", resp, err)
+	}
+	return &socketClient{t: t, conn: conn, br: br}
+}
+
+// send writes a masked text frame.
+func (c *socketClient) send(msg string) {
+	frame := []byte{0x80 | websocket.TextMessage, 0x80 | byte(len(msg)), 0, 0, 0, 0}
+	c.conn.Write(append(frame, msg...))
+}
+
+// next reads the next message, skipping pings.
+func (c *socketClient) next() socketMessage {
+	c.t.Helper()
+	for {
+		var head [2]byte
+		if _, err := io.ReadFull(c.br, head[:]); err != nil {
+			c.t.Fatalf("Failed to read frame: %v", err)
+		}
+		n := int(head[1] & 0x7f)
+		if n == 126 {
+			var ext [2]byte
+			io.ReadFull(c.br, ext[:])
+			n = int(binary.BigEndian.Uint16(ext[:]))
+		}
+		payload := make([]byte, n)
+		io.ReadFull(c.br, payload)
+		if head[0]&0x0f != websocket.TextMessage {
+			continue
+		}
+		var m socketMessage
+		if err := json.Unmarshal(payload, &m); err != nil {
+			c.t.Fatalf("Invalid message %q: %v", payload, err)
+		}
+		return m
+	}
+}
+
+func TestDriverSession(t *testing.T) {
+	hub, err := realtime.New(eventStore{}, config.Defaults().Realtime, logger.NewLogger())
+	if err != nil {
+		t.Fatalf("realtime.New failed: %v", err)
+	}
+	me, other := int64(7), int64(8)
+	missed := []data.BookingEvent{
+		{ID: 1, BookingID: 3, Type: data.BookingEventOffered, DriverID: &other},
+		{ID: 2, BookingID: 3, Type: data.BookingEventUpdated, Status: data.BookingConfirmed, DriverID: &me},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Upgrade(w, r, websocket.Options{})
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		s := &driverSession{conn: conn, driverID: me, tracking: config.Defaults().Tracking, out: make(chan socketMessage, socketQueue)}
+		sub := hub.Subscribe(0)
+		defer sub.Close()
+		s.serve(ctx, sub, socketMessage{Type: socketHello, DriverID: me}, missed, false, time.Minute, time.Now().Add(time.Minute))
+	}))
+	defer srv.Close()
+	c := dialSocket(t, srv)
+
+	if m := c.next(); m.Type != socketHello || m.DriverID != me {
+		t.Errorf("Expected hello, got %+v", m)
+	}
+	// The offer to another driver is not shown.
+	if m := c.next(); m.Type != socketBooking || m.Event == nil || m.Event.ID != 2 {
+		t.Errorf("Expected booking event 2, got %+v", m)
+	}
+
+	c.send(`{"type":"teleport","ref":"a"}`)
+	if m := c.next(); m.Type != socketError || m.Ref != "a" || m.Code != http.StatusBadRequest {
+		t.Errorf("Expected an error for an unknown type, got %+v", m)
+	}
+	c.send(`{"type":"status","ref":"b","booking_id":3,"status":"cancelled"}`)
+	if m := c.next(); m.Type != socketError || m.Ref != "b" || m.Code != http.StatusBadRequest {
+		t.Errorf("Expected an error for a status drivers cannot set, got %+v", m)
+	}
+	c.send(`{"type":"location","ref":"c","booking_id":3,"pings":[]}`)
+	if m := c.next(); m.Type != socketError || m.Ref != "c" || m.Code != http.StatusBadRequest {
+		t.Errorf("Expected an error for an empty batch, got %+v", m)
+	}
+}
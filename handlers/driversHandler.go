@@ -1,95 +1,65 @@
 package handlers
 
 import (
-	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/go-chi/chi/v5"
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/jackc/pgx/v5"
-	"log"
+	"luxsuv-backend/data"
 	"luxsuv-backend/repository"
+	"math"
 	"net/http"
-	"os"
 	"strconv"
-	"strings"
 	"time"
 )
 
-type DriverAuthMiddleware struct {
-	repo   *repository.BookingRepository
-	secret []byte
-}
-
-func NewDriverAuthMiddleware(repo *repository.BookingRepository) *DriverAuthMiddleware {
-	secret := []byte(os.Getenv("JWT_SECRET"))
-	if len(secret) == 0 {
-		log.Fatalf("JWT_SECRET environment variable not set")
-	}
-	return &DriverAuthMiddleware{
-		repo:   repo,
-		secret: secret,
-	}
-}
-
-func (a *DriverAuthMiddleware) Middleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			http.Error(w, "Authorization header required", http.StatusUnauthorized)
-			return
-		}
-
-		tokenStr := strings.TrimPrefix(authHeader, "Bearer ")
-		token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method")
-			}
-			return a.secret, nil
-		})
+func SetupDriverRouter(repo *repository.BookingRepository, auth *AuthMiddleware, svc Services) *chi.Mux {
+	r := chi.NewRouter()
 
-		if err != nil || !token.Valid {
-			http.Error(w, "Invalid token", http.StatusUnauthorized)
-			return
-		}
+	// Login and token refresh endpoints
+	r.Post("/login", loginHandler(repo, auth))
+	r.Post("/login/mfa", loginMFAHandler(repo, auth))
+	r.Post("/refresh", refreshHandler(repo, auth))
 
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok || !token.Valid {
-			http.Error(w, "Invalid token claims", http.StatusUnauthorized)
-			return
-		}
-
-		role, ok := claims["role"].(string)
-		if !ok || role != "driver" {
-			http.Error(w, "Access denied: driver role required", http.StatusForbidden)
-			return
-		}
+	// Two-factor enrolment also accepts the token issued when login requires it
+	r.Group(func(r chi.Router) {
+		r.Use(auth.requireToken([]string{data.RoleDriver, data.RoleAdmin}, purposeAccess, purposeMFAEnroll))
 
-		ctx := context.WithValue(r.Context(), "userID", int64(claims["id"].(float64)))
-		next.ServeHTTP(w, r.WithContext(ctx))
+		r.Post("/mfa/enroll", enrollMFA(repo))
+		r.Post("/mfa/enroll/confirm", confirmMFA(repo, auth))
 	})
-}
-
-func SetupDriverRouter(repo *repository.BookingRepository) *chi.Mux {
-	r := chi.NewRouter()
-	authMiddleware := NewDriverAuthMiddleware(repo)
 
-	// Login endpoint
-	r.Post("/login", loginHandler(repo, authMiddleware))
+	// The driver app's WebSocket checks the access token itself, since
+	// browsers cannot set headers on one
+	r.Get("/ws", driverSocket(repo, auth, svc))
 
-	// Protected driver endpoints
+	// Protected driver endpoints; admins can see everything drivers can
 	r.Group(func(r chi.Router) {
-		r.Use(authMiddleware.Middleware)
+		r.Use(auth.RequireRoles(data.RoleDriver, data.RoleAdmin))
 
 		r.Get("/book-rides", listAllBookRides(repo))
 		r.Get("/book-ride/{id}", getBookRide(repo))
 		r.Delete("/book-ride/{id}", deleteBookRide(repo))
+		r.Put("/password", changePassword(repo, auth))
+		r.Post("/logout", logoutHandler(repo))
+		r.Get("/events", streamDriverEvents(svc.Events))
+		r.Get("/offers", listDriverOffers(repo))
+		r.Post("/offers/{id}/accept", respondDriverOffer(repo, svc.Dispatch, svc.Scheduler, true))
+		r.Post("/offers/{id}/decline", respondDriverOffer(repo, svc.Dispatch, svc.Scheduler, false))
+		r.Put("/book-ride/{id}/status", setDriverBookRideStatus(repo, svc.Pricing))
+		r.Get("/book-ride/{id}/trip", getBookRideTrip(repo, svc.Pricing))
+		r.Get("/book-ride/{id}/charges", listBookRideCharges(repo))
+		r.Post("/book-ride/{id}/charges", createTripCharge(repo))
+		r.Delete("/charges/{id}", deleteTripCharge(repo))
+		r.Put("/charges/{id}/receipt", uploadChargeReceipt(repo))
+		r.Get("/charges/{id}/receipt", getChargeReceipt(repo, "id"))
+		r.Post("/book-ride/{id}/locations", recordLocationPings(repo, svc.Tracking))
 	})
 
 	return r
 }
 
-func loginHandler(repo *repository.BookingRepository, middleware *DriverAuthMiddleware) http.HandlerFunc {
+func loginHandler(repo *repository.BookingRepository, middleware *AuthMiddleware) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var creds struct {
 			Username string `json:"username"`
@@ -100,29 +70,29 @@ func loginHandler(repo *repository.BookingRepository, middleware *DriverAuthMidd
 			return
 		}
 
-		user, err := repo.GetUserByCredentials(r.Context(), creds.Username, creds.Password)
+		ctx := r.Context()
+		until, err := middleware.logins.lockedUntil(ctx, r, creds.Username)
 		if err != nil {
-			if err == pgx.ErrNoRows {
-				respondError(w, http.StatusUnauthorized, fmt.Errorf("invalid credentials"))
-				return
-			}
 			respondError(w, http.StatusInternalServerError, fmt.Errorf("login failed: %w", err))
 			return
 		}
+		if !until.IsZero() {
+			respondLockedOut(w, r, middleware, creds.Username, until)
+			return
+		}
 
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-			"id":       user.ID,
-			"username": user.Username,
-			"role":     user.Role,
-			"exp":      time.Now().Add(24 * time.Hour).Unix(),
-		})
-		tokenString, err := token.SignedString(middleware.secret) // Use middleware parameter
+		user, err := repo.GetUserByCredentials(ctx, creds.Username, creds.Password)
 		if err != nil {
-			respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to generate token: %w", err))
+			if err == pgx.ErrNoRows {
+				middleware.logins.recordFailure(ctx, r, creds.Username, "invalid_credentials")
+				respondError(w, http.StatusUnauthorized, fmt.Errorf("invalid credentials"))
+				return
+			}
+			respondError(w, http.StatusInternalServerError, fmt.Errorf("login failed: %w", err))
 			return
 		}
 
-		respondJSON(w, http.StatusOK, map[string]string{"token": tokenString})
+		completeLogin(w, r, middleware, user)
 	}
 }
 
@@ -146,7 +116,7 @@ func getBookRide(repo *repository.BookingRepository) http.HandlerFunc {
 			return
 		}
 
-		respondJSON(w, http.StatusOK, ride)
+		respondJSON(w, http.StatusOK, bookRideV1FromModel(ride))
 	}
 }
 
@@ -181,6 +151,83 @@ func listAllBookRides(repo *repository.BookingRepository) http.HandlerFunc {
 			respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to list all ride bookings: %w", err))
 			return
 		}
-		respondJSON(w, http.StatusOK, rides)
+		respondJSON(w, http.StatusOK, bookRidesV1FromModels(rides))
+	}
+}
+
+// respondLockedOut answers a login attempt for username, or from the client's
+// IP, that is locked out until the given time with 429 and Retry-After.
+func respondLockedOut(w http.ResponseWriter, r *http.Request, auth *AuthMiddleware, username string, until time.Time) {
+	auth.logins.audit(r.Context(), r, username, nil, false, "locked_out")
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(time.Until(until).Seconds()))))
+	respondError(w, http.StatusTooManyRequests, fmt.Errorf("too many failed login attempts, try again later"))
+}
+
+// changePassword sets a new password for the authenticated user. Every
+// existing session is revoked, so fresh tokens are returned for this one.
+func changePassword(repo *repository.BookingRepository, auth *AuthMiddleware) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		userID, ok := userIDFromContext(ctx)
+		if !ok {
+			respondError(w, http.StatusUnauthorized, fmt.Errorf("missing user identity"))
+			return
+		}
+
+		var req struct {
+			CurrentPassword string `json:"current_password"`
+			NewPassword     string `json:"new_password"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+			return
+		}
+		if err := validatePassword(req.NewPassword); err != nil {
+			respondError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		user, err := repo.GetUserByID(ctx, userID)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to load user: %w", err))
+			return
+		}
+		// A stolen access token must not allow guessing the password faster
+		// than logging in would.
+		until, err := auth.logins.lockedUntil(ctx, r, user.Username)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to verify password: %w", err))
+			return
+		}
+		if !until.IsZero() {
+			respondLockedOut(w, r, auth, user.Username, until)
+			return
+		}
+		if _, err := repo.GetUserByCredentials(ctx, user.Username, req.CurrentPassword); err != nil {
+			if err == pgx.ErrNoRows {
+				auth.logins.recordFailure(ctx, r, user.Username, "password_change_failed")
+				respondError(w, http.StatusUnauthorized, fmt.Errorf("current password is incorrect"))
+				return
+			}
+			respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to verify password: %w", err))
+			return
+		}
+
+		if err := repo.UpdateUserPassword(ctx, userID, req.NewPassword); err != nil {
+			respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to change password: %w", err))
+			return
+		}
+		auth.logins.audit(ctx, r, user.Username, &user.ID, true, "password_changed")
+		if user, err = repo.GetUserByID(ctx, userID); err != nil {
+			respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to load user: %w", err))
+			return
+		}
+
+		resp, err := auth.issueTokens(r, user)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, fmt.Errorf("password changed but failed to generate token: %w", err))
+			return
+		}
+		respondJSON(w, http.StatusOK, resp)
 	}
 }
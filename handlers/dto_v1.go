@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"luxsuv-backend/data"
+)
+
+// bookRideV1 is the /v1 wire representation of a ride booking. It is kept
+// separate from data.BookRide so the storage model can change without
+// breaking clients pinned to /v1; a later API version defines its own DTOs
+// and conversions next to this one.
+type bookRideV1 struct {
+	ID                 int64                 `json:"id"`
+	YourName           string                `json:"your_name"`
+	Email              string                `json:"email"`
+	PhoneNumber        string                `json:"phone_number"`
+	RideType           string                `json:"ride_type"`
+	PickupLocation     locationV1            `json:"pickup_location"`
+	DropoffLocation    locationV1            `json:"dropoff_location"`
+	Date               string                `json:"date"`
+	Time               string                `json:"time"`
+	NumberOfPassengers int                   `json:"number_of_passengers"`
+	NumberOfLuggage    int                   `json:"number_of_luggage"`
+	AdditionalNotes    string                `json:"additional_notes"`
+	PickupDetails      *data.Location        `json:"pickup_details,omitempty"`  // read-only
+	DropoffDetails     *data.Location        `json:"dropoff_details,omitempty"` // read-only
+	GeocodeStatus      string                `json:"geocode_status,omitempty"`  // read-only
+	ZoneID             *int64                `json:"zone_id,omitempty"`         // read-only
+	RouteEstimate      *data.RouteEstimate   `json:"route_estimate,omitempty"`  // read-only
+	VehicleID          *int64                `json:"vehicle_id,omitempty"`      // read-only
+	Hours              int                   `json:"hours,omitempty"`
+	DriverID           *int64                `json:"driver_id,omitempty"` // read-only
+	Occupied           *data.TimeRange       `json:"occupied,omitempty"`  // read-only
+	Status             string                `json:"status,omitempty"`    // read-only
+	Preferences        *data.RidePreferences `json:"preferences,omitempty"`
+}
+
+func (b *bookRideV1) toModel() *data.BookRide {
+	return &data.BookRide{
+		ID:                 b.ID,
+		YourName:           b.YourName,
+		Email:              b.Email,
+		PhoneNumber:        b.PhoneNumber,
+		RideType:           b.RideType,
+		PickupLocation:     data.Location(b.PickupLocation),
+		DropoffLocation:    data.Location(b.DropoffLocation),
+		Date:               b.Date,
+		Time:               b.Time,
+		NumberOfPassengers: b.NumberOfPassengers,
+		NumberOfLuggage:    b.NumberOfLuggage,
+		AdditionalNotes:    b.AdditionalNotes,
+		Hours:              b.Hours,
+		Preferences:        b.Preferences,
+	}
+}
+
+func bookRideV1FromModel(ride *data.BookRide) bookRideV1 {
+	return bookRideV1{
+		ID:                 ride.ID,
+		YourName:           ride.YourName,
+		Email:              ride.Email,
+		PhoneNumber:        ride.PhoneNumber,
+		RideType:           ride.RideType,
+		PickupLocation:     locationV1{Address: ride.PickupLocation.Address},
+		DropoffLocation:    locationV1{Address: ride.DropoffLocation.Address},
+		Date:               ride.Date,
+		Time:               ride.Time,
+		NumberOfPassengers: ride.NumberOfPassengers,
+		NumberOfLuggage:    ride.NumberOfLuggage,
+		AdditionalNotes:    ride.AdditionalNotes,
+		PickupDetails:      locationDetails(ride.PickupLocation),
+		DropoffDetails:     locationDetails(ride.DropoffLocation),
+		GeocodeStatus:      ride.GeocodeStatus,
+		ZoneID:             ride.ZoneID,
+		RouteEstimate:      ride.RouteEstimate,
+		VehicleID:          ride.VehicleID,
+		Hours:              ride.Hours,
+		DriverID:           ride.DriverID,
+		Occupied:           ride.Occupied,
+		Status:             ride.Status,
+		Preferences:        ride.Preferences,
+	}
+}
+
+func bookRidesV1FromModels(rides []*data.BookRide) []bookRideV1 {
+	out := make([]bookRideV1, 0, len(rides))
+	for _, ride := range rides {
+		out = append(out, bookRideV1FromModel(ride))
+	}
+	return out
+}
+
+// locationV1 is a pickup or dropoff location in /v1. It was originally a plain
+// address string, which is still accepted; clients may instead send a
+// data.Location object. It is always written back as the address string, so
+// clients that never send objects never receive one, whatever geocoding
+// adds; the structured details are returned in separate fields.
+type locationV1 data.Location
+
+func (l *locationV1) UnmarshalJSON(b []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(b), []byte(`"`)) {
+		*l = locationV1{}
+		return json.Unmarshal(b, &l.Address)
+	}
+	var loc data.Location
+	if err := json.Unmarshal(b, &loc); err != nil {
+		return err
+	}
+	*l = locationV1(loc)
+	return nil
+}
+
+func (l locationV1) MarshalJSON() ([]byte, error) {
+	return json.Marshal(l.Address)
+}
+
+// locationDetails returns loc for the *_details fields, or nil if it holds
+// nothing beyond its address.
+func locationDetails(loc data.Location) *data.Location {
+	if (data.Location{Address: loc.Address}) == loc {
+		return nil
+	}
+	return &loc
+}
@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/go-chi/chi/v5"
+	"luxsuv-backend/config"
+	"luxsuv-backend/data"
+	"luxsuv-backend/schedule"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBookRideV1AcceptsLegacyAndStructuredLocations(t *testing.T) {
+	body := `{
+		"pickup_location": "123 Main St",
+		"dropoff_location": {"address": "LAX Terminal 4", "lat": 33.9416, "lng": -118.4085, "airport_code": "LAX", "terminal": "4"}
+	}`
+	var req bookRideV1
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		t.Fatalf("Failed to decode booking: %v", err)
+	}
+	ride := req.toModel()
+	if ride.PickupLocation.Address != "123 Main St" || ride.PickupLocation.HasCoordinates() {
+		t.Errorf("Unexpected pickup location: %+v", ride.PickupLocation)
+	}
+	if !ride.DropoffLocation.HasCoordinates() || *ride.DropoffLocation.Lat != 33.9416 || ride.DropoffLocation.AirportCode != "LAX" {
+		t.Errorf("Unexpected dropoff location: %+v", ride.DropoffLocation)
+	}
+
+	out, err := json.Marshal(bookRideV1FromModel(ride))
+	if err != nil {
+		t.Fatalf("Failed to encode booking: %v", err)
+	}
+	if !strings.Contains(string(out), `"pickup_location":"123 Main St"`) {
+		t.Errorf("Expected address-only location to be written as a string, got %s", out)
+	}
+	if !strings.Contains(string(out), `"dropoff_location":"LAX Terminal 4"`) ||
+		!strings.Contains(string(out), `"dropoff_details":{"address":"LAX Terminal 4","lat":33.9416`) {
+		t.Errorf("Expected structured location to be written as a string with details, got %s", out)
+	}
+	if strings.Contains(string(out), `"pickup_details"`) {
+		t.Errorf("Expected no details for an address-only location, got %s", out)
+	}
+}
+
+func TestGeocodedBookRideKeepsStringLocations(t *testing.T) {
+	zones := []*data.Zone{{ID: 2, Name: "LAX", Boundary: json.RawMessage(`{"type": "Polygon",
+		"coordinates": [[[-118.45, 33.90], [-118.35, 33.90], [-118.35, 33.98], [-118.45, 33.98], [-118.45, 33.90]]]}`)}}
+	geocoder := stubGeocoder{"LAX": {Lat: 33.9416, Lng: -118.4085}}
+	var req bookRideV1
+	if err := json.Unmarshal([]byte(`{"pickup_location": "LAX", "dropoff_location": "Union Station"}`), &req); err != nil {
+		t.Fatalf("Failed to decode booking: %v", err)
+	}
+	ride := req.toModel()
+	if err := tagZone(context.Background(), geocoder, zones, ride); err != nil || !ride.PickupLocation.HasCoordinates() {
+		t.Fatalf("Expected the pickup to be geocoded, got %+v (%v)", ride.PickupLocation, err)
+	}
+
+	out, err := json.Marshal(bookRidesV1FromModels([]*data.BookRide{ride}))
+	if err != nil {
+		t.Fatalf("Failed to encode bookings: %v", err)
+	}
+	var list []map[string]json.RawMessage
+	if err := json.Unmarshal(out, &list); err != nil || len(list) != 1 {
+		t.Fatalf("Unexpected list %s (%v)", out, err)
+	}
+	if got := string(list[0]["pickup_location"]); got != `"LAX"` {
+		t.Errorf("Expected pickup_location to stay a string, got %s", got)
+	}
+	if got := string(list[0]["dropoff_location"]); got != `"Union Station"` {
+		t.Errorf("Expected dropoff_location to stay a string, got %s", got)
+	}
+	var details data.Location
+	if err := json.Unmarshal(list[0]["pickup_details"], &details); err != nil || !details.HasCoordinates() {
+		t.Errorf("Expected the geocoded pickup in pickup_details, got %s (%v)", list[0]["pickup_details"], err)
+	}
+}
+
+func TestValidateLocation(t *testing.T) {
+	lat, lng := 91.0, 0.0
+	cases := []struct {
+		body string
+		ok   bool
+	}{
+		{`"123 Main St"`, true},
+		{`""`, false},
+		{`{"address": "x", "lat": 1}`, false},
+		{`{"address": "x", "airport_code": "lax"}`, false},
+		{`{"address": "x", "terminal": "B"}`, false},
+		{`{"address": "x", "airport_code": "JFK", "terminal": "B", "place_id": "abc"}`, true},
+	}
+	for _, c := range cases {
+		var loc locationV1
+		if err := json.Unmarshal([]byte(c.body), &loc); err != nil {
+			t.Fatalf("Failed to decode %s: %v", c.body, err)
+		}
+		if err := validateLocation("pickup_location", data.Location(loc)); (err == nil) != c.ok {
+			t.Errorf("validateLocation(%s): expected ok=%v, got %v", c.body, c.ok, err)
+		}
+	}
+
+	loc := data.Location{Address: "x", Lat: &lat, Lng: &lng}
+	if err := validateLocation("pickup_location", loc); err == nil {
+		t.Errorf("Expected latitude 91 to be rejected")
+	}
+}
+
+func TestValidateBookRideSchedule(t *testing.T) {
+	valid := data.BookRide{
+		YourName: "Jane Doe", Email: "jane@example.com", PhoneNumber: "098-765-4321", RideType: "hourly",
+		PickupLocation: data.Location{Address: "LAX"}, DropoffLocation: data.Location{Address: "Union Station"},
+		Date: "2026-06-24", Time: "3:00 pm", NumberOfPassengers: 2, Hours: 3,
+	}
+	if err := validateBookRide(&valid, true); err != nil {
+		t.Fatalf("Expected valid booking, got %v", err)
+	}
+	for name, mutate := range map[string]func(*data.BookRide){
+		"bad date":          func(r *data.BookRide) { r.Date = "June 24" },
+		"bad time":          func(r *data.BookRide) { r.Time = "afternoon" },
+		"negative hours":    func(r *data.BookRide) { r.Hours = -1 },
+		"hours on per_ride": func(r *data.BookRide) { r.RideType = "per_ride" },
+		"empty amenity":     func(r *data.BookRide) { r.Preferences = &data.RidePreferences{Amenities: []string{" "}} },
+	} {
+		ride := valid
+		mutate(&ride)
+		if err := validateBookRide(&ride, true); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+
+	// Legacy requests keep accepting the free-form dates they always did.
+	legacy := valid
+	legacy.Date, legacy.Time = "June 24", "afternoon"
+	if err := validateBookRide(&legacy, false); err != nil {
+		t.Errorf("Expected legacy pickup formats to be accepted, got %v", err)
+	}
+}
+
+func TestScheduleRiderBookRideLegacyPickup(t *testing.T) {
+	sched, err := schedule.New(config.Defaults().Scheduling)
+	if err != nil {
+		t.Fatalf("schedule.New failed: %v", err)
+	}
+
+	var legacy bool
+	DeprecatedAlias("/v1", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		legacy = isLegacyRequest(r.Context())
+	})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/rider/book-ride", nil))
+	if !legacy {
+		t.Fatal("Expected requests through DeprecatedAlias to be marked legacy")
+	}
+
+	ride := &data.BookRide{RideType: "per_ride", Date: "June 24", Time: "afternoon"}
+	if err := scheduleRiderBookRide(sched, ride, false); err == nil {
+		t.Error("Expected an unparsable pickup to be rejected on the versioned API")
+	}
+	if err := scheduleRiderBookRide(sched, ride, true); err != nil || ride.Occupied != nil {
+		t.Errorf("Expected a legacy booking to be left unscheduled, got %v, %v", ride.Occupied, err)
+	}
+	ride.Date, ride.Time = "2026-06-24", "3:00 pm"
+	if err := scheduleRiderBookRide(sched, ride, true); err != nil || ride.Occupied == nil {
+		t.Errorf("Expected a legacy booking with a valid pickup to be scheduled, got %v, %v", ride.Occupied, err)
+	}
+}
+
+func TestScheduleAssignedBookRideLegacyPickup(t *testing.T) {
+	sched, err := schedule.New(config.Defaults().Scheduling)
+	if err != nil {
+		t.Fatalf("schedule.New failed: %v", err)
+	}
+
+	ride := &data.BookRide{RideType: "per_ride", Date: "June 24", Time: "afternoon", Occupied: &data.TimeRange{}}
+	if err := scheduleAssignedBookRide(sched, ride); err != nil || ride.Occupied != nil {
+		t.Errorf("Expected an unscheduled booking to be assignable without an interval, got %v, %v", ride.Occupied, err)
+	}
+	ride.Date, ride.Time = "2026-06-24", "15:00"
+	if err := scheduleAssignedBookRide(sched, ride); err != nil || ride.Occupied == nil {
+		t.Errorf("Expected a booking with a valid pickup to be scheduled, got %v, %v", ride.Occupied, err)
+	}
+}
+
+func TestUpdateBookRideRequiresTheBookingsToken(t *testing.T) {
+	handler := updateBookRide(nil, Services{})
+	r := httptest.NewRequest("PUT", "/book-ride/8", strings.NewReader(`{}`))
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", "8")
+	ctx := context.WithValue(r.Context(), chi.RouteCtxKey, rctx)
+	ctx = context.WithValue(ctx, bookRideKey, &data.BookRide{ID: 7})
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r.WithContext(ctx))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for another booking's token, got %d", w.Code)
+	}
+}
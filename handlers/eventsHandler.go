@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"luxsuv-backend/data"
+	"luxsuv-backend/realtime"
+	"luxsuv-backend/repository"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sseRetry is how long clients wait before reconnecting a dropped stream.
+const sseRetry = 3 * time.Second
+
+// requireBookingAccess lets through requests for the booking in the id URL
+// parameter that carry a driver or admin access token, or the booking's own
+// token. Browsers cannot set headers on an EventSource, so the booking token
+// is also accepted in the token query parameter.
+func requireBookingAccess(repo *repository.BookingRepository, auth *AuthMiddleware) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			id, err := parseIDParam(r, "id")
+			if err != nil {
+				respondError(w, http.StatusBadRequest, fmt.Errorf("invalid booking ID: %w", err))
+				return
+			}
+
+			if authHeader := r.Header.Get("Authorization"); authHeader != "" {
+				claims, status, msg := auth.authenticate(ctx, strings.TrimPrefix(authHeader, "Bearer "), purposeAccess)
+				if claims == nil {
+					http.Error(w, msg, status)
+					return
+				}
+				if claims.role != data.RoleDriver && claims.role != data.RoleAdmin {
+					http.Error(w, "Access denied: driver or admin role required", http.StatusForbidden)
+					return
+				}
+				if _, err := repo.GetBookRideByID(ctx, id); err != nil {
+					if err == pgx.ErrNoRows {
+						respondError(w, http.StatusNotFound, fmt.Errorf("ride booking not found: %d", id))
+						return
+					}
+					respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to get ride booking: %w", err))
+					return
+				}
+				next.ServeHTTP(w, r.WithContext(context.WithValue(ctx, claimsKey, claims)))
+				return
+			}
+
+			token := r.Header.Get(bookingTokenHeader)
+			if token == "" {
+				token = r.URL.Query().Get("token")
+			}
+			if token == "" {
+				respondError(w, http.StatusUnauthorized, fmt.Errorf("%s header or access token required", bookingTokenHeader))
+				return
+			}
+			ride, err := repo.GetBookRideByAccessToken(ctx, token)
+			if err != nil {
+				if err == pgx.ErrNoRows {
+					respondError(w, http.StatusUnauthorized, fmt.Errorf("invalid booking token"))
+					return
+				}
+				respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to validate booking token: %w", err))
+				return
+			}
+			if ride.ID != id {
+				respondError(w, http.StatusForbidden, fmt.Errorf("booking token is for another booking"))
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(ctx, bookRideKey, ride)))
+		})
+	}
+}
+
+// streamBookRideEvents streams the events of the booking in the id URL
+// parameter; see streamEvents.
+func streamBookRideEvents(hub *realtime.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := parseIDParam(r, "id")
+		if err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid booking ID: %w", err))
+			return
+		}
+		streamEvents(w, r, hub, id)
+	}
+}
+
+// streamDriverEvents streams the events of every booking; see streamEvents.
+func streamDriverEvents(hub *realtime.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		streamEvents(w, r, hub, 0)
+	}
+}
+
+// streamEvents writes the booking events of one booking, or of all if
+// bookingID is 0, as Server-Sent Events named "booking" until the client
+// goes away, falls behind or the server shuts down. A client reconnecting
+// with Last-Event-ID first gets the events it missed; one that missed too
+// many gets a "reset" event instead and should reload the bookings it shows.
+// Idle streams get a comment every heartbeat. Offer events are only streamed
+// to the driver they are for.
+func streamEvents(w http.ResponseWriter, r *http.Request, hub *realtime.Hub, bookingID int64) {
+	ctx := r.Context()
+	userID, _ := userIDFromContext(ctx)
+	lastID, err := parseLastEventID(r.Header.Get("Last-Event-ID"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err)
+		return
+	}
+	sub, missed, reset, err := subscribeEvents(ctx, hub, bookingID, lastID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to replay events: %w", err))
+		return
+	}
+	defer sub.Close()
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", sseRetry.Milliseconds())
+	if reset {
+		fmt.Fprintf(w, "id: %d\nevent: reset\ndata: {}\n\n", hub.Last())
+	}
+	sent := make(map[int64]bool, len(missed))
+	for _, e := range missed {
+		sent[e.ID] = true
+		if !e.VisibleTo(userID) {
+			continue
+		}
+		if err := writeBookingEvent(w, e); err != nil {
+			return
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(hub.Heartbeat())
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e, ok := <-sub.Events():
+			if !ok {
+				return
+			}
+			if sent[e.ID] || !e.VisibleTo(userID) {
+				continue
+			}
+			if err := writeBookingEvent(w, e); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// parseLastEventID parses the ID of the last event a client saw, 0 if v is
+// empty.
+func parseLastEventID(v string) (int64, error) {
+	if v == "" {
+		return 0, nil
+	}
+	id, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || id < 0 {
+		return 0, fmt.Errorf("invalid last event ID: %q", v)
+	}
+	return id, nil
+}
+
+// subscribeEvents subscribes to the events of one booking, or of all if
+// bookingID is 0, returning the events after lastID if it is not 0. If too
+// many were missed it subscribes without them and reports that the client
+// must reset.
+func subscribeEvents(ctx context.Context, hub *realtime.Hub, bookingID, lastID int64) (*realtime.Subscription, []data.BookingEvent, bool, error) {
+	if lastID == 0 {
+		return hub.Subscribe(bookingID), nil, false, nil
+	}
+	sub, missed, err := hub.Resume(ctx, bookingID, lastID)
+	if err == realtime.ErrTooFarBehind {
+		return hub.Subscribe(bookingID), nil, true, nil
+	}
+	if err != nil {
+		return nil, nil, false, err
+	}
+	return sub, missed, false, nil
+}
+
+func writeBookingEvent(w http.ResponseWriter, e data.BookingEvent) error {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: booking\ndata: %s\n\n", e.ID, payload)
+	return err
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"context"
+	"luxsuv-backend/config"
+	"luxsuv-backend/data"
+	"luxsuv-backend/logger"
+	"luxsuv-backend/realtime"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// eventStore serves fixed events and never announces any.
+type eventStore struct {
+	events []data.BookingEvent
+}
+
+func (s eventStore) ListenBookingEvents(ctx context.Context, listening func(), handle func(data.BookingEvent)) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (s eventStore) ListBookingEvents(_ context.Context, afterID, bookingID int64, limit int) ([]data.BookingEvent, error) {
+	var out []data.BookingEvent
+	for _, e := range s.events {
+		if e.ID > afterID && (bookingID == 0 || e.BookingID == bookingID) && len(out) < limit {
+			out = append(out, e)
+		}
+	}
+	return out, nil
+}
+
+func (s eventStore) LastBookingEventID(context.Context) (int64, error) {
+	return int64(len(s.events)), nil
+}
+
+func TestStreamEventsReplaysMissedEvents(t *testing.T) {
+	store := eventStore{}
+	for i := int64(1); i <= 4; i++ {
+		store.events = append(store.events, data.BookingEvent{ID: i, BookingID: 7, Type: data.BookingEventUpdated, Status: data.BookingConfirmed})
+	}
+	hub, err := realtime.New(store, config.Defaults().Realtime, logger.NewLogger())
+	if err != nil {
+		t.Fatalf("realtime.New failed: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req := httptest.NewRequest(http.MethodGet, "/events", nil).WithContext(ctx)
+	req.Header.Set("Last-Event-ID", "2")
+	w := httptest.NewRecorder()
+	streamDriverEvents(hub)(w, req)
+
+	if ct := w.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Expected an event stream, got %q", ct)
+	}
+	body := w.Body.String()
+	for _, want := range []string{"retry: 3000\n\n", "id: 3\nevent: booking\ndata: {\"id\":3,", "id: 4\nevent: booking\n"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected %q in stream, got %q", want, body)
+		}
+	}
+	if strings.Contains(body, "id: 2\n") {
+		t.Errorf("Expected events up to Last-Event-ID to be skipped, got %q", body)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/events", nil)
+	req.Header.Set("Last-Event-ID", "latest")
+	w = httptest.NewRecorder()
+	streamDriverEvents(hub)(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a malformed Last-Event-ID, got %d", w.Code)
+	}
+}
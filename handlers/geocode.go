@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"luxsuv-backend/data"
+	"luxsuv-backend/geo"
+	"luxsuv-backend/repository"
+	"luxsuv-backend/schedule"
+	"math"
+	"time"
+)
+
+// geocodeTimeout bounds the background lookup of a booking's addresses.
+const geocodeTimeout = 30 * time.Second
+
+// routeTimeout bounds a route estimate.
+const routeTimeout = 10 * time.Second
+
+// initialGeocodeStatus is the status a booking is stored with: resolved if
+// the rider sent coordinates for both locations, pending if they will be
+// looked up, and empty without a geocoder.
+func initialGeocodeStatus(ride *data.BookRide, geocoder geo.Geocoder) string {
+	if ride.PickupLocation.HasCoordinates() && ride.DropoffLocation.HasCoordinates() {
+		return data.GeocodeResolved
+	}
+	if geocoder == nil {
+		return ""
+	}
+	return data.GeocodePending
+}
+
+// geocodeBookRide looks up the locations of a pending booking without
+// coordinates and stores the result, flagging the booking unresolved if an
+// address is unknown. It runs in the background after the booking has been
+// saved; on geocoder errors the booking stays pending. Once both locations are
+// resolved the route is estimated too, and the occupied interval of a
+// scheduled booking recomputed from it.
+func geocodeBookRide(repo *repository.BookingRepository, geocoder geo.Geocoder, router geo.Router, sched *schedule.Scheduler, id int64, ride *data.BookRide) {
+	ctx, cancel := context.WithTimeout(context.Background(), geocodeTimeout)
+	defer cancel()
+
+	status := data.GeocodeResolved
+	locations := []*data.Location{&ride.PickupLocation, &ride.DropoffLocation}
+	for _, loc := range locations {
+		if loc.HasCoordinates() {
+			continue
+		}
+		place, err := geocoder.Geocode(ctx, loc.Address)
+		if err == geo.ErrNotFound {
+			status = data.GeocodeUnresolved
+			continue
+		}
+		if err != nil {
+			log.Printf("Failed to geocode booking %d: %v", id, err)
+			return
+		}
+		resolveLocation(loc, place)
+	}
+
+	saved := ride.Occupied
+	if status == data.GeocodeResolved {
+		estimateRoute(ctx, router, ride)
+		if saved != nil {
+			if err := scheduleBookRide(sched, ride); err != nil {
+				log.Printf("Failed to reschedule booking %d: %v", id, err)
+				ride.Occupied = saved
+			}
+		}
+	}
+	err := repo.SetBookRideGeocode(ctx, id, ride.PickupLocation, ride.DropoffLocation, status, ride.RouteEstimate, saved, ride.Occupied)
+	var conflict *repository.ScheduleConflictError
+	if errors.As(err, &conflict) {
+		// The estimated trip runs into another booking of the assigned
+		// driver. Keep the old interval and leave the clash to dispatchers.
+		log.Printf("Booking %d no longer fits its driver's schedule: %v", id, conflict)
+		err = repo.SetBookRideGeocode(ctx, id, ride.PickupLocation, ride.DropoffLocation, status, ride.RouteEstimate, saved, saved)
+	}
+	if err != nil {
+		log.Printf("Failed to store geocode of booking %d: %v", id, err)
+	}
+}
+
+// resolveLocation fills loc in from a geocoded place.
+func resolveLocation(loc *data.Location, place *geo.Place) {
+	resolved := place.Location(loc.Address)
+	// What the rider said about the airport wins over the geocoder.
+	if loc.AirportCode != "" {
+		resolved.AirportCode = loc.AirportCode
+	}
+	if loc.PlaceID != "" {
+		resolved.PlaceID = loc.PlaceID
+	}
+	resolved.Terminal = loc.Terminal
+	*loc = resolved
+}
+
+// estimateRoute sets the route estimate of a booking whose locations both
+// have coordinates. Without a router, or if the router fails, the booking is
+// left without an estimate.
+func estimateRoute(ctx context.Context, router geo.Router, ride *data.BookRide) {
+	ride.RouteEstimate = nil
+	pickup, dropoff := ride.PickupLocation, ride.DropoffLocation
+	if router == nil || !pickup.HasCoordinates() || !dropoff.HasCoordinates() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, routeTimeout)
+	defer cancel()
+	route, err := router.Route(ctx, geo.Point{Lat: *pickup.Lat, Lng: *pickup.Lng}, geo.Point{Lat: *dropoff.Lat, Lng: *dropoff.Lng})
+	if err != nil {
+		log.Printf("Failed to estimate route: %v", err)
+		return
+	}
+	ride.RouteEstimate = &data.RouteEstimate{
+		DistanceMeters:  int(math.Round(route.DistanceMeters)),
+		DurationSeconds: int(math.Round(route.Duration.Seconds())),
+	}
+}
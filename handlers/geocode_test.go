@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"context"
+	"luxsuv-backend/data"
+	"luxsuv-backend/geo"
+	"testing"
+)
+
+func TestEstimateRoute(t *testing.T) {
+	lat1, lng1, lat2, lng2 := 33.9416, -118.4085, 34.0562, -118.2365
+	router := geo.NewHaversine(1.3, 40)
+	ride := &data.BookRide{
+		PickupLocation:  data.Location{Address: "LAX", Lat: &lat1, Lng: &lng1},
+		DropoffLocation: data.Location{Address: "Union Station"},
+	}
+	estimateRoute(context.Background(), router, ride)
+	if ride.RouteEstimate != nil {
+		t.Errorf("Expected no estimate without dropoff coordinates, got %+v", ride.RouteEstimate)
+	}
+
+	ride.DropoffLocation.Lat, ride.DropoffLocation.Lng = &lat2, &lng2
+	estimateRoute(context.Background(), router, ride)
+	if ride.RouteEstimate == nil || ride.RouteEstimate.DistanceMeters < 26000 || ride.RouteEstimate.DistanceMeters > 27000 {
+		t.Fatalf("Unexpected estimate %+v", ride.RouteEstimate)
+	}
+	if want := ride.RouteEstimate.DistanceMeters * 9 / 100; ride.RouteEstimate.DurationSeconds-want > 1 || want-ride.RouteEstimate.DurationSeconds > 1 {
+		t.Errorf("Expected duration at 40 km/h, got %+v", ride.RouteEstimate)
+	}
+
+	estimateRoute(context.Background(), nil, ride)
+	if ride.RouteEstimate != nil {
+		t.Error("Expected no estimate without a router")
+	}
+}
@@ -3,57 +3,113 @@ package handlers
 import (
 	_ "context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/go-chi/chi/v5"
 	"github.com/jackc/pgx/v5"
 	"luxsuv-backend/data"
 	"luxsuv-backend/repository"
+	"luxsuv-backend/schedule"
 	"net/http"
+	"regexp"
 	"strconv"
+	"strings"
+	"time"
 )
 
-func SetupRiderRouter(repo *repository.BookingRepository) *chi.Mux { // Changed to *chi.Router
+var airportCodePattern = regexp.MustCompile(`^[A-Z]{3}$`)
+
+func SetupRiderRouter(repo *repository.BookingRepository, auth *AuthMiddleware, limit RouteLimiter, svc Services) *chi.Mux { // Changed to *chi.Router
 	r := chi.NewRouter()
 
-	// Public endpoints for riders
+	// Public endpoints for riders, rate limited per client IP and email
 	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
 		respondJSON(w, http.StatusOK, map[string]string{"message": "Hello world"})
 	})
-	r.Post("/book-ride", createBookRide(repo))
-	r.Put("/book-ride/{id}", updateBookRide(repo))
-	r.Get("/book-rides", listBookRidesByEmail(repo))
+	r.With(limit("create_book_ride")).Post("/book-ride", createBookRide(repo, svc))
+	// Rate limited before the token check so tokens cannot be guessed quickly
+	r.With(limit("update_book_ride"), requireBookingToken(repo)).Put("/book-ride/{id}", updateBookRide(repo, svc))
+	r.With(limit("list_book_rides")).Get("/book-rides", listBookRidesByEmail(repo))
+	r.With(limit("availability")).Get("/availability", getAvailability(repo, svc.Scheduler))
+
+	// Endpoints authenticated by the booking token returned on creation
+	r.Group(func(r chi.Router) {
+		r.Use(requireBookingToken(repo))
+		r.Get("/my-data", exportOwnRiderData(repo))
+		r.Delete("/my-data", eraseOwnRiderData(repo))
+		r.Get("/book-ride/{id}/location", getBookRideLocation(repo))
+		r.Get("/book-ride/{id}/trip", getBookRideTrip(repo, svc.Pricing))
+		r.Get("/book-ride/{id}/receipt", getBookRideReceipt(repo))
+		r.Get("/book-ride/{id}/charges/{chargeID}/receipt", getChargeReceipt(repo, "chargeID"))
+	})
+
+	// Live updates of a booking, for its rider, drivers and admins
+	r.With(requireBookingAccess(repo, auth)).Get("/book-ride/{id}/events", streamBookRideEvents(svc.Events))
 
 	return r
 }
 
-func createBookRide(repo *repository.BookingRepository) http.HandlerFunc {
+func createBookRide(repo *repository.BookingRepository, svc Services) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
-		var ride data.BookRide
-		if err := json.NewDecoder(r.Body).Decode(&ride); err != nil {
+		var req bookRideV1
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
 			return
 		}
+		ride := req.toModel()
 
-		if err := validateBookRide(&ride); err != nil {
+		legacy := isLegacyRequest(ctx)
+		if err := validateBookRide(ride, !legacy); err != nil {
 			respondError(w, http.StatusBadRequest, err)
 			return
 		}
 
-		id, err := repo.CreateBookRide(ctx, &ride)
+		if err := assignZone(ctx, repo, svc.Geocoder, ride); err != nil {
+			respondZoneError(w, err)
+			return
+		}
+		ride.GeocodeStatus = initialGeocodeStatus(ride, svc.Geocoder)
+		estimateRoute(ctx, svc.Router, ride)
+		if err := scheduleRiderBookRide(svc.Scheduler, ride, legacy); err != nil {
+			respondError(w, http.StatusBadRequest, err)
+			return
+		}
+		if err := checkSlotAvailable(ctx, repo, svc.Scheduler, ride, nil); err != nil {
+			if err == errSlotUnavailable {
+				respondError(w, http.StatusConflict, err)
+				return
+			}
+			respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to check availability: %w", err))
+			return
+		}
+
+		// The booking token is the rider's credential for their data; it is
+		// only ever shown in this response.
+		bookingToken, err := randomToken(32)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err)
+			return
+		}
+		id, err := repo.CreateBookRide(ctx, ride, bookingToken)
 		if err != nil {
 			respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to create ride booking: %w", err))
 			return
 		}
 
+		if ride.GeocodeStatus == data.GeocodePending {
+			go geocodeBookRide(repo, svc.Geocoder, svc.Router, svc.Scheduler, id, ride)
+		}
+
 		respondJSON(w, http.StatusCreated, map[string]interface{}{
-			"message": "Ride booking created successfully",
-			"id":      id,
+			"message":       "Ride booking created successfully",
+			"id":            id,
+			"booking_token": bookingToken,
 		})
 	}
 }
 
-func updateBookRide(repo *repository.BookingRepository) http.HandlerFunc {
+func updateBookRide(repo *repository.BookingRepository, svc Services) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 		idStr := chi.URLParam(r, "id")
@@ -62,28 +118,70 @@ func updateBookRide(repo *repository.BookingRepository) http.HandlerFunc {
 			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid booking ID: %w", err))
 			return
 		}
+		if bookRideFromContext(ctx).ID != id {
+			respondError(w, http.StatusForbidden, fmt.Errorf("booking token is for another booking"))
+			return
+		}
 
-		var ride data.BookRide
-		if err := json.NewDecoder(r.Body).Decode(&ride); err != nil {
+		var req bookRideV1
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
 			return
 		}
+		ride := req.toModel()
 		ride.ID = id
 
-		if err := validateBookRide(&ride); err != nil {
+		legacy := isLegacyRequest(ctx)
+		if err := validateBookRide(ride, !legacy); err != nil {
 			respondError(w, http.StatusBadRequest, err)
 			return
 		}
 
-		if err := repo.UpdateBookRide(ctx, &ride); err != nil {
+		if err := assignZone(ctx, repo, svc.Geocoder, ride); err != nil {
+			respondZoneError(w, err)
+			return
+		}
+		ride.GeocodeStatus = initialGeocodeStatus(ride, svc.Geocoder)
+		estimateRoute(ctx, svc.Router, ride)
+		if err := scheduleRiderBookRide(svc.Scheduler, ride, legacy); err != nil {
+			respondError(w, http.StatusBadRequest, err)
+			return
+		}
+		// An assigned driver keeps the booking and is checked when it is
+		// stored; an unassigned one competes for the new time without
+		// counting its current interval against itself.
+		if current := bookRideFromContext(ctx); current.DriverID == nil {
+			if err := checkSlotAvailable(ctx, repo, svc.Scheduler, ride, current.Occupied); err != nil {
+				if err == errSlotUnavailable {
+					respondError(w, http.StatusConflict, err)
+					return
+				}
+				respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to check availability: %w", err))
+				return
+			}
+		}
+		if err := repo.UpdateBookRide(ctx, ride); err != nil {
 			if err == pgx.ErrNoRows {
 				respondError(w, http.StatusNotFound, fmt.Errorf("ride booking not found: %d", id))
 				return
 			}
+			if errors.Is(err, data.ErrVehicleCapacity) {
+				respondError(w, http.StatusUnprocessableEntity, err)
+				return
+			}
+			var conflict *repository.ScheduleConflictError
+			if errors.As(err, &conflict) {
+				respondError(w, http.StatusConflict, fmt.Errorf("the assigned driver is not available at the new time"))
+				return
+			}
 			respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to update ride booking: %w", err))
 			return
 		}
 
+		if ride.GeocodeStatus == data.GeocodePending {
+			go geocodeBookRide(repo, svc.Geocoder, svc.Router, svc.Scheduler, id, ride)
+		}
+
 		respondJSON(w, http.StatusOK, map[string]string{"message": "Ride booking updated successfully"})
 	}
 }
@@ -103,11 +201,14 @@ func listBookRidesByEmail(repo *repository.BookingRepository) http.HandlerFunc {
 			return
 		}
 
-		respondJSON(w, http.StatusOK, rides)
+		respondJSON(w, http.StatusOK, bookRidesV1FromModels(rides))
 	}
 }
 
-func validateBookRide(ride *data.BookRide) error {
+// validateBookRide checks a rider's booking. With strictPickup its date and
+// time must be a pickup time the scheduler understands; legacy requests,
+// which predate that check, may use other formats.
+func validateBookRide(ride *data.BookRide, strictPickup bool) error {
 	if ride.YourName == "" {
 		return fmt.Errorf("your_name is required")
 	}
@@ -120,11 +221,11 @@ func validateBookRide(ride *data.BookRide) error {
 	if ride.RideType != "hourly" && ride.RideType != "per_ride" {
 		return fmt.Errorf("rideType must be 'hourly' or 'per_ride', got %s", ride.RideType)
 	}
-	if ride.PickupLocation == "" {
-		return fmt.Errorf("pickup_location is required")
+	if err := validateLocation("pickup_location", ride.PickupLocation); err != nil {
+		return err
 	}
-	if ride.DropoffLocation == "" {
-		return fmt.Errorf("dropoff_location is required")
+	if err := validateLocation("dropoff_location", ride.DropoffLocation); err != nil {
+		return err
 	}
 	if ride.Date == "" {
 		return fmt.Errorf("date is required")
@@ -132,8 +233,46 @@ func validateBookRide(ride *data.BookRide) error {
 	if ride.Time == "" {
 		return fmt.Errorf("time is required")
 	}
+	// Only the format matters here; the time zone is applied when scheduling.
+	if strictPickup {
+		if _, err := schedule.ParsePickup(ride.Date, ride.Time, time.UTC); err != nil {
+			return err
+		}
+	}
 	if ride.NumberOfPassengers <= 0 {
 		return fmt.Errorf("number_of_passengers must be positive")
 	}
+	if ride.Hours < 0 {
+		return fmt.Errorf("hours must not be negative")
+	}
+	if ride.Hours > 0 && ride.RideType != "hourly" {
+		return fmt.Errorf("hours only applies to hourly rides")
+	}
+	if ride.Preferences != nil {
+		for _, a := range ride.Preferences.Amenities {
+			if strings.TrimSpace(a) == "" {
+				return fmt.Errorf("preferences: amenities must not be empty")
+			}
+		}
+	}
+	return nil
+}
+
+func validateLocation(field string, loc data.Location) error {
+	if loc.Address == "" {
+		return fmt.Errorf("%s is required", field)
+	}
+	if (loc.Lat == nil) != (loc.Lng == nil) {
+		return fmt.Errorf("%s: lat and lng must be given together", field)
+	}
+	if loc.HasCoordinates() && (*loc.Lat < -90 || *loc.Lat > 90 || *loc.Lng < -180 || *loc.Lng > 180) {
+		return fmt.Errorf("%s: coordinates out of range", field)
+	}
+	if loc.AirportCode != "" && !airportCodePattern.MatchString(loc.AirportCode) {
+		return fmt.Errorf("%s: airport_code must be a 3-letter IATA code", field)
+	}
+	if loc.Terminal != "" && loc.AirportCode == "" {
+		return fmt.Errorf("%s: terminal requires airport_code", field)
+	}
 	return nil
 }
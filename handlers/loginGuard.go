@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"luxsuv-backend/data"
+	"luxsuv-backend/ratelimit"
+	"luxsuv-backend/repository"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// lockoutPolicy locks a key out for base, 2*base, 4*base, ... (capped at max)
+// once it reaches maxFailures consecutive failed logins.
+type lockoutPolicy struct {
+	maxFailures int
+	base        time.Duration
+	max         time.Duration
+}
+
+func (p lockoutPolicy) lockoutFor(failures int) time.Duration {
+	if failures < p.maxFailures {
+		return 0
+	}
+	d := p.base
+	for i := p.maxFailures; i < failures && d < p.max; i++ {
+		d *= 2
+	}
+	if d > p.max {
+		d = p.max
+	}
+	return d
+}
+
+// loginGuard tracks failed logins per username and per client IP in Postgres,
+// so lockouts hold across all Fly machines, and writes the login audit log.
+type loginGuard struct {
+	repo       *repository.BookingRepository
+	user       lockoutPolicy
+	ip         lockoutPolicy
+	resetAfter time.Duration
+}
+
+func newLoginGuard(repo *repository.BookingRepository) *loginGuard {
+	base := durationFromEnv("LOGIN_LOCKOUT_BASE", time.Minute)
+	max := durationFromEnv("LOGIN_LOCKOUT_MAX", time.Hour)
+	return &loginGuard{
+		repo:       repo,
+		user:       lockoutPolicy{maxFailures: intFromEnv("LOGIN_MAX_FAILURES", 5), base: base, max: max},
+		ip:         lockoutPolicy{maxFailures: intFromEnv("LOGIN_IP_MAX_FAILURES", 20), base: base, max: max},
+		resetAfter: durationFromEnv("LOGIN_FAILURE_RESET", 24*time.Hour),
+	}
+}
+
+func intFromEnv(name string, fallback int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		log.Fatalf("invalid %s %q: must be a positive integer", name, value)
+	}
+	return n
+}
+
+func userLockoutKey(username string) string { return "user:" + username }
+func ipLockoutKey(ip string) string         { return "ip:" + ip }
+
+// lockedUntil returns when the username or client IP of r may try again, or
+// the zero time if neither is locked out.
+func (g *loginGuard) lockedUntil(ctx context.Context, r *http.Request, username string) (time.Time, error) {
+	return g.repo.GetLoginLockout(ctx, userLockoutKey(username), ipLockoutKey(ratelimit.ClientIP(r)))
+}
+
+// recordFailure counts a failed login against the username and client IP,
+// locking either out once its policy threshold is reached.
+func (g *loginGuard) recordFailure(ctx context.Context, r *http.Request, username, reason string) {
+	g.audit(ctx, r, username, nil, false, reason)
+	for key, policy := range map[string]lockoutPolicy{
+		userLockoutKey(username):            g.user,
+		ipLockoutKey(ratelimit.ClientIP(r)): g.ip,
+	} {
+		failures, err := g.repo.RecordLoginFailure(ctx, key, g.resetAfter)
+		if err != nil {
+			log.Printf("Failed to record login failure for %s: %v", key, err)
+			continue
+		}
+		if d := policy.lockoutFor(failures); d > 0 {
+			if err := g.repo.LockLogin(ctx, key, time.Now().Add(d)); err != nil {
+				log.Printf("Failed to lock %s: %v", key, err)
+			}
+		}
+	}
+}
+
+// recordSuccess clears the username's failure counter. The IP counter is left
+// alone so one valid account cannot be used to reset it.
+func (g *loginGuard) recordSuccess(ctx context.Context, r *http.Request, user *data.User) {
+	g.audit(ctx, r, user.Username, &user.ID, true, "success")
+	if err := g.repo.ClearLoginFailures(ctx, userLockoutKey(user.Username)); err != nil {
+		log.Printf("Failed to clear login failures for %s: %v", user.Username, err)
+	}
+}
+
+func (g *loginGuard) audit(ctx context.Context, r *http.Request, username string, userID *int64, success bool, reason string) {
+	attempt := &data.LoginAttempt{
+		Username:  username,
+		UserID:    userID,
+		IPAddress: ratelimit.ClientIP(r),
+		UserAgent: r.UserAgent(),
+		Success:   success,
+		Reason:    reason,
+	}
+	if err := g.repo.CreateLoginAttempt(ctx, attempt); err != nil {
+		log.Printf("Failed to write login audit record: %v", err)
+	}
+}
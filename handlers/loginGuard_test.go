@@ -0,0 +1,24 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLockoutPolicyBacksOffExponentially(t *testing.T) {
+	p := lockoutPolicy{maxFailures: 5, base: time.Minute, max: time.Hour}
+	cases := map[int]time.Duration{
+		1:  0,
+		4:  0,
+		5:  time.Minute,
+		6:  2 * time.Minute,
+		7:  4 * time.Minute,
+		11: time.Hour,
+		50: time.Hour,
+	}
+	for failures, want := range cases {
+		if got := p.lockoutFor(failures); got != want {
+			t.Errorf("lockoutFor(%d): expected %v, got %v", failures, want, got)
+		}
+	}
+}
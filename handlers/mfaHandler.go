@@ -0,0 +1,335 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"log"
+	"luxsuv-backend/data"
+	"luxsuv-backend/repository"
+	"luxsuv-backend/totp"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	mfaTokenTTL       = 5 * time.Minute
+	recoveryCodeCount = 10
+)
+
+// mfaChallengeResponse is returned by login instead of a token pair when the
+// user must complete (or first set up) two-factor authentication. MFAToken is
+// only good for the next step of the flow.
+type mfaChallengeResponse struct {
+	MFARequired           bool   `json:"mfa_required"`
+	MFAEnrollmentRequired bool   `json:"mfa_enrollment_required"`
+	MFAToken              string `json:"mfa_token"`
+	ExpiresIn             int64  `json:"expires_in"`
+}
+
+type mfaEnrollResponse struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+}
+
+// mfaConfirmResponse carries the one-time display of the recovery codes and,
+// when enrolment was forced during login, the token pair that completes it.
+type mfaConfirmResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+	*tokenResponse
+}
+
+// mfaRequiredRolesFromEnv parses MFA_REQUIRED_ROLES, e.g. "admin,driver".
+// Users in these roles must enrol before they can get an access token.
+func mfaRequiredRolesFromEnv() map[string]bool {
+	roles := map[string]bool{}
+	for _, role := range strings.Split(os.Getenv("MFA_REQUIRED_ROLES"), ",") {
+		role = strings.TrimSpace(role)
+		if role == "" {
+			continue
+		}
+		if role != data.RoleDriver && role != data.RoleAdmin {
+			log.Fatalf("invalid MFA_REQUIRED_ROLES entry %q", role)
+		}
+		roles[role] = true
+	}
+	return roles
+}
+
+func mfaIssuer() string {
+	if issuer := os.Getenv("MFA_ISSUER"); issuer != "" {
+		return issuer
+	}
+	return "LuxSUV"
+}
+
+// completeLogin responds to a user who has passed the password check: with a
+// token pair, or with an MFA challenge if a second factor is needed.
+func completeLogin(w http.ResponseWriter, r *http.Request, auth *AuthMiddleware, user *data.User) {
+	ctx := r.Context()
+	purpose := purposeAccess
+	switch {
+	case user.MFAEnabledAt != nil:
+		purpose = purposeMFA
+	case auth.mfaRequiredRoles[user.Role]:
+		purpose = purposeMFAEnroll
+	}
+
+	if purpose == purposeAccess {
+		auth.logins.recordSuccess(ctx, r, user)
+		resp, err := auth.issueTokens(r, user)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to generate token: %w", err))
+			return
+		}
+		respondJSON(w, http.StatusOK, resp)
+		return
+	}
+
+	// The login is not complete yet, so the failure counter is left alone:
+	// otherwise a known password could be used to keep resetting it while
+	// guessing codes.
+	auth.logins.audit(ctx, r, user.Username, &user.ID, false, "mfa_pending")
+	token, err := auth.signToken(user, purpose, mfaTokenTTL)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to generate token: %w", err))
+		return
+	}
+	respondJSON(w, http.StatusOK, mfaChallengeResponse{
+		MFARequired:           purpose == purposeMFA,
+		MFAEnrollmentRequired: purpose == purposeMFAEnroll,
+		MFAToken:              token,
+		ExpiresIn:             int64(mfaTokenTTL.Seconds()),
+	})
+}
+
+// loginMFAHandler completes a login with a TOTP code or a recovery code.
+func loginMFAHandler(repo *repository.BookingRepository, auth *AuthMiddleware) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		var req struct {
+			MFAToken     string `json:"mfa_token"`
+			Code         string `json:"code"`
+			RecoveryCode string `json:"recovery_code"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+			return
+		}
+		if req.Code == "" && req.RecoveryCode == "" {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("code or recovery_code is required"))
+			return
+		}
+
+		claims, status, msg := auth.authenticate(ctx, req.MFAToken, purposeMFA)
+		if claims == nil {
+			respondError(w, status, errors.New(msg))
+			return
+		}
+		user, err := repo.GetUserByID(ctx, claims.userID)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to load user: %w", err))
+			return
+		}
+
+		until, err := auth.logins.lockedUntil(ctx, r, user.Username)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, fmt.Errorf("login failed: %w", err))
+			return
+		}
+		if !until.IsZero() {
+			respondLockedOut(w, r, auth, user.Username, until)
+			return
+		}
+
+		ok, err := verifySecondFactor(r, repo, user, req.Code, req.RecoveryCode)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to verify code: %w", err))
+			return
+		}
+		if !ok {
+			auth.logins.recordFailure(ctx, r, user.Username, "mfa_failed")
+			respondError(w, http.StatusUnauthorized, fmt.Errorf("invalid code"))
+			return
+		}
+
+		// The MFA token is single-use.
+		if err := repo.RevokeAccessToken(ctx, claims.jti, claims.expiresAt); err != nil {
+			respondError(w, http.StatusInternalServerError, fmt.Errorf("login failed: %w", err))
+			return
+		}
+		auth.logins.recordSuccess(ctx, r, user)
+		resp, err := auth.issueTokens(r, user)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to generate token: %w", err))
+			return
+		}
+		respondJSON(w, http.StatusOK, resp)
+	}
+}
+
+func verifySecondFactor(r *http.Request, repo *repository.BookingRepository, user *data.User, code, recoveryCode string) (bool, error) {
+	if user.MFAEnabledAt == nil {
+		return false, nil
+	}
+	if recoveryCode != "" {
+		return repo.UseRecoveryCode(r.Context(), user.ID, normalizeRecoveryCode(recoveryCode))
+	}
+	step, ok := totp.Validate(user.MFASecret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+	return repo.UseMFAStep(r.Context(), user.ID, step)
+}
+
+// enrollMFA starts enrolment by generating a new TOTP secret for the user.
+func enrollMFA(repo *repository.BookingRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		claims, _ := claimsFromContext(ctx)
+		user, err := repo.GetUserByID(ctx, claims.userID)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to load user: %w", err))
+			return
+		}
+
+		secret, err := totp.GenerateSecret()
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if err := repo.SetPendingMFASecret(ctx, user.ID, secret); err != nil {
+			if errors.Is(err, repository.ErrMFAAlreadyEnabled) {
+				respondError(w, http.StatusConflict, err)
+				return
+			}
+			respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to start enrolment: %w", err))
+			return
+		}
+
+		respondJSON(w, http.StatusOK, mfaEnrollResponse{
+			Secret:     secret,
+			OTPAuthURI: totp.URI(mfaIssuer(), user.Username, secret),
+		})
+	}
+}
+
+// confirmMFA enables MFA once the user proves their authenticator works, and
+// returns freshly generated recovery codes. Wrong codes count as failed
+// logins, so guessing them locks the user out like guessing passwords.
+func confirmMFA(repo *repository.BookingRepository, auth *AuthMiddleware) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		claims, _ := claimsFromContext(ctx)
+		var req struct {
+			Code string `json:"code"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+			return
+		}
+
+		user, err := repo.GetUserByID(ctx, claims.userID)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to load user: %w", err))
+			return
+		}
+		if user.MFAEnabledAt != nil {
+			respondError(w, http.StatusConflict, repository.ErrMFAAlreadyEnabled)
+			return
+		}
+		if user.MFASecret == "" {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("enrolment has not been started"))
+			return
+		}
+
+		until, err := auth.logins.lockedUntil(ctx, r, user.Username)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to enable MFA: %w", err))
+			return
+		}
+		if !until.IsZero() {
+			respondLockedOut(w, r, auth, user.Username, until)
+			return
+		}
+		step, ok := totp.Validate(user.MFASecret, req.Code, time.Now())
+		if !ok {
+			auth.logins.recordFailure(ctx, r, user.Username, "mfa_enroll_failed")
+			respondError(w, http.StatusUnauthorized, fmt.Errorf("invalid code"))
+			return
+		}
+
+		codes := make([]string, recoveryCodeCount)
+		for i := range codes {
+			if codes[i], err = generateRecoveryCode(); err != nil {
+				respondError(w, http.StatusInternalServerError, err)
+				return
+			}
+		}
+		if err := repo.EnableMFA(ctx, user.ID, step, codes); err != nil {
+			if errors.Is(err, repository.ErrMFAAlreadyEnabled) {
+				respondError(w, http.StatusConflict, err)
+				return
+			}
+			respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to enable MFA: %w", err))
+			return
+		}
+
+		resp := mfaConfirmResponse{RecoveryCodes: codes}
+		if claims.purpose == purposeMFAEnroll {
+			// Enrolment was forced during login; finish that login now.
+			if err := repo.RevokeAccessToken(ctx, claims.jti, claims.expiresAt); err != nil {
+				respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to complete login: %w", err))
+				return
+			}
+			auth.logins.recordSuccess(ctx, r, user)
+			if resp.tokenResponse, err = auth.issueTokens(r, user); err != nil {
+				respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to generate token: %w", err))
+				return
+			}
+		}
+		respondJSON(w, http.StatusOK, resp)
+	}
+}
+
+// resetUserMFA lets an admin remove a user's second factor, e.g. after a lost phone.
+func resetUserMFA(repo *repository.BookingRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := parseIDParam(r, "id")
+		if err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid user ID: %w", err))
+			return
+		}
+		if err := repo.ResetMFA(r.Context(), id); err != nil {
+			if err == pgx.ErrNoRows {
+				respondError(w, http.StatusNotFound, fmt.Errorf("user not found: %d", id))
+				return
+			}
+			respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to reset MFA: %w", err))
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]string{"message": "Two-factor authentication reset successfully"})
+	}
+}
+
+// generateRecoveryCode returns a code like "k3f9x-2mq7p". Codes carry 50 bits
+// of entropy and are stored hashed.
+func generateRecoveryCode() (string, error) {
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return "", err
+	}
+	code := strings.ToLower(secret[:10])
+	return code[:5] + "-" + code[5:], nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), " ", ""))
+	if len(code) == 10 && !strings.Contains(code, "-") {
+		code = code[:5] + "-" + code[5:]
+	}
+	return code
+}
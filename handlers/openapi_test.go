@@ -0,0 +1,232 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/go-chi/chi/v5"
+	"luxsuv-backend/config"
+	"luxsuv-backend/data"
+	"luxsuv-backend/dispatch"
+	"luxsuv-backend/jwtkeys"
+	"luxsuv-backend/ratelimit"
+	"luxsuv-backend/schedule"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type openAPIDoc struct {
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Schemas map[string]struct {
+			Properties map[string]struct {
+				Type interface{} `json:"type"`
+			} `json:"properties"`
+		} `json:"schemas"`
+	} `json:"components"`
+}
+
+func loadOpenAPIDoc(t *testing.T) *openAPIDoc {
+	t.Helper()
+	var doc openAPIDoc
+	if err := json.Unmarshal(openAPISpec, &doc); err != nil {
+		t.Fatalf("Failed to parse openapi.json: %v", err)
+	}
+	return &doc
+}
+
+func TestOpenAPICoversAllRoutes(t *testing.T) {
+	doc := loadOpenAPIDoc(t)
+	keys, err := jwtkeys.NewKeySet("test", jwtkeys.HMACKey("test", []byte("test-secret")))
+	if err != nil {
+		t.Fatalf("Failed to create key set: %v", err)
+	}
+
+	limit, err := NewRouteLimiter(ratelimit.NewMemoryStore(), config.Defaults().RateLimit)
+	if err != nil {
+		t.Fatalf("Failed to create rate limiter: %v", err)
+	}
+
+	routers := map[string]chi.Routes{
+		"/v1": SetupV1Router(nil, keys, limit, Services{}),
+	}
+	for prefix, router := range routers {
+		err := chi.Walk(router, func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+			path := prefix + route
+			ops, ok := doc.Paths[path]
+			if !ok {
+				t.Errorf("Route %s %s is missing from openapi.json", method, path)
+				return nil
+			}
+			if _, ok := ops[strings.ToLower(method)]; !ok {
+				t.Errorf("Operation %s is missing for path %s in openapi.json", method, path)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Failed to walk %s router: %v", prefix, err)
+		}
+	}
+}
+
+func TestOpenAPISchemasMatchModels(t *testing.T) {
+	doc := loadOpenAPIDoc(t)
+	assertSchemaMatchesType(t, doc, "BookRide", reflect.TypeOf(bookRideV1{}))
+	assertSchemaMatchesType(t, doc, "User", reflect.TypeOf(data.User{}))
+	assertSchemaMatchesType(t, doc, "Location", reflect.TypeOf(data.Location{}))
+	assertSchemaMatchesType(t, doc, "RouteEstimate", reflect.TypeOf(data.RouteEstimate{}))
+	assertSchemaMatchesType(t, doc, "TokenResponse", reflect.TypeOf(tokenResponse{}))
+	assertSchemaMatchesType(t, doc, "LoginAttempt", reflect.TypeOf(data.LoginAttempt{}))
+	assertSchemaMatchesType(t, doc, "MFAChallenge", reflect.TypeOf(mfaChallengeResponse{}))
+	assertSchemaMatchesType(t, doc, "MFAEnrollResponse", reflect.TypeOf(mfaEnrollResponse{}))
+	assertSchemaMatchesType(t, doc, "RiderDataExport", reflect.TypeOf(riderDataExport{}))
+	assertSchemaMatchesType(t, doc, "LocationTrail", reflect.TypeOf(locationTrail{}))
+	assertSchemaMatchesType(t, doc, "EraseRiderRequest", reflect.TypeOf(eraseRiderRequest{}))
+	assertSchemaMatchesType(t, doc, "EraseRiderResponse", reflect.TypeOf(eraseRiderResponse{}))
+	assertSchemaMatchesType(t, doc, "Zone", reflect.TypeOf(data.Zone{}))
+	assertSchemaMatchesType(t, doc, "ZoneRequest", reflect.TypeOf(zoneRequest{}))
+	assertSchemaMatchesType(t, doc, "Vehicle", reflect.TypeOf(data.Vehicle{}))
+	assertSchemaMatchesType(t, doc, "VehicleRequest", reflect.TypeOf(vehicleRequest{}))
+	assertSchemaMatchesType(t, doc, "VehicleAssignment", reflect.TypeOf(vehicleAssignment{}))
+	assertSchemaMatchesType(t, doc, "TimeRange", reflect.TypeOf(data.TimeRange{}))
+	assertSchemaMatchesType(t, doc, "DriverAssignment", reflect.TypeOf(driverAssignment{}))
+	assertSchemaMatchesType(t, doc, "ScheduleConflict", reflect.TypeOf(scheduleConflictResponse{}))
+	assertSchemaMatchesType(t, doc, "WorkingHours", reflect.TypeOf(data.WorkingHours{}))
+	assertSchemaMatchesType(t, doc, "TimeOff", reflect.TypeOf(data.TimeOff{}))
+	assertSchemaMatchesType(t, doc, "TimeOffRequest", reflect.TypeOf(timeOffRequest{}))
+	assertSchemaMatchesType(t, doc, "Slot", reflect.TypeOf(schedule.Slot{}))
+	assertSchemaMatchesType(t, doc, "Availability", reflect.TypeOf(availabilityResponse{}))
+	assertSchemaMatchesType(t, doc, "RidePreferences", reflect.TypeOf(data.RidePreferences{}))
+	assertSchemaMatchesType(t, doc, "BookRideStatusRequest", reflect.TypeOf(bookRideStatusRequest{}))
+	assertSchemaMatchesType(t, doc, "DispatchOffer", reflect.TypeOf(data.DispatchOffer{}))
+	assertSchemaMatchesType(t, doc, "DispatchFactors", reflect.TypeOf(dispatch.Factors{}))
+	assertSchemaMatchesType(t, doc, "DispatchCandidate", reflect.TypeOf(dispatch.Candidate{}))
+	assertSchemaMatchesType(t, doc, "DeclineRequest", reflect.TypeOf(declineRequest{}))
+	assertSchemaMatchesType(t, doc, "OfferResponseStats", reflect.TypeOf(data.OfferResponseStats{}))
+	assertSchemaMatchesType(t, doc, "BookingEvent", reflect.TypeOf(data.BookingEvent{}))
+	assertSchemaMatchesType(t, doc, "LocationPing", reflect.TypeOf(data.LocationPing{}))
+	assertSchemaMatchesType(t, doc, "DriverLocation", reflect.TypeOf(data.DriverLocation{}))
+	assertSchemaMatchesType(t, doc, "LocationPingsRequest", reflect.TypeOf(locationPingsRequest{}))
+	assertSchemaMatchesType(t, doc, "LocationPingsResponse", reflect.TypeOf(locationPingsResponse{}))
+	assertSchemaMatchesType(t, doc, "DriverSocketRequest", reflect.TypeOf(socketRequest{}))
+	assertSchemaMatchesType(t, doc, "DriverSocketMessage", reflect.TypeOf(socketMessage{}))
+	assertSchemaMatchesType(t, doc, "Trip", reflect.TypeOf(data.Trip{}))
+	assertSchemaMatchesType(t, doc, "TripRequest", reflect.TypeOf(tripRequest{}))
+	assertSchemaMatchesType(t, doc, "Fare", reflect.TypeOf(data.Fare{}))
+	assertSchemaMatchesType(t, doc, "FareLine", reflect.TypeOf(data.FareLine{}))
+	assertSchemaMatchesType(t, doc, "TripCharge", reflect.TypeOf(data.TripCharge{}))
+	assertSchemaMatchesType(t, doc, "TripChargeRequest", reflect.TypeOf(tripChargeRequest{}))
+	assertSchemaMatchesType(t, doc, "ChargeReviewRequest", reflect.TypeOf(chargeReviewRequest{}))
+	assertSchemaMatchesType(t, doc, "RideReceipt", reflect.TypeOf(rideReceipt{}))
+}
+
+func TestBookRideV1CoversModel(t *testing.T) {
+	// Every stored field must be reachable through the v1 DTO; fields added to
+	// data.BookRide later need a deliberate mapping (or exclusion) here.
+	model := reflect.TypeOf(data.BookRide{})
+	dto := reflect.TypeOf(bookRideV1{})
+	for i := 0; i < model.NumField(); i++ {
+		if _, ok := dto.FieldByName(model.Field(i).Name); !ok {
+			t.Errorf("Field %s of data.BookRide is not mapped by bookRideV1", model.Field(i).Name)
+		}
+	}
+}
+
+// assertSchemaMatchesType checks that the named component schema has exactly the
+// JSON fields of typ and that each property's type agrees with the Go kind.
+func assertSchemaMatchesType(t *testing.T, doc *openAPIDoc, name string, typ reflect.Type) {
+	t.Helper()
+	schema, ok := doc.Components.Schemas[name]
+	if !ok {
+		t.Fatalf("Schema %s is missing from openapi.json", name)
+	}
+
+	fields := map[string]reflect.Type{}
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		tag := strings.Split(f.Tag.Get("json"), ",")[0]
+		if tag == "" || tag == "-" {
+			continue
+		}
+		fields[tag] = f.Type
+	}
+
+	for field, fieldType := range fields {
+		prop, ok := schema.Properties[field]
+		if !ok {
+			t.Errorf("Field %s of %s is missing from schema %s", field, typ.Name(), name)
+			continue
+		}
+		if want := openAPIType(fieldType); want != "" && !schemaHasType(prop.Type, want) {
+			t.Errorf("Field %s of schema %s: expected type %s, got %v", field, name, want, prop.Type)
+		}
+	}
+	for prop := range schema.Properties {
+		if _, ok := fields[prop]; !ok {
+			t.Errorf("Schema %s has property %s that %s does not", name, prop, typ.Name())
+		}
+	}
+}
+
+func openAPIType(t reflect.Type) string {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "integer"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.Struct, reflect.Map:
+		if t.String() == "time.Time" {
+			return "string"
+		}
+		return "object"
+	}
+	return ""
+}
+
+func schemaHasType(v interface{}, want string) bool {
+	switch typ := v.(type) {
+	case string:
+		return typ == want
+	case []interface{}:
+		for _, t := range typ {
+			if s, ok := t.(string); ok && s == want {
+				return true
+			}
+		}
+	case nil:
+		// $ref or oneOf properties carry no direct type.
+		return true
+	}
+	return false
+}
+
+func TestSwaggerUIIsSelfHosted(t *testing.T) {
+	if strings.Contains(string(swaggerUIPage), "https://") {
+		t.Error("Expected the docs page to load nothing from other hosts")
+	}
+
+	mux := chi.NewRouter()
+	mux.Get("/docs/{file}", SwaggerUIAssetsHandler())
+	for path, want := range map[string]int{
+		"/docs/swagger-ui.css":       http.StatusOK,
+		"/docs/swagger-ui-bundle.js": http.StatusOK,
+		"/docs/README.md":            http.StatusNotFound,
+	} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != want {
+			t.Errorf("%s: expected %d, got %d", path, want, w.Code)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package handlers
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"log"
+	"luxsuv-backend/data"
+	"luxsuv-backend/repository"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// bookingTokenHeader carries the token returned when a booking is created.
+// Riders have no accounts; the token proves they made the booking and gives
+// access to that booking only. Anyone can book with any email address, so it
+// says nothing about the rider's other bookings.
+const bookingTokenHeader = "X-Booking-Token"
+
+const bookRideKey contextKey = "book_ride"
+
+// riderDataExport is the bundle returned for data access requests: the
+// bookings and everything recorded about them. Offer events, which concern
+// drivers, and receipt photos are left out.
+type riderDataExport struct {
+	Email          string              `json:"email"`
+	GeneratedAt    time.Time           `json:"generated_at"`
+	BookRides      []bookRideV1        `json:"book_rides"`
+	Events         []data.BookingEvent `json:"events"`
+	LocationTrails []locationTrail     `json:"location_trails"`
+	Trips          []*data.Trip        `json:"trips"`
+	Charges        []*data.TripCharge  `json:"charges"`
+}
+
+// locationTrail is the trail the driver's app recorded for a booking.
+type locationTrail struct {
+	BookingID int64               `json:"booking_id"`
+	Points    []data.LocationPing `json:"points"`
+}
+
+// maxExportEvents bounds the events exported per booking; events are only
+// kept for days, so this is not reached in practice.
+const maxExportEvents = 10000
+
+type eraseRiderRequest struct {
+	Email string `json:"email"`
+}
+
+type eraseRiderResponse struct {
+	Message string `json:"message"`
+	Erased  int64  `json:"erased"`
+}
+
+// requireBookingToken authenticates riders by their booking token and stores
+// the booking in the request context.
+func requireBookingToken(repo *repository.BookingRepository) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := r.Header.Get(bookingTokenHeader)
+			if token == "" {
+				respondError(w, http.StatusUnauthorized, fmt.Errorf("%s header required", bookingTokenHeader))
+				return
+			}
+			ride, err := repo.GetBookRideByAccessToken(r.Context(), token)
+			if err != nil {
+				if err == pgx.ErrNoRows {
+					respondError(w, http.StatusUnauthorized, fmt.Errorf("invalid booking token"))
+					return
+				}
+				respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to validate booking token: %w", err))
+				return
+			}
+			ctx := context.WithValue(r.Context(), bookRideKey, ride)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func bookRideFromContext(ctx context.Context) *data.BookRide {
+	ride, _ := ctx.Value(bookRideKey).(*data.BookRide)
+	return ride
+}
+
+// exportOwnRiderData exports the booking the token was issued for. Other
+// bookings under the same email need a request to an admin, who can check
+// the rider owns the address.
+func exportOwnRiderData(repo *repository.BookingRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ride := bookRideFromContext(r.Context())
+		writeRiderExport(w, r, repo, ride.Email, []*data.BookRide{ride})
+	}
+}
+
+// eraseOwnRiderData erases the booking the token was issued for; like the
+// export, other bookings under the same email are left to an admin.
+func eraseOwnRiderData(repo *repository.BookingRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := repo.EraseBookRideData(r.Context(), bookRideFromContext(r.Context()).ID); err != nil {
+			respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to erase rider data: %w", err))
+			return
+		}
+		respondJSON(w, http.StatusOK, eraseRiderResponse{Message: "Rider data erased", Erased: 1})
+	}
+}
+
+func exportRiderData(repo *repository.BookingRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		email := strings.TrimSpace(r.URL.Query().Get("email"))
+		if email == "" {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("email query parameter is required"))
+			return
+		}
+		rides, err := repo.ListBookRidesByEmail(r.Context(), email)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to export rider data: %w", err))
+			return
+		}
+		writeRiderExport(w, r, repo, email, rides)
+	}
+}
+
+func eraseRiderData(repo *repository.BookingRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req eraseRiderRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+			return
+		}
+		req.Email = strings.TrimSpace(req.Email)
+		if req.Email == "" {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("email is required"))
+			return
+		}
+		eraseRider(w, r, repo, req.Email)
+	}
+}
+
+// writeRiderExport responds with the export of rides, made with email, as
+// JSON or, with ?format=zip, as a ZIP archive containing the same document.
+func writeRiderExport(w http.ResponseWriter, r *http.Request, repo *repository.BookingRepository, email string, rides []*data.BookRide) {
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "zip" {
+		respondError(w, http.StatusBadRequest, fmt.Errorf("format must be 'json' or 'zip'"))
+		return
+	}
+
+	export, err := collectRiderExport(r.Context(), repo, email, rides)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to export rider data: %w", err))
+		return
+	}
+
+	if format != "zip" {
+		respondJSON(w, http.StatusOK, export)
+		return
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="luxsuv-data-export.zip"`)
+	w.WriteHeader(http.StatusOK)
+	zw := zip.NewWriter(w)
+	f, err := zw.Create("luxsuv-data-export.json")
+	if err == nil {
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(export)
+	}
+	if err == nil {
+		err = zw.Close()
+	}
+	if err != nil {
+		log.Printf("Failed to write rider data export: %v", err)
+	}
+}
+
+// collectRiderExport gathers the export of rides and what was recorded
+// about them.
+func collectRiderExport(ctx context.Context, repo *repository.BookingRepository, email string, rides []*data.BookRide) (riderDataExport, error) {
+	export := riderDataExport{
+		Email:          email,
+		GeneratedAt:    time.Now().UTC(),
+		BookRides:      bookRidesV1FromModels(rides),
+		Events:         []data.BookingEvent{},
+		LocationTrails: []locationTrail{},
+		Trips:          []*data.Trip{},
+		Charges:        []*data.TripCharge{},
+	}
+	for _, ride := range rides {
+		events, err := repo.ListBookingEvents(ctx, 0, ride.ID, maxExportEvents)
+		if err != nil {
+			return export, err
+		}
+		for _, e := range events {
+			if e.VisibleTo(0) {
+				export.Events = append(export.Events, e)
+			}
+		}
+		trail, err := repo.ListLocationTrail(ctx, ride.ID)
+		if err != nil {
+			return export, err
+		}
+		if len(trail) > 0 {
+			export.LocationTrails = append(export.LocationTrails, locationTrail{BookingID: ride.ID, Points: trail})
+		}
+		trip, err := repo.GetTrip(ctx, ride.ID)
+		if err != nil && err != pgx.ErrNoRows {
+			return export, err
+		}
+		if trip != nil && (trip.ArrivedAt != nil || trip.StartedAt != nil || trip.Fare != nil) {
+			export.Trips = append(export.Trips, trip)
+		}
+		charges, err := repo.ListTripCharges(ctx, "", &ride.ID)
+		if err != nil {
+			return export, err
+		}
+		export.Charges = append(export.Charges, charges...)
+	}
+	return export, nil
+}
+
+func eraseRider(w http.ResponseWriter, r *http.Request, repo *repository.BookingRepository, email string) {
+	n, err := repo.EraseRiderData(r.Context(), email)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to erase rider data: %w", err))
+		return
+	}
+	respondJSON(w, http.StatusOK, eraseRiderResponse{Message: "Rider data erased", Erased: n})
+}
@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"fmt"
+	"luxsuv-backend/config"
+	"luxsuv-backend/ratelimit"
+	"net/http"
+)
+
+// RouteLimiter returns the rate limit middleware for a named route. Routes
+// without configured limits get a pass-through middleware.
+type RouteLimiter func(route string) func(http.Handler) http.Handler
+
+// NewRouteLimiter parses the configured per-route limits up front so a bad
+// config fails at startup rather than on the first request.
+func NewRouteLimiter(store ratelimit.Store, cfg config.RateLimitConfig) (RouteLimiter, error) {
+	middlewares := map[string]func(http.Handler) http.Handler{}
+	for route, limits := range cfg.Routes {
+		var rules []ratelimit.Rule
+		for _, spec := range []struct {
+			name  string
+			limit string
+			key   ratelimit.KeyFunc
+		}{
+			{"ip", limits.PerIP, ratelimit.ClientIP},
+			{"email", limits.PerEmail, ratelimit.Email},
+		} {
+			if spec.limit == "" {
+				continue
+			}
+			limit, err := ratelimit.ParseLimit(spec.limit)
+			if err != nil {
+				return nil, fmt.Errorf("rate_limit.routes.%s: %w", route, err)
+			}
+			rules = append(rules, ratelimit.Rule{Name: spec.name, Limit: limit, Key: spec.key})
+		}
+		middlewares[route] = ratelimit.Middleware(store, route, rules...)
+	}
+
+	return func(route string) func(http.Handler) http.Handler {
+		if mw, ok := middlewares[route]; ok {
+			return mw
+		}
+		return func(next http.Handler) http.Handler { return next }
+	}, nil
+}
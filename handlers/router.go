@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"luxsuv-backend/config"
+	"luxsuv-backend/dispatch"
+	"luxsuv-backend/geo"
+	"luxsuv-backend/jwtkeys"
+	"luxsuv-backend/pricing"
+	"luxsuv-backend/realtime"
+	"luxsuv-backend/repository"
+	"luxsuv-backend/schedule"
+	"net/http"
+	"time"
+)
+
+// Each API version is mounted under its own prefix and owns its request and
+// response DTOs (see dto_v1.go), so a breaking change to the wire format ships
+// as a new version while clients pinned to an older one keep working.
+
+// legacyRequestKey marks requests served through DeprecatedAlias.
+const legacyRequestKey contextKey = "legacy_request"
+
+// Dates advertised on the unversioned legacy routes.
+var (
+	LegacyDeprecationDate = time.Date(2026, time.October, 18, 0, 0, 0, 0, time.UTC)
+	LegacySunsetDate      = time.Date(2027, time.April, 30, 0, 0, 0, 0, time.UTC)
+)
+
+// Services are the collaborators the handlers use besides the repository.
+type Services struct {
+	Geocoder  geo.Geocoder // nil disables geocoding
+	Router    geo.Router   // nil disables route estimates
+	Scheduler *schedule.Scheduler
+	Dispatch  *dispatch.Engine
+	Events    *realtime.Hub
+	Tracking  config.TrackingConfig
+	Pricing   *pricing.Pricer
+}
+
+// SetupV1Router mounts the rider, driver and admin routers for API version 1.
+func SetupV1Router(repo *repository.BookingRepository, keys *jwtkeys.KeySet, limit RouteLimiter, svc Services) *chi.Mux {
+	r := chi.NewRouter()
+	auth := NewAuthMiddleware(repo, keys)
+	r.Mount("/rider", SetupRiderRouter(repo, auth, limit, svc))
+	r.Mount("/driver", SetupDriverRouter(repo, auth, svc))
+	r.Mount("/admin", SetupAdminRouter(repo, auth, svc))
+	return r
+}
+
+// DeprecatedAlias serves a legacy unversioned path through the router of the
+// given version, marking every response with Deprecation, Sunset and a Link to
+// the versioned successor. Handlers can tell legacy requests apart with
+// isLegacyRequest where the versioned API is stricter.
+func DeprecatedAlias(versionPrefix string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", fmt.Sprintf("@%d", LegacyDeprecationDate.Unix()))
+		w.Header().Set("Sunset", LegacySunsetDate.Format(http.TimeFormat))
+		w.Header().Set("Link", fmt.Sprintf("<%s%s>; rel=\"successor-version\"", versionPrefix, r.URL.Path))
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), legacyRequestKey, true)))
+	})
+}
+
+// isLegacyRequest reports whether the request came in through DeprecatedAlias.
+func isLegacyRequest(ctx context.Context) bool {
+	legacy, _ := ctx.Value(legacyRequestKey).(bool)
+	return legacy
+}
@@ -0,0 +1,299 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"log"
+	"luxsuv-backend/data"
+	"luxsuv-backend/repository"
+	"luxsuv-backend/schedule"
+	"net/http"
+	"time"
+)
+
+// driverAssignment is the body of the booking driver assignment endpoint; a
+// null driver_id removes the assignment.
+type driverAssignment struct {
+	DriverID *int64 `json:"driver_id"`
+}
+
+// scheduleConflictResponse is returned with 409 when a driver already has a
+// booking at the time.
+type scheduleConflictResponse struct {
+	Error                string          `json:"error"`
+	ConflictingBookingID int64           `json:"conflicting_booking_id,omitempty"`
+	ConflictingInterval  *data.TimeRange `json:"conflicting_interval,omitempty"`
+}
+
+// timeOffRequest is the body of the driver time off endpoint.
+type timeOffRequest struct {
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	Reason string    `json:"reason"`
+}
+
+// scheduleBookRide sets the interval in which a booking occupies its driver.
+func scheduleBookRide(s *schedule.Scheduler, ride *data.BookRide) error {
+	occupied, err := s.Occupied(ride)
+	if err != nil {
+		return err
+	}
+	ride.Occupied = &occupied
+	return nil
+}
+
+// scheduleRiderBookRide is scheduleBookRide for a booking a rider made or
+// changed. A legacy request whose pickup date or time is in a format the
+// scheduler does not understand leaves the booking unscheduled, as bookings
+// were before scheduling, for an admin to assign by hand (see
+// scheduleAssignedBookRide).
+func scheduleRiderBookRide(s *schedule.Scheduler, ride *data.BookRide, legacy bool) error {
+	if legacy {
+		return scheduleAssignedBookRide(s, ride)
+	}
+	return scheduleBookRide(s, ride)
+}
+
+// scheduleAssignedBookRide is scheduleBookRide for a booking an admin
+// assigns. A booking whose pickup date or time the scheduler does not
+// understand, stored through the legacy rider API, is left unscheduled so it
+// can still be assigned.
+func scheduleAssignedBookRide(s *schedule.Scheduler, ride *data.BookRide) error {
+	err := scheduleBookRide(s, ride)
+	if errors.Is(err, schedule.ErrInvalidPickupTime) {
+		ride.Occupied = nil
+		return nil
+	}
+	return err
+}
+
+// assignBookRideDriver assigns a driver to a booking. The trip must fall in
+// the driver's working hours and outside their time off, and must not
+// overlap another of their bookings. An unscheduled booking is assigned
+// without these checks, as it has no trip to check. If the booking has no
+// vehicle yet, the driver's default vehicle is assigned when it fits.
+func assignBookRideDriver(repo *repository.BookingRepository, sched *schedule.Scheduler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		id, err := parseIDParam(r, "id")
+		if err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid booking ID: %w", err))
+			return
+		}
+		var req driverAssignment
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+			return
+		}
+
+		ride, err := repo.GetBookRideByID(ctx, id)
+		if err != nil {
+			if err == pgx.ErrNoRows {
+				respondError(w, http.StatusNotFound, fmt.Errorf("ride booking not found: %d", id))
+				return
+			}
+			respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to get ride booking: %w", err))
+			return
+		}
+		// Recomputed so the interval reflects the latest route estimate.
+		if err := scheduleAssignedBookRide(sched, ride); err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("ride booking cannot be scheduled: %w", err))
+			return
+		}
+
+		var driver *data.User
+		if req.DriverID != nil {
+			driver, err = repo.GetUserByID(ctx, *req.DriverID)
+			if err != nil && err != pgx.ErrNoRows {
+				respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to get driver: %w", err))
+				return
+			}
+			if err == pgx.ErrNoRows || driver.Role != data.RoleDriver || driver.DisabledAt != nil {
+				respondError(w, http.StatusBadRequest, fmt.Errorf("driver not found: %d", *req.DriverID))
+				return
+			}
+		}
+		if driver != nil && ride.Occupied != nil {
+			if err := checkDriverAvailable(ctx, repo, sched, driver.ID, ride); err != nil {
+				if errors.Is(err, schedule.ErrOutsideWorkingHours) || errors.Is(err, schedule.ErrTimeOff) {
+					respondError(w, http.StatusConflict, err)
+					return
+				}
+				respondError(w, http.StatusInternalServerError, err)
+				return
+			}
+		}
+
+		if err := repo.AssignBookRideDriver(ctx, id, req.DriverID, ride.Occupied); err != nil {
+			var conflict *repository.ScheduleConflictError
+			switch {
+			case err == pgx.ErrNoRows:
+				respondError(w, http.StatusNotFound, fmt.Errorf("ride booking not found: %d", id))
+			case errors.As(err, &conflict):
+				resp := scheduleConflictResponse{Error: conflict.Error()}
+				if conflict.BookingID != 0 {
+					resp.ConflictingBookingID = conflict.BookingID
+					resp.ConflictingInterval = &conflict.Occupied
+				}
+				respondJSON(w, http.StatusConflict, resp)
+			default:
+				respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to assign driver: %w", err))
+			}
+			return
+		}
+
+		if driver != nil && ride.VehicleID == nil && driver.DefaultVehicleID != nil {
+			err := repo.AssignBookRideVehicle(ctx, id, driver.DefaultVehicleID)
+			if err != nil && !errors.Is(err, data.ErrVehicleCapacity) && !errors.Is(err, repository.ErrVehicleUnavailable) {
+				log.Printf("Failed to assign default vehicle of driver %d to booking %d: %v", driver.ID, id, err)
+			}
+		}
+
+		respondJSON(w, http.StatusOK, map[string]string{"message": "Driver assigned successfully"})
+	}
+}
+
+// checkDriverAvailable checks a booking's trip against the driver's working
+// hours and time off.
+func checkDriverAvailable(ctx context.Context, repo *repository.BookingRepository, sched *schedule.Scheduler, driverID int64, ride *data.BookRide) error {
+	trip, err := sched.Trip(ride)
+	if err != nil {
+		return err
+	}
+	hours, err := repo.GetDriverWorkingHours(ctx, driverID)
+	if err != nil {
+		return err
+	}
+	timeOff, err := repo.ListDriverTimeOff(ctx, driverID, &trip)
+	if err != nil {
+		return err
+	}
+	return sched.CheckAvailability(trip, hours, timeOff)
+}
+
+func getDriverWorkingHours(repo *repository.BookingRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := parseIDParam(r, "id")
+		if err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid driver ID: %w", err))
+			return
+		}
+		hours, err := repo.GetDriverWorkingHours(r.Context(), id)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to get working hours: %w", err))
+			return
+		}
+		respondJSON(w, http.StatusOK, hours)
+	}
+}
+
+// setDriverWorkingHours replaces a driver's weekly working hours. Bookings
+// already assigned to the driver are not re-checked.
+func setDriverWorkingHours(repo *repository.BookingRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := parseIDParam(r, "id")
+		if err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid driver ID: %w", err))
+			return
+		}
+		var hours []data.WorkingHours
+		if err := json.NewDecoder(r.Body).Decode(&hours); err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+			return
+		}
+		if err := schedule.ValidateWorkingHours(hours); err != nil {
+			respondError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := repo.SetDriverWorkingHours(r.Context(), id, hours); err != nil {
+			if err == pgx.ErrNoRows {
+				respondError(w, http.StatusNotFound, fmt.Errorf("driver not found: %d", id))
+				return
+			}
+			respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to set working hours: %w", err))
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]string{"message": "Working hours updated successfully"})
+	}
+}
+
+func listDriverTimeOff(repo *repository.BookingRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := parseIDParam(r, "id")
+		if err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid driver ID: %w", err))
+			return
+		}
+		timeOff, err := repo.ListDriverTimeOff(r.Context(), id, nil)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to list time off: %w", err))
+			return
+		}
+		respondJSON(w, http.StatusOK, timeOff)
+	}
+}
+
+// createDriverTimeOff records time off. Bookings already assigned to the
+// driver in that period are not unassigned.
+func createDriverTimeOff(repo *repository.BookingRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := parseIDParam(r, "id")
+		if err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid driver ID: %w", err))
+			return
+		}
+		var req timeOffRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+			return
+		}
+		if !req.End.After(req.Start) {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("end must be after start"))
+			return
+		}
+
+		off, err := repo.CreateDriverTimeOff(r.Context(), &data.TimeOff{
+			DriverID: id,
+			Start:    req.Start,
+			End:      req.End,
+			Reason:   req.Reason,
+		})
+		if err != nil {
+			if err == pgx.ErrNoRows {
+				respondError(w, http.StatusNotFound, fmt.Errorf("driver not found: %d", id))
+				return
+			}
+			respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to create time off: %w", err))
+			return
+		}
+		respondJSON(w, http.StatusCreated, off)
+	}
+}
+
+func deleteDriverTimeOff(repo *repository.BookingRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := parseIDParam(r, "id")
+		if err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid driver ID: %w", err))
+			return
+		}
+		timeOffID, err := parseIDParam(r, "timeOffID")
+		if err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid time off ID: %w", err))
+			return
+		}
+		if err := repo.DeleteDriverTimeOff(r.Context(), id, timeOffID); err != nil {
+			if err == pgx.ErrNoRows {
+				respondError(w, http.StatusNotFound, fmt.Errorf("time off not found: %d", timeOffID))
+				return
+			}
+			respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to delete time off: %w", err))
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]string{"message": "Time off deleted successfully"})
+	}
+}
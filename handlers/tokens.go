@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"io"
+	"luxsuv-backend/data"
+	"luxsuv-backend/repository"
+	"net/http"
+	"time"
+)
+
+// tokenResponse is returned by login and refresh. Token is the short-lived
+// access token; RefreshToken is single-use and rotated on every refresh.
+type tokenResponse struct {
+	Token        string `json:"token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+func randomToken(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// signAccessToken issues an access token for user carrying a unique jti and
+// the user's current token version.
+func (a *AuthMiddleware) signAccessToken(user *data.User) (string, error) {
+	return a.signToken(user, purposeAccess, a.accessTTL)
+}
+
+func (a *AuthMiddleware) signToken(user *data.User, purpose string, ttl time.Duration) (string, error) {
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", fmt.Errorf("failed to generate token ID: %w", err)
+	}
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"aud":      tokenAudience(purpose),
+		"id":       user.ID,
+		"username": user.Username,
+		"role":     user.Role,
+		"ver":      user.TokenVersion,
+		"jti":      hex.EncodeToString(jti),
+		"iat":      now.Unix(),
+		"exp":      now.Add(ttl).Unix(),
+	}
+	if purpose != purposeAccess {
+		claims["purpose"] = purpose
+	}
+	return a.keys.Sign(claims)
+}
+
+// issueTokens starts a new refresh token family for user and returns it with
+// a fresh access token.
+func (a *AuthMiddleware) issueTokens(r *http.Request, user *data.User) (*tokenResponse, error) {
+	familyID, err := randomToken(16)
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.repo.CreateRefreshToken(r.Context(), user.ID, familyID, refreshToken, time.Now().Add(a.refreshTTL)); err != nil {
+		return nil, err
+	}
+	return a.tokenResponse(user, refreshToken)
+}
+
+func (a *AuthMiddleware) tokenResponse(user *data.User, refreshToken string) (*tokenResponse, error) {
+	accessToken, err := a.signAccessToken(user)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate token: %w", err)
+	}
+	return &tokenResponse{
+		Token:        accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(a.accessTTL.Seconds()),
+		RefreshToken: refreshToken,
+	}, nil
+}
+
+func refreshHandler(repo *repository.BookingRepository, auth *AuthMiddleware) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			RefreshToken string `json:"refresh_token"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+			return
+		}
+		if req.RefreshToken == "" {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("refresh_token is required"))
+			return
+		}
+
+		newToken, err := randomToken(32)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err)
+			return
+		}
+		user, err := repo.RotateRefreshToken(r.Context(), req.RefreshToken, newToken, time.Now().Add(auth.refreshTTL))
+		if err != nil {
+			if errors.Is(err, repository.ErrRefreshTokenInvalid) || errors.Is(err, repository.ErrRefreshTokenReused) {
+				respondError(w, http.StatusUnauthorized, err)
+				return
+			}
+			respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to refresh token: %w", err))
+			return
+		}
+
+		resp, err := auth.tokenResponse(user, newToken)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err)
+			return
+		}
+		respondJSON(w, http.StatusOK, resp)
+	}
+}
+
+// logoutHandler revokes the presented access token and, if given, the
+// refresh token family it was issued with.
+func logoutHandler(repo *repository.BookingRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		claims, ok := claimsFromContext(ctx)
+		if !ok {
+			respondError(w, http.StatusUnauthorized, fmt.Errorf("missing token"))
+			return
+		}
+
+		var req struct {
+			RefreshToken string `json:"refresh_token"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+			return
+		}
+
+		if err := repo.RevokeAccessToken(ctx, claims.jti, claims.expiresAt); err != nil {
+			respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to log out: %w", err))
+			return
+		}
+		if req.RefreshToken != "" {
+			if err := repo.RevokeRefreshTokenFamily(ctx, claims.userID, req.RefreshToken); err != nil {
+				respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to log out: %w", err))
+				return
+			}
+		}
+
+		respondJSON(w, http.StatusOK, map[string]string{"message": "Logged out successfully"})
+	}
+}
@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"context"
+	"github.com/golang-jwt/jwt/v5"
+	"luxsuv-backend/data"
+	"luxsuv-backend/jwtkeys"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestMFATokensAreNotAccessTokens(t *testing.T) {
+	keys, err := jwtkeys.NewKeySet("test", jwtkeys.HMACKey("test", []byte("0123456789abcdef0123456789abcdef")))
+	if err != nil {
+		t.Fatalf("NewKeySet failed: %v", err)
+	}
+	auth := &AuthMiddleware{keys: keys, accessTTL: time.Minute}
+	user := &data.User{ID: 7, Username: "driver", Role: data.RoleDriver}
+
+	for purpose, aud := range map[string]string{
+		purposeAccess:    audienceAccess,
+		purposeMFA:       audienceMFA,
+		purposeMFAEnroll: audienceMFA,
+	} {
+		token, err := auth.signToken(user, purpose, time.Minute)
+		if err != nil {
+			t.Fatalf("signToken(%q) failed: %v", purpose, err)
+		}
+		// A verifier that only knows the JWKS tells the tokens apart by audience.
+		if _, err := jwt.Parse(token, keys.Keyfunc, jwt.WithAudience(audienceAccess)); (err == nil) != (aud == audienceAccess) {
+			t.Errorf("%q token: unexpected result verifying as an access token: %v", purpose, err)
+		}
+		parsed, err := keys.Parse(token)
+		if err != nil {
+			t.Fatalf("Parse failed: %v", err)
+		}
+		if got, _ := parsed.Claims.GetAudience(); !slices.Equal(got, jwt.ClaimStrings{aud}) {
+			t.Errorf("%q token: expected audience %q, got %v", purpose, aud, got)
+		}
+		if purpose == purposeAccess {
+			continue
+		}
+		if claims, status, _ := auth.authenticate(context.Background(), token, purposeAccess); claims != nil || status != http.StatusUnauthorized {
+			t.Errorf("%q token: expected to be rejected as an access token, got status %d", purpose, status)
+		}
+	}
+}
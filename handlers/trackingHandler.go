@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"luxsuv-backend/config"
+	"luxsuv-backend/data"
+	"luxsuv-backend/pricing"
+	"luxsuv-backend/repository"
+	"net/http"
+	"time"
+)
+
+const (
+	// maxLocationPings is the most pings accepted in one request.
+	maxLocationPings = 100
+	// maxClockSkew is how far in the future a ping may be timestamped, to
+	// allow for device clocks running slightly ahead.
+	maxClockSkew = time.Minute
+)
+
+// locationPingsRequest is the body of the driver location endpoint. Apps
+// batch the pings collected while offline or between uploads.
+type locationPingsRequest struct {
+	Pings []data.LocationPing `json:"pings"`
+}
+
+// locationPingsResponse reports how many pings were stored and the booking's
+// location afterwards, which is null if every ping was ignored.
+type locationPingsResponse struct {
+	Accepted int                  `json:"accepted"`
+	Ignored  int                  `json:"ignored"`
+	Location *data.DriverLocation `json:"location"`
+}
+
+// filterLocationPings validates a batch of pings and drops those less
+// accurate than maxAccuracy, returning the rest and how many were dropped.
+func filterLocationPings(pings []data.LocationPing, maxAccuracy float64, now time.Time) ([]data.LocationPing, int, error) {
+	if len(pings) == 0 {
+		return nil, 0, fmt.Errorf("at least one ping is required")
+	}
+	if len(pings) > maxLocationPings {
+		return nil, 0, fmt.Errorf("at most %d pings are accepted per request, got %d", maxLocationPings, len(pings))
+	}
+	kept := make([]data.LocationPing, 0, len(pings))
+	for i, p := range pings {
+		switch {
+		case p.Lat < -90 || p.Lat > 90 || p.Lng < -180 || p.Lng > 180:
+			return nil, 0, fmt.Errorf("ping %d: coordinates out of range", i)
+		case p.Accuracy < 0:
+			return nil, 0, fmt.Errorf("ping %d: accuracy must not be negative", i)
+		case p.Heading != nil && (*p.Heading < 0 || *p.Heading >= 360):
+			return nil, 0, fmt.Errorf("ping %d: heading must be between 0 and 360 degrees", i)
+		case p.Speed != nil && *p.Speed < 0:
+			return nil, 0, fmt.Errorf("ping %d: speed must not be negative", i)
+		case p.RecordedAt.IsZero():
+			return nil, 0, fmt.Errorf("ping %d: recorded_at is required", i)
+		case p.RecordedAt.After(now.Add(maxClockSkew)):
+			return nil, 0, fmt.Errorf("ping %d: recorded_at is in the future", i)
+		}
+		if p.Accuracy <= maxAccuracy {
+			kept = append(kept, p)
+		}
+	}
+	return kept, len(pings) - len(kept), nil
+}
+
+// setDriverBookRideStatus lets the assigned driver move a booking through
+// the ride: en route to the pickup, arrived there, in progress and
+// completed, with the odometer reading when the ride starts and ends.
+func setDriverBookRideStatus(repo *repository.BookingRepository, pricer *pricing.Pricer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := parseIDParam(r, "id")
+		if err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid booking ID: %w", err))
+			return
+		}
+		driverID, ok := userIDFromContext(r.Context())
+		if !ok {
+			respondError(w, http.StatusUnauthorized, fmt.Errorf("missing user"))
+			return
+		}
+		var req bookRideStatusRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+			return
+		}
+		change := data.StatusChange{Status: req.Status, DriverID: &driverID, Odometer: req.Odometer}
+		if status, err := setDriverStatus(r.Context(), repo, pricer, id, change); err != nil {
+			respondError(w, status, err)
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]string{"message": "Ride booking status updated successfully"})
+	}
+}
+
+// setDriverStatus makes a status change of the driver in change.DriverID,
+// settling the fare when the ride is completed. On failure it returns the
+// HTTP status to report with the error.
+func setDriverStatus(ctx context.Context, repo *repository.BookingRepository, pricer *pricing.Pricer, id int64, change data.StatusChange) (int, error) {
+	if !data.IsDriverStatus(change.Status) {
+		return http.StatusBadRequest, fmt.Errorf("drivers can only set status %s, %s, %s or %s",
+			data.BookingEnRoute, data.BookingArrived, data.BookingInProgress, data.BookingCompleted)
+	}
+	if err := repo.SetBookRideStatus(ctx, id, change); err != nil {
+		switch {
+		case err == pgx.ErrNoRows:
+			return http.StatusNotFound, fmt.Errorf("ride booking not found or not assigned to you: %d", id)
+		case errors.Is(err, data.ErrInvalidOdometer):
+			return http.StatusBadRequest, err
+		case errors.Is(err, data.ErrInvalidStatusTransition):
+			return http.StatusConflict, err
+		default:
+			return http.StatusInternalServerError, fmt.Errorf("failed to set ride booking status: %w", err)
+		}
+	}
+	if change.Status == data.BookingCompleted {
+		if err := settleTrip(ctx, repo, pricer, id); err != nil {
+			return http.StatusInternalServerError, fmt.Errorf("ride booking completed but its fare could not be calculated: %w", err)
+		}
+	}
+	return http.StatusOK, nil
+}
+
+// recordLocationPings stores the positions the assigned driver reports while
+// a booking is en route or in progress.
+func recordLocationPings(repo *repository.BookingRepository, cfg config.TrackingConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := parseIDParam(r, "id")
+		if err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid booking ID: %w", err))
+			return
+		}
+		driverID, ok := userIDFromContext(r.Context())
+		if !ok {
+			respondError(w, http.StatusUnauthorized, fmt.Errorf("missing user"))
+			return
+		}
+		var req locationPingsRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+			return
+		}
+		resp, status, err := recordLocations(r.Context(), repo, cfg, id, driverID, req.Pings)
+		if err != nil {
+			respondError(w, status, err)
+			return
+		}
+		respondJSON(w, http.StatusOK, resp)
+	}
+}
+
+// recordLocations validates and stores the pings the driver reports for a
+// booking. On failure it returns the HTTP status to report with the error.
+func recordLocations(ctx context.Context, repo *repository.BookingRepository, cfg config.TrackingConfig, id, driverID int64, pings []data.LocationPing) (locationPingsResponse, int, error) {
+	pings, ignored, err := filterLocationPings(pings, cfg.MaxAccuracyMeters, time.Now())
+	if err != nil {
+		return locationPingsResponse{}, http.StatusBadRequest, err
+	}
+	if len(pings) == 0 {
+		return locationPingsResponse{Ignored: ignored}, http.StatusOK, nil
+	}
+
+	loc, err := repo.RecordLocationPings(ctx, id, driverID, pings, cfg.TrailLength)
+	if err != nil {
+		switch {
+		case err == pgx.ErrNoRows:
+			return locationPingsResponse{}, http.StatusNotFound, fmt.Errorf("ride booking not found or not assigned to you: %d", id)
+		case errors.Is(err, data.ErrNotTracked):
+			return locationPingsResponse{}, http.StatusConflict, err
+		default:
+			return locationPingsResponse{}, http.StatusInternalServerError, fmt.Errorf("failed to record location: %w", err)
+		}
+	}
+	return locationPingsResponse{Accepted: len(pings), Ignored: ignored, Location: loc}, http.StatusOK, nil
+}
+
+// getBookRideLocation returns the driver's live location to the rider
+// holding the booking's token.
+func getBookRideLocation(repo *repository.BookingRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := parseIDParam(r, "id")
+		if err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid booking ID: %w", err))
+			return
+		}
+		if bookRideFromContext(r.Context()).ID != id {
+			respondError(w, http.StatusForbidden, fmt.Errorf("booking token is for another booking"))
+			return
+		}
+
+		loc, err := repo.GetLiveLocation(r.Context(), id)
+		if err != nil {
+			switch {
+			case err == pgx.ErrNoRows:
+				respondError(w, http.StatusNotFound, fmt.Errorf("no driver location yet for ride booking %d", id))
+			case errors.Is(err, data.ErrNotTracked):
+				respondError(w, http.StatusConflict, err)
+			default:
+				respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to get location: %w", err))
+			}
+			return
+		}
+		respondJSON(w, http.StatusOK, loc)
+	}
+}
+
+// getBookRideTrail returns the breadcrumb trail of a booking, oldest first.
+func getBookRideTrail(repo *repository.BookingRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := parseIDParam(r, "id")
+		if err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid booking ID: %w", err))
+			return
+		}
+		trail, err := repo.ListLocationTrail(r.Context(), id)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to list location trail: %w", err))
+			return
+		}
+		respondJSON(w, http.StatusOK, trail)
+	}
+}
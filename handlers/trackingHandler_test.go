@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"luxsuv-backend/data"
+	"testing"
+	"time"
+)
+
+func TestFilterLocationPings(t *testing.T) {
+	now := time.Date(2026, time.March, 1, 12, 0, 0, 0, time.UTC)
+	valid := data.LocationPing{Lat: 33.94, Lng: -118.40, Accuracy: 8, RecordedAt: now.Add(-5 * time.Second)}
+	coarse := valid
+	coarse.Accuracy = 500
+
+	kept, ignored, err := filterLocationPings([]data.LocationPing{valid, coarse}, 100, now)
+	if err != nil {
+		t.Fatalf("filterLocationPings failed: %v", err)
+	}
+	if len(kept) != 1 || kept[0] != valid || ignored != 1 {
+		t.Errorf("Expected the coarse ping to be ignored, got %+v and %d ignored", kept, ignored)
+	}
+
+	heading, speed := 360.0, -1.0
+	for name, mutate := range map[string]func(*data.LocationPing){
+		"latitude out of range":  func(p *data.LocationPing) { p.Lat = 91 },
+		"longitude out of range": func(p *data.LocationPing) { p.Lng = -181 },
+		"negative accuracy":      func(p *data.LocationPing) { p.Accuracy = -1 },
+		"heading out of range":   func(p *data.LocationPing) { p.Heading = &heading },
+		"negative speed":         func(p *data.LocationPing) { p.Speed = &speed },
+		"missing timestamp":      func(p *data.LocationPing) { p.RecordedAt = time.Time{} },
+		"future timestamp":       func(p *data.LocationPing) { p.RecordedAt = now.Add(2 * time.Minute) },
+	} {
+		p := valid
+		mutate(&p)
+		if _, _, err := filterLocationPings([]data.LocationPing{valid, p}, 100, now); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+
+	if _, _, err := filterLocationPings(nil, 100, now); err == nil {
+		t.Error("Expected error for an empty batch")
+	}
+	if _, _, err := filterLocationPings(make([]data.LocationPing, maxLocationPings+1), 100, now); err == nil {
+		t.Error("Expected error for an oversized batch")
+	}
+}
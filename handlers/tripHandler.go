@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"luxsuv-backend/data"
+	"luxsuv-backend/pricing"
+	"luxsuv-backend/repository"
+	"net/http"
+	"time"
+)
+
+// tripRequest is the body of the admin trip endpoint; it replaces every
+// recorded time and odometer reading.
+type tripRequest struct {
+	ArrivedAt     *time.Time `json:"arrived_at"`
+	StartedAt     *time.Time `json:"started_at"`
+	EndedAt       *time.Time `json:"ended_at"`
+	StartOdometer *float64   `json:"start_odometer"`
+	EndOdometer   *float64   `json:"end_odometer"`
+}
+
+// validate checks that the times are in order and the odometer did not run
+// backwards.
+func (req tripRequest) validate() error {
+	if req.ArrivedAt != nil && req.StartedAt != nil && req.StartedAt.Before(*req.ArrivedAt) {
+		return fmt.Errorf("started_at must not be before arrived_at")
+	}
+	if req.EndedAt != nil && (req.StartedAt == nil || req.EndedAt.Before(*req.StartedAt)) {
+		return fmt.Errorf("ended_at requires started_at and must not be before it")
+	}
+	if (req.StartOdometer != nil && *req.StartOdometer < 0) || (req.EndOdometer != nil && *req.EndOdometer < 0) {
+		return fmt.Errorf("%w: readings must not be negative", data.ErrInvalidOdometer)
+	}
+	if req.StartOdometer != nil && req.EndOdometer != nil && *req.EndOdometer < *req.StartOdometer {
+		return fmt.Errorf("%w: end_odometer is below start_odometer", data.ErrInvalidOdometer)
+	}
+	return nil
+}
+
+// settleTrip calculates the quote and final fare, with the approved charges,
+// of a completed booking and stores them on its trip.
+func settleTrip(ctx context.Context, repo *repository.BookingRepository, pricer *pricing.Pricer, id int64) error {
+	ride, err := repo.GetBookRideByID(ctx, id)
+	if err != nil {
+		return fmt.Errorf("failed to get ride booking: %w", err)
+	}
+	trip, err := repo.GetTrip(ctx, id)
+	if err != nil {
+		return err
+	}
+	charges, err := repo.ListTripCharges(ctx, data.ChargeApproved, &id)
+	if err != nil {
+		return err
+	}
+	fare, err := pricer.Final(ride, trip, charges)
+	if err != nil {
+		return fmt.Errorf("failed to calculate fare: %w", err)
+	}
+	return repo.SetTripFare(ctx, id, pricer.Quote(ride), fare)
+}
+
+// getBookRideTrip returns what has been recorded of a booking's trip. Until
+// the trip is completed the quote is worked out afresh and there is no
+// fare. Riders authenticated by a booking token only see their own.
+func getBookRideTrip(repo *repository.BookingRepository, pricer *pricing.Pricer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		id, err := parseIDParam(r, "id")
+		if err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid booking ID: %w", err))
+			return
+		}
+		if ride := bookRideFromContext(ctx); ride != nil && ride.ID != id {
+			respondError(w, http.StatusForbidden, fmt.Errorf("booking token is for another booking"))
+			return
+		}
+
+		trip, err := repo.GetTrip(ctx, id)
+		if err != nil {
+			if err == pgx.ErrNoRows {
+				respondError(w, http.StatusNotFound, fmt.Errorf("ride booking not found: %d", id))
+				return
+			}
+			respondError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if trip.Quote == nil {
+			ride, err := repo.GetBookRideByID(ctx, id)
+			if err != nil {
+				respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to get ride booking: %w", err))
+				return
+			}
+			quote := pricer.Quote(ride)
+			trip.Quote = &quote
+		}
+		respondJSON(w, http.StatusOK, trip)
+	}
+}
+
+// updateBookRideTrip corrects the recorded times and odometer readings of a
+// booking's trip, for when the driver forgot to change the status or the
+// app was offline. The fare of a completed booking is calculated again.
+func updateBookRideTrip(repo *repository.BookingRepository, pricer *pricing.Pricer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		id, err := parseIDParam(r, "id")
+		if err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid booking ID: %w", err))
+			return
+		}
+		var req tripRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+			return
+		}
+		if err := req.validate(); err != nil {
+			respondError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		trip := &data.Trip{BookingID: id, ArrivedAt: req.ArrivedAt, StartedAt: req.StartedAt, EndedAt: req.EndedAt,
+			StartOdometer: req.StartOdometer, EndOdometer: req.EndOdometer}
+		if err := repo.UpdateTrip(ctx, trip); err != nil {
+			if err == pgx.ErrNoRows {
+				respondError(w, http.StatusNotFound, fmt.Errorf("ride booking not found: %d", id))
+				return
+			}
+			respondError(w, http.StatusInternalServerError, err)
+			return
+		}
+		ride, err := repo.GetBookRideByID(ctx, id)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to get ride booking: %w", err))
+			return
+		}
+		if ride.Status == data.BookingCompleted {
+			if err := settleTrip(ctx, repo, pricer, id); err != nil {
+				respondError(w, http.StatusUnprocessableEntity, fmt.Errorf("trip saved but the fare could not be calculated: %w", err))
+				return
+			}
+		}
+		if trip, err = repo.GetTrip(ctx, id); err != nil {
+			respondError(w, http.StatusInternalServerError, err)
+			return
+		}
+		respondJSON(w, http.StatusOK, trip)
+	}
+}
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"errors"
+	"luxsuv-backend/data"
+	"testing"
+	"time"
+)
+
+func TestTripRequestValidate(t *testing.T) {
+	arrived := time.Date(2026, time.March, 1, 9, 55, 0, 0, time.UTC)
+	started, ended := arrived.Add(5*time.Minute), arrived.Add(2*time.Hour)
+	start, end, negative := 1200.0, 1265.5, -1.0
+
+	valid := tripRequest{ArrivedAt: &arrived, StartedAt: &started, EndedAt: &ended, StartOdometer: &start, EndOdometer: &end}
+	if err := valid.validate(); err != nil {
+		t.Errorf("Expected a complete trip to be valid, got %v", err)
+	}
+	if err := (tripRequest{ArrivedAt: &arrived}).validate(); err != nil {
+		t.Errorf("Expected a trip in progress to be valid, got %v", err)
+	}
+
+	for name, tc := range map[string]struct {
+		mutate   func(*tripRequest)
+		odometer bool
+	}{
+		"started before arrival": {func(r *tripRequest) { r.ArrivedAt = &ended }, false},
+		"ended before start":     {func(r *tripRequest) { r.EndedAt = &arrived }, false},
+		"ended without start":    {func(r *tripRequest) { r.StartedAt = nil; r.ArrivedAt = nil }, false},
+		"negative reading":       {func(r *tripRequest) { r.StartOdometer = &negative }, true},
+		"odometer backwards":     {func(r *tripRequest) { r.StartOdometer, r.EndOdometer = &end, &start }, true},
+	} {
+		req := valid
+		tc.mutate(&req)
+		err := req.validate()
+		if err == nil {
+			t.Errorf("%s: expected error", name)
+		} else if errors.Is(err, data.ErrInvalidOdometer) != tc.odometer {
+			t.Errorf("%s: unexpected error %v", name, err)
+		}
+	}
+}
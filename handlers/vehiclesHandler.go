@@ -0,0 +1,252 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"luxsuv-backend/data"
+	"luxsuv-backend/repository"
+	"net/http"
+	"strings"
+)
+
+// vehicleRequest is the body of the admin vehicle create and update endpoints.
+type vehicleRequest struct {
+	Make            string   `json:"make"`
+	Model           string   `json:"model"`
+	Plate           string   `json:"plate"`
+	Seats           int      `json:"seats"`
+	LuggageCapacity int      `json:"luggage_capacity"`
+	Amenities       []string `json:"amenities"`
+	Status          string   `json:"status"` // defaults to active
+}
+
+func (req *vehicleRequest) toModel() (*data.Vehicle, error) {
+	v := &data.Vehicle{
+		Make:            strings.TrimSpace(req.Make),
+		Model:           strings.TrimSpace(req.Model),
+		Plate:           strings.ToUpper(strings.TrimSpace(req.Plate)),
+		Seats:           req.Seats,
+		LuggageCapacity: req.LuggageCapacity,
+		Amenities:       []string{},
+		Status:          req.Status,
+	}
+	if v.Make == "" || v.Model == "" {
+		return nil, fmt.Errorf("make and model are required")
+	}
+	if v.Plate == "" {
+		return nil, fmt.Errorf("plate is required")
+	}
+	if v.Seats <= 0 {
+		return nil, fmt.Errorf("seats must be positive")
+	}
+	if v.LuggageCapacity < 0 {
+		return nil, fmt.Errorf("luggage_capacity must not be negative")
+	}
+	for _, amenity := range req.Amenities {
+		if amenity = strings.TrimSpace(amenity); amenity == "" {
+			return nil, fmt.Errorf("amenities must not be empty")
+		}
+		v.Amenities = append(v.Amenities, amenity)
+	}
+	if v.Status == "" {
+		v.Status = data.VehicleActive
+	}
+	if !validVehicleStatus(v.Status) {
+		return nil, fmt.Errorf("status must be '%s', '%s' or '%s', got %s",
+			data.VehicleActive, data.VehicleMaintenance, data.VehicleRetired, v.Status)
+	}
+	return v, nil
+}
+
+func validVehicleStatus(status string) bool {
+	return status == data.VehicleActive || status == data.VehicleMaintenance || status == data.VehicleRetired
+}
+
+// vehicleAssignment is the body of the endpoints that assign a vehicle; a
+// null vehicle_id removes the assignment.
+type vehicleAssignment struct {
+	VehicleID *int64 `json:"vehicle_id"`
+}
+
+func listVehicles(repo *repository.BookingRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status := r.URL.Query().Get("status")
+		if status != "" && !validVehicleStatus(status) {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid status: %s", status))
+			return
+		}
+		vehicles, err := repo.ListVehicles(r.Context(), status)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to list vehicles: %w", err))
+			return
+		}
+		if vehicles == nil {
+			vehicles = []*data.Vehicle{}
+		}
+		respondJSON(w, http.StatusOK, vehicles)
+	}
+}
+
+func createVehicle(repo *repository.BookingRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req vehicleRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+			return
+		}
+		vehicle, err := req.toModel()
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		created, err := repo.CreateVehicle(r.Context(), vehicle)
+		if err != nil {
+			if errors.Is(err, repository.ErrPlateTaken) {
+				respondError(w, http.StatusConflict, err)
+				return
+			}
+			respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to create vehicle: %w", err))
+			return
+		}
+		respondJSON(w, http.StatusCreated, created)
+	}
+}
+
+func getVehicle(repo *repository.BookingRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := parseIDParam(r, "id")
+		if err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid vehicle ID: %w", err))
+			return
+		}
+		vehicle, err := repo.GetVehicleByID(r.Context(), id)
+		if err != nil {
+			if err == pgx.ErrNoRows {
+				respondError(w, http.StatusNotFound, fmt.Errorf("vehicle not found: %d", id))
+				return
+			}
+			respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to get vehicle: %w", err))
+			return
+		}
+		respondJSON(w, http.StatusOK, vehicle)
+	}
+}
+
+func updateVehicle(repo *repository.BookingRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := parseIDParam(r, "id")
+		if err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid vehicle ID: %w", err))
+			return
+		}
+		var req vehicleRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+			return
+		}
+		vehicle, err := req.toModel()
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err)
+			return
+		}
+		vehicle.ID = id
+
+		updated, err := repo.UpdateVehicle(r.Context(), vehicle)
+		if err != nil {
+			if err == pgx.ErrNoRows {
+				respondError(w, http.StatusNotFound, fmt.Errorf("vehicle not found: %d", id))
+				return
+			}
+			if errors.Is(err, repository.ErrPlateTaken) {
+				respondError(w, http.StatusConflict, err)
+				return
+			}
+			respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to update vehicle: %w", err))
+			return
+		}
+		respondJSON(w, http.StatusOK, updated)
+	}
+}
+
+func deleteVehicle(repo *repository.BookingRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := parseIDParam(r, "id")
+		if err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid vehicle ID: %w", err))
+			return
+		}
+		if err := repo.DeleteVehicle(r.Context(), id); err != nil {
+			if err == pgx.ErrNoRows {
+				respondError(w, http.StatusNotFound, fmt.Errorf("vehicle not found: %d", id))
+				return
+			}
+			respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to delete vehicle: %w", err))
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]string{"message": "Vehicle deleted successfully"})
+	}
+}
+
+func setDriverDefaultVehicle(repo *repository.BookingRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := parseIDParam(r, "id")
+		if err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid driver ID: %w", err))
+			return
+		}
+		var req vehicleAssignment
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+			return
+		}
+
+		if err := repo.SetDriverDefaultVehicle(r.Context(), id, req.VehicleID); err != nil {
+			if err == pgx.ErrNoRows {
+				respondError(w, http.StatusNotFound, fmt.Errorf("driver not found: %d", id))
+				return
+			}
+			if errors.Is(err, repository.ErrVehicleNotFound) {
+				respondError(w, http.StatusBadRequest, err)
+				return
+			}
+			respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to set default vehicle: %w", err))
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]string{"message": "Default vehicle updated successfully"})
+	}
+}
+
+func assignBookRideVehicle(repo *repository.BookingRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := parseIDParam(r, "id")
+		if err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid booking ID: %w", err))
+			return
+		}
+		var req vehicleAssignment
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+			return
+		}
+
+		if err := repo.AssignBookRideVehicle(r.Context(), id, req.VehicleID); err != nil {
+			switch {
+			case err == pgx.ErrNoRows:
+				respondError(w, http.StatusNotFound, fmt.Errorf("ride booking not found: %d", id))
+			case errors.Is(err, repository.ErrVehicleNotFound):
+				respondError(w, http.StatusBadRequest, err)
+			case errors.Is(err, repository.ErrVehicleUnavailable):
+				respondError(w, http.StatusConflict, err)
+			case errors.Is(err, data.ErrVehicleCapacity):
+				respondError(w, http.StatusUnprocessableEntity, err)
+			default:
+				respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to assign vehicle: %w", err))
+			}
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]string{"message": "Vehicle assigned successfully"})
+	}
+}
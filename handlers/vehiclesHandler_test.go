@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"errors"
+	"luxsuv-backend/data"
+	"testing"
+)
+
+func TestVehicleRequestValidation(t *testing.T) {
+	valid := vehicleRequest{Make: "Cadillac", Model: "Escalade", Plate: " 8abc123 ", Seats: 6, LuggageCapacity: 5,
+		Amenities: []string{"wifi", " water "}}
+	vehicle, err := valid.toModel()
+	if err != nil {
+		t.Fatalf("toModel failed: %v", err)
+	}
+	if vehicle.Plate != "8ABC123" || vehicle.Status != data.VehicleActive || vehicle.Amenities[1] != "water" {
+		t.Errorf("Unexpected vehicle %+v", vehicle)
+	}
+
+	for name, mutate := range map[string]func(*vehicleRequest){
+		"missing make":     func(r *vehicleRequest) { r.Make = "" },
+		"missing plate":    func(r *vehicleRequest) { r.Plate = " " },
+		"no seats":         func(r *vehicleRequest) { r.Seats = 0 },
+		"negative luggage": func(r *vehicleRequest) { r.LuggageCapacity = -1 },
+		"empty amenity":    func(r *vehicleRequest) { r.Amenities = []string{""} },
+		"unknown status":   func(r *vehicleRequest) { r.Status = "stolen" },
+	} {
+		req := valid
+		mutate(&req)
+		if _, err := req.toModel(); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestVehicleCheckCapacity(t *testing.T) {
+	v := &data.Vehicle{Seats: 6, LuggageCapacity: 4}
+	if err := v.CheckCapacity(6, 4); err != nil {
+		t.Errorf("Expected booking to fit, got %v", err)
+	}
+	if err := v.CheckCapacity(7, 0); !errors.Is(err, data.ErrVehicleCapacity) {
+		t.Errorf("Expected ErrVehicleCapacity for passengers, got %v", err)
+	}
+	if err := v.CheckCapacity(1, 5); !errors.Is(err, data.ErrVehicleCapacity) {
+		t.Errorf("Expected ErrVehicleCapacity for luggage, got %v", err)
+	}
+}
@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"log"
+	"luxsuv-backend/data"
+	"luxsuv-backend/geo"
+	"luxsuv-backend/repository"
+	"net/http"
+	"strings"
+)
+
+// errOutsideServiceArea rejects bookings whose pickup is in no active zone.
+var errOutsideServiceArea = errors.New("pickup location is outside our service area")
+
+// errPickupNotLocated rejects bookings whose pickup address the geocoder
+// does not know, so it cannot be placed in or out of the service area.
+var errPickupNotLocated = errors.New("pickup location could not be located, please check the address or include its coordinates")
+
+// errGeocoderUnavailable rejects bookings whose pickup address could not be
+// looked up because no geocoder is configured or it failed.
+var errGeocoderUnavailable = errors.New("pickup location cannot be looked up right now, please try again later or include its coordinates")
+
+// zoneRequest is the body of the admin zone create and update endpoints.
+type zoneRequest struct {
+	Name     string          `json:"name"`
+	Boundary json.RawMessage `json:"boundary"`
+	Active   *bool           `json:"active"` // defaults to true
+}
+
+func (req *zoneRequest) toModel() (*data.Zone, error) {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+	if len(req.Boundary) == 0 {
+		return nil, fmt.Errorf("boundary is required")
+	}
+	if _, err := geo.ParseArea(req.Boundary); err != nil {
+		return nil, fmt.Errorf("boundary: %w", err)
+	}
+	zone := &data.Zone{Name: req.Name, Boundary: req.Boundary, Active: true}
+	if req.Active != nil {
+		zone.Active = *req.Active
+	}
+	return zone, nil
+}
+
+func listZones(repo *repository.BookingRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		zones, err := repo.ListZones(r.Context(), false)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to list zones: %w", err))
+			return
+		}
+		if zones == nil {
+			zones = []*data.Zone{}
+		}
+		respondJSON(w, http.StatusOK, zones)
+	}
+}
+
+func createZone(repo *repository.BookingRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req zoneRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+			return
+		}
+		zone, err := req.toModel()
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		created, err := repo.CreateZone(r.Context(), zone)
+		if err != nil {
+			if errors.Is(err, repository.ErrZoneNameTaken) {
+				respondError(w, http.StatusConflict, err)
+				return
+			}
+			respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to create zone: %w", err))
+			return
+		}
+		respondJSON(w, http.StatusCreated, created)
+	}
+}
+
+func updateZone(repo *repository.BookingRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := parseIDParam(r, "id")
+		if err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid zone ID: %w", err))
+			return
+		}
+		var req zoneRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+			return
+		}
+		zone, err := req.toModel()
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err)
+			return
+		}
+		zone.ID = id
+
+		updated, err := repo.UpdateZone(r.Context(), zone)
+		if err != nil {
+			if err == pgx.ErrNoRows {
+				respondError(w, http.StatusNotFound, fmt.Errorf("zone not found: %d", id))
+				return
+			}
+			if errors.Is(err, repository.ErrZoneNameTaken) {
+				respondError(w, http.StatusConflict, err)
+				return
+			}
+			respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to update zone: %w", err))
+			return
+		}
+		respondJSON(w, http.StatusOK, updated)
+	}
+}
+
+func deleteZone(repo *repository.BookingRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := parseIDParam(r, "id")
+		if err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid zone ID: %w", err))
+			return
+		}
+		if err := repo.DeleteZone(r.Context(), id); err != nil {
+			if err == pgx.ErrNoRows {
+				respondError(w, http.StatusNotFound, fmt.Errorf("zone not found: %d", id))
+				return
+			}
+			respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to delete zone: %w", err))
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]string{"message": "Zone deleted successfully"})
+	}
+}
+
+// assignZone tags ride with the active service zone containing its pickup.
+// Without active zones every pickup is accepted. A pickup without coordinates
+// is geocoded first. It returns errOutsideServiceArea for pickups outside
+// every zone, errPickupNotLocated if the geocoder does not know the pickup
+// and an error wrapping errGeocoderUnavailable if it cannot be asked, so no
+// booking escapes the service area check.
+func assignZone(ctx context.Context, repo *repository.BookingRepository, geocoder geo.Geocoder, ride *data.BookRide) error {
+	ride.ZoneID = nil
+	zones, err := repo.ListZones(ctx, true)
+	if err != nil {
+		return err
+	}
+	return tagZone(ctx, geocoder, zones, ride)
+}
+
+// respondZoneError writes the response for an assignZone error.
+func respondZoneError(w http.ResponseWriter, err error) {
+	switch {
+	case err == errOutsideServiceArea || err == errPickupNotLocated:
+		respondError(w, http.StatusUnprocessableEntity, err)
+	case errors.Is(err, errGeocoderUnavailable):
+		log.Printf("Failed to geocode pickup for zone check: %v", err)
+		respondError(w, http.StatusServiceUnavailable, errGeocoderUnavailable)
+	default:
+		respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to check service area: %w", err))
+	}
+}
+
+// tagZone is assignZone for the given active zones.
+func tagZone(ctx context.Context, geocoder geo.Geocoder, zones []*data.Zone, ride *data.BookRide) error {
+	if len(zones) == 0 {
+		return nil
+	}
+
+	pickup := &ride.PickupLocation
+	if !pickup.HasCoordinates() {
+		if geocoder == nil {
+			return errGeocoderUnavailable
+		}
+		lookupCtx, cancel := context.WithTimeout(ctx, geocodeTimeout)
+		defer cancel()
+		place, err := geocoder.Geocode(lookupCtx, pickup.Address)
+		if err == geo.ErrNotFound {
+			return errPickupNotLocated
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %v", errGeocoderUnavailable, err)
+		}
+		resolveLocation(pickup, place)
+	}
+
+	zone, err := zoneContaining(zones, geo.Point{Lat: *pickup.Lat, Lng: *pickup.Lng})
+	if err != nil {
+		return err
+	}
+	if zone == nil {
+		return errOutsideServiceArea
+	}
+	ride.ZoneID = &zone.ID
+	return nil
+}
+
+// zoneContaining returns the first of zones whose boundary contains p, or nil.
+func zoneContaining(zones []*data.Zone, p geo.Point) (*data.Zone, error) {
+	for _, zone := range zones {
+		area, err := geo.ParseArea(zone.Boundary)
+		if err != nil {
+			return nil, fmt.Errorf("zone %d: %w", zone.ID, err)
+		}
+		if area.Contains(p) {
+			return zone, nil
+		}
+	}
+	return nil, nil
+}
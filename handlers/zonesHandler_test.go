@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"luxsuv-backend/data"
+	"luxsuv-backend/geo"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// stubGeocoder resolves the addresses in places; "down" fails as an outage.
+type stubGeocoder map[string]geo.Point
+
+func (g stubGeocoder) Geocode(_ context.Context, address string) (*geo.Place, error) {
+	if address == "down" {
+		return nil, errors.New("geocoder unavailable")
+	}
+	p, ok := g[address]
+	if !ok {
+		return nil, geo.ErrNotFound
+	}
+	return &geo.Place{Address: address, Point: p}, nil
+}
+
+func (g stubGeocoder) ReverseGeocode(context.Context, geo.Point) (*geo.Place, error) {
+	return nil, geo.ErrNotFound
+}
+
+func (g stubGeocoder) Autocomplete(context.Context, string, int) ([]geo.Place, error) {
+	return nil, nil
+}
+
+func TestZoneContaining(t *testing.T) {
+	zones := []*data.Zone{
+		{ID: 1, Name: "Downtown", Boundary: json.RawMessage(`{"type": "Polygon",
+			"coordinates": [[[-118.30, 34.00], [-118.20, 34.00], [-118.20, 34.10], [-118.30, 34.10], [-118.30, 34.00]]]}`)},
+		{ID: 2, Name: "LAX", Boundary: json.RawMessage(`{"type": "Polygon",
+			"coordinates": [[[-118.45, 33.90], [-118.35, 33.90], [-118.35, 33.98], [-118.45, 33.98], [-118.45, 33.90]]]}`)},
+	}
+
+	zone, err := zoneContaining(zones, geo.Point{Lat: 33.9416, Lng: -118.4085})
+	if err != nil || zone == nil || zone.ID != 2 {
+		t.Errorf("Expected LAX zone, got %+v (%v)", zone, err)
+	}
+	zone, err = zoneContaining(zones, geo.Point{Lat: 40.7, Lng: -74})
+	if err != nil || zone != nil {
+		t.Errorf("Expected no zone, got %+v (%v)", zone, err)
+	}
+
+	zones[0].Boundary = json.RawMessage(`{"type": "Point"}`)
+	if _, err := zoneContaining(zones, geo.Point{Lat: 33.9416, Lng: -118.4085}); err == nil {
+		t.Error("Expected error for invalid stored boundary")
+	}
+}
+
+func TestTagZone(t *testing.T) {
+	zones := []*data.Zone{{ID: 2, Name: "LAX", Boundary: json.RawMessage(`{"type": "Polygon",
+		"coordinates": [[[-118.45, 33.90], [-118.35, 33.90], [-118.35, 33.98], [-118.45, 33.98], [-118.45, 33.90]]]}`)}}
+	geocoder := stubGeocoder{"LAX": {Lat: 33.9416, Lng: -118.4085}, "JFK": {Lat: 40.6413, Lng: -73.7781}}
+	ctx := context.Background()
+
+	ride := &data.BookRide{PickupLocation: data.Location{Address: "LAX"}}
+	if err := tagZone(ctx, geocoder, zones, ride); err != nil || ride.ZoneID == nil || *ride.ZoneID != 2 {
+		t.Errorf("Expected the LAX zone, got %v (%v)", ride.ZoneID, err)
+	}
+	if err := tagZone(ctx, geocoder, zones, &data.BookRide{PickupLocation: data.Location{Address: "JFK"}}); err != errOutsideServiceArea {
+		t.Errorf("Expected errOutsideServiceArea, got %v", err)
+	}
+	if err := tagZone(ctx, geocoder, nil, &data.BookRide{PickupLocation: data.Location{Address: "nowhere"}}); err != nil {
+		t.Errorf("Expected any pickup to be accepted without active zones, got %v", err)
+	}
+
+	ride = &data.BookRide{PickupLocation: data.Location{Address: "nowhere"}}
+	if err := tagZone(ctx, geocoder, zones, ride); err != errPickupNotLocated || ride.ZoneID != nil {
+		t.Errorf("Expected errPickupNotLocated for an unknown address, got %v", err)
+	}
+	for name, g := range map[string]geo.Geocoder{"no geocoder": nil, "geocoder down": geocoder} {
+		ride := &data.BookRide{PickupLocation: data.Location{Address: "down"}}
+		err := tagZone(ctx, g, zones, ride)
+		if !errors.Is(err, errGeocoderUnavailable) || ride.ZoneID != nil {
+			t.Errorf("%s: expected errGeocoderUnavailable, got %v", name, err)
+		}
+		w := httptest.NewRecorder()
+		respondZoneError(w, err)
+		if w.Code != http.StatusServiceUnavailable {
+			t.Errorf("%s: expected 503, got %d", name, w.Code)
+		}
+	}
+}
+
+func TestZoneRequestValidation(t *testing.T) {
+	square := json.RawMessage(`{"type": "Polygon",
+		"coordinates": [[[-118.30, 34.00], [-118.20, 34.00], [-118.20, 34.10], [-118.30, 34.00]]]}`)
+	inactive := false
+	for _, tc := range []struct {
+		name    string
+		req     zoneRequest
+		wantErr bool
+	}{
+		{"valid", zoneRequest{Name: "Downtown", Boundary: square}, false},
+		{"inactive", zoneRequest{Name: "Downtown", Boundary: square, Active: &inactive}, false},
+		{"missing name", zoneRequest{Name: "  ", Boundary: square}, true},
+		{"missing boundary", zoneRequest{Name: "Downtown"}, true},
+		{"bad boundary", zoneRequest{Name: "Downtown", Boundary: json.RawMessage(`{"type": "Point"}`)}, true},
+	} {
+		zone, err := tc.req.toModel()
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: expected error %v, got %v", tc.name, tc.wantErr, err)
+			continue
+		}
+		if err == nil && zone.Active != (tc.req.Active == nil) {
+			t.Errorf("%s: unexpected active flag %v", tc.name, zone.Active)
+		}
+	}
+}
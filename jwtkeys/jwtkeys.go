@@ -0,0 +1,224 @@
+// Package jwtkeys manages the keys used to sign and verify access tokens.
+//
+// A KeySet holds one signing key and any number of verification-only keys,
+// each identified by a kid that is written into the JWT header. Rotating keys
+// is done by adding the new key, switching JWT_SIGNING_KEY_ID to it, and
+// removing the old key once every token it signed has expired.
+package jwtkeys
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"math/big"
+	"os"
+	"strings"
+)
+
+// LegacyHMACKeyID is the kid given to the JWT_SECRET key. Tokens without a kid
+// header are verified against it so tokens issued before rotation support
+// keep working.
+const LegacyHMACKeyID = "hs256-legacy"
+
+// Key is a single signing or verification key.
+type Key struct {
+	ID     string
+	Method jwt.SigningMethod
+	// signKey is nil for verification-only keys.
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// CanSign reports whether the key holds private material.
+func (k *Key) CanSign() bool {
+	return k.signKey != nil
+}
+
+// HMACKey returns an HS256 key for a shared secret.
+func HMACKey(id string, secret []byte) *Key {
+	return &Key{ID: id, Method: jwt.SigningMethodHS256, signKey: secret, verifyKey: secret}
+}
+
+// ParsePEM parses a PEM encoded Ed25519 or RSA key. Private keys (PKCS#8 or
+// PKCS#1) can sign and verify; public keys (PKIX) can only verify.
+func ParsePEM(id string, pemBytes []byte) (*Key, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, fmt.Errorf("key %s: no PEM block found", id)
+	}
+
+	var parsed interface{}
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("key %s: unsupported PEM block type %q", id, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("key %s: %w", id, err)
+	}
+
+	switch k := parsed.(type) {
+	case ed25519.PrivateKey:
+		return &Key{ID: id, Method: jwt.SigningMethodEdDSA, signKey: k, verifyKey: k.Public()}, nil
+	case ed25519.PublicKey:
+		return &Key{ID: id, Method: jwt.SigningMethodEdDSA, verifyKey: k}, nil
+	case *rsa.PrivateKey:
+		return &Key{ID: id, Method: jwt.SigningMethodRS256, signKey: k, verifyKey: &k.PublicKey}, nil
+	case *rsa.PublicKey:
+		return &Key{ID: id, Method: jwt.SigningMethodRS256, verifyKey: k}, nil
+	}
+	return nil, fmt.Errorf("key %s: unsupported key type %T", id, parsed)
+}
+
+// KeySet signs tokens with one key and verifies them against all of its keys.
+type KeySet struct {
+	signing *Key
+	keys    map[string]*Key
+	order   []string
+}
+
+// NewKeySet builds a KeySet that signs with the key identified by signingID.
+func NewKeySet(signingID string, keys ...*Key) (*KeySet, error) {
+	ks := &KeySet{keys: make(map[string]*Key, len(keys))}
+	for _, k := range keys {
+		if _, dup := ks.keys[k.ID]; dup {
+			return nil, fmt.Errorf("duplicate key ID %q", k.ID)
+		}
+		ks.keys[k.ID] = k
+		ks.order = append(ks.order, k.ID)
+	}
+	signing, ok := ks.keys[signingID]
+	if !ok {
+		return nil, fmt.Errorf("signing key %q not found", signingID)
+	}
+	if !signing.CanSign() {
+		return nil, fmt.Errorf("signing key %q has no private key", signingID)
+	}
+	ks.signing = signing
+	return ks, nil
+}
+
+// LoadFromEnv builds the KeySet from the environment:
+//
+//	JWT_KEYS            comma-separated kid=path/to/key.pem pairs
+//	JWT_SIGNING_KEY_ID  kid of the key used to sign new tokens
+//	JWT_SECRET          optional legacy HS256 secret (kid hs256-legacy)
+//
+// With only JWT_SECRET set, tokens are signed with HS256 as before.
+func LoadFromEnv() (*KeySet, error) {
+	var keys []*Key
+	if secret := os.Getenv("JWT_SECRET"); secret != "" {
+		keys = append(keys, HMACKey(LegacyHMACKeyID, []byte(secret)))
+	}
+
+	if spec := strings.TrimSpace(os.Getenv("JWT_KEYS")); spec != "" {
+		for _, entry := range strings.Split(spec, ",") {
+			id, path, ok := strings.Cut(strings.TrimSpace(entry), "=")
+			if !ok || id == "" || path == "" {
+				return nil, fmt.Errorf("invalid JWT_KEYS entry %q: expected kid=path", entry)
+			}
+			pemBytes, err := os.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read key %s: %w", id, err)
+			}
+			key, err := ParsePEM(id, pemBytes)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, key)
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("no JWT keys configured: set JWT_KEYS or JWT_SECRET")
+	}
+	signingID := os.Getenv("JWT_SIGNING_KEY_ID")
+	if signingID == "" {
+		if len(keys) != 1 {
+			return nil, errors.New("JWT_SIGNING_KEY_ID must be set when more than one key is configured")
+		}
+		signingID = keys[0].ID
+	}
+	return NewKeySet(signingID, keys...)
+}
+
+// Sign returns a token for claims signed by the current signing key, with its
+// kid in the header.
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(ks.signing.Method, claims)
+	token.Header["kid"] = ks.signing.ID
+	return token.SignedString(ks.signing.signKey)
+}
+
+// Keyfunc resolves the verification key for token by its kid, rejecting
+// tokens whose algorithm does not match the key's.
+func (ks *KeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		kid = LegacyHMACKeyID
+	}
+	key, ok := ks.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key ID %q", kid)
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %s for key %q", token.Method.Alg(), kid)
+	}
+	return key.verifyKey, nil
+}
+
+// Parse parses and verifies tokenStr against the key set.
+func (ks *KeySet) Parse(tokenStr string) (*jwt.Token, error) {
+	return jwt.Parse(tokenStr, ks.Keyfunc, jwt.WithValidMethods([]string{"HS256", "RS256", "EdDSA"}))
+}
+
+// JWK is a single JSON Web Key (RFC 7517).
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set document.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public halves of all asymmetric keys. HMAC keys are shared
+// secrets and are never published.
+func (ks *KeySet) JWKS() JWKS {
+	doc := JWKS{Keys: []JWK{}}
+	for _, id := range ks.order {
+		if jwk, ok := publicJWK(ks.keys[id]); ok {
+			doc.Keys = append(doc.Keys, jwk)
+		}
+	}
+	return doc
+}
+
+func publicJWK(key *Key) (JWK, bool) {
+	b64 := base64.RawURLEncoding.EncodeToString
+	switch k := key.verifyKey.(type) {
+	case ed25519.PublicKey:
+		return JWK{Kty: "OKP", Kid: key.ID, Alg: key.Method.Alg(), Use: "sig", Crv: "Ed25519", X: b64(k)}, true
+	case *rsa.PublicKey:
+		return JWK{Kty: "RSA", Kid: key.ID, Alg: key.Method.Alg(), Use: "sig", N: b64(k.N.Bytes()), E: b64(big.NewInt(int64(k.E)).Bytes())}, true
+	}
+	return JWK{}, false
+}
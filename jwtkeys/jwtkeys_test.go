@@ -0,0 +1,158 @@
+package jwtkeys
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/golang-jwt/jwt/v5"
+	"testing"
+	"time"
+)
+
+func ed25519PEM(t *testing.T) ([]byte, []byte) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate Ed25519 key: %v", err)
+	}
+	privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatalf("Failed to marshal private key: %v", err)
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatalf("Failed to marshal public key: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER}),
+		pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER})
+}
+
+func rsaPEM(t *testing.T) []byte {
+	t.Helper()
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(priv)})
+}
+
+func mustParse(t *testing.T, id string, pemBytes []byte) *Key {
+	t.Helper()
+	key, err := ParsePEM(id, pemBytes)
+	if err != nil {
+		t.Fatalf("Failed to parse key %s: %v", id, err)
+	}
+	return key
+}
+
+func claims() jwt.MapClaims {
+	return jwt.MapClaims{"id": 1, "exp": time.Now().Add(time.Minute).Unix()}
+}
+
+func TestRotationWindow(t *testing.T) {
+	oldPriv, _ := ed25519PEM(t)
+	oldKey := mustParse(t, "2025-01", oldPriv)
+	newKey := mustParse(t, "2025-07", rsaPEM(t))
+
+	before, err := NewKeySet("2025-01", oldKey)
+	if err != nil {
+		t.Fatalf("Failed to create key set: %v", err)
+	}
+	oldToken, err := before.Sign(claims())
+	if err != nil {
+		t.Fatalf("Failed to sign: %v", err)
+	}
+
+	during, err := NewKeySet("2025-07", oldKey, newKey)
+	if err != nil {
+		t.Fatalf("Failed to create key set: %v", err)
+	}
+	newToken, err := during.Sign(claims())
+	if err != nil {
+		t.Fatalf("Failed to sign: %v", err)
+	}
+	for name, tok := range map[string]string{"old": oldToken, "new": newToken} {
+		if _, err := during.Parse(tok); err != nil {
+			t.Errorf("Expected %s token to verify during rotation, got %v", name, err)
+		}
+	}
+
+	parsed, _, err := jwt.NewParser().ParseUnverified(newToken, jwt.MapClaims{})
+	if err != nil {
+		t.Fatalf("Failed to parse token header: %v", err)
+	}
+	if parsed.Header["kid"] != "2025-07" || parsed.Header["alg"] != "RS256" {
+		t.Errorf("Expected kid 2025-07 and alg RS256, got %v", parsed.Header)
+	}
+
+	after, err := NewKeySet("2025-07", newKey)
+	if err != nil {
+		t.Fatalf("Failed to create key set: %v", err)
+	}
+	if _, err := after.Parse(oldToken); err == nil {
+		t.Errorf("Expected token signed by a removed key to be rejected")
+	}
+}
+
+func TestRejectsAlgorithmMismatch(t *testing.T) {
+	_, pubPEM := ed25519PEM(t)
+	pubKey := mustParse(t, "ed", pubPEM)
+	hmac := HMACKey(LegacyHMACKeyID, []byte("secret"))
+	ks, err := NewKeySet(LegacyHMACKeyID, hmac, pubKey)
+	if err != nil {
+		t.Fatalf("Failed to create key set: %v", err)
+	}
+
+	// An HS256 token that claims the Ed25519 kid must not be verified with the
+	// public key bytes as an HMAC secret.
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, claims())
+	forged.Header["kid"] = "ed"
+	tok, err := forged.SignedString([]byte(pubKey.verifyKey.(ed25519.PublicKey)))
+	if err != nil {
+		t.Fatalf("Failed to sign: %v", err)
+	}
+	if _, err := ks.Parse(tok); err == nil {
+		t.Errorf("Expected algorithm mismatch to be rejected")
+	}
+
+	// Legacy tokens without a kid still verify against JWT_SECRET.
+	legacy, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims()).SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatalf("Failed to sign: %v", err)
+	}
+	if _, err := ks.Parse(legacy); err != nil {
+		t.Errorf("Expected legacy token to verify, got %v", err)
+	}
+}
+
+func TestJWKSPublishesOnlyPublicKeys(t *testing.T) {
+	edPriv, _ := ed25519PEM(t)
+	ks, err := NewKeySet("ed",
+		HMACKey(LegacyHMACKeyID, []byte("secret")),
+		mustParse(t, "ed", edPriv),
+		mustParse(t, "rsa", rsaPEM(t)),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create key set: %v", err)
+	}
+
+	doc := ks.JWKS()
+	if len(doc.Keys) != 2 {
+		t.Fatalf("Expected 2 published keys, got %d", len(doc.Keys))
+	}
+	if k := doc.Keys[0]; k.Kid != "ed" || k.Kty != "OKP" || k.Crv != "Ed25519" || k.X == "" {
+		t.Errorf("Unexpected Ed25519 JWK: %+v", k)
+	}
+	if k := doc.Keys[1]; k.Kid != "rsa" || k.Kty != "RSA" || k.N == "" || k.E != "AQAB" {
+		t.Errorf("Unexpected RSA JWK: %+v", k)
+	}
+}
+
+func TestNewKeySetRequiresPrivateSigningKey(t *testing.T) {
+	_, pubPEM := ed25519PEM(t)
+	if _, err := NewKeySet("ed", mustParse(t, "ed", pubPEM)); err == nil {
+		t.Errorf("Expected error when signing key has no private key")
+	}
+}
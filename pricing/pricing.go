@@ -0,0 +1,184 @@
+// Package pricing calculates what rides cost: the quote a booking is made
+// at and the final fare once its trip is over.
+//
+// Hourly rides are quoted for the booked hours, at least the scheduling
+// minimum. Their final fare charges the time actually used, from the
+// scheduled pickup or the driver's later arrival to the drop-off, rounded
+// up to the configured step but never below the booked hours. Per_ride
+// trips are quoted a base fare plus the route estimate's distance, topped
+// up to the minimum fare, and keep that price, adding only what the driver
+// waited at the pickup beyond the free waiting time. Either way the final
+// fare includes the add-on charges, such as tolls, an admin approved.
+package pricing
+
+import (
+	"errors"
+	"fmt"
+	"luxsuv-backend/config"
+	"luxsuv-backend/data"
+	"luxsuv-backend/schedule"
+	"math"
+	"time"
+)
+
+// ErrTripNotOver is returned when the final fare of a trip that has not
+// ended is asked for.
+var ErrTripNotOver = errors.New("trip has not ended")
+
+// Pricer calculates fares under one configuration.
+type Pricer struct {
+	cfg         config.PricingConfig
+	sched       *schedule.Scheduler
+	rounding    time.Duration
+	freeWaiting time.Duration
+}
+
+// New returns a Pricer for cfg, which must have been validated by
+// config.Load.
+func New(cfg config.PricingConfig, sched *schedule.Scheduler) (*Pricer, error) {
+	rounding, err := time.ParseDuration(cfg.Rounding)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pricing rounding: %w", err)
+	}
+	freeWaiting, err := time.ParseDuration(cfg.FreeWaiting)
+	if err != nil {
+		return nil, fmt.Errorf("invalid free waiting time: %w", err)
+	}
+	return &Pricer{cfg: cfg, sched: sched, rounding: rounding, freeWaiting: freeWaiting}, nil
+}
+
+// Quote returns what a booking is expected to cost.
+func (p *Pricer) Quote(ride *data.BookRide) data.Fare {
+	fare := data.Fare{Currency: p.cfg.Currency, Lines: []data.FareLine{}}
+	if ride.RideType == "hourly" {
+		booked := time.Duration(p.bookedHours(ride)) * time.Hour
+		fare.Add(data.FareHourly, fmt.Sprintf("%s booked at %s per hour", formatDuration(booked), p.money(p.cfg.HourlyRateCents)),
+			p.hourly(booked))
+		return fare
+	}
+
+	fare.Add(data.FareBase, "Base fare", p.cfg.BaseFareCents)
+	if ride.RouteEstimate != nil {
+		km := float64(ride.RouteEstimate.DistanceMeters) / 1000
+		fare.Add(data.FareDistance, fmt.Sprintf("%.1f km at %s per km", km, p.money(p.cfg.PerKmCents)),
+			int64(math.Round(km*float64(p.cfg.PerKmCents))))
+	}
+	if fare.TotalCents < p.cfg.MinimumFareCents {
+		fare.Add(data.FareMinimum, fmt.Sprintf("Minimum fare of %s", p.money(p.cfg.MinimumFareCents)),
+			p.cfg.MinimumFareCents-fare.TotalCents)
+	}
+	return fare
+}
+
+// chargeLabels describe charges the driver gave no description for.
+var chargeLabels = map[string]string{
+	data.ChargeToll:      "Toll",
+	data.ChargeParking:   "Parking",
+	data.ChargeExtraStop: "Extra stop",
+	data.ChargeCleaning:  "Cleaning fee",
+	data.ChargeGratuity:  "Gratuity",
+}
+
+// Final returns what a completed trip costs, with a line for each approved
+// charge; the others are left out. It returns ErrTripNotOver if the trip
+// has not started and ended.
+func (p *Pricer) Final(ride *data.BookRide, trip *data.Trip, charges []*data.TripCharge) (data.Fare, error) {
+	fare, err := p.usage(ride, trip)
+	if err != nil {
+		return data.Fare{}, err
+	}
+	for _, c := range charges {
+		if c.Status != data.ChargeApproved {
+			continue
+		}
+		desc := c.Description
+		if desc == "" {
+			desc = chargeLabels[c.Kind]
+		}
+		fare.Add(c.Kind, desc, c.AmountCents)
+	}
+	return fare, nil
+}
+
+// usage returns the fare for the time, distance and waiting of a trip.
+func (p *Pricer) usage(ride *data.BookRide, trip *data.Trip) (data.Fare, error) {
+	if trip.StartedAt == nil || trip.EndedAt == nil {
+		return data.Fare{}, ErrTripNotOver
+	}
+	// A legacy booking may have a pickup the scheduler cannot read; the
+	// driver's arrival, or the start of the ride, stands in for it.
+	pickup, err := p.sched.Pickup(ride)
+	if errors.Is(err, schedule.ErrInvalidPickupTime) {
+		pickup = *trip.StartedAt
+		if trip.ArrivedAt != nil {
+			pickup = *trip.ArrivedAt
+		}
+	} else if err != nil {
+		return data.Fare{}, err
+	}
+
+	if ride.RideType == "hourly" {
+		// The clock starts at the scheduled pickup, or when the driver got
+		// there if they were late; without an arrival, when the ride started.
+		from := trip.StartedAt
+		if trip.ArrivedAt != nil {
+			from = trip.ArrivedAt
+		}
+		start := pickup
+		if from.After(pickup) {
+			start = *from
+		}
+		used := max(trip.EndedAt.Sub(start), 0)
+		billed := (used + p.rounding - 1) / p.rounding * p.rounding
+		booked := time.Duration(p.bookedHours(ride)) * time.Hour
+		desc := fmt.Sprintf("%s used at %s per hour", formatDuration(billed), p.money(p.cfg.HourlyRateCents))
+		if billed <= booked {
+			billed = booked
+			desc = fmt.Sprintf("%s booked at %s per hour (%s used)", formatDuration(booked),
+				p.money(p.cfg.HourlyRateCents), formatDuration(used.Round(time.Minute)))
+		}
+		fare := data.Fare{Currency: p.cfg.Currency, Lines: []data.FareLine{}}
+		fare.Add(data.FareHourly, desc, p.hourly(billed))
+		return fare, nil
+	}
+
+	fare := p.Quote(ride)
+	if trip.ArrivedAt != nil {
+		waitFrom := pickup
+		if trip.ArrivedAt.After(pickup) {
+			waitFrom = *trip.ArrivedAt
+		}
+		if waited := trip.StartedAt.Sub(waitFrom) - p.freeWaiting; waited > 0 {
+			minutes := int64((waited + time.Minute - 1) / time.Minute)
+			fare.Add(data.FareWaiting, fmt.Sprintf("%d min waiting at %s per minute", minutes, p.money(p.cfg.WaitingPerMinuteCents)),
+				minutes*p.cfg.WaitingPerMinuteCents)
+		}
+	}
+	return fare, nil
+}
+
+func (p *Pricer) bookedHours(ride *data.BookRide) int {
+	return max(ride.Hours, p.sched.MinimumHours())
+}
+
+// hourly returns the charge for d at the hourly rate, to the nearest cent.
+func (p *Pricer) hourly(d time.Duration) int64 {
+	return int64(math.Round(d.Hours() * float64(p.cfg.HourlyRateCents)))
+}
+
+// money formats an amount in cents, e.g. "125.00 USD".
+func (p *Pricer) money(cents int64) string {
+	return fmt.Sprintf("%d.%02d %s", cents/100, cents%100, p.cfg.Currency)
+}
+
+// formatDuration formats d in hours and minutes, e.g. "2h 15m".
+func formatDuration(d time.Duration) string {
+	h, m := int(d/time.Hour), int(d%time.Hour/time.Minute)
+	switch {
+	case m == 0:
+		return fmt.Sprintf("%dh", h)
+	case h == 0:
+		return fmt.Sprintf("%dm", m)
+	}
+	return fmt.Sprintf("%dh %dm", h, m)
+}
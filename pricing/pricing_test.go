@@ -0,0 +1,165 @@
+package pricing
+
+import (
+	"luxsuv-backend/config"
+	"luxsuv-backend/data"
+	"luxsuv-backend/schedule"
+	"testing"
+	"time"
+)
+
+func newTestPricer(t *testing.T) (*Pricer, *time.Location) {
+	t.Helper()
+	cfg := config.Defaults()
+	sched, err := schedule.New(cfg.Scheduling)
+	if err != nil {
+		t.Fatalf("schedule.New failed: %v", err)
+	}
+	p, err := New(cfg.Pricing, sched)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	return p, sched.Location()
+}
+
+func at(loc *time.Location, clock string) *time.Time {
+	t, err := schedule.ParsePickup("2026-03-01", clock, loc)
+	if err != nil {
+		panic(err)
+	}
+	return &t
+}
+
+func TestQuote(t *testing.T) {
+	p, _ := newTestPricer(t)
+	for name, tc := range map[string]struct {
+		ride  data.BookRide
+		total int64
+		kinds []string
+	}{
+		"hourly":            {data.BookRide{RideType: "hourly", Hours: 3}, 37500, []string{data.FareHourly}},
+		"hourly minimum":    {data.BookRide{RideType: "hourly", Hours: 1}, 25000, []string{data.FareHourly}},
+		"per_ride":          {data.BookRide{RideType: "per_ride", RouteEstimate: &data.RouteEstimate{DistanceMeters: 20000}}, 10000, []string{data.FareBase, data.FareDistance}},
+		"per_ride minimum":  {data.BookRide{RideType: "per_ride", RouteEstimate: &data.RouteEstimate{DistanceMeters: 4000}}, 8500, []string{data.FareBase, data.FareDistance, data.FareMinimum}},
+		"per_ride no route": {data.BookRide{RideType: "per_ride"}, 8500, []string{data.FareBase, data.FareMinimum}},
+	} {
+		fare := p.Quote(&tc.ride)
+		if fare.TotalCents != tc.total || fare.Currency != "USD" {
+			t.Errorf("%s: expected %d USD, got %d %s", name, tc.total, fare.TotalCents, fare.Currency)
+		}
+		if len(fare.Lines) != len(tc.kinds) {
+			t.Errorf("%s: expected lines %v, got %+v", name, tc.kinds, fare.Lines)
+			continue
+		}
+		for i, kind := range tc.kinds {
+			if fare.Lines[i].Kind != kind {
+				t.Errorf("%s: expected line %d to be %s, got %+v", name, i, kind, fare.Lines[i])
+			}
+		}
+	}
+}
+
+func TestFinalHourly(t *testing.T) {
+	p, loc := newTestPricer(t)
+	ride := &data.BookRide{RideType: "hourly", Date: "2026-03-01", Time: "10:00", Hours: 2}
+	for name, tc := range map[string]struct {
+		trip  data.Trip
+		total int64
+	}{
+		// Early arrivals and short rides are charged the booked hours.
+		"within booking": {data.Trip{ArrivedAt: at(loc, "09:50"), StartedAt: at(loc, "10:05"), EndedAt: at(loc, "11:40")}, 25000},
+		// 2h 20m from pickup is rounded up to 2h 30m.
+		"overtime": {data.Trip{ArrivedAt: at(loc, "09:55"), StartedAt: at(loc, "10:00"), EndedAt: at(loc, "12:20")}, 31250},
+		// A late driver's clock starts when they arrive: 2h 10m, billed 2h 15m.
+		"late driver": {data.Trip{ArrivedAt: at(loc, "10:30"), StartedAt: at(loc, "10:35"), EndedAt: at(loc, "12:40")}, 28125},
+		// Without an arrival the clock starts when the ride does.
+		"no arrival": {data.Trip{StartedAt: at(loc, "10:30"), EndedAt: at(loc, "12:31")}, 28125},
+	} {
+		fare, err := p.Final(ride, &tc.trip, nil)
+		if err != nil {
+			t.Errorf("%s: Final failed: %v", name, err)
+			continue
+		}
+		if fare.TotalCents != tc.total || len(fare.Lines) != 1 || fare.Lines[0].Kind != data.FareHourly {
+			t.Errorf("%s: expected %d on one hourly line, got %+v", name, tc.total, fare)
+		}
+	}
+
+	if _, err := p.Final(ride, &data.Trip{StartedAt: at(loc, "10:00")}, nil); err != ErrTripNotOver {
+		t.Errorf("Expected ErrTripNotOver, got %v", err)
+	}
+}
+
+func TestFinalPerRide(t *testing.T) {
+	p, loc := newTestPricer(t)
+	ride := &data.BookRide{RideType: "per_ride", Date: "2026-03-01", Time: "10:00",
+		RouteEstimate: &data.RouteEstimate{DistanceMeters: 20000}}
+
+	// Waiting from the pickup, not the early arrival: 22m 30s less 15m free
+	// is 8 minutes.
+	trip := data.Trip{ArrivedAt: at(loc, "09:45"), StartedAt: at(loc, "10:22:30"), EndedAt: at(loc, "10:50")}
+	fare, err := p.Final(ride, &trip, nil)
+	if err != nil {
+		t.Fatalf("Final failed: %v", err)
+	}
+	last := fare.Lines[len(fare.Lines)-1]
+	if fare.TotalCents != 10000+8*150 || last.Kind != data.FareWaiting || last.AmountCents != 8*150 {
+		t.Errorf("Expected the quote plus 8 minutes waiting, got %+v", fare)
+	}
+
+	trip.StartedAt = at(loc, "10:10")
+	if fare, err := p.Final(ride, &trip, nil); err != nil || fare.TotalCents != 10000 {
+		t.Errorf("Expected the quote within the free waiting time, got %+v, %v", fare, err)
+	}
+}
+
+func TestFinalLegacyPickup(t *testing.T) {
+	p, loc := newTestPricer(t)
+
+	// The clock starts at the arrival: 2h 20m, billed 2h 30m.
+	hourly := &data.BookRide{RideType: "hourly", Date: "June 24", Time: "afternoon", Hours: 2}
+	trip := data.Trip{ArrivedAt: at(loc, "10:00"), StartedAt: at(loc, "10:05"), EndedAt: at(loc, "12:20")}
+	if fare, err := p.Final(hourly, &trip, nil); err != nil || fare.TotalCents != 31250 {
+		t.Errorf("Expected an hourly fare from the arrival, got %+v, %v", fare, err)
+	}
+
+	// Waiting is counted from the arrival: 22m 30s less 15m free is 8
+	// minutes.
+	perRide := &data.BookRide{RideType: "per_ride", Date: "June 24", Time: "afternoon",
+		RouteEstimate: &data.RouteEstimate{DistanceMeters: 20000}}
+	trip = data.Trip{ArrivedAt: at(loc, "10:00"), StartedAt: at(loc, "10:22:30"), EndedAt: at(loc, "10:50")}
+	if fare, err := p.Final(perRide, &trip, nil); err != nil || fare.TotalCents != 10000+8*150 {
+		t.Errorf("Expected the quote plus 8 minutes waiting, got %+v, %v", fare, err)
+	}
+
+	// Without an arrival there is no waiting to charge.
+	trip.ArrivedAt = nil
+	if fare, err := p.Final(perRide, &trip, nil); err != nil || fare.TotalCents != 10000 {
+		t.Errorf("Expected the quote without an arrival, got %+v, %v", fare, err)
+	}
+}
+
+func TestFinalCharges(t *testing.T) {
+	p, loc := newTestPricer(t)
+	ride := &data.BookRide{RideType: "hourly", Date: "2026-03-01", Time: "10:00", Hours: 2}
+	trip := &data.Trip{StartedAt: at(loc, "10:00"), EndedAt: at(loc, "11:30")}
+	charges := []*data.TripCharge{
+		{Kind: data.ChargeToll, Description: "I-405 Express Lanes", AmountCents: 725, Status: data.ChargeApproved},
+		{Kind: data.ChargeParking, AmountCents: 1800, Status: data.ChargeApproved},
+		{Kind: data.ChargeCleaning, AmountCents: 15000, Status: data.ChargePending},
+		{Kind: data.ChargeGratuity, AmountCents: 5000, Status: data.ChargeRejected},
+	}
+	fare, err := p.Final(ride, trip, charges)
+	if err != nil {
+		t.Fatalf("Final failed: %v", err)
+	}
+	if fare.TotalCents != 25000+725+1800 || len(fare.Lines) != 3 {
+		t.Fatalf("Expected the approved charges on top of the booked hours, got %+v", fare)
+	}
+	if l := fare.Lines[1]; l.Kind != data.ChargeToll || l.Description != "I-405 Express Lanes" {
+		t.Errorf("Expected the toll with the driver's description, got %+v", l)
+	}
+	if l := fare.Lines[2]; l.Kind != data.ChargeParking || l.Description != "Parking" {
+		t.Errorf("Expected parking with the default description, got %+v", l)
+	}
+}
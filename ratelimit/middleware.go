@@ -0,0 +1,142 @@
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// KeyFunc extracts the value a rule limits on from a request. Returning ""
+// exempts the request from that rule.
+type KeyFunc func(r *http.Request) string
+
+// Rule limits requests that share the same Key value.
+type Rule struct {
+	Name  string // e.g. "ip" or "email"; part of the bucket key
+	Limit Limit
+	Key   KeyFunc
+}
+
+// Middleware enforces every rule for route, answering 429 when any bucket is
+// empty. RateLimit-* headers describe the most restrictive bucket. If the
+// store fails the request is let through, so an outage of the rate limit
+// backend does not take bookings down with it.
+func Middleware(store Store, route string, rules ...Rule) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var tightest *Result
+			for _, rule := range rules {
+				value := rule.Key(r)
+				if value == "" {
+					continue
+				}
+				res, err := store.Take(r.Context(), route+":"+rule.Name+":"+value, rule.Limit)
+				if err != nil {
+					log.Printf("Rate limiter unavailable for %s: %v", route, err)
+					continue
+				}
+				if tightest == nil || moreRestrictive(res, *tightest) {
+					tightest = &res
+				}
+			}
+
+			if tightest != nil {
+				setHeaders(w, *tightest)
+				if !tightest.Allowed {
+					w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(tightest.RetryAfter.Seconds())))
+					w.Header().Set("Content-Type", "application/json")
+					w.WriteHeader(http.StatusTooManyRequests)
+					json.NewEncoder(w).Encode(map[string]string{"error": "rate limit exceeded, try again later"})
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func moreRestrictive(a, b Result) bool {
+	if a.Allowed != b.Allowed {
+		return !a.Allowed
+	}
+	return a.Remaining < b.Remaining
+}
+
+func setHeaders(w http.ResponseWriter, res Result) {
+	h := w.Header()
+	h.Set("RateLimit-Limit", strconv.Itoa(res.Limit.Burst))
+	h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset.Seconds())))
+	h.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", res.Limit.Burst, ceilSeconds(res.Limit.Period.Seconds())))
+}
+
+func ceilSeconds(s float64) int {
+	return int(math.Ceil(s))
+}
+
+type clientIPKey struct{}
+
+// TrustFlyClientIP makes ClientIP use the Fly-Client-IP header, which the
+// Fly.io proxy sets to the real client address. Only use it behind that
+// proxy; anywhere else clients can set the header themselves.
+func TrustFlyClientIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ip := r.Header.Get("Fly-Client-IP"); ip != "" {
+			r = r.WithContext(context.WithValue(r.Context(), clientIPKey{}, ip))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ClientIP returns the address of the client that made r: the Fly-Client-IP
+// header if TrustFlyClientIP accepted it, otherwise the connection's peer.
+func ClientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// maxEmailBodyBytes bounds how much of a request body Email will buffer.
+const maxEmailBodyBytes = 1 << 20
+
+// Email returns the normalized email address from the "email" query parameter
+// or, failing that, the "email" field of a JSON request body. The body is
+// restored so the handler can still read it.
+func Email(r *http.Request) string {
+	if email := r.URL.Query().Get("email"); email != "" {
+		return normalizeEmail(email)
+	}
+	if r.Body == nil {
+		return ""
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxEmailBodyBytes))
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return ""
+	}
+	var payload struct {
+		Email string `json:"email"`
+	}
+	if json.Unmarshal(body, &payload) != nil {
+		return ""
+	}
+	return normalizeEmail(payload.Email)
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
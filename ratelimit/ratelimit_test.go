@@ -0,0 +1,112 @@
+package ratelimit
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseLimit(t *testing.T) {
+	l, err := ParseLimit("10/1h")
+	if err != nil {
+		t.Fatalf("ParseLimit failed: %v", err)
+	}
+	if l.Burst != 10 || l.Period != time.Hour {
+		t.Errorf("Expected 10/1h, got %+v", l)
+	}
+	for _, bad := range []string{"10", "0/1m", "x/1m", "5/soon", "5/-1m"} {
+		if _, err := ParseLimit(bad); err == nil {
+			t.Errorf("Expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestMemoryStoreRefills(t *testing.T) {
+	now := time.Unix(0, 0)
+	store := NewMemoryStore()
+	store.now = func() time.Time { return now }
+	limit := Limit{Burst: 2, Period: time.Minute}
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if res, _ := store.Take(ctx, "k", limit); !res.Allowed {
+			t.Fatalf("Expected take %d to be allowed", i+1)
+		}
+	}
+	res, _ := store.Take(ctx, "k", limit)
+	if res.Allowed {
+		t.Fatalf("Expected third take to be denied")
+	}
+	if res.RetryAfter != 30*time.Second {
+		t.Errorf("Expected RetryAfter 30s, got %v", res.RetryAfter)
+	}
+
+	now = now.Add(30 * time.Second)
+	if res, _ := store.Take(ctx, "k", limit); !res.Allowed {
+		t.Errorf("Expected a token to have refilled after 30s")
+	}
+	if res, _ := store.Take(ctx, "other", limit); !res.Allowed || res.Remaining != 1 {
+		t.Errorf("Expected separate bucket per key, got %+v", res)
+	}
+}
+
+func TestMiddlewareLimitsByEmailAndSetsHeaders(t *testing.T) {
+	store := NewMemoryStore()
+	handler := Middleware(store, "create",
+		Rule{Name: "ip", Limit: Limit{Burst: 100, Period: time.Hour}, Key: ClientIP},
+		Rule{Name: "email", Limit: Limit{Burst: 1, Period: time.Hour}, Key: Email},
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The body must still be readable after the limiter peeked at it.
+		body, _ := io.ReadAll(r.Body)
+		if !strings.Contains(string(body), "email") {
+			t.Errorf("Expected handler to see the original body, got %q", body)
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	send := func(email string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/book-ride", strings.NewReader(`{"email":"`+email+`"}`))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := send("Rider@Example.com"); w.Code != http.StatusCreated {
+		t.Fatalf("Expected first booking to pass, got %d", w.Code)
+	}
+	w := send("rider@example.com ")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected second booking for the same email to be limited, got %d", w.Code)
+	}
+	if w.Header().Get("RateLimit-Limit") != "1" || w.Header().Get("RateLimit-Remaining") != "0" || w.Header().Get("Retry-After") == "" {
+		t.Errorf("Unexpected rate limit headers: %v", w.Header())
+	}
+	if w := send("someone-else@example.com"); w.Code != http.StatusCreated {
+		t.Errorf("Expected a different email to pass, got %d", w.Code)
+	}
+}
+
+func TestClientIPTrustsFlyHeaderOnlyWhenEnabled(t *testing.T) {
+	r := httptest.NewRequest("POST", "/login", nil)
+	r.RemoteAddr = "10.0.0.1:4321"
+	if got := ClientIP(r); got != "10.0.0.1" {
+		t.Errorf("Expected 10.0.0.1, got %s", got)
+	}
+	r.Header.Set("Fly-Client-IP", "203.0.113.7")
+	if got := ClientIP(r); got != "10.0.0.1" {
+		t.Errorf("Expected the header to be ignored without TrustFlyClientIP, got %s", got)
+	}
+
+	var got string
+	TrustFlyClientIP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = ClientIP(r)
+	})).ServeHTTP(httptest.NewRecorder(), r)
+	if got != "203.0.113.7" {
+		t.Errorf("Expected 203.0.113.7 behind the proxy, got %s", got)
+	}
+}
@@ -0,0 +1,277 @@
+// Package realtime fans booking events out to the clients streaming them.
+// Every server listens for the events the database announces when a booking
+// changes, so a change made on one machine reaches clients connected to any
+// other. A client that reconnects resumes after the last event it saw; one
+// that falls behind is dropped rather than holding everyone else up, and
+// resumes the same way.
+//
+// The hub is published with expvar:
+//
+//	realtime_subscribers  current subscribers
+//	realtime_events       events received
+//	realtime_dropped      subscribers dropped for falling behind
+//	realtime_reconnects   times listening had to be restarted
+package realtime
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"luxsuv-backend/config"
+	"luxsuv-backend/data"
+	"luxsuv-backend/logger"
+	"sync"
+	"time"
+)
+
+var (
+	subscribers = expvar.NewInt("realtime_subscribers")
+	received    = expvar.NewInt("realtime_events")
+	dropped     = expvar.NewInt("realtime_dropped")
+	reconnects  = expvar.NewInt("realtime_reconnects")
+)
+
+const (
+	// bufferSize is how many events a subscriber can fall behind by.
+	bufferSize = 64
+	// replayPage is how many events are read at a time when catching up.
+	replayPage = 500
+	// MaxReplay is the most events Resume replays; a client further behind
+	// must reload what it shows.
+	MaxReplay = 1000
+	// maxBackoff bounds the wait before listening again after a failure.
+	maxBackoff = 30 * time.Second
+)
+
+// ErrTooFarBehind is returned by Resume when more than MaxReplay events
+// were missed.
+var ErrTooFarBehind = errors.New("too many events missed to replay")
+
+// Store is where booking events come from.
+type Store interface {
+	// ListenBookingEvents passes each event committed to handle until ctx is
+	// cancelled or it fails, calling listening once events are received.
+	ListenBookingEvents(ctx context.Context, listening func(), handle func(data.BookingEvent)) error
+	// ListBookingEvents returns up to limit events after afterID, oldest
+	// first; only those of one booking if bookingID is not 0.
+	ListBookingEvents(ctx context.Context, afterID, bookingID int64, limit int) ([]data.BookingEvent, error)
+	LastBookingEventID(ctx context.Context) (int64, error)
+}
+
+// Hub delivers booking events to subscribers.
+type Hub struct {
+	store     Store
+	logger    *logger.Logger
+	heartbeat time.Duration
+	// minBackoff is the first wait before listening again after a failure.
+	minBackoff time.Duration
+
+	mu   sync.Mutex
+	subs map[*Subscription]bool
+	// last is the latest event delivered, which listening catches up from
+	// after a failure; it is set when listening first starts.
+	last    int64
+	started bool
+	// caughtUp are the events the last catch-up delivered, which may also
+	// be announced.
+	caughtUp map[int64]bool
+	closed   bool
+}
+
+// Subscription receives the events of one booking, or of all bookings.
+type Subscription struct {
+	hub       *Hub
+	bookingID int64
+	events    chan data.BookingEvent
+}
+
+// New returns a Hub for cfg, which must have been validated by config.Load.
+func New(store Store, cfg config.RealtimeConfig, logger *logger.Logger) (*Hub, error) {
+	heartbeat, err := time.ParseDuration(cfg.Heartbeat)
+	if err != nil {
+		return nil, fmt.Errorf("invalid heartbeat: %w", err)
+	}
+	return &Hub{
+		store:      store,
+		logger:     logger,
+		heartbeat:  heartbeat,
+		minBackoff: time.Second,
+		subs:       map[*Subscription]bool{},
+	}, nil
+}
+
+// Heartbeat is how often an idle stream should show it is alive.
+func (h *Hub) Heartbeat() time.Duration {
+	return h.heartbeat
+}
+
+// Last returns the ID of the latest event delivered.
+func (h *Hub) Last() int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.last
+}
+
+// Run listens for events until ctx is cancelled, listening again with
+// backoff after a failure, and then ends every subscription.
+func (h *Hub) Run(ctx context.Context) {
+	defer h.closeAll()
+	backoff := h.minBackoff
+	for {
+		started := time.Now()
+		err := h.store.ListenBookingEvents(ctx, func() { h.catchUp(ctx) }, h.receive)
+		if ctx.Err() != nil {
+			return
+		}
+		reconnects.Add(1)
+		h.logger.Error(fmt.Sprintf("Realtime: %v; listening again in %s", err, backoff))
+		if time.Since(started) > maxBackoff {
+			backoff = h.minBackoff
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, maxBackoff)
+	}
+}
+
+// catchUp delivers the events committed while the hub was not listening.
+func (h *Hub) catchUp(ctx context.Context) {
+	h.mu.Lock()
+	last, started := h.last, h.started
+	h.caughtUp = map[int64]bool{}
+	h.mu.Unlock()
+
+	// Nobody can have subscribed to earlier events yet.
+	if !started {
+		id, err := h.store.LastBookingEventID(ctx)
+		if err != nil {
+			h.logger.Error(fmt.Sprintf("Realtime: %v", err))
+			return
+		}
+		h.mu.Lock()
+		h.last, h.started = max(h.last, id), true
+		h.mu.Unlock()
+		return
+	}
+	for {
+		events, err := h.store.ListBookingEvents(ctx, last, 0, replayPage)
+		if err != nil {
+			h.logger.Error(fmt.Sprintf("Realtime: failed to catch up: %v", err))
+			return
+		}
+		h.mu.Lock()
+		for _, e := range events {
+			h.caughtUp[e.ID] = true
+			h.publish(e)
+		}
+		h.mu.Unlock()
+		if len(events) < replayPage {
+			return
+		}
+		last = events[len(events)-1].ID
+	}
+}
+
+// receive delivers an announced event.
+func (h *Hub) receive(e data.BookingEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.caughtUp[e.ID] {
+		return
+	}
+	h.publish(e)
+}
+
+// publish delivers e, dropping subscribers whose buffer is full. h.mu must
+// be held.
+func (h *Hub) publish(e data.BookingEvent) {
+	received.Add(1)
+	h.last = max(h.last, e.ID)
+	for s := range h.subs {
+		if s.bookingID != 0 && s.bookingID != e.BookingID {
+			continue
+		}
+		select {
+		case s.events <- e:
+		default:
+			dropped.Add(1)
+			h.remove(s)
+		}
+	}
+}
+
+// Subscribe returns a subscription to the events of a booking, or of every
+// booking if bookingID is 0. Its channel is closed when it is closed, falls
+// behind or the hub stops.
+func (h *Hub) Subscribe(bookingID int64) *Subscription {
+	s := &Subscription{hub: h, bookingID: bookingID, events: make(chan data.BookingEvent, bufferSize)}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		close(s.events)
+		return s
+	}
+	h.subs[s] = true
+	subscribers.Add(1)
+	return s
+}
+
+// Resume subscribes like Subscribe and also returns the events after
+// lastID, oldest first. Live events may repeat the returned ones. It
+// returns ErrTooFarBehind, without a subscription, if more than MaxReplay
+// events were missed.
+func (h *Hub) Resume(ctx context.Context, bookingID, lastID int64) (*Subscription, []data.BookingEvent, error) {
+	s := h.Subscribe(bookingID)
+	var missed []data.BookingEvent
+	for after := lastID; ; {
+		events, err := h.store.ListBookingEvents(ctx, after, bookingID, replayPage)
+		if err != nil {
+			s.Close()
+			return nil, nil, err
+		}
+		missed = append(missed, events...)
+		if len(missed) > MaxReplay {
+			s.Close()
+			return nil, nil, ErrTooFarBehind
+		}
+		if len(events) < replayPage {
+			return s, missed, nil
+		}
+		after = events[len(events)-1].ID
+	}
+}
+
+// remove ends a subscription. h.mu must be held.
+func (h *Hub) remove(s *Subscription) {
+	if !h.subs[s] {
+		return
+	}
+	delete(h.subs, s)
+	close(s.events)
+	subscribers.Add(-1)
+}
+
+func (h *Hub) closeAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for s := range h.subs {
+		h.remove(s)
+	}
+}
+
+// Events returns the channel events are delivered on.
+func (s *Subscription) Events() <-chan data.BookingEvent {
+	return s.events
+}
+
+// Close ends the subscription. It is safe to call more than once.
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	s.hub.remove(s)
+}
@@ -0,0 +1,197 @@
+package realtime
+
+import (
+	"context"
+	"errors"
+	"luxsuv-backend/config"
+	"luxsuv-backend/data"
+	"luxsuv-backend/logger"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeStore holds events in memory. Each call of ListenBookingEvents takes
+// the next connection from conns and announces what is sent on it until it
+// is closed, which fails the connection.
+type fakeStore struct {
+	mu     sync.Mutex
+	events []data.BookingEvent
+	conns  chan chan data.BookingEvent
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{conns: make(chan chan data.BookingEvent, 4)}
+}
+
+// commit stores an event without announcing it.
+func (f *fakeStore) commit(bookingID int64) data.BookingEvent {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	e := data.BookingEvent{ID: int64(len(f.events) + 1), BookingID: bookingID, Type: data.BookingEventUpdated}
+	f.events = append(f.events, e)
+	return e
+}
+
+func (f *fakeStore) ListenBookingEvents(ctx context.Context, listening func(), handle func(data.BookingEvent)) error {
+	var conn chan data.BookingEvent
+	select {
+	case conn = <-f.conns:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	listening()
+	for {
+		select {
+		case e, ok := <-conn:
+			if !ok {
+				return errors.New("connection lost")
+			}
+			handle(e)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (f *fakeStore) ListBookingEvents(_ context.Context, afterID, bookingID int64, limit int) ([]data.BookingEvent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := []data.BookingEvent{}
+	for _, e := range f.events {
+		if e.ID > afterID && (bookingID == 0 || e.BookingID == bookingID) && len(out) < limit {
+			out = append(out, e)
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeStore) LastBookingEventID(context.Context) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return int64(len(f.events)), nil
+}
+
+func newTestHub(t *testing.T, store Store) *Hub {
+	t.Helper()
+	hub, err := New(store, config.Defaults().Realtime, logger.NewLogger())
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	return hub
+}
+
+func next(t *testing.T, s *Subscription) data.BookingEvent {
+	t.Helper()
+	select {
+	case e, ok := <-s.Events():
+		if !ok {
+			t.Fatal("Subscription closed")
+		}
+		return e
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for an event")
+	}
+	return data.BookingEvent{}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestHubFansOutAndCatchesUp(t *testing.T) {
+	store := newFakeStore()
+	store.commit(1) // before the hub started; never delivered
+	hub := newTestHub(t, store)
+	hub.minBackoff = time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	all, one := hub.Subscribe(0), hub.Subscribe(2)
+	first := make(chan data.BookingEvent)
+	store.conns <- first
+	go hub.Run(ctx)
+	waitFor(t, func() bool { return hub.Last() == 1 })
+
+	e := store.commit(1)
+	first <- e
+	if got := next(t, all); got.ID != e.ID {
+		t.Errorf("Expected event %d, got %+v", e.ID, got)
+	}
+	e = store.commit(2)
+	first <- e
+	if next(t, all).ID != e.ID || next(t, one).ID != e.ID {
+		t.Errorf("Expected event %d for both subscribers", e.ID)
+	}
+
+	// Events committed while the connection is down are caught up on once
+	// listening again, and not delivered twice when announced as well.
+	close(first)
+	missed := store.commit(2)
+	second := make(chan data.BookingEvent, 2)
+	second <- missed
+	store.conns <- second
+	if got := next(t, one); got.ID != missed.ID {
+		t.Errorf("Expected caught up event %d, got %+v", missed.ID, got)
+	}
+	e = store.commit(2)
+	second <- e
+	if got := next(t, one); got.ID != e.ID {
+		t.Errorf("Expected event %d after the caught up one, got %+v", e.ID, got)
+	}
+
+	cancel()
+	waitFor(t, func() bool {
+		_, ok := <-all.Events()
+		return !ok
+	})
+}
+
+func TestHubDropsSlowSubscribers(t *testing.T) {
+	store := newFakeStore()
+	hub := newTestHub(t, store)
+	hub.started = true
+	slow := hub.Subscribe(0)
+	for i := 0; i <= bufferSize; i++ {
+		hub.receive(store.commit(1))
+	}
+	n := 0
+	for range slow.Events() {
+		n++
+	}
+	if n != bufferSize {
+		t.Errorf("Expected %d buffered events before being dropped, got %d", bufferSize, n)
+	}
+	slow.Close()
+}
+
+func TestHubResume(t *testing.T) {
+	store := newFakeStore()
+	hub := newTestHub(t, store)
+	for i := 0; i < 5; i++ {
+		store.commit(int64(i%2 + 1))
+	}
+
+	sub, missed, err := hub.Resume(context.Background(), 1, 1)
+	if err != nil {
+		t.Fatalf("Resume failed: %v", err)
+	}
+	defer sub.Close()
+	if len(missed) != 2 || missed[0].ID != 3 || missed[1].ID != 5 {
+		t.Errorf("Expected events 3 and 5 of booking 1, got %+v", missed)
+	}
+
+	for i := 0; i < MaxReplay; i++ {
+		store.commit(1)
+	}
+	if _, _, err := hub.Resume(context.Background(), 0, 1); err != ErrTooFarBehind {
+		t.Errorf("Expected ErrTooFarBehind, got %v", err)
+	}
+}
@@ -0,0 +1,152 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"luxsuv-backend/data"
+)
+
+// ListDriverSchedules returns the schedules of all enabled drivers, with their
+// time off and assigned bookings limited to those overlapping window. A
+// driver's Vehicle is their default vehicle if it is active. The schedules
+// are read from one snapshot so they are consistent with each other.
+func (r *BookingRepository) ListDriverSchedules(ctx context.Context, window data.TimeRange) ([]data.DriverSchedule, error) {
+	tx, err := r.db.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	vehicles := map[int64]*data.Vehicle{}
+	rows, err := tx.Query(ctx, `SELECT `+vehicleColumns+` FROM vehicles WHERE status = $1`, data.VehicleActive)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list vehicles: %w", err)
+	}
+	for rows.Next() {
+		v, err := scanVehicle(rows)
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan vehicle: %w", err)
+		}
+		vehicles[v.ID] = v
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list vehicles: %w", err)
+	}
+
+	var schedules []data.DriverSchedule
+	byDriver := map[int64]int{}
+	rows, err = tx.Query(ctx, `
+		SELECT id, default_vehicle_id FROM users
+		WHERE role = $1 AND disabled_at IS NULL
+		ORDER BY id`, data.RoleDriver)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list drivers: %w", err)
+	}
+	for rows.Next() {
+		var s data.DriverSchedule
+		if err := rows.Scan(&s.DriverID, &s.DefaultVehicleID); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan driver: %w", err)
+		}
+		if s.DefaultVehicleID != nil {
+			s.Vehicle = vehicles[*s.DefaultVehicleID]
+		}
+		byDriver[s.DriverID] = len(schedules)
+		schedules = append(schedules, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list drivers: %w", err)
+	}
+
+	rows, err = tx.Query(ctx, `
+		SELECT driver_id, weekday, to_char(start_time, 'HH24:MI'), to_char(end_time, 'HH24:MI')
+		FROM driver_working_hours
+		ORDER BY driver_id, weekday, start_time`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list working hours: %w", err)
+	}
+	for rows.Next() {
+		var driverID int64
+		var h data.WorkingHours
+		if err := rows.Scan(&driverID, &h.Weekday, &h.Start, &h.End); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan working hours: %w", err)
+		}
+		if i, ok := byDriver[driverID]; ok {
+			schedules[i].WorkingHours = append(schedules[i].WorkingHours, h)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list working hours: %w", err)
+	}
+
+	rows, err = tx.Query(ctx, `SELECT `+timeOffColumns+` FROM driver_time_off WHERE period && $1`, occupiedRange(&window))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list time off: %w", err)
+	}
+	for rows.Next() {
+		off, err := scanTimeOff(rows)
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan time off: %w", err)
+		}
+		if i, ok := byDriver[off.DriverID]; ok {
+			schedules[i].TimeOff = append(schedules[i].TimeOff, *off)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list time off: %w", err)
+	}
+
+	rows, err = tx.Query(ctx, `
+		SELECT driver_id, lower(occupied), upper(occupied)
+		FROM book_rides
+		WHERE driver_id IS NOT NULL AND erased_at IS NULL AND occupied && $1`, occupiedRange(&window))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list assigned bookings: %w", err)
+	}
+	for rows.Next() {
+		var driverID int64
+		var busy data.TimeRange
+		if err := rows.Scan(&driverID, &busy.Start, &busy.End); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan assigned booking: %w", err)
+		}
+		if i, ok := byDriver[driverID]; ok {
+			schedules[i].Busy = append(schedules[i].Busy, busy)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list assigned bookings: %w", err)
+	}
+	return schedules, nil
+}
+
+// ListUnassignedOccupied returns the occupied intervals of bookings without
+// a driver that overlap window.
+func (r *BookingRepository) ListUnassignedOccupied(ctx context.Context, window data.TimeRange) ([]data.TimeRange, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT lower(occupied), upper(occupied)
+		FROM book_rides
+		WHERE driver_id IS NULL AND erased_at IS NULL AND occupied && $1`, occupiedRange(&window))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list unassigned bookings: %w", err)
+	}
+	defer rows.Close()
+	var unassigned []data.TimeRange
+	for rows.Next() {
+		var u data.TimeRange
+		if err := rows.Scan(&u.Start, &u.End); err != nil {
+			return nil, fmt.Errorf("failed to scan unassigned booking: %w", err)
+		}
+		unassigned = append(unassigned, u)
+	}
+	return unassigned, rows.Err()
+}
@@ -0,0 +1,196 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"luxsuv-backend/data"
+)
+
+const tripChargeColumns = `id, booking_id, driver_id, kind, description, amount_cents, receipt IS NOT NULL, status, created_at,
+	reviewed_by, reviewed_at, review_note`
+
+func scanTripCharge(row pgx.Row) (*data.TripCharge, error) {
+	c := &data.TripCharge{}
+	err := row.Scan(&c.ID, &c.BookingID, &c.DriverID, &c.Kind, &c.Description, &c.AmountCents, &c.HasReceipt, &c.Status,
+		&c.CreatedAt, &c.ReviewedBy, &c.ReviewedAt, &c.ReviewNote)
+	return c, err
+}
+
+// CreateTripCharge attaches a pending charge to a booking assigned to
+// c.DriverID. It returns pgx.ErrNoRows if the booking does not exist or is
+// assigned to someone else, and data.ErrChargeClosed unless the ride is
+// under way or completed.
+func (r *BookingRepository) CreateTripCharge(ctx context.Context, c *data.TripCharge) (*data.TripCharge, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var status string
+	var driverID *int64
+	err = tx.QueryRow(ctx, `SELECT status, driver_id FROM book_rides WHERE id = $1 AND erased_at IS NULL FOR SHARE`, c.BookingID).
+		Scan(&status, &driverID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, pgx.ErrNoRows
+		}
+		return nil, fmt.Errorf("failed to get ride booking: %w", err)
+	}
+	if driverID == nil || *driverID != c.DriverID {
+		return nil, pgx.ErrNoRows
+	}
+	if !data.IsTracked(status) && status != data.BookingCompleted {
+		return nil, fmt.Errorf("%w: ride booking is %s", data.ErrChargeClosed, status)
+	}
+
+	created, err := scanTripCharge(tx.QueryRow(ctx, `
+		INSERT INTO trip_charges (booking_id, driver_id, kind, description, amount_cents)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING `+tripChargeColumns, c.BookingID, c.DriverID, c.Kind, c.Description, c.AmountCents))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trip charge: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit trip charge: %w", err)
+	}
+	return created, nil
+}
+
+func (r *BookingRepository) GetTripCharge(ctx context.Context, id int64) (*data.TripCharge, error) {
+	c, err := scanTripCharge(r.db.QueryRow(ctx, `SELECT `+tripChargeColumns+` FROM trip_charges WHERE id = $1`, id))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, pgx.ErrNoRows
+		}
+		return nil, fmt.Errorf("failed to get trip charge: %w", err)
+	}
+	return c, nil
+}
+
+// ListTripCharges returns charges ordered by ID, only those with the given
+// status if it is not empty and only those of the given booking if bookingID
+// is not nil.
+func (r *BookingRepository) ListTripCharges(ctx context.Context, status string, bookingID *int64) ([]*data.TripCharge, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT `+tripChargeColumns+`
+		FROM trip_charges
+		WHERE ($1 = '' OR status = $1) AND ($2::bigint IS NULL OR booking_id = $2)
+		ORDER BY id`, status, bookingID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list trip charges: %w", err)
+	}
+	defer rows.Close()
+	charges := []*data.TripCharge{}
+	for rows.Next() {
+		c, err := scanTripCharge(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan trip charge: %w", err)
+		}
+		charges = append(charges, c)
+	}
+	return charges, rows.Err()
+}
+
+// pendingTripCharge locks a charge of driverID for a change, returning
+// pgx.ErrNoRows if it is unknown or someone else's and
+// data.ErrChargeClosed if it has been reviewed.
+func pendingTripCharge(ctx context.Context, tx pgx.Tx, id, driverID int64) error {
+	var owner int64
+	var status string
+	err := tx.QueryRow(ctx, `SELECT driver_id, status FROM trip_charges WHERE id = $1 FOR UPDATE`, id).Scan(&owner, &status)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return pgx.ErrNoRows
+		}
+		return fmt.Errorf("failed to get trip charge: %w", err)
+	}
+	if owner != driverID {
+		return pgx.ErrNoRows
+	}
+	if status != data.ChargePending {
+		return fmt.Errorf("%w: it has been %s", data.ErrChargeClosed, status)
+	}
+	return nil
+}
+
+// SetTripChargeReceipt stores the receipt photo of a pending charge of
+// driverID, replacing any earlier one.
+func (r *BookingRepository) SetTripChargeReceipt(ctx context.Context, id, driverID int64, receipt data.Receipt) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+	if err := pendingTripCharge(ctx, tx, id, driverID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `UPDATE trip_charges SET receipt = $2, receipt_content_type = $3 WHERE id = $1`,
+		id, receipt.Image, receipt.ContentType); err != nil {
+		return fmt.Errorf("failed to store receipt: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit receipt: %w", err)
+	}
+	return nil
+}
+
+// GetTripChargeReceipt returns the receipt photo of a charge, or
+// pgx.ErrNoRows if the charge is unknown or has none.
+func (r *BookingRepository) GetTripChargeReceipt(ctx context.Context, id int64) (*data.Receipt, error) {
+	receipt := &data.Receipt{}
+	err := r.db.QueryRow(ctx, `
+		SELECT receipt_content_type, receipt FROM trip_charges WHERE id = $1 AND receipt IS NOT NULL`, id).
+		Scan(&receipt.ContentType, &receipt.Image)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, pgx.ErrNoRows
+		}
+		return nil, fmt.Errorf("failed to get receipt: %w", err)
+	}
+	return receipt, nil
+}
+
+// DeleteTripCharge withdraws a pending charge of driverID.
+func (r *BookingRepository) DeleteTripCharge(ctx context.Context, id, driverID int64) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+	if err := pendingTripCharge(ctx, tx, id, driverID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM trip_charges WHERE id = $1`, id); err != nil {
+		return fmt.Errorf("failed to delete trip charge: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit trip charge deletion: %w", err)
+	}
+	return nil
+}
+
+// ReviewTripCharge approves or rejects a pending charge. It returns
+// data.ErrChargeClosed if the charge has already been reviewed.
+func (r *BookingRepository) ReviewTripCharge(ctx context.Context, id int64, review data.ChargeReview) (*data.TripCharge, error) {
+	status := data.ChargeRejected
+	if review.Approve {
+		status = data.ChargeApproved
+	}
+	c, err := scanTripCharge(r.db.QueryRow(ctx, `
+		UPDATE trip_charges SET status = $2, reviewed_by = $3, reviewed_at = CURRENT_TIMESTAMP, review_note = $4
+		WHERE id = $1 AND status = $5
+		RETURNING `+tripChargeColumns, id, status, review.ReviewedBy, review.Note, data.ChargePending))
+	if err == pgx.ErrNoRows {
+		existing, err := r.GetTripCharge(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: it has been %s", data.ErrChargeClosed, existing.Status)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to review trip charge: %w", err)
+	}
+	return c, nil
+}
@@ -0,0 +1,353 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"luxsuv-backend/data"
+	"time"
+)
+
+const dispatchOfferColumns = `id, booking_id, driver_id, score, status, created_at, expires_at, responded_at,
+               responded_by, decline_reason`
+
+func scanDispatchOffer(row pgx.Row) (*data.DispatchOffer, error) {
+	o := &data.DispatchOffer{}
+	err := row.Scan(&o.ID, &o.BookingID, &o.DriverID, &o.Score, &o.Status, &o.CreatedAt, &o.ExpiresAt, &o.RespondedAt,
+		&o.RespondedBy, &o.DeclineReason)
+	return o, err
+}
+
+// SetBookRideStatus moves a booking to a new status. If change.DriverID is
+// set the booking must be assigned to that driver, otherwise pgx.ErrNoRows
+// is returned. Cancelling a booking releases its driver and withdraws
+// pending offers. Arriving, starting and completing the ride record the
+// time, and the odometer reading for the latter two, on its trip. It
+// returns data.ErrInvalidStatusTransition if the booking cannot move to the
+// status, data.ErrNoDriver if it has no driver to be en route and
+// data.ErrInvalidOdometer for an unexpected or decreasing odometer reading.
+func (r *BookingRepository) SetBookRideStatus(ctx context.Context, id int64, change data.StatusChange) error {
+	status := change.Status
+	if change.Odometer != nil && status != data.BookingInProgress && status != data.BookingCompleted {
+		return fmt.Errorf("%w: only taken when the ride starts or is completed", data.ErrInvalidOdometer)
+	}
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var current string
+	var assigned *int64
+	err = tx.QueryRow(ctx, `SELECT status, driver_id FROM book_rides WHERE id = $1 AND erased_at IS NULL FOR UPDATE`, id).
+		Scan(&current, &assigned)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return pgx.ErrNoRows
+		}
+		return fmt.Errorf("failed to get ride booking: %w", err)
+	}
+	if change.DriverID != nil && (assigned == nil || *assigned != *change.DriverID) {
+		return pgx.ErrNoRows
+	}
+	if !data.CanTransition(current, status) {
+		return fmt.Errorf("%w: %s to %s", data.ErrInvalidStatusTransition, current, status)
+	}
+	if status == data.BookingEnRoute && assigned == nil {
+		return data.ErrNoDriver
+	}
+
+	query := `UPDATE book_rides SET status = $2 WHERE id = $1`
+	if status == data.BookingCancelled {
+		query = `UPDATE book_rides SET status = $2, driver_id = NULL, occupied = NULL WHERE id = $1`
+		if err := withdrawDispatchOffers(ctx, tx, id); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(ctx, query, id, status); err != nil {
+		return fmt.Errorf("failed to set ride booking status: %w", err)
+	}
+	if err := recordTripProgress(ctx, tx, id, status, change.Odometer); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit ride booking status: %w", err)
+	}
+	return nil
+}
+
+// ListDispatchableBookRides returns the confirmed bookings without a driver
+// whose pickup falls in window, ordered by pickup.
+func (r *BookingRepository) ListDispatchableBookRides(ctx context.Context, window data.TimeRange) ([]*data.BookRide, error) {
+	query := `
+		SELECT ` + bookRideColumns + `
+		FROM book_rides
+		WHERE status = $1 AND driver_id IS NULL AND erased_at IS NULL
+		  AND lower(occupied) >= $2 AND lower(occupied) < $3
+		ORDER BY lower(occupied), id`
+	return r.listBookRides(ctx, query, data.BookingConfirmed, window.Start, window.End)
+}
+
+// ListAssignedBookRides returns the bookings with a driver whose occupied
+// interval overlaps window, ordered by pickup.
+func (r *BookingRepository) ListAssignedBookRides(ctx context.Context, window data.TimeRange) ([]*data.BookRide, error) {
+	query := `
+		SELECT ` + bookRideColumns + `
+		FROM book_rides
+		WHERE driver_id IS NOT NULL AND erased_at IS NULL AND occupied && $1
+		ORDER BY lower(occupied), id`
+	return r.listBookRides(ctx, query, occupiedRange(&window))
+}
+
+// ListDispatchOffers returns offers ordered by ID, only those with the given
+// status if it is not empty and only those for the given bookings if
+// bookingIDs is not nil.
+func (r *BookingRepository) ListDispatchOffers(ctx context.Context, status string, bookingIDs []int64) ([]*data.DispatchOffer, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT `+dispatchOfferColumns+`
+		FROM dispatch_offers
+		WHERE ($1 = '' OR status = $1) AND ($2::bigint[] IS NULL OR booking_id = ANY($2))
+		ORDER BY id`, status, bookingIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list dispatch offers: %w", err)
+	}
+	defer rows.Close()
+	offers := []*data.DispatchOffer{}
+	for rows.Next() {
+		o, err := scanDispatchOffer(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan dispatch offer: %w", err)
+		}
+		offers = append(offers, o)
+	}
+	return offers, rows.Err()
+}
+
+// CreateDispatchOffer records a pending offer of a booking to a driver.
+func (r *BookingRepository) CreateDispatchOffer(ctx context.Context, offer *data.DispatchOffer) (*data.DispatchOffer, error) {
+	created, err := scanDispatchOffer(r.db.QueryRow(ctx, `
+		INSERT INTO dispatch_offers (booking_id, driver_id, score, expires_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING `+dispatchOfferColumns, offer.BookingID, offer.DriverID, offer.Score, offer.ExpiresAt))
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, data.ErrOfferClosed
+		}
+		return nil, fmt.Errorf("failed to create dispatch offer: %w", err)
+	}
+	return created, nil
+}
+
+// RespondDispatchOffer accepts or declines a pending offer. Accepting assigns
+// the driver, and their default vehicle if the booking has none, to the
+// booking. It returns pgx.ErrNoRows for unknown offers and offers made to
+// another driver than resp.DriverID, data.ErrOfferClosed if the offer has
+// expired or been answered, the booking is no longer waiting for a driver or
+// resp.CanTake says the driver can no longer take it, and a
+// *ScheduleConflictError if the driver has since taken an overlapping
+// booking.
+func (r *BookingRepository) RespondDispatchOffer(ctx context.Context, id int64, resp data.OfferResponse) (*data.DispatchOffer, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	offer, err := scanDispatchOffer(tx.QueryRow(ctx, `SELECT `+dispatchOfferColumns+` FROM dispatch_offers WHERE id = $1 FOR UPDATE`, id))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, pgx.ErrNoRows
+		}
+		return nil, fmt.Errorf("failed to get dispatch offer: %w", err)
+	}
+	if resp.DriverID != nil && *resp.DriverID != offer.DriverID {
+		return nil, pgx.ErrNoRows
+	}
+	if offer.Status != data.OfferPending || !offer.ExpiresAt.After(time.Now()) {
+		return nil, data.ErrOfferClosed
+	}
+
+	status, reason := data.OfferDeclined, resp.Reason
+	if resp.Accept {
+		status, reason = data.OfferAccepted, ""
+		if resp.CanTake != nil {
+			if err := r.checkOfferDriver(ctx, tx, offer, resp.CanTake); err != nil {
+				return nil, err
+			}
+		}
+		result, err := tx.Exec(ctx, `
+			UPDATE book_rides SET driver_id = $2,
+			    vehicle_id = COALESCE(vehicle_id, (SELECT default_vehicle_id FROM users WHERE id = $2))
+			WHERE id = $1 AND driver_id IS NULL AND status = $3 AND erased_at IS NULL`,
+			offer.BookingID, offer.DriverID, data.BookingConfirmed)
+		if err != nil {
+			if isExclusionViolation(err) {
+				return nil, r.bookRideConflict(ctx, offer.DriverID, offer.BookingID)
+			}
+			return nil, fmt.Errorf("failed to assign driver: %w", err)
+		}
+		if result.RowsAffected() == 0 {
+			return nil, data.ErrOfferClosed
+		}
+	}
+	offer, err = scanDispatchOffer(tx.QueryRow(ctx, `
+		UPDATE dispatch_offers SET status = $2, responded_at = CURRENT_TIMESTAMP, responded_by = $3, decline_reason = $4
+		WHERE id = $1
+		RETURNING `+dispatchOfferColumns, id, status, resp.RespondedBy, reason))
+	if err != nil {
+		return nil, fmt.Errorf("failed to respond to dispatch offer: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit dispatch offer response: %w", err)
+	}
+	return offer, nil
+}
+
+// checkOfferDriver locks the offered booking and the vehicle the driver
+// would drive, the booking's or else their default one, and asks canTake
+// whether the driver can take the booking. Overlaps with the driver's other
+// bookings are left to the exclusion constraint.
+func (r *BookingRepository) checkOfferDriver(ctx context.Context, tx pgx.Tx, offer *data.DispatchOffer,
+	canTake func(*data.BookRide, data.DriverSchedule) (bool, error)) error {
+	ride, err := r.scanBookRide(tx.QueryRow(ctx, `
+		SELECT `+bookRideColumns+`
+		FROM book_rides WHERE id = $1 AND erased_at IS NULL FOR UPDATE`, offer.BookingID))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return data.ErrOfferClosed
+		}
+		return fmt.Errorf("failed to get ride booking: %w", err)
+	}
+	if ride.DriverID != nil || ride.Status != data.BookingConfirmed {
+		return data.ErrOfferClosed
+	}
+
+	d := data.DriverSchedule{DriverID: offer.DriverID}
+	err = tx.QueryRow(ctx, `
+		SELECT default_vehicle_id FROM users
+		WHERE id = $1 AND role = $2 AND disabled_at IS NULL FOR SHARE`, offer.DriverID, data.RoleDriver).Scan(&d.DefaultVehicleID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return fmt.Errorf("%w: driver %d is disabled", data.ErrOfferClosed, offer.DriverID)
+		}
+		return fmt.Errorf("failed to get driver: %w", err)
+	}
+	vehicleID := ride.VehicleID
+	if vehicleID == nil {
+		vehicleID = d.DefaultVehicleID
+	}
+	if vehicleID != nil {
+		v, err := scanVehicle(tx.QueryRow(ctx, `SELECT `+vehicleColumns+` FROM vehicles WHERE id = $1 FOR SHARE`, *vehicleID))
+		if err != nil && err != pgx.ErrNoRows {
+			return fmt.Errorf("failed to get vehicle: %w", err)
+		}
+		if err == nil {
+			d.Vehicle = v
+		}
+	}
+	if d.WorkingHours, err = driverWorkingHours(ctx, tx, offer.DriverID); err != nil {
+		return err
+	}
+	if d.TimeOff, err = driverTimeOff(ctx, tx, offer.DriverID, ride.Occupied); err != nil {
+		return err
+	}
+
+	ok, err := canTake(ride, d)
+	if err != nil {
+		return fmt.Errorf("failed to check driver %d: %w", offer.DriverID, err)
+	}
+	if !ok {
+		return fmt.Errorf("%w: driver %d can no longer take booking %d", data.ErrOfferClosed, offer.DriverID, offer.BookingID)
+	}
+	return nil
+}
+
+// ListDriverDispatchOffers returns the offers made to a driver, newest
+// first, only those with the given status if it is not empty.
+func (r *BookingRepository) ListDriverDispatchOffers(ctx context.Context, driverID int64, status string) ([]*data.DispatchOffer, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT `+dispatchOfferColumns+`
+		FROM dispatch_offers
+		WHERE driver_id = $1 AND ($2 = '' OR status = $2)
+		ORDER BY id DESC`, driverID, status)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list dispatch offers: %w", err)
+	}
+	defer rows.Close()
+	offers := []*data.DispatchOffer{}
+	for rows.Next() {
+		o, err := scanDispatchOffer(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan dispatch offer: %w", err)
+		}
+		offers = append(offers, o)
+	}
+	return offers, rows.Err()
+}
+
+// ListOfferResponseStats summarises per driver how they answered the offers
+// made in [since, until), ordered by driver ID.
+func (r *BookingRepository) ListOfferResponseStats(ctx context.Context, since, until time.Time) ([]data.OfferResponseStats, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT driver_id,
+		       count(*),
+		       count(*) FILTER (WHERE status = $3),
+		       count(*) FILTER (WHERE status = $4),
+		       count(*) FILTER (WHERE status = $5),
+		       avg(extract(EPOCH FROM responded_at - created_at)) FILTER (WHERE responded_by = driver_id),
+		       percentile_cont(0.5) WITHIN GROUP (ORDER BY extract(EPOCH FROM responded_at - created_at))
+		           FILTER (WHERE responded_by = driver_id)
+		FROM dispatch_offers
+		WHERE created_at >= $1 AND created_at < $2
+		  AND status <> $6 AND NOT (status = $3 AND responded_by IS NULL)
+		GROUP BY driver_id
+		ORDER BY driver_id`,
+		since, until, data.OfferAccepted, data.OfferDeclined, data.OfferExpired, data.OfferWithdrawn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to summarise offer responses: %w", err)
+	}
+	defer rows.Close()
+	stats := []data.OfferResponseStats{}
+	for rows.Next() {
+		var s data.OfferResponseStats
+		if err := rows.Scan(&s.DriverID, &s.Offers, &s.Accepted, &s.Declined, &s.Expired,
+			&s.MeanResponseSeconds, &s.MedianResponseSeconds); err != nil {
+			return nil, fmt.Errorf("failed to scan offer responses: %w", err)
+		}
+		s.AcceptanceRate = float64(s.Accepted) / float64(s.Offers)
+		stats = append(stats, s)
+	}
+	return stats, rows.Err()
+}
+
+// bookRideConflict finds the booking of driverID that overlaps bookingID.
+func (r *BookingRepository) bookRideConflict(ctx context.Context, driverID, bookingID int64) error {
+	var occupied data.TimeRange
+	err := r.db.QueryRow(ctx, `SELECT lower(occupied), upper(occupied) FROM book_rides WHERE id = $1`, bookingID).
+		Scan(&occupied.Start, &occupied.End)
+	if err != nil {
+		return fmt.Errorf("failed to get ride booking: %w", err)
+	}
+	return r.scheduleConflict(ctx, driverID, bookingID, occupied)
+}
+
+// ExpireDispatchOffers marks pending offers that expired by now as expired
+// and returns how many there were.
+func (r *BookingRepository) ExpireDispatchOffers(ctx context.Context, now time.Time) (int64, error) {
+	result, err := r.db.Exec(ctx, `UPDATE dispatch_offers SET status = $1 WHERE status = $2 AND expires_at <= $3`,
+		data.OfferExpired, data.OfferPending, now)
+	if err != nil {
+		return 0, fmt.Errorf("failed to expire dispatch offers: %w", err)
+	}
+	return result.RowsAffected(), nil
+}
+
+// withdrawDispatchOffers withdraws the pending offer of a booking, if any.
+func withdrawDispatchOffers(ctx context.Context, tx pgx.Tx, bookingID int64) error {
+	_, err := tx.Exec(ctx, `UPDATE dispatch_offers SET status = $2 WHERE booking_id = $1 AND status = $3`,
+		bookingID, data.OfferWithdrawn, data.OfferPending)
+	if err != nil {
+		return fmt.Errorf("failed to withdraw dispatch offers: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"luxsuv-backend/data"
+)
+
+// bookingEventsChannel is the channel record_booking_event notifies on.
+const bookingEventsChannel = "booking_events"
+
+const bookingEventColumns = `id, booking_id, type, status, driver_id, vehicle_id, offer_id, created_at`
+
+// ListBookingEvents returns up to limit booking events after the event with
+// ID afterID, oldest first; only those of one booking if bookingID is not 0.
+func (r *BookingRepository) ListBookingEvents(ctx context.Context, afterID, bookingID int64, limit int) ([]data.BookingEvent, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT `+bookingEventColumns+`
+		FROM booking_events
+		WHERE id > $1 AND ($2 = 0 OR booking_id = $2)
+		ORDER BY id
+		LIMIT $3`, afterID, bookingID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list booking events: %w", err)
+	}
+	defer rows.Close()
+	events := []data.BookingEvent{}
+	for rows.Next() {
+		var e data.BookingEvent
+		if err := rows.Scan(&e.ID, &e.BookingID, &e.Type, &e.Status, &e.DriverID, &e.VehicleID, &e.OfferID, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan booking event: %w", err)
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// ListenBookingEvents listens for booking events committed by any server and
+// passes each to handle until ctx is cancelled or the connection fails.
+// listening is called once notifications are being received, so events
+// committed before can be caught up on without a gap. The connection is taken
+// out of the pool and closed afterwards rather than returned still listening.
+func (r *BookingRepository) ListenBookingEvents(ctx context.Context, listening func(), handle func(data.BookingEvent)) error {
+	pooled, err := r.db.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire connection: %w", err)
+	}
+	conn := pooled.Hijack()
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, `LISTEN `+bookingEventsChannel); err != nil {
+		return fmt.Errorf("failed to listen for booking events: %w", err)
+	}
+	listening()
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to wait for booking events: %w", err)
+		}
+		var e data.BookingEvent
+		if err := json.Unmarshal([]byte(n.Payload), &e); err != nil {
+			return fmt.Errorf("failed to decode booking event: %w", err)
+		}
+		handle(e)
+	}
+}
+
+// LastBookingEventID returns the ID of the latest booking event, 0 if there
+// is none.
+func (r *BookingRepository) LastBookingEventID(ctx context.Context) (int64, error) {
+	var id int64
+	if err := r.db.QueryRow(ctx, `SELECT COALESCE(max(id), 0) FROM booking_events`).Scan(&id); err != nil {
+		return 0, fmt.Errorf("failed to get last booking event: %w", err)
+	}
+	return id, nil
+}
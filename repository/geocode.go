@@ -0,0 +1,177 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"luxsuv-backend/data"
+	"time"
+)
+
+// GetGeocode implements geo.CacheStore. Entries are stored under the keyed
+// HMAC of key with their result encrypted, like rider PII; entries whose
+// master key has since been removed read as failures and are looked up again.
+func (r *BookingRepository) GetGeocode(ctx context.Context, key string) ([]byte, bool, error) {
+	if r.pii == nil {
+		return nil, false, errNoKeyring
+	}
+	key = r.pii.MAC(key)
+	var result, keyID *string
+	var wrappedKey []byte
+	err := r.db.QueryRow(ctx, `
+		SELECT result, key_id, data_key FROM geocode_cache
+		WHERE key = $1 AND expires_at > CURRENT_TIMESTAMP`, key).Scan(&result, &keyID, &wrappedKey)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, false, nil
+		}
+		return nil, false, fmt.Errorf("failed to read geocode cache: %w", err)
+	}
+	if result == nil {
+		return nil, true, nil
+	}
+	if keyID == nil {
+		return nil, false, fmt.Errorf("geocode cache entry is not encrypted")
+	}
+	env, err := r.pii.OpenEnvelope(*keyID, wrappedKey)
+	if err != nil {
+		return nil, false, fmt.Errorf("geocode cache: %w", err)
+	}
+	value, err := env.Decrypt("geocode_cache:"+key, *result)
+	if err != nil {
+		return nil, false, fmt.Errorf("geocode cache: %w", err)
+	}
+	return []byte(value), true, nil
+}
+
+// PutGeocode implements geo.CacheStore.
+func (r *BookingRepository) PutGeocode(ctx context.Context, key string, value []byte, expiresAt time.Time) error {
+	if r.pii == nil {
+		return errNoKeyring
+	}
+	key = r.pii.MAC(key)
+	var result, keyID *string
+	var wrappedKey []byte
+	if value != nil {
+		env, err := r.pii.NewEnvelope()
+		if err != nil {
+			return err
+		}
+		// The key is bound to the ciphertext so results cannot be swapped
+		// between entries.
+		sealed, err := env.Encrypt("geocode_cache:"+key, string(value))
+		if err != nil {
+			return err
+		}
+		result, keyID, wrappedKey = &sealed, &env.KeyID, env.WrappedKey
+	}
+	query := `
+		INSERT INTO geocode_cache (key, result, key_id, data_key, expires_at) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (key) DO UPDATE SET result = EXCLUDED.result, key_id = EXCLUDED.key_id,
+		    data_key = EXCLUDED.data_key, expires_at = EXCLUDED.expires_at`
+	if _, err := r.db.Exec(ctx, query, key, result, keyID, wrappedKey, expiresAt); err != nil {
+		return fmt.Errorf("failed to write geocode cache: %w", err)
+	}
+	return nil
+}
+
+// DeleteExpiredGeocodes deletes expired geocode cache entries.
+func (r *BookingRepository) DeleteExpiredGeocodes(ctx context.Context, dryRun bool) (int64, error) {
+	n, err := r.purge(ctx, dryRun, `DELETE FROM geocode_cache`, "geocode_cache", `expires_at < CURRENT_TIMESTAMP`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired geocodes: %w", err)
+	}
+	return n, nil
+}
+
+// SetBookRideGeocode stores the geocoding outcome for a booking: the
+// structured pickup and dropoff details (the addresses are left as typed) and
+// the geocode status, and the route estimate if both locations were resolved
+// with the occupied interval recomputed from it. The details are encrypted
+// with the booking's data key.
+// Nothing is stored if the addresses or the occupied interval have changed
+// since the booking was saved with saved. It returns a *ScheduleConflictError
+// if the assigned driver is not free for the new interval.
+func (r *BookingRepository) SetBookRideGeocode(ctx context.Context, id int64, pickup, dropoff data.Location, status string, route *data.RouteEstimate, saved, occupied *data.TimeRange) error {
+	if r.pii == nil {
+		return errNoKeyring
+	}
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var keyID *string
+	var wrappedKey []byte
+	var pickupAddress, dropoffAddress string
+	var driverID *int64
+	var occupiedStart, occupiedEnd *time.Time
+	err = tx.QueryRow(ctx, `
+		SELECT pii_key_id, pii_data_key, pickup_location, dropoff_location, driver_id, lower(occupied), upper(occupied)
+		FROM book_rides WHERE id = $1 AND erased_at IS NULL FOR UPDATE`, id).
+		Scan(&keyID, &wrappedKey, &pickupAddress, &dropoffAddress, &driverID, &occupiedStart, &occupiedEnd)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return pgx.ErrNoRows
+		}
+		return fmt.Errorf("failed to get ride booking: %w", err)
+	}
+	if keyID == nil {
+		// Only bookings from before field encryption lack a data key, and
+		// they are never geocoded.
+		return fmt.Errorf("book ride %d is not encrypted", id)
+	}
+	env, err := r.pii.OpenEnvelope(*keyID, wrappedKey)
+	if err != nil {
+		return fmt.Errorf("book ride %d: %w", id, err)
+	}
+	// The rider may have changed the addresses while they were looked up.
+	if pickupAddress, err = env.Decrypt("pickup_location", pickupAddress); err != nil {
+		return fmt.Errorf("book ride %d: %w", id, err)
+	}
+	if dropoffAddress, err = env.Decrypt("dropoff_location", dropoffAddress); err != nil {
+		return fmt.Errorf("book ride %d: %w", id, err)
+	}
+	if pickupAddress != pickup.Address || dropoffAddress != dropoff.Address {
+		return nil
+	}
+	// A new pickup time or duration means the rider updated the booking; the
+	// geocoding of that update will store its own interval.
+	if !sameRange(saved, occupiedStart, occupiedEnd) {
+		return nil
+	}
+	pickupDetails, err := sealLocationDetails(env, "pickup_details", pickup)
+	if err != nil {
+		return err
+	}
+	dropoffDetails, err := sealLocationDetails(env, "dropoff_details", dropoff)
+	if err != nil {
+		return err
+	}
+	distance, duration := routeColumns(route)
+	_, err = tx.Exec(ctx, `
+		UPDATE book_rides SET pickup_details = $2, dropoff_details = $3, geocode_status = $4,
+		    route_distance_meters = $5, route_duration_seconds = $6, occupied = $7
+		WHERE id = $1`,
+		id, pickupDetails, dropoffDetails, status, distance, duration, occupiedRange(occupied))
+	if err != nil {
+		if isExclusionViolation(err) && driverID != nil && occupied != nil {
+			return r.scheduleConflict(ctx, *driverID, id, *occupied)
+		}
+		return fmt.Errorf("failed to update ride geocode: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit ride geocode: %w", err)
+	}
+	return nil
+}
+
+// sameRange reports whether r is the interval from start to end, both nil
+// standing for no interval.
+func sameRange(r *data.TimeRange, start, end *time.Time) bool {
+	if r == nil || start == nil || end == nil {
+		return r == nil && start == nil && end == nil
+	}
+	return r.Start.Equal(*start) && r.End.Equal(*end)
+}
@@ -0,0 +1,91 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"luxsuv-backend/data"
+	"time"
+)
+
+// GetLoginLockout returns the latest locked_until among keys, or the zero time
+// if none of them is locked.
+func (r *BookingRepository) GetLoginLockout(ctx context.Context, keys ...string) (time.Time, error) {
+	var until *time.Time
+	query := `SELECT MAX(locked_until) FROM login_lockouts WHERE key = ANY($1) AND locked_until > CURRENT_TIMESTAMP`
+	if err := r.db.QueryRow(ctx, query, keys).Scan(&until); err != nil {
+		return time.Time{}, fmt.Errorf("failed to get login lockout: %w", err)
+	}
+	if until == nil {
+		return time.Time{}, nil
+	}
+	return *until, nil
+}
+
+// RecordLoginFailure increments the failure counter for key and returns the
+// new count. Counters older than resetAfter start again from one.
+func (r *BookingRepository) RecordLoginFailure(ctx context.Context, key string, resetAfter time.Duration) (int, error) {
+	query := `
+        INSERT INTO login_lockouts (key, failures, last_failure_at)
+        VALUES ($1, 1, CURRENT_TIMESTAMP)
+        ON CONFLICT (key) DO UPDATE SET
+            failures = CASE
+                WHEN login_lockouts.last_failure_at < CURRENT_TIMESTAMP - $2::interval THEN 1
+                ELSE login_lockouts.failures + 1
+            END,
+            last_failure_at = CURRENT_TIMESTAMP
+        RETURNING failures`
+	var failures int
+	if err := r.db.QueryRow(ctx, query, key, resetAfter).Scan(&failures); err != nil {
+		return 0, fmt.Errorf("failed to record login failure: %w", err)
+	}
+	return failures, nil
+}
+
+// LockLogin locks key until the given time.
+func (r *BookingRepository) LockLogin(ctx context.Context, key string, until time.Time) error {
+	if _, err := r.db.Exec(ctx, `UPDATE login_lockouts SET locked_until = $2 WHERE key = $1`, key, until); err != nil {
+		return fmt.Errorf("failed to lock login: %w", err)
+	}
+	return nil
+}
+
+// ClearLoginFailures resets the failure counter and lockout for key.
+func (r *BookingRepository) ClearLoginFailures(ctx context.Context, key string) error {
+	if _, err := r.db.Exec(ctx, `DELETE FROM login_lockouts WHERE key = $1`, key); err != nil {
+		return fmt.Errorf("failed to clear login failures: %w", err)
+	}
+	return nil
+}
+
+func (r *BookingRepository) CreateLoginAttempt(ctx context.Context, attempt *data.LoginAttempt) error {
+	query := `
+        INSERT INTO login_audit (username, user_id, ip_address, user_agent, success, reason)
+        VALUES ($1, $2, $3, $4, $5, $6)`
+	_, err := r.db.Exec(ctx, query,
+		attempt.Username, attempt.UserID, attempt.IPAddress, attempt.UserAgent, attempt.Success, attempt.Reason)
+	if err != nil {
+		return fmt.Errorf("failed to record login attempt: %w", err)
+	}
+	return nil
+}
+
+// ListLoginAttempts returns the most recent login attempts, optionally
+// filtered by username.
+func (r *BookingRepository) ListLoginAttempts(ctx context.Context, username string, limit int) ([]*data.LoginAttempt, error) {
+	query := `
+        SELECT id, username, user_id, ip_address, COALESCE(user_agent, ''), success, reason, created_at
+        FROM login_audit
+        WHERE $1 = '' OR username = $1
+        ORDER BY created_at DESC
+        LIMIT $2`
+	rows, err := r.db.Query(ctx, query, username, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list login attempts: %w", err)
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (*data.LoginAttempt, error) {
+		a := &data.LoginAttempt{}
+		err := row.Scan(&a.ID, &a.Username, &a.UserID, &a.IPAddress, &a.UserAgent, &a.Success, &a.Reason, &a.CreatedAt)
+		return a, err
+	})
+}
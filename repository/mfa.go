@@ -0,0 +1,114 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrMFAAlreadyEnabled is returned when enrolling a user who already has MFA enabled.
+var ErrMFAAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+
+// SetPendingMFASecret stores a TOTP secret that is not yet in effect. It
+// replaces any previous unconfirmed secret.
+func (r *BookingRepository) SetPendingMFASecret(ctx context.Context, userID int64, secret string) error {
+	query := `UPDATE users SET mfa_secret = $2, mfa_last_step = NULL WHERE id = $1 AND mfa_enabled_at IS NULL`
+	result, err := r.db.Exec(ctx, query, userID, secret)
+	if err != nil {
+		return fmt.Errorf("failed to store MFA secret: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return ErrMFAAlreadyEnabled
+	}
+	return nil
+}
+
+// EnableMFA puts the pending secret into effect, records step as used and
+// replaces the user's recovery codes.
+func (r *BookingRepository) EnableMFA(ctx context.Context, userID int64, step int64, recoveryCodes []string) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+        UPDATE users SET mfa_enabled_at = CURRENT_TIMESTAMP, mfa_last_step = $2
+        WHERE id = $1 AND mfa_secret IS NOT NULL AND mfa_enabled_at IS NULL`
+	result, err := tx.Exec(ctx, query, userID, step)
+	if err != nil {
+		return fmt.Errorf("failed to enable MFA: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return ErrMFAAlreadyEnabled
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to clear recovery codes: %w", err)
+	}
+	for _, code := range recoveryCodes {
+		if _, err := tx.Exec(ctx, `INSERT INTO mfa_recovery_codes (user_id, code_hash) VALUES ($1, $2)`, userID, hashToken(code)); err != nil {
+			return fmt.Errorf("failed to store recovery code: %w", err)
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// UseMFAStep records that the TOTP code for step was used. It returns false if
+// that step (or a later one) was already used, so a code cannot be replayed.
+func (r *BookingRepository) UseMFAStep(ctx context.Context, userID int64, step int64) (bool, error) {
+	query := `
+        UPDATE users SET mfa_last_step = $2
+        WHERE id = $1 AND (mfa_last_step IS NULL OR mfa_last_step < $2)`
+	result, err := r.db.Exec(ctx, query, userID, step)
+	if err != nil {
+		return false, fmt.Errorf("failed to record MFA code use: %w", err)
+	}
+	return result.RowsAffected() == 1, nil
+}
+
+// UseRecoveryCode consumes one of the user's unused recovery codes. It returns
+// false if code does not match an unused one.
+func (r *BookingRepository) UseRecoveryCode(ctx context.Context, userID int64, code string) (bool, error) {
+	query := `
+        UPDATE mfa_recovery_codes SET used_at = CURRENT_TIMESTAMP
+        WHERE id = (
+            SELECT id FROM mfa_recovery_codes
+            WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+            LIMIT 1 FOR UPDATE
+        )`
+	result, err := r.db.Exec(ctx, query, userID, hashToken(code))
+	if err != nil {
+		return false, fmt.Errorf("failed to use recovery code: %w", err)
+	}
+	return result.RowsAffected() == 1, nil
+}
+
+// ResetMFA removes a user's TOTP secret and recovery codes and revokes their
+// tokens, so they must log in again and re-enrol.
+func (r *BookingRepository) ResetMFA(ctx context.Context, userID int64) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+        UPDATE users SET mfa_secret = NULL, mfa_enabled_at = NULL, mfa_last_step = NULL,
+                         token_version = token_version + 1
+        WHERE id = $1`
+	result, err := tx.Exec(ctx, query, userID)
+	if err != nil {
+		return fmt.Errorf("failed to reset MFA: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+	if _, err := tx.Exec(ctx, revokeUserRefreshTokensQuery, userID); err != nil {
+		return fmt.Errorf("failed to revoke refresh tokens: %w", err)
+	}
+	return tx.Commit(ctx)
+}
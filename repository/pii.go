@@ -0,0 +1,275 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"luxsuv-backend/data"
+	"luxsuv-backend/fieldcrypt"
+	"time"
+)
+
+var errNoKeyring = errors.New("field encryption is not configured")
+
+// SetKeyring sets the keys used to encrypt rider PII in book_rides. It must be
+// called before any booking is read or written.
+func (r *BookingRepository) SetKeyring(keyring *fieldcrypt.Keyring) {
+	r.pii = keyring
+}
+
+// bookRideColumns are the book_rides columns read by scanBookRide.
+const bookRideColumns = `id, your_name, email, phone_number, ride_type, pickup_location,
+               dropoff_location, date, time, number_of_passengers, number_of_luggage, additional_notes,
+               COALESCE(geocode_status, ''), zone_id, route_distance_meters,
+               route_duration_seconds, vehicle_id, hours, driver_id, lower(occupied), upper(occupied), status, preferences, pickup_details, dropoff_details, pii_key_id, pii_data_key`
+
+type piiField struct {
+	column string
+	value  *string
+}
+
+// bookRidePII returns the encrypted fields of ride, keyed by column name.
+func bookRidePII(ride *data.BookRide) []piiField {
+	return []piiField{
+		{"your_name", &ride.YourName},
+		{"email", &ride.Email},
+		{"phone_number", &ride.PhoneNumber},
+		{"pickup_location", &ride.PickupLocation.Address},
+		{"dropoff_location", &ride.DropoffLocation.Address},
+		{"additional_notes", &ride.AdditionalNotes},
+	}
+}
+
+// rawBookRide is a book_rides row as stored: for encrypted rows (keyID set)
+// the PII fields and location details hold ciphertext.
+type rawBookRide struct {
+	ride           *data.BookRide
+	pickupDetails  *string
+	dropoffDetails *string
+	keyID          *string
+	wrappedKey     []byte
+}
+
+// routeColumns returns the route_distance_meters and route_duration_seconds
+// values of est.
+func routeColumns(est *data.RouteEstimate) (distance, duration *int) {
+	if est == nil {
+		return nil, nil
+	}
+	return &est.DistanceMeters, &est.DurationSeconds
+}
+
+// scanRawBookRide scans bookRideColumns without decrypting.
+func scanRawBookRide(row pgx.Row) (*rawBookRide, error) {
+	raw := &rawBookRide{ride: &data.BookRide{}}
+	ride := raw.ride
+	var distance, duration *int
+	var occupiedStart, occupiedEnd *time.Time
+	err := row.Scan(
+		&ride.ID, &ride.YourName, &ride.Email, &ride.PhoneNumber, &ride.RideType,
+		&ride.PickupLocation.Address, &ride.DropoffLocation.Address, &ride.Date, &ride.Time,
+		&ride.NumberOfPassengers, &ride.NumberOfLuggage, &ride.AdditionalNotes,
+		&ride.GeocodeStatus, &ride.ZoneID, &distance, &duration, &ride.VehicleID,
+		&ride.Hours, &ride.DriverID, &occupiedStart, &occupiedEnd, &ride.Status, &ride.Preferences,
+		&raw.pickupDetails, &raw.dropoffDetails, &raw.keyID, &raw.wrappedKey)
+	if distance != nil && duration != nil {
+		ride.RouteEstimate = &data.RouteEstimate{DistanceMeters: *distance, DurationSeconds: *duration}
+	}
+	if occupiedStart != nil && occupiedEnd != nil {
+		ride.Occupied = &data.TimeRange{Start: *occupiedStart, End: *occupiedEnd}
+	}
+	return raw, err
+}
+
+// scanBookRide scans bookRideColumns and decrypts the PII fields.
+func (r *BookingRepository) scanBookRide(row pgx.Row) (*data.BookRide, error) {
+	raw, err := scanRawBookRide(row)
+	if err != nil {
+		return nil, err
+	}
+	ride := raw.ride
+	if raw.keyID == nil {
+		return ride, nil
+	}
+	if r.pii == nil {
+		return nil, errNoKeyring
+	}
+	env, err := r.pii.OpenEnvelope(*raw.keyID, raw.wrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("book ride %d: %w", ride.ID, err)
+	}
+	for _, f := range bookRidePII(ride) {
+		if *f.value, err = env.Decrypt(f.column, *f.value); err != nil {
+			return nil, fmt.Errorf("book ride %d: %w", ride.ID, err)
+		}
+	}
+	if err := openLocationDetails(env, "pickup_details", raw.pickupDetails, &ride.PickupLocation); err != nil {
+		return nil, fmt.Errorf("book ride %d: %w", ride.ID, err)
+	}
+	if err := openLocationDetails(env, "dropoff_details", raw.dropoffDetails, &ride.DropoffLocation); err != nil {
+		return nil, fmt.Errorf("book ride %d: %w", ride.ID, err)
+	}
+	return ride, nil
+}
+
+// sealedBookRide is a booking ready to be written: the embedded BookRide's
+// PII fields hold ciphertext.
+type sealedBookRide struct {
+	*data.BookRide
+	pickupDetails  *string
+	dropoffDetails *string
+	env            *fieldcrypt.Envelope
+}
+
+// sealBookRide encrypts the PII fields and location details of a copy of
+// ride under a new data key.
+func (r *BookingRepository) sealBookRide(ride *data.BookRide) (*sealedBookRide, error) {
+	if r.pii == nil {
+		return nil, errNoKeyring
+	}
+	env, err := r.pii.NewEnvelope()
+	if err != nil {
+		return nil, err
+	}
+	sealed := &sealedBookRide{BookRide: new(data.BookRide), env: env}
+	*sealed.BookRide = *ride
+	for _, f := range bookRidePII(sealed.BookRide) {
+		if *f.value, err = env.Encrypt(f.column, *f.value); err != nil {
+			return nil, err
+		}
+	}
+	if sealed.pickupDetails, err = sealLocationDetails(env, "pickup_details", ride.PickupLocation); err != nil {
+		return nil, err
+	}
+	if sealed.dropoffDetails, err = sealLocationDetails(env, "dropoff_details", ride.DropoffLocation); err != nil {
+		return nil, err
+	}
+	return sealed, nil
+}
+
+// sealLocationDetails encrypts everything but the address of loc as JSON. It
+// returns nil for locations that only have an address.
+func sealLocationDetails(env *fieldcrypt.Envelope, column string, loc data.Location) (*string, error) {
+	loc.Address = ""
+	if loc == (data.Location{}) {
+		return nil, nil
+	}
+	details, err := json.Marshal(loc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s: %w", column, err)
+	}
+	sealed, err := env.Encrypt(column, string(details))
+	if err != nil {
+		return nil, err
+	}
+	return &sealed, nil
+}
+
+// openLocationDetails decrypts details into loc, keeping loc's address.
+func openLocationDetails(env *fieldcrypt.Envelope, column string, details *string, loc *data.Location) error {
+	if details == nil {
+		return nil
+	}
+	plain, err := env.Decrypt(column, *details)
+	if err != nil {
+		return err
+	}
+	address := loc.Address
+	if err := json.Unmarshal([]byte(plain), loc); err != nil {
+		return fmt.Errorf("failed to decode %s: %w", column, err)
+	}
+	loc.Address = address
+	return nil
+}
+
+// ReencryptBookRides brings every booking under the primary key: plaintext
+// rows are encrypted and data keys wrapped by older keys are re-wrapped.
+// Erased bookings hold no PII and are skipped. Rows are processed in
+// transactions of batchSize. It returns the number of rows updated.
+func (r *BookingRepository) ReencryptBookRides(ctx context.Context, batchSize int) (int, error) {
+	if r.pii == nil {
+		return 0, errNoKeyring
+	}
+	total := 0
+	for {
+		n, err := r.reencryptBookRideBatch(ctx, batchSize)
+		total += n
+		if err != nil || n == 0 {
+			return total, err
+		}
+	}
+}
+
+func (r *BookingRepository) reencryptBookRideBatch(ctx context.Context, batchSize int) (int, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `
+		SELECT `+bookRideColumns+`
+		FROM book_rides
+		WHERE pii_key_id IS DISTINCT FROM $1 AND erased_at IS NULL
+		ORDER BY id
+		LIMIT $2
+		FOR UPDATE SKIP LOCKED`, r.pii.PrimaryID(), batchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to select book rides to re-encrypt: %w", err)
+	}
+	var stale []*rawBookRide
+	for rows.Next() {
+		raw, err := scanRawBookRide(rows)
+		if err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan book ride: %w", err)
+		}
+		stale = append(stale, raw)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to select book rides to re-encrypt: %w", err)
+	}
+
+	for _, s := range stale {
+		if s.keyID == nil {
+			sealed, err := r.sealBookRide(s.ride)
+			if err != nil {
+				return 0, fmt.Errorf("book ride %d: %w", s.ride.ID, err)
+			}
+			_, err = tx.Exec(ctx, `
+				UPDATE book_rides SET
+				    your_name = $2, email = $3, phone_number = $4, pickup_location = $5,
+				    dropoff_location = $6, additional_notes = $7,
+				    pii_key_id = $8, pii_data_key = $9, email_index = $10
+				WHERE id = $1`,
+				s.ride.ID, sealed.YourName, sealed.Email, sealed.PhoneNumber, sealed.PickupLocation.Address,
+				sealed.DropoffLocation.Address, sealed.AdditionalNotes,
+				sealed.env.KeyID, sealed.env.WrappedKey, r.pii.BlindIndex(s.ride.Email))
+			if err != nil {
+				return 0, fmt.Errorf("failed to encrypt book ride %d: %w", s.ride.ID, err)
+			}
+			continue
+		}
+
+		env, err := r.pii.OpenEnvelope(*s.keyID, s.wrappedKey)
+		if err != nil {
+			return 0, fmt.Errorf("book ride %d: %w", s.ride.ID, err)
+		}
+		if env, err = r.pii.Rewrap(env); err != nil {
+			return 0, fmt.Errorf("book ride %d: %w", s.ride.ID, err)
+		}
+		_, err = tx.Exec(ctx, `UPDATE book_rides SET pii_key_id = $2, pii_data_key = $3 WHERE id = $1`,
+			s.ride.ID, env.KeyID, env.WrappedKey)
+		if err != nil {
+			return 0, fmt.Errorf("failed to re-wrap book ride %d: %w", s.ride.ID, err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("failed to commit re-encryption: %w", err)
+	}
+	return len(stale), nil
+}
@@ -0,0 +1,125 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"luxsuv-backend/data"
+)
+
+// GetBookRideByAccessToken returns the booking the rider access token was
+// issued for, or pgx.ErrNoRows if the token is unknown or the booking erased.
+func (r *BookingRepository) GetBookRideByAccessToken(ctx context.Context, token string) (*data.BookRide, error) {
+	query := `
+        SELECT ` + bookRideColumns + `
+        FROM book_rides WHERE access_token_hash = $1 AND erased_at IS NULL`
+	ride, err := r.scanBookRide(r.db.QueryRow(ctx, query, hashToken(token)))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, pgx.ErrNoRows
+		}
+		return nil, fmt.Errorf("failed to get ride booking: %w", err)
+	}
+	return ride, nil
+}
+
+// eraseBookRidePII blanks the PII of the book_rides rows it is applied to. A
+// booking that has not been completed is cancelled like a rider cancellation,
+// so it no longer holds a driver or an occupied interval; completed bookings
+// keep their driver for financial records.
+const eraseBookRidePII = `
+        UPDATE book_rides SET
+            your_name = '', email = '', phone_number = '',
+            pickup_location = '', dropoff_location = '', additional_notes = '',
+            pickup_details = NULL, dropoff_details = NULL,
+            pii_key_id = NULL, pii_data_key = NULL, email_index = NULL,
+            access_token_hash = NULL, erased_at = CURRENT_TIMESTAMP,
+            status = CASE WHEN status = 'completed' THEN status ELSE 'cancelled' END,
+            driver_id = CASE WHEN status = 'completed' THEN driver_id END,
+            occupied = NULL`
+
+// EraseRiderData anonymises every booking made with email. The rows are kept
+// with their ride type, date, time and counts for financial records; the PII
+// columns are blanked, the data key and blind index are dropped, rider
+// access tokens stop working, bookings not yet completed are cancelled and
+// the driver locations, trip times and odometer readings and charge receipts
+// recorded are deleted. It returns the number of bookings erased.
+func (r *BookingRepository) EraseRiderData(ctx context.Context, email string) (int64, error) {
+	if r.pii == nil {
+		return 0, errNoKeyring
+	}
+	n, err := r.eraseBookRides(ctx, `(email_index = $1 OR (pii_key_id IS NULL AND email = $2))`, r.pii.BlindIndex(email), email)
+	if err != nil {
+		return 0, fmt.Errorf("failed to erase rider data: %w", err)
+	}
+	return n, nil
+}
+
+// EraseBookRideData anonymises one booking like EraseRiderData. It returns
+// pgx.ErrNoRows if the booking does not exist or was already erased.
+func (r *BookingRepository) EraseBookRideData(ctx context.Context, id int64) error {
+	n, err := r.eraseBookRides(ctx, `id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to erase ride booking: %w", err)
+	}
+	if n == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// eraseBookRides anonymises the bookings not yet erased that match where,
+// and deletes what was recorded about them beyond their own row, in one
+// transaction: pending dispatch offers are withdrawn, the driver's locations
+// and the trip's times and odometer readings are deleted and so are the
+// receipt photos of its charges. Quotes, fares and charge amounts stay for
+// financial records. It returns the number of bookings erased.
+func (r *BookingRepository) eraseBookRides(ctx context.Context, where string, args ...interface{}) (int64, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, eraseBookRidePII+` WHERE `+where+` AND erased_at IS NULL RETURNING id`, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to blank ride bookings: %w", err)
+	}
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[int64])
+	if err != nil {
+		return 0, fmt.Errorf("failed to blank ride bookings: %w", err)
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE dispatch_offers SET status = $2 WHERE booking_id = ANY($1) AND status = $3`,
+		ids, data.OfferWithdrawn, data.OfferPending)
+	if err != nil {
+		return 0, fmt.Errorf("failed to withdraw dispatch offers: %w", err)
+	}
+	// The driver's trail shows where the rider was picked up and dropped off.
+	for _, table := range []string{"booking_location_trail", "booking_locations"} {
+		if _, err := tx.Exec(ctx, `DELETE FROM `+table+` WHERE booking_id = ANY($1)`, ids); err != nil {
+			return 0, fmt.Errorf("failed to erase locations: %w", err)
+		}
+	}
+	_, err = tx.Exec(ctx, `
+		UPDATE booking_trips SET arrived_at = NULL, started_at = NULL, ended_at = NULL,
+		    start_odometer = NULL, end_odometer = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE booking_id = ANY($1)`, ids)
+	if err != nil {
+		return 0, fmt.Errorf("failed to erase trip records: %w", err)
+	}
+	_, err = tx.Exec(ctx, `
+		UPDATE trip_charges SET receipt = NULL, receipt_content_type = NULL
+		WHERE booking_id = ANY($1) AND receipt IS NOT NULL`, ids)
+	if err != nil {
+		return 0, fmt.Errorf("failed to erase charge receipts: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("failed to commit erasure: %w", err)
+	}
+	return int64(len(ids)), nil
+}
@@ -0,0 +1,45 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"luxsuv-backend/ratelimit"
+	"time"
+)
+
+// Take implements ratelimit.Store with one row per bucket, so limits hold
+// across every machine sharing the database. The refill and take happen in a
+// single upsert, which Postgres serializes per key. Buckets are stored under
+// the keyed HMAC of key, so the table holds no client IPs or email addresses.
+func (r *BookingRepository) Take(ctx context.Context, key string, limit ratelimit.Limit) (ratelimit.Result, error) {
+	if r.pii == nil {
+		return ratelimit.Result{}, errNoKeyring
+	}
+	// refilled is the bucket's old level plus what has dripped in since; SET
+	// expressions all see the old row, so it can be used in each of them.
+	const refilled = `LEAST($3::float8, b.tokens + EXTRACT(EPOCH FROM now() - b.updated_at)::float8 * $2::float8)`
+	query := `
+        INSERT INTO rate_limit_buckets AS b (key, tokens, last_allowed, updated_at)
+        VALUES ($1, $3::float8 - 1, TRUE, now())
+        ON CONFLICT (key) DO UPDATE SET
+            tokens = CASE WHEN ` + refilled + ` >= 1 THEN ` + refilled + ` - 1 ELSE ` + refilled + ` END,
+            last_allowed = ` + refilled + ` >= 1,
+            updated_at = now()
+        RETURNING tokens, last_allowed`
+	var tokens float64
+	var allowed bool
+	if err := r.db.QueryRow(ctx, query, r.pii.MAC(key), limit.Rate(), float64(limit.Burst)).Scan(&tokens, &allowed); err != nil {
+		return ratelimit.Result{}, fmt.Errorf("failed to take rate limit token: %w", err)
+	}
+	return ratelimit.NewResult(allowed, tokens, limit), nil
+}
+
+// DeleteIdleRateLimitBuckets removes buckets untouched for longer than idle;
+// such buckets have refilled and are equivalent to missing ones.
+func (r *BookingRepository) DeleteIdleRateLimitBuckets(ctx context.Context, idle time.Duration) (int64, error) {
+	result, err := r.db.Exec(ctx, `DELETE FROM rate_limit_buckets WHERE updated_at < CURRENT_TIMESTAMP - $1::interval`, idle)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete idle rate limit buckets: %w", err)
+	}
+	return result.RowsAffected(), nil
+}
@@ -2,16 +2,21 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"golang.org/x/crypto/bcrypt"
 	"luxsuv-backend/data"
+	"luxsuv-backend/fieldcrypt"
 	"time"
 )
 
 type BookingRepository struct {
 	db *pgxpool.Pool
+	// pii encrypts rider PII in book_rides; see SetKeyring.
+	pii *fieldcrypt.Keyring
 }
 
 func NewBookingRepository(ctx context.Context, connString string) (*BookingRepository, error) {
@@ -37,13 +42,19 @@ func (r *BookingRepository) Ping(ctx context.Context) error {
 	return r.db.Ping(ctx)
 }
 
-func (r *BookingRepository) CreateBookRide(ctx context.Context, bookRide *data.BookRide) (int64, error) {
+// CreateBookRide stores a booking. accessToken is the secret handed to the
+// rider to authenticate later requests about their data; only its hash is kept.
+func (r *BookingRepository) CreateBookRide(ctx context.Context, bookRide *data.BookRide, accessToken string) (int64, error) {
 	if bookRide.RideType != "hourly" && bookRide.RideType != "per_ride" {
 		return 0, fmt.Errorf("invalid rideType: must be 'hourly' or 'per_ride', got %s", bookRide.RideType)
 	}
 	if bookRide.Email == "" {
 		return 0, fmt.Errorf("email is required for rider access")
 	}
+	sealed, err := r.sealBookRide(bookRide)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encrypt book ride: %w", err)
+	}
 	query := `
 		INSERT INTO book_rides (
 		                        your_name,
@@ -56,24 +67,52 @@ func (r *BookingRepository) CreateBookRide(ctx context.Context, bookRide *data.B
 		                        time,
 		                        number_of_passengers,
 		                        number_of_luggage,
-		                        additional_notes
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		                        additional_notes,
+		                        pii_key_id,
+		                        pii_data_key,
+		                        email_index,
+		                        access_token_hash,
+		                        pickup_details,
+		                        dropoff_details,
+		                        geocode_status,
+		                        zone_id,
+		                        route_distance_meters,
+		                        route_duration_seconds,
+		                        hours,
+		                        occupied,
+		                        preferences
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, NULLIF($18, ''), $19, $20, $21,
+		          $22, $23, $24)
 		RETURNING id`
+	distance, duration := routeColumns(bookRide.RouteEstimate)
 	var generatedID int64
-	err := r.db.QueryRow(
+	err = r.db.QueryRow(
 		ctx,
 		query,
-		bookRide.YourName,
-		bookRide.Email,
-		bookRide.PhoneNumber,
-		bookRide.RideType,
-		bookRide.PickupLocation,
-		bookRide.DropoffLocation,
-		bookRide.Date,
-		bookRide.Time,
-		bookRide.NumberOfPassengers,
-		bookRide.NumberOfLuggage,
-		bookRide.AdditionalNotes,
+		sealed.YourName,
+		sealed.Email,
+		sealed.PhoneNumber,
+		sealed.RideType,
+		sealed.PickupLocation.Address,
+		sealed.DropoffLocation.Address,
+		sealed.Date,
+		sealed.Time,
+		sealed.NumberOfPassengers,
+		sealed.NumberOfLuggage,
+		sealed.AdditionalNotes,
+		sealed.env.KeyID,
+		sealed.env.WrappedKey,
+		r.pii.BlindIndex(bookRide.Email),
+		hashToken(accessToken),
+		sealed.pickupDetails,
+		sealed.dropoffDetails,
+		sealed.GeocodeStatus,
+		sealed.ZoneID,
+		distance,
+		duration,
+		sealed.Hours,
+		occupiedRange(bookRide.Occupied),
+		bookRide.Preferences,
 	).Scan(&generatedID)
 	if err != nil {
 		return 0, fmt.Errorf("failed to create book ride: %w", err)
@@ -81,88 +120,35 @@ func (r *BookingRepository) CreateBookRide(ctx context.Context, bookRide *data.B
 	return generatedID, nil
 }
 
+// ListBookRidesByEmail finds bookings through the email blind index; rows not
+// yet encrypted are matched on the plaintext column.
 func (r *BookingRepository) ListBookRidesByEmail(ctx context.Context, email string) ([]*data.BookRide, error) {
-	query := `
-		SELECT id,
-		       your_name,
-		       email,
-		       phone_number,
-		       ride_type,
-		       pickup_location,
-		       dropoff_location,
-		       date,
-		       time,
-		       number_of_passengers,
-		       number_of_luggage,
-		       additional_notes
-		FROM book_rides WHERE email = $1`
-	rows, err := r.db.Query(ctx, query, email)
-	if err != nil {
-		return nil, fmt.Errorf("failed to list book rides by email: %w", err)
+	if r.pii == nil {
+		return nil, errNoKeyring
 	}
-	defer rows.Close()
-	var rides []*data.BookRide
-	for rows.Next() {
-		ride := &data.BookRide{}
-		err := rows.Scan(
-			&ride.ID,
-			&ride.YourName,
-			&ride.Email,
-			&ride.PhoneNumber,
-			&ride.RideType,
-			&ride.PickupLocation,
-			&ride.DropoffLocation,
-			&ride.Date,
-			&ride.Time,
-			&ride.NumberOfPassengers,
-			&ride.NumberOfLuggage,
-			&ride.AdditionalNotes,
-		)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan book ride: %w", err)
-		}
-		rides = append(rides, ride)
-	}
-	return rides, nil
+	query := `
+		SELECT ` + bookRideColumns + `
+		FROM book_rides
+		WHERE email_index = $1 OR (pii_key_id IS NULL AND email = $2)`
+	return r.listBookRides(ctx, query, r.pii.BlindIndex(email), email)
 }
 
 func (r *BookingRepository) ListBookRides(ctx context.Context) ([]*data.BookRide, error) {
 	query := `
-		SELECT id,
-		       your_name,
-		       email,
-		       phone_number,
-		       ride_type,
-		       pickup_location,
-		       dropoff_location,
-		       date,
-		       time,
-		       number_of_passengers,
-		       number_of_luggage,
-		       additional_notes
+		SELECT ` + bookRideColumns + `
 		FROM book_rides`
-	rows, err := r.db.Query(ctx, query)
+	return r.listBookRides(ctx, query)
+}
+
+func (r *BookingRepository) listBookRides(ctx context.Context, query string, args ...interface{}) ([]*data.BookRide, error) {
+	rows, err := r.db.Query(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list book rides: %w", err)
 	}
 	defer rows.Close()
 	var rides []*data.BookRide
 	for rows.Next() {
-		ride := &data.BookRide{}
-		err := rows.Scan(
-			&ride.ID,
-			&ride.YourName,
-			&ride.Email,
-			&ride.PhoneNumber,
-			&ride.RideType,
-			&ride.PickupLocation,
-			&ride.DropoffLocation,
-			&ride.Date,
-			&ride.Time,
-			&ride.NumberOfPassengers,
-			&ride.NumberOfLuggage,
-			&ride.AdditionalNotes,
-		)
+		ride, err := r.scanBookRide(rows)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan book ride: %w", err)
 		}
@@ -173,14 +159,9 @@ func (r *BookingRepository) ListBookRides(ctx context.Context) ([]*data.BookRide
 
 func (r *BookingRepository) GetBookRideByID(ctx context.Context, id int64) (*data.BookRide, error) {
 	query := `
-        SELECT id, your_name, email, phone_number, ride_type, pickup_location, 
-               dropoff_location, date, time, number_of_passengers, number_of_luggage, additional_notes
+        SELECT ` + bookRideColumns + `
         FROM book_rides WHERE id = $1`
-	ride := &data.BookRide{}
-	err := r.db.QueryRow(ctx, query, id).Scan(
-		&ride.ID, &ride.YourName, &ride.Email, &ride.PhoneNumber, &ride.RideType,
-		&ride.PickupLocation, &ride.DropoffLocation, &ride.Date, &ride.Time,
-		&ride.NumberOfPassengers, &ride.NumberOfLuggage, &ride.AdditionalNotes)
+	ride, err := r.scanBookRide(r.db.QueryRow(ctx, query, id))
 	if err != nil {
 		if err == pgx.ErrNoRows {
 			return nil, pgx.ErrNoRows
@@ -194,47 +175,85 @@ func (r *BookingRepository) UpdateBookRide(ctx context.Context, ride *data.BookR
 	if ride.RideType != "hourly" && ride.RideType != "per_ride" {
 		return fmt.Errorf("invalid rideType: must be 'hourly' or 'per_ride', got %s", ride.RideType)
 	}
+	sealed, err := r.sealBookRide(ride)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt ride booking: %w", err)
+	}
+	distance, duration := routeColumns(ride.RouteEstimate)
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+	// The assigned vehicle, if any, must still fit the booking.
+	locked, err := lockBookRide(ctx, tx, ride.ID)
+	if err != nil {
+		return err
+	}
+	if locked.vehicle != nil {
+		if err := locked.vehicle.CheckCapacity(ride.NumberOfPassengers, ride.NumberOfLuggage); err != nil {
+			return err
+		}
+	}
+
 	query := `
         UPDATE book_rides SET 
             your_name = $2, email = $3, phone_number = $4, ride_type = $5, 
             pickup_location = $6, dropoff_location = $7, date = $8, time = $9, 
-            number_of_passengers = $10, number_of_luggage = $11, additional_notes = $12
-        WHERE id = $1`
-	result, err := r.db.Exec(ctx, query,
-		ride.ID,
-		ride.YourName,
-		ride.Email,
-		ride.PhoneNumber,
-		ride.RideType,
-		ride.PickupLocation,
-		ride.DropoffLocation,
-		ride.Date,
-		ride.Time,
-		ride.NumberOfPassengers,
-		ride.NumberOfLuggage,
-		ride.AdditionalNotes)
+            number_of_passengers = $10, number_of_luggage = $11, additional_notes = $12,
+            pii_key_id = $13, pii_data_key = $14, email_index = $15,
+            pickup_details = $16, dropoff_details = $17, geocode_status = NULLIF($18, ''),
+            zone_id = $19, route_distance_meters = $20, route_duration_seconds = $21,
+            hours = $22, occupied = CASE WHEN status = 'cancelled' THEN NULL ELSE $23 END, preferences = $24
+        WHERE id = $1 AND erased_at IS NULL`
+	result, err := tx.Exec(ctx, query,
+		sealed.ID,
+		sealed.YourName,
+		sealed.Email,
+		sealed.PhoneNumber,
+		sealed.RideType,
+		sealed.PickupLocation.Address,
+		sealed.DropoffLocation.Address,
+		sealed.Date,
+		sealed.Time,
+		sealed.NumberOfPassengers,
+		sealed.NumberOfLuggage,
+		sealed.AdditionalNotes,
+		sealed.env.KeyID,
+		sealed.env.WrappedKey,
+		r.pii.BlindIndex(ride.Email),
+		sealed.pickupDetails,
+		sealed.dropoffDetails,
+		sealed.GeocodeStatus,
+		sealed.ZoneID,
+		distance,
+		duration,
+		sealed.Hours,
+		occupiedRange(ride.Occupied),
+		ride.Preferences)
 	if err != nil {
+		// The assigned driver, if any, must still be free at the new time.
+		if isExclusionViolation(err) && locked.driverID != nil && ride.Occupied != nil {
+			return r.scheduleConflict(ctx, *locked.driverID, ride.ID, *ride.Occupied)
+		}
 		return fmt.Errorf("failed to update ride booking: %w", err)
 	}
 	rowsAffected := result.RowsAffected()
 	if rowsAffected == 0 {
 		return pgx.ErrNoRows
 	}
-	// Placeholder for notification
-	fmt.Printf("Update notification sent to email: %s, phone: %s\n", ride.Email, ride.PhoneNumber)
+	// An offer made for the old details no longer stands.
+	if err := withdrawDispatchOffers(ctx, tx, ride.ID); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit ride booking update: %w", err)
+	}
 	return nil
 }
 
 func (r *BookingRepository) DeleteBookRide(ctx context.Context, id int64) error {
-	// Fetch ride details before deletion
-	ride, err := r.GetBookRideByID(ctx, id)
-	if err != nil {
-		if err == pgx.ErrNoRows {
-			return pgx.ErrNoRows
-		}
-		return fmt.Errorf("failed to fetch ride for notification: %w", err)
-	}
-
 	query := `DELETE FROM book_rides WHERE id = $1`
 	result, err := r.db.Exec(ctx, query, id)
 	if err != nil {
@@ -245,20 +264,17 @@ func (r *BookingRepository) DeleteBookRide(ctx context.Context, id int64) error
 	if rowsAffected == 0 {
 		return pgx.ErrNoRows
 	}
-	// Notification placeholder
-	fmt.Printf("Delete notification sent to email: %s, phone: %s\n", ride.Email, ride.PhoneNumber)
 	return nil
 }
 
 func (r *BookingRepository) GetUserByCredentials(ctx context.Context, username, password string) (*data.User, error) {
-	query := `
-        SELECT id, username, password, role, created_at
-        FROM users WHERE username = $1`
-	user := &data.User{}
-	err := r.db.QueryRow(ctx, query, username).Scan(
-		&user.ID, &user.Username, &user.Password, &user.Role, &user.CreatedAt)
+	query := `SELECT ` + userColumns + ` FROM users WHERE username = $1 AND disabled_at IS NULL`
+	user, err := scanUser(r.db.QueryRow(ctx, query, username))
 	if err != nil {
 		if err == pgx.ErrNoRows {
+			// Spend the same bcrypt time as for a real user so response
+			// timing does not reveal which usernames exist.
+			bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(password))
 			return nil, pgx.ErrNoRows
 		}
 		return nil, fmt.Errorf("failed to get user: %w", err)
@@ -268,3 +284,18 @@ func (r *BookingRepository) GetUserByCredentials(ctx context.Context, username,
 	}
 	return user, nil
 }
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
+func isForeignKeyViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23503"
+}
+
+func isExclusionViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23P01"
+}
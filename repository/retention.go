@@ -0,0 +1,90 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// The methods below implement retention.Store. With dryRun set they only
+// count the rows they would change.
+
+// AnonymiseBookRidesBefore erases the PII of bookings whose ride date is
+// before date (YYYY-MM-DD) like EraseRiderData, keeping the rows for
+// financial records. Bookings with a free-form date from the legacy rider
+// API are aged by when they were made instead.
+func (r *BookingRepository) AnonymiseBookRidesBefore(ctx context.Context, date string, dryRun bool) (int64, error) {
+	where := `CASE WHEN date ~ '^\d{4}-\d{2}-\d{2}$' THEN date < $1 ELSE created_at < $1::date END`
+	var n int64
+	var err error
+	if dryRun {
+		n, err = r.purge(ctx, true, "", "book_rides", where+` AND erased_at IS NULL`, date)
+	} else {
+		n, err = r.eraseBookRides(ctx, where, date)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to anonymise book rides: %w", err)
+	}
+	return n, nil
+}
+
+// DeleteLoginAuditBefore deletes login audit records created before t.
+func (r *BookingRepository) DeleteLoginAuditBefore(ctx context.Context, t time.Time, dryRun bool) (int64, error) {
+	n, err := r.purge(ctx, dryRun, `DELETE FROM login_audit`, "login_audit", `created_at < $1`, t)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete login audit records: %w", err)
+	}
+	return n, nil
+}
+
+// DeleteExpiredTokens deletes refresh tokens and access token revocations
+// past their expiry; neither can be presented successfully any more.
+func (r *BookingRepository) DeleteExpiredTokens(ctx context.Context, dryRun bool) (int64, error) {
+	refresh, err := r.purge(ctx, dryRun, `DELETE FROM refresh_tokens`, "refresh_tokens", `expires_at < CURRENT_TIMESTAMP`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired refresh tokens: %w", err)
+	}
+	revoked, err := r.purge(ctx, dryRun, `DELETE FROM revoked_access_tokens`, "revoked_access_tokens", `expires_at < CURRENT_TIMESTAMP`)
+	if err != nil {
+		return refresh, fmt.Errorf("failed to delete expired access token revocations: %w", err)
+	}
+	return refresh + revoked, nil
+}
+
+// DeleteBookingEventsBefore deletes booking events created before t.
+func (r *BookingRepository) DeleteBookingEventsBefore(ctx context.Context, t time.Time, dryRun bool) (int64, error) {
+	n, err := r.purge(ctx, dryRun, `DELETE FROM booking_events`, "booking_events", `created_at < $1`, t)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete booking events: %w", err)
+	}
+	return n, nil
+}
+
+// DeleteLocationsBefore deletes the positions recorded before t, both in
+// trails and as the latest location of a booking.
+func (r *BookingRepository) DeleteLocationsBefore(ctx context.Context, t time.Time, dryRun bool) (int64, error) {
+	trail, err := r.purge(ctx, dryRun, `DELETE FROM booking_location_trail`, "booking_location_trail", `recorded_at < $1`, t)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete location trails: %w", err)
+	}
+	latest, err := r.purge(ctx, dryRun, `DELETE FROM booking_locations`, "booking_locations", `recorded_at < $1`, t)
+	if err != nil {
+		return trail, fmt.Errorf("failed to delete locations: %w", err)
+	}
+	return trail + latest, nil
+}
+
+// purge runs stmt (a DELETE or UPDATE of table) restricted by where, or in a
+// dry run counts the rows of table matching where.
+func (r *BookingRepository) purge(ctx context.Context, dryRun bool, stmt, table, where string, args ...interface{}) (int64, error) {
+	if dryRun {
+		var n int64
+		err := r.db.QueryRow(ctx, `SELECT count(*) FROM `+table+` WHERE `+where, args...).Scan(&n)
+		return n, err
+	}
+	result, err := r.db.Exec(ctx, stmt+` WHERE `+where, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
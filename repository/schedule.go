@@ -0,0 +1,223 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"luxsuv-backend/data"
+	"time"
+)
+
+// ScheduleConflictError is returned when a driver would hold two bookings
+// whose occupied intervals overlap.
+type ScheduleConflictError struct {
+	// BookingID and Occupied describe the clashing booking; BookingID is 0
+	// if it could not be identified, e.g. because it is not yet committed.
+	BookingID int64
+	Occupied  data.TimeRange
+}
+
+func (e *ScheduleConflictError) Error() string {
+	if e.BookingID == 0 {
+		return "driver already has a booking at that time"
+	}
+	return fmt.Sprintf("driver already has booking %d from %s to %s", e.BookingID,
+		e.Occupied.Start.Format(time.RFC3339), e.Occupied.End.Format(time.RFC3339))
+}
+
+// occupiedRange converts a booking's occupied interval to a tstzrange
+// parameter; nil is stored as NULL.
+func occupiedRange(r *data.TimeRange) any {
+	if r == nil {
+		return nil
+	}
+	return pgtype.Range[pgtype.Timestamptz]{
+		Lower:     pgtype.Timestamptz{Time: r.Start, Valid: true},
+		Upper:     pgtype.Timestamptz{Time: r.End, Valid: true},
+		LowerType: pgtype.Inclusive,
+		UpperType: pgtype.Exclusive,
+		Valid:     true,
+	}
+}
+
+// scheduleConflict finds the booking of driverID, other than bookingID, that
+// overlaps occupied.
+func (r *BookingRepository) scheduleConflict(ctx context.Context, driverID, bookingID int64, occupied data.TimeRange) error {
+	conflict := &ScheduleConflictError{}
+	err := r.db.QueryRow(ctx, `
+		SELECT id, lower(occupied), upper(occupied)
+		FROM book_rides
+		WHERE driver_id = $1 AND id <> $2 AND occupied && $3
+		ORDER BY lower(occupied)
+		LIMIT 1`, driverID, bookingID, occupiedRange(&occupied)).
+		Scan(&conflict.BookingID, &conflict.Occupied.Start, &conflict.Occupied.End)
+	if err != nil && err != pgx.ErrNoRows {
+		return fmt.Errorf("failed to find conflicting booking: %w", err)
+	}
+	return conflict
+}
+
+// AssignBookRideDriver assigns a driver to a booking, or with a nil driverID
+// unassigns it, and stores the booking's occupied interval, nil for an
+// unscheduled booking. Pending dispatch offers for the booking are withdrawn.
+// It returns a *ScheduleConflictError if the driver already has an
+// overlapping booking.
+func (r *BookingRepository) AssignBookRideDriver(ctx context.Context, bookingID int64, driverID *int64, occupied *data.TimeRange) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	result, err := tx.Exec(ctx, `UPDATE book_rides SET driver_id = $2, occupied = $3 WHERE id = $1 AND erased_at IS NULL AND status <> 'cancelled'`,
+		bookingID, driverID, occupiedRange(occupied))
+	if err != nil {
+		if isExclusionViolation(err) && driverID != nil && occupied != nil {
+			return r.scheduleConflict(ctx, *driverID, bookingID, *occupied)
+		}
+		return fmt.Errorf("failed to assign driver: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	if err := withdrawDispatchOffers(ctx, tx, bookingID); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit driver assignment: %w", err)
+	}
+	return nil
+}
+
+// GetDriverWorkingHours returns a driver's weekly working hours ordered by
+// weekday and start.
+func (r *BookingRepository) GetDriverWorkingHours(ctx context.Context, driverID int64) ([]data.WorkingHours, error) {
+	return driverWorkingHours(ctx, r.db, driverID)
+}
+
+// queryer is what driver schedules are read through: the pool, or a
+// transaction that has to see them consistently with its own changes.
+type queryer interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+func driverWorkingHours(ctx context.Context, q queryer, driverID int64) ([]data.WorkingHours, error) {
+	rows, err := q.Query(ctx, `
+		SELECT weekday, to_char(start_time, 'HH24:MI'), to_char(end_time, 'HH24:MI')
+		FROM driver_working_hours WHERE driver_id = $1
+		ORDER BY weekday, start_time`, driverID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get working hours: %w", err)
+	}
+	defer rows.Close()
+	hours := []data.WorkingHours{}
+	for rows.Next() {
+		var h data.WorkingHours
+		if err := rows.Scan(&h.Weekday, &h.Start, &h.End); err != nil {
+			return nil, fmt.Errorf("failed to scan working hours: %w", err)
+		}
+		hours = append(hours, h)
+	}
+	return hours, rows.Err()
+}
+
+// SetDriverWorkingHours replaces a driver's weekly working hours. It returns
+// pgx.ErrNoRows if driverID is not a driver.
+func (r *BookingRepository) SetDriverWorkingHours(ctx context.Context, driverID int64, hours []data.WorkingHours) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Locking the driver serialises concurrent replacements.
+	err = tx.QueryRow(ctx, `SELECT id FROM users WHERE id = $1 AND role = $2 FOR UPDATE`, driverID, data.RoleDriver).
+		Scan(&driverID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return pgx.ErrNoRows
+		}
+		return fmt.Errorf("failed to get driver: %w", err)
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM driver_working_hours WHERE driver_id = $1`, driverID); err != nil {
+		return fmt.Errorf("failed to clear working hours: %w", err)
+	}
+	for _, h := range hours {
+		_, err := tx.Exec(ctx, `
+			INSERT INTO driver_working_hours (driver_id, weekday, start_time, end_time)
+			VALUES ($1, $2, $3::time, $4::time)`, driverID, h.Weekday, h.Start, h.End)
+		if err != nil {
+			return fmt.Errorf("failed to store working hours: %w", err)
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit working hours: %w", err)
+	}
+	return nil
+}
+
+const timeOffColumns = `id, driver_id, lower(period), upper(period), reason, created_at`
+
+func scanTimeOff(row pgx.Row) (*data.TimeOff, error) {
+	off := &data.TimeOff{}
+	err := row.Scan(&off.ID, &off.DriverID, &off.Start, &off.End, &off.Reason, &off.CreatedAt)
+	return off, err
+}
+
+// ListDriverTimeOff returns a driver's time off ordered by start, only the
+// periods overlapping the given interval if it is not nil.
+func (r *BookingRepository) ListDriverTimeOff(ctx context.Context, driverID int64, overlapping *data.TimeRange) ([]data.TimeOff, error) {
+	return driverTimeOff(ctx, r.db, driverID, overlapping)
+}
+
+func driverTimeOff(ctx context.Context, q queryer, driverID int64, overlapping *data.TimeRange) ([]data.TimeOff, error) {
+	rows, err := q.Query(ctx, `
+		SELECT `+timeOffColumns+`
+		FROM driver_time_off
+		WHERE driver_id = $1 AND ($2::tstzrange IS NULL OR period && $2)
+		ORDER BY lower(period)`, driverID, occupiedRange(overlapping))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list time off: %w", err)
+	}
+	defer rows.Close()
+	timeOff := []data.TimeOff{}
+	for rows.Next() {
+		off, err := scanTimeOff(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan time off: %w", err)
+		}
+		timeOff = append(timeOff, *off)
+	}
+	return timeOff, rows.Err()
+}
+
+// CreateDriverTimeOff records time off for a driver. It returns pgx.ErrNoRows
+// if off.DriverID is not a driver. Bookings already assigned to the driver in
+// that period are left alone.
+func (r *BookingRepository) CreateDriverTimeOff(ctx context.Context, off *data.TimeOff) (*data.TimeOff, error) {
+	created, err := scanTimeOff(r.db.QueryRow(ctx, `
+		INSERT INTO driver_time_off (driver_id, period, reason)
+		SELECT $1, $2, $3
+		WHERE EXISTS (SELECT 1 FROM users WHERE id = $1 AND role = $4)
+		RETURNING `+timeOffColumns,
+		off.DriverID, occupiedRange(&data.TimeRange{Start: off.Start, End: off.End}), off.Reason, data.RoleDriver))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, pgx.ErrNoRows
+		}
+		return nil, fmt.Errorf("failed to create time off: %w", err)
+	}
+	return created, nil
+}
+
+func (r *BookingRepository) DeleteDriverTimeOff(ctx context.Context, driverID, id int64) error {
+	result, err := r.db.Exec(ctx, `DELETE FROM driver_time_off WHERE id = $1 AND driver_id = $2`, id, driverID)
+	if err != nil {
+		return fmt.Errorf("failed to delete time off: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package repository
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"luxsuv-backend/data"
+	"time"
+)
+
+var (
+	// ErrRefreshTokenInvalid is returned for unknown, expired or revoked refresh tokens.
+	ErrRefreshTokenInvalid = errors.New("invalid refresh token")
+	// ErrRefreshTokenReused is returned when an already rotated refresh token is
+	// presented again. The whole token family is revoked when this happens.
+	ErrRefreshTokenReused = errors.New("refresh token reuse detected")
+)
+
+const revokeUserRefreshTokensQuery = `
+        UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP
+        WHERE user_id = $1 AND revoked_at IS NULL`
+
+// hashToken returns the SHA-256 hex digest under which opaque tokens are
+// stored, so a database leak does not expose usable tokens.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateRefreshToken stores a new refresh token as the first member of familyID.
+func (r *BookingRepository) CreateRefreshToken(ctx context.Context, userID int64, familyID, token string, expiresAt time.Time) error {
+	query := `
+        INSERT INTO refresh_tokens (user_id, family_id, token_hash, expires_at)
+        VALUES ($1, $2, $3, $4)`
+	if _, err := r.db.Exec(ctx, query, userID, familyID, hashToken(token), expiresAt); err != nil {
+		return fmt.Errorf("failed to create refresh token: %w", err)
+	}
+	return nil
+}
+
+// RotateRefreshToken exchanges oldToken for newToken within the same family
+// and returns the token's user. Presenting a token that was already rotated
+// revokes the entire family and returns ErrRefreshTokenReused.
+func (r *BookingRepository) RotateRefreshToken(ctx context.Context, oldToken, newToken string, expiresAt time.Time) (*data.User, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var (
+		id, userID       int64
+		familyID         string
+		tokenExpiresAt   time.Time
+		usedAt, revokeAt *time.Time
+	)
+	query := `
+        SELECT id, user_id, family_id, expires_at, used_at, revoked_at
+        FROM refresh_tokens WHERE token_hash = $1 FOR UPDATE`
+	err = tx.QueryRow(ctx, query, hashToken(oldToken)).Scan(&id, &userID, &familyID, &tokenExpiresAt, &usedAt, &revokeAt)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrRefreshTokenInvalid
+		}
+		return nil, fmt.Errorf("failed to get refresh token: %w", err)
+	}
+
+	if usedAt != nil {
+		// A rotated token came back: someone holds a stolen copy. Revoke the
+		// family so neither party can continue.
+		if _, err := tx.Exec(ctx, `UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE family_id = $1 AND revoked_at IS NULL`, familyID); err != nil {
+			return nil, fmt.Errorf("failed to revoke token family: %w", err)
+		}
+		if err := tx.Commit(ctx); err != nil {
+			return nil, fmt.Errorf("failed to commit token family revocation: %w", err)
+		}
+		return nil, ErrRefreshTokenReused
+	}
+	if revokeAt != nil || time.Now().After(tokenExpiresAt) {
+		return nil, ErrRefreshTokenInvalid
+	}
+
+	user, err := scanUser(tx.QueryRow(ctx, `SELECT `+userColumns+` FROM users WHERE id = $1`, userID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	if user.DisabledAt != nil {
+		return nil, ErrRefreshTokenInvalid
+	}
+
+	if _, err := tx.Exec(ctx, `UPDATE refresh_tokens SET used_at = CURRENT_TIMESTAMP WHERE id = $1`, id); err != nil {
+		return nil, fmt.Errorf("failed to mark refresh token used: %w", err)
+	}
+	insert := `
+        INSERT INTO refresh_tokens (user_id, family_id, token_hash, expires_at)
+        VALUES ($1, $2, $3, $4)`
+	if _, err := tx.Exec(ctx, insert, userID, familyID, hashToken(newToken), expiresAt); err != nil {
+		return nil, fmt.Errorf("failed to create refresh token: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit refresh token rotation: %w", err)
+	}
+	return user, nil
+}
+
+// RevokeRefreshTokenFamily revokes the family that token belongs to, provided
+// it belongs to userID.
+func (r *BookingRepository) RevokeRefreshTokenFamily(ctx context.Context, userID int64, token string) error {
+	query := `
+        UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP
+        WHERE revoked_at IS NULL AND family_id = (
+            SELECT family_id FROM refresh_tokens WHERE token_hash = $1 AND user_id = $2
+        )`
+	if _, err := r.db.Exec(ctx, query, hashToken(token), userID); err != nil {
+		return fmt.Errorf("failed to revoke refresh token: %w", err)
+	}
+	return nil
+}
+
+// RevokeAccessToken denylists an access token's jti until it would expire anyway.
+func (r *BookingRepository) RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	query := `INSERT INTO revoked_access_tokens (jti, expires_at) VALUES ($1, $2) ON CONFLICT (jti) DO NOTHING`
+	if _, err := r.db.Exec(ctx, query, jti, expiresAt); err != nil {
+		return fmt.Errorf("failed to revoke access token: %w", err)
+	}
+	return nil
+}
+
+// AccessTokenState is what the auth middleware checks on every request.
+type AccessTokenState struct {
+	TokenVersion int
+	Disabled     bool
+	Revoked      bool
+}
+
+// GetAccessTokenState returns the current token version and disabled flag of
+// userID, and whether jti has been revoked. It returns pgx.ErrNoRows if the
+// user no longer exists.
+func (r *BookingRepository) GetAccessTokenState(ctx context.Context, userID int64, jti string) (*AccessTokenState, error) {
+	query := `
+        SELECT token_version,
+               disabled_at IS NOT NULL,
+               EXISTS (SELECT 1 FROM revoked_access_tokens WHERE jti = $2)
+        FROM users WHERE id = $1`
+	state := &AccessTokenState{}
+	err := r.db.QueryRow(ctx, query, userID, jti).Scan(&state.TokenVersion, &state.Disabled, &state.Revoked)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, pgx.ErrNoRows
+		}
+		return nil, fmt.Errorf("failed to get token state: %w", err)
+	}
+	return state, nil
+}
@@ -0,0 +1,138 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"luxsuv-backend/data"
+)
+
+const locationColumns = `lat, lng, accuracy_meters, heading, speed, recorded_at`
+
+// RecordLocationPings stores the positions the driver of a booking reported,
+// keeping the latest as the booking's location and the most recent
+// trailLength in its trail. Pings older than the stored location only go to
+// the trail. It returns pgx.ErrNoRows if the booking is not assigned to the
+// driver and data.ErrNotTracked if it is not en route or in progress, and
+// otherwise the booking's location afterwards.
+func (r *BookingRepository) RecordLocationPings(ctx context.Context, bookingID, driverID int64, pings []data.LocationPing, trailLength int) (*data.DriverLocation, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var status string
+	var assigned *int64
+	err = tx.QueryRow(ctx, `SELECT status, driver_id FROM book_rides WHERE id = $1 AND erased_at IS NULL FOR SHARE`, bookingID).
+		Scan(&status, &assigned)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, pgx.ErrNoRows
+		}
+		return nil, fmt.Errorf("failed to get ride booking: %w", err)
+	}
+	if assigned == nil || *assigned != driverID {
+		return nil, pgx.ErrNoRows
+	}
+	if !data.IsTracked(status) {
+		return nil, data.ErrNotTracked
+	}
+
+	latest := pings[0]
+	batch := &pgx.Batch{}
+	for _, p := range pings {
+		batch.Queue(`
+			INSERT INTO booking_location_trail (booking_id, driver_id, `+locationColumns+`)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+			bookingID, driverID, p.Lat, p.Lng, p.Accuracy, p.Heading, p.Speed, p.RecordedAt)
+		if p.RecordedAt.After(latest.RecordedAt) {
+			latest = p
+		}
+	}
+	batch.Queue(`
+		DELETE FROM booking_location_trail
+		WHERE id IN (SELECT id FROM booking_location_trail WHERE booking_id = $1
+		             ORDER BY recorded_at DESC, id DESC OFFSET $2)`, bookingID, trailLength)
+	batch.Queue(`
+		INSERT INTO booking_locations (booking_id, driver_id, `+locationColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (booking_id) DO UPDATE SET
+		    driver_id = EXCLUDED.driver_id, lat = EXCLUDED.lat, lng = EXCLUDED.lng,
+		    accuracy_meters = EXCLUDED.accuracy_meters, heading = EXCLUDED.heading, speed = EXCLUDED.speed,
+		    recorded_at = EXCLUDED.recorded_at, updated_at = CURRENT_TIMESTAMP
+		WHERE booking_locations.recorded_at < EXCLUDED.recorded_at
+		   OR booking_locations.driver_id <> EXCLUDED.driver_id`,
+		bookingID, driverID, latest.Lat, latest.Lng, latest.Accuracy, latest.Heading, latest.Speed, latest.RecordedAt)
+	if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+		return nil, fmt.Errorf("failed to record location: %w", err)
+	}
+
+	loc, err := scanDriverLocation(tx.QueryRow(ctx, `
+		SELECT booking_id, driver_id, `+locationColumns+` FROM booking_locations WHERE booking_id = $1`, bookingID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get location: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit location: %w", err)
+	}
+	return loc, nil
+}
+
+// GetLiveLocation returns the latest location of the driver of a booking
+// that is en route or in progress. It returns pgx.ErrNoRows if the booking
+// is unknown or has no location yet and data.ErrNotTracked if it is not en
+// route or in progress.
+func (r *BookingRepository) GetLiveLocation(ctx context.Context, bookingID int64) (*data.DriverLocation, error) {
+	var status string
+	err := r.db.QueryRow(ctx, `SELECT status FROM book_rides WHERE id = $1 AND erased_at IS NULL`, bookingID).Scan(&status)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, pgx.ErrNoRows
+		}
+		return nil, fmt.Errorf("failed to get ride booking: %w", err)
+	}
+	if !data.IsTracked(status) {
+		return nil, data.ErrNotTracked
+	}
+	// Only the current driver's position counts after a reassignment.
+	loc, err := scanDriverLocation(r.db.QueryRow(ctx, `
+		SELECT l.booking_id, l.driver_id, l.lat, l.lng, l.accuracy_meters, l.heading, l.speed, l.recorded_at
+		FROM booking_locations l JOIN book_rides b ON b.id = l.booking_id AND b.driver_id = l.driver_id
+		WHERE l.booking_id = $1`, bookingID))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, pgx.ErrNoRows
+		}
+		return nil, fmt.Errorf("failed to get location: %w", err)
+	}
+	return loc, nil
+}
+
+// ListLocationTrail returns the trail of a booking, oldest first.
+func (r *BookingRepository) ListLocationTrail(ctx context.Context, bookingID int64) ([]data.LocationPing, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT `+locationColumns+`
+		FROM booking_location_trail
+		WHERE booking_id = $1
+		ORDER BY recorded_at, id`, bookingID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list location trail: %w", err)
+	}
+	defer rows.Close()
+	trail := []data.LocationPing{}
+	for rows.Next() {
+		var p data.LocationPing
+		if err := rows.Scan(&p.Lat, &p.Lng, &p.Accuracy, &p.Heading, &p.Speed, &p.RecordedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan location: %w", err)
+		}
+		trail = append(trail, p)
+	}
+	return trail, rows.Err()
+}
+
+func scanDriverLocation(row pgx.Row) (*data.DriverLocation, error) {
+	l := &data.DriverLocation{}
+	err := row.Scan(&l.BookingID, &l.DriverID, &l.Lat, &l.Lng, &l.Accuracy, &l.Heading, &l.Speed, &l.RecordedAt)
+	return l, err
+}
@@ -0,0 +1,105 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"luxsuv-backend/data"
+)
+
+const tripColumns = `t.arrived_at, t.started_at, t.ended_at, t.start_odometer, t.end_odometer, t.quote, t.fare`
+
+// recordTripProgress records on the trip of a booking when it reached
+// status, with the odometer reading when the ride starts or ends.
+func recordTripProgress(ctx context.Context, tx pgx.Tx, id int64, status string, odometer *float64) error {
+	var query string
+	switch status {
+	case data.BookingArrived:
+		query = `
+			INSERT INTO booking_trips (booking_id, arrived_at) VALUES ($1, CURRENT_TIMESTAMP)
+			ON CONFLICT (booking_id) DO UPDATE SET arrived_at = EXCLUDED.arrived_at, updated_at = CURRENT_TIMESTAMP`
+	case data.BookingInProgress:
+		query = `
+			INSERT INTO booking_trips (booking_id, started_at, start_odometer) VALUES ($1, CURRENT_TIMESTAMP, $2)
+			ON CONFLICT (booking_id) DO UPDATE SET started_at = EXCLUDED.started_at,
+			    start_odometer = EXCLUDED.start_odometer, updated_at = CURRENT_TIMESTAMP`
+	case data.BookingCompleted:
+		if odometer != nil {
+			var start *float64
+			err := tx.QueryRow(ctx, `SELECT start_odometer FROM booking_trips WHERE booking_id = $1`, id).Scan(&start)
+			if err != nil && err != pgx.ErrNoRows {
+				return fmt.Errorf("failed to get trip: %w", err)
+			}
+			if start != nil && *odometer < *start {
+				return fmt.Errorf("%w: %v is below the start reading %v", data.ErrInvalidOdometer, *odometer, *start)
+			}
+		}
+		query = `
+			INSERT INTO booking_trips (booking_id, ended_at, end_odometer) VALUES ($1, CURRENT_TIMESTAMP, $2)
+			ON CONFLICT (booking_id) DO UPDATE SET ended_at = EXCLUDED.ended_at,
+			    end_odometer = EXCLUDED.end_odometer, updated_at = CURRENT_TIMESTAMP`
+	default:
+		return nil
+	}
+	args := []any{id}
+	if status != data.BookingArrived {
+		args = append(args, odometer)
+	}
+	if _, err := tx.Exec(ctx, query, args...); err != nil {
+		return fmt.Errorf("failed to record trip: %w", err)
+	}
+	return nil
+}
+
+// GetTrip returns the trip of a booking; its fields are unset until the
+// driver records them. It returns pgx.ErrNoRows if the booking is unknown.
+func (r *BookingRepository) GetTrip(ctx context.Context, bookingID int64) (*data.Trip, error) {
+	t := &data.Trip{BookingID: bookingID}
+	err := r.db.QueryRow(ctx, `
+		SELECT `+tripColumns+`
+		FROM book_rides b LEFT JOIN booking_trips t ON t.booking_id = b.id
+		WHERE b.id = $1 AND b.erased_at IS NULL`, bookingID).
+		Scan(&t.ArrivedAt, &t.StartedAt, &t.EndedAt, &t.StartOdometer, &t.EndOdometer, &t.Quote, &t.Fare)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, pgx.ErrNoRows
+		}
+		return nil, fmt.Errorf("failed to get trip: %w", err)
+	}
+	return t, nil
+}
+
+// UpdateTrip replaces the recorded times and odometer readings of a
+// booking's trip, for corrections, leaving its fare alone. It returns
+// pgx.ErrNoRows if the booking is unknown.
+func (r *BookingRepository) UpdateTrip(ctx context.Context, trip *data.Trip) error {
+	_, err := r.db.Exec(ctx, `
+		INSERT INTO booking_trips (booking_id, arrived_at, started_at, ended_at, start_odometer, end_odometer)
+		SELECT id, $2, $3, $4, $5, $6 FROM book_rides WHERE id = $1 AND erased_at IS NULL
+		ON CONFLICT (booking_id) DO UPDATE SET
+		    arrived_at = EXCLUDED.arrived_at, started_at = EXCLUDED.started_at, ended_at = EXCLUDED.ended_at,
+		    start_odometer = EXCLUDED.start_odometer, end_odometer = EXCLUDED.end_odometer,
+		    updated_at = CURRENT_TIMESTAMP`,
+		trip.BookingID, trip.ArrivedAt, trip.StartedAt, trip.EndedAt, trip.StartOdometer, trip.EndOdometer)
+	if err != nil {
+		return fmt.Errorf("failed to update trip: %w", err)
+	}
+	if _, err := r.GetTrip(ctx, trip.BookingID); err != nil {
+		return err
+	}
+	return nil
+}
+
+// SetTripFare stores the quote and final fare of a booking's trip.
+func (r *BookingRepository) SetTripFare(ctx context.Context, bookingID int64, quote, fare data.Fare) error {
+	tag, err := r.db.Exec(ctx, `
+		UPDATE booking_trips SET quote = $2, fare = $3, updated_at = CURRENT_TIMESTAMP WHERE booking_id = $1`,
+		bookingID, quote, fare)
+	if err != nil {
+		return fmt.Errorf("failed to set trip fare: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
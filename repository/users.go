@@ -0,0 +1,143 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"golang.org/x/crypto/bcrypt"
+	"luxsuv-backend/data"
+	"sync"
+)
+
+// ErrUsernameTaken is returned when creating a user whose username already exists.
+var ErrUsernameTaken = errors.New("username already exists")
+
+const userColumns = `id, username, password, role, created_at, disabled_at, token_version, COALESCE(mfa_secret, ''), mfa_enabled_at, default_vehicle_id`
+
+func scanUser(row pgx.Row) (*data.User, error) {
+	user := &data.User{}
+	err := row.Scan(&user.ID, &user.Username, &user.Password, &user.Role, &user.CreatedAt, &user.DisabledAt,
+		&user.TokenVersion, &user.MFASecret, &user.MFAEnabledAt, &user.DefaultVehicleID)
+	return user, err
+}
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     []byte
+)
+
+// dummyPasswordHash returns a bcrypt hash, at the cost used for real
+// passwords, to compare against when a username does not exist.
+func dummyPasswordHash() []byte {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = bcrypt.GenerateFromPassword([]byte("luxsuv-dummy-password"), bcrypt.DefaultCost)
+	})
+	return dummyHash
+}
+
+// HashPassword returns the bcrypt hash stored in users.password.
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+	return string(hash), nil
+}
+
+func (r *BookingRepository) CreateUser(ctx context.Context, username, password, role string) (*data.User, error) {
+	if role != data.RoleDriver && role != data.RoleAdmin {
+		return nil, fmt.Errorf("invalid role: must be '%s' or '%s', got %s", data.RoleDriver, data.RoleAdmin, role)
+	}
+	hash, err := HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+	query := `
+        INSERT INTO users (username, password, role)
+        VALUES ($1, $2, $3)
+        RETURNING ` + userColumns
+	user, err := scanUser(r.db.QueryRow(ctx, query, username, hash, role))
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrUsernameTaken
+		}
+		return nil, fmt.Errorf("failed to create user: %w", err)
+	}
+	return user, nil
+}
+
+func (r *BookingRepository) GetUserByID(ctx context.Context, id int64) (*data.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE id = $1`
+	user, err := scanUser(r.db.QueryRow(ctx, query, id))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, pgx.ErrNoRows
+		}
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	return user, nil
+}
+
+func (r *BookingRepository) ListUsersByRole(ctx context.Context, role string) ([]*data.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE role = $1 ORDER BY username`
+	rows, err := r.db.Query(ctx, query, role)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+	defer rows.Close()
+	var users []*data.User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan user: %w", err)
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// SetUserDisabled disables or re-enables a user with the given role. Disabling
+// a user also revokes all of their tokens, so it takes effect immediately.
+func (r *BookingRepository) SetUserDisabled(ctx context.Context, id int64, role string, disabled bool) error {
+	query := `UPDATE users SET disabled_at = NULL WHERE id = $1 AND role = $2`
+	if disabled {
+		query = `
+        UPDATE users SET disabled_at = COALESCE(disabled_at, CURRENT_TIMESTAMP), token_version = token_version + 1
+        WHERE id = $1 AND role = $2`
+	}
+	return r.updateUserAndRevoke(ctx, id, disabled, query, id, role)
+}
+
+// UpdateUserPassword sets a new password and revokes every outstanding token
+// issued under the old one.
+func (r *BookingRepository) UpdateUserPassword(ctx context.Context, id int64, password string) error {
+	hash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+	query := `UPDATE users SET password = $2, token_version = token_version + 1 WHERE id = $1`
+	return r.updateUserAndRevoke(ctx, id, true, query, id, hash)
+}
+
+func (r *BookingRepository) updateUserAndRevoke(ctx context.Context, id int64, revoke bool, query string, args ...interface{}) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	result, err := tx.Exec(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to update user: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	if revoke {
+		if _, err := tx.Exec(ctx, revokeUserRefreshTokensQuery, id); err != nil {
+			return fmt.Errorf("failed to revoke refresh tokens: %w", err)
+		}
+	}
+	return tx.Commit(ctx)
+}
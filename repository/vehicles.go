@@ -0,0 +1,200 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"luxsuv-backend/data"
+)
+
+var (
+	// ErrPlateTaken is returned when a vehicle's plate is already registered.
+	ErrPlateTaken = errors.New("plate already registered")
+	// ErrVehicleNotFound is returned when a referenced vehicle does not exist.
+	ErrVehicleNotFound = errors.New("vehicle not found")
+	// ErrVehicleUnavailable is returned when assigning a vehicle that is not active.
+	ErrVehicleUnavailable = errors.New("vehicle is not active")
+)
+
+const vehicleColumns = `id, make, model, plate, seats, luggage_capacity, amenities, status, created_at, updated_at`
+
+func scanVehicle(row pgx.Row) (*data.Vehicle, error) {
+	v := &data.Vehicle{}
+	err := row.Scan(&v.ID, &v.Make, &v.Model, &v.Plate, &v.Seats, &v.LuggageCapacity, &v.Amenities, &v.Status,
+		&v.CreatedAt, &v.UpdatedAt)
+	return v, err
+}
+
+func (r *BookingRepository) CreateVehicle(ctx context.Context, v *data.Vehicle) (*data.Vehicle, error) {
+	query := `
+        INSERT INTO vehicles (make, model, plate, seats, luggage_capacity, amenities, status)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+        RETURNING ` + vehicleColumns
+	created, err := scanVehicle(r.db.QueryRow(ctx, query, v.Make, v.Model, v.Plate, v.Seats, v.LuggageCapacity,
+		v.Amenities, v.Status))
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrPlateTaken
+		}
+		return nil, fmt.Errorf("failed to create vehicle: %w", err)
+	}
+	return created, nil
+}
+
+func (r *BookingRepository) GetVehicleByID(ctx context.Context, id int64) (*data.Vehicle, error) {
+	v, err := scanVehicle(r.db.QueryRow(ctx, `SELECT `+vehicleColumns+` FROM vehicles WHERE id = $1`, id))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, pgx.ErrNoRows
+		}
+		return nil, fmt.Errorf("failed to get vehicle: %w", err)
+	}
+	return v, nil
+}
+
+// ListVehicles returns the fleet ordered by ID, optionally only vehicles with
+// the given status.
+func (r *BookingRepository) ListVehicles(ctx context.Context, status string) ([]*data.Vehicle, error) {
+	query := `SELECT ` + vehicleColumns + ` FROM vehicles WHERE $1 = '' OR status = $1 ORDER BY id`
+	rows, err := r.db.Query(ctx, query, status)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list vehicles: %w", err)
+	}
+	defer rows.Close()
+	var vehicles []*data.Vehicle
+	for rows.Next() {
+		v, err := scanVehicle(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan vehicle: %w", err)
+		}
+		vehicles = append(vehicles, v)
+	}
+	return vehicles, rows.Err()
+}
+
+// UpdateVehicle replaces a vehicle's details. Bookings it is already assigned
+// to are not re-checked against a reduced capacity.
+func (r *BookingRepository) UpdateVehicle(ctx context.Context, v *data.Vehicle) (*data.Vehicle, error) {
+	query := `
+        UPDATE vehicles SET make = $2, model = $3, plate = $4, seats = $5, luggage_capacity = $6,
+            amenities = $7, status = $8, updated_at = CURRENT_TIMESTAMP
+        WHERE id = $1
+        RETURNING ` + vehicleColumns
+	updated, err := scanVehicle(r.db.QueryRow(ctx, query, v.ID, v.Make, v.Model, v.Plate, v.Seats, v.LuggageCapacity,
+		v.Amenities, v.Status))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, pgx.ErrNoRows
+		}
+		if isUniqueViolation(err) {
+			return nil, ErrPlateTaken
+		}
+		return nil, fmt.Errorf("failed to update vehicle: %w", err)
+	}
+	return updated, nil
+}
+
+// DeleteVehicle removes a vehicle; bookings and drivers referencing it are
+// unassigned. Retiring keeps the history instead.
+func (r *BookingRepository) DeleteVehicle(ctx context.Context, id int64) error {
+	result, err := r.db.Exec(ctx, `DELETE FROM vehicles WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete vehicle: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// SetDriverDefaultVehicle sets or, with a nil vehicleID, clears a driver's
+// default vehicle.
+func (r *BookingRepository) SetDriverDefaultVehicle(ctx context.Context, driverID int64, vehicleID *int64) error {
+	result, err := r.db.Exec(ctx, `UPDATE users SET default_vehicle_id = $2 WHERE id = $1 AND role = $3`,
+		driverID, vehicleID, data.RoleDriver)
+	if err != nil {
+		if isForeignKeyViolation(err) {
+			return ErrVehicleNotFound
+		}
+		return fmt.Errorf("failed to set default vehicle: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// AssignBookRideVehicle assigns an active vehicle to a booking, or with a nil
+// vehicleID unassigns it. The booking's passengers and luggage must fit; an
+// error wrapping data.ErrVehicleCapacity is returned otherwise.
+func (r *BookingRepository) AssignBookRideVehicle(ctx context.Context, bookingID int64, vehicleID *int64) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var passengers, luggage int
+	err = tx.QueryRow(ctx, `
+		SELECT number_of_passengers, number_of_luggage
+		FROM book_rides WHERE id = $1 AND erased_at IS NULL FOR UPDATE`, bookingID).Scan(&passengers, &luggage)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return pgx.ErrNoRows
+		}
+		return fmt.Errorf("failed to get ride booking: %w", err)
+	}
+	if vehicleID != nil {
+		v, err := scanVehicle(tx.QueryRow(ctx, `SELECT `+vehicleColumns+` FROM vehicles WHERE id = $1 FOR SHARE`, *vehicleID))
+		if err != nil {
+			if err == pgx.ErrNoRows {
+				return ErrVehicleNotFound
+			}
+			return fmt.Errorf("failed to get vehicle: %w", err)
+		}
+		if v.Status != data.VehicleActive {
+			return ErrVehicleUnavailable
+		}
+		if err := v.CheckCapacity(passengers, luggage); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(ctx, `UPDATE book_rides SET vehicle_id = $2 WHERE id = $1`, bookingID, vehicleID); err != nil {
+		return fmt.Errorf("failed to assign vehicle: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit vehicle assignment: %w", err)
+	}
+	return nil
+}
+
+// lockedBookRide is the assignment of a booking locked by lockBookRide.
+type lockedBookRide struct {
+	vehicle  *data.Vehicle
+	driverID *int64
+}
+
+// lockBookRide locks a booking and returns its assigned vehicle and driver.
+// It returns pgx.ErrNoRows for unknown or erased bookings.
+func lockBookRide(ctx context.Context, tx pgx.Tx, bookingID int64) (*lockedBookRide, error) {
+	locked := &lockedBookRide{}
+	var vehicleID *int64
+	err := tx.QueryRow(ctx, `SELECT vehicle_id, driver_id FROM book_rides WHERE id = $1 AND erased_at IS NULL FOR UPDATE`, bookingID).
+		Scan(&vehicleID, &locked.driverID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, pgx.ErrNoRows
+		}
+		return nil, fmt.Errorf("failed to get ride booking: %w", err)
+	}
+	if vehicleID == nil {
+		return locked, nil
+	}
+	locked.vehicle, err = scanVehicle(tx.QueryRow(ctx, `SELECT `+vehicleColumns+` FROM vehicles WHERE id = $1`, *vehicleID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get vehicle: %w", err)
+	}
+	return locked, nil
+}
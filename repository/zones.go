@@ -0,0 +1,89 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"luxsuv-backend/data"
+)
+
+// ErrZoneNameTaken is returned when a service zone name is already in use.
+var ErrZoneNameTaken = errors.New("zone name already exists")
+
+const zoneColumns = `id, name, boundary, active, created_at, updated_at`
+
+func scanZone(row pgx.Row) (*data.Zone, error) {
+	zone := &data.Zone{}
+	err := row.Scan(&zone.ID, &zone.Name, &zone.Boundary, &zone.Active, &zone.CreatedAt, &zone.UpdatedAt)
+	return zone, err
+}
+
+// CreateZone stores a service zone. The boundary must already be valid
+// GeoJSON.
+func (r *BookingRepository) CreateZone(ctx context.Context, zone *data.Zone) (*data.Zone, error) {
+	query := `
+        INSERT INTO service_zones (name, boundary, active)
+        VALUES ($1, $2, $3)
+        RETURNING ` + zoneColumns
+	created, err := scanZone(r.db.QueryRow(ctx, query, zone.Name, zone.Boundary, zone.Active))
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrZoneNameTaken
+		}
+		return nil, fmt.Errorf("failed to create zone: %w", err)
+	}
+	return created, nil
+}
+
+// ListZones returns the service zones ordered by ID, optionally only the
+// active ones.
+func (r *BookingRepository) ListZones(ctx context.Context, activeOnly bool) ([]*data.Zone, error) {
+	query := `SELECT ` + zoneColumns + ` FROM service_zones WHERE active OR NOT $1 ORDER BY id`
+	rows, err := r.db.Query(ctx, query, activeOnly)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list zones: %w", err)
+	}
+	defer rows.Close()
+	var zones []*data.Zone
+	for rows.Next() {
+		zone, err := scanZone(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan zone: %w", err)
+		}
+		zones = append(zones, zone)
+	}
+	return zones, rows.Err()
+}
+
+// UpdateZone replaces the name, boundary and active flag of a zone. Bookings
+// already tagged with the zone keep their tag.
+func (r *BookingRepository) UpdateZone(ctx context.Context, zone *data.Zone) (*data.Zone, error) {
+	query := `
+        UPDATE service_zones SET name = $2, boundary = $3, active = $4, updated_at = CURRENT_TIMESTAMP
+        WHERE id = $1
+        RETURNING ` + zoneColumns
+	updated, err := scanZone(r.db.QueryRow(ctx, query, zone.ID, zone.Name, zone.Boundary, zone.Active))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, pgx.ErrNoRows
+		}
+		if isUniqueViolation(err) {
+			return nil, ErrZoneNameTaken
+		}
+		return nil, fmt.Errorf("failed to update zone: %w", err)
+	}
+	return updated, nil
+}
+
+// DeleteZone removes a zone; bookings tagged with it are untagged.
+func (r *BookingRepository) DeleteZone(ctx context.Context, id int64) error {
+	result, err := r.db.Exec(ctx, `DELETE FROM service_zones WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete zone: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+// Package retention runs the background job that enforces the data retention
+// policy: rider PII on old bookings is anonymised, old login audit records,
+// expired tokens, expired geocode cache entries, old booking events and old
+// driver locations are deleted.
+//
+// Every run is logged, and the counts are published with expvar:
+//
+//	retention_purged_rows   rows purged per data class since start
+//	retention_dry_run_rows  rows the last dry run would have purged, per class
+//	retention_runs          completed runs
+//	retention_failures      runs in which any class failed
+//	retention_last_run      time of the last run
+package retention
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"luxsuv-backend/config"
+	"luxsuv-backend/logger"
+	"time"
+)
+
+// Data classes that are deleted as soon as they expire; they are not
+// configurable.
+const (
+	// ExpiredTokens are expired refresh tokens and access token revocations.
+	ExpiredTokens = "expired_tokens"
+	// ExpiredGeocodes are expired geocode cache entries.
+	ExpiredGeocodes = "expired_geocodes"
+	// ExpiredBookingEvents are booking events older than BookingEventsKeep.
+	ExpiredBookingEvents = "expired_booking_events"
+	// ExpiredLocations are driver locations older than LocationsKeep.
+	ExpiredLocations = "expired_locations"
+)
+
+const (
+	// BookingEventsKeep is how long booking events are kept for streams
+	// resuming after a disconnect.
+	BookingEventsKeep = 7 * 24 * time.Hour
+	// LocationsKeep is how long the driver locations recorded during rides
+	// are kept for settling disputes.
+	LocationsKeep = 30 * 24 * time.Hour
+)
+
+var (
+	purgedRows = expvar.NewMap("retention_purged_rows")
+	dryRunRows = expvar.NewMap("retention_dry_run_rows")
+	runs       = expvar.NewInt("retention_runs")
+	failures   = expvar.NewInt("retention_failures")
+	lastRun    = expvar.NewString("retention_last_run")
+)
+
+// Store purges data. With dryRun set it only counts what it would purge.
+type Store interface {
+	// AnonymiseBookRidesBefore erases PII of bookings with a ride date
+	// (YYYY-MM-DD), or for a free-form date a creation date, before date,
+	// with their locations, trip times and odometer readings and charge
+	// receipts.
+	AnonymiseBookRidesBefore(ctx context.Context, date string, dryRun bool) (int64, error)
+	DeleteLoginAuditBefore(ctx context.Context, t time.Time, dryRun bool) (int64, error)
+	DeleteExpiredTokens(ctx context.Context, dryRun bool) (int64, error)
+	DeleteExpiredGeocodes(ctx context.Context, dryRun bool) (int64, error)
+	DeleteBookingEventsBefore(ctx context.Context, t time.Time, dryRun bool) (int64, error)
+	DeleteLocationsBefore(ctx context.Context, t time.Time, dryRun bool) (int64, error)
+}
+
+// Job applies the retention policy every interval.
+type Job struct {
+	store      Store
+	logger     *logger.Logger
+	interval   time.Duration
+	dryRun     bool
+	keepMonths map[string]int
+	now        func() time.Time
+}
+
+// New returns a Job for cfg, which must have been validated by config.Load.
+func New(store Store, cfg config.RetentionConfig, logger *logger.Logger) (*Job, error) {
+	interval, err := time.ParseDuration(cfg.Interval)
+	if err != nil {
+		return nil, fmt.Errorf("invalid retention interval: %w", err)
+	}
+	return &Job{
+		store:      store,
+		logger:     logger,
+		interval:   interval,
+		dryRun:     cfg.DryRun,
+		keepMonths: cfg.KeepMonths,
+		now:        time.Now,
+	}, nil
+}
+
+// Run applies the policy immediately and then every interval until ctx is
+// cancelled. It returns at once if the job is disabled.
+func (j *Job) Run(ctx context.Context) {
+	if j.interval == 0 {
+		j.logger.Info("Retention job disabled")
+		return
+	}
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+	for {
+		j.RunOnce(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce applies the policy to every data class and returns the number of
+// rows purged (or, in a dry run, that would be) per class. A failing class is
+// logged and does not stop the others.
+func (j *Job) RunOnce(ctx context.Context) map[string]int64 {
+	now := j.now().UTC()
+	counts := map[string]int64{}
+	failed := false
+	apply := func(class string, purge func() (int64, error)) {
+		n, err := purge()
+		if err != nil {
+			failed = true
+			j.logger.Error(fmt.Sprintf("Retention: failed to purge %s: %v", class, err))
+			return
+		}
+		counts[class] = n
+	}
+
+	if months := j.keepMonths[config.RetentionBookingPII]; months > 0 {
+		cutoff := now.AddDate(0, -months, 0).Format("2006-01-02")
+		apply(config.RetentionBookingPII, func() (int64, error) {
+			return j.store.AnonymiseBookRidesBefore(ctx, cutoff, j.dryRun)
+		})
+	}
+	if months := j.keepMonths[config.RetentionLoginAudit]; months > 0 {
+		cutoff := now.AddDate(0, -months, 0)
+		apply(config.RetentionLoginAudit, func() (int64, error) {
+			return j.store.DeleteLoginAuditBefore(ctx, cutoff, j.dryRun)
+		})
+	}
+	apply(ExpiredTokens, func() (int64, error) {
+		return j.store.DeleteExpiredTokens(ctx, j.dryRun)
+	})
+	apply(ExpiredGeocodes, func() (int64, error) {
+		return j.store.DeleteExpiredGeocodes(ctx, j.dryRun)
+	})
+	apply(ExpiredBookingEvents, func() (int64, error) {
+		return j.store.DeleteBookingEventsBefore(ctx, now.Add(-BookingEventsKeep), j.dryRun)
+	})
+	apply(ExpiredLocations, func() (int64, error) {
+		return j.store.DeleteLocationsBefore(ctx, now.Add(-LocationsKeep), j.dryRun)
+	})
+
+	for class, n := range counts {
+		if j.dryRun {
+			dryRunRows.Add(class, 0)
+			dryRunRows.Get(class).(*expvar.Int).Set(n)
+			j.logger.Info(fmt.Sprintf("Retention (dry run): would purge %d %s rows", n, class))
+			continue
+		}
+		purgedRows.Add(class, n)
+		j.logger.Info(fmt.Sprintf("Retention: purged %d %s rows", n, class))
+	}
+	runs.Add(1)
+	if failed {
+		failures.Add(1)
+	}
+	lastRun.Set(now.Format(time.RFC3339))
+	return counts
+}
@@ -0,0 +1,128 @@
+package retention
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"luxsuv-backend/config"
+	"luxsuv-backend/logger"
+	"testing"
+	"time"
+)
+
+type fakeStore struct {
+	bookRidesBefore string
+	auditBefore     time.Time
+	eventsBefore    time.Time
+	locationsBefore time.Time
+	dryRun          bool
+	auditErr        error
+}
+
+func (f *fakeStore) AnonymiseBookRidesBefore(_ context.Context, date string, dryRun bool) (int64, error) {
+	f.bookRidesBefore, f.dryRun = date, dryRun
+	return 3, nil
+}
+
+func (f *fakeStore) DeleteLoginAuditBefore(_ context.Context, t time.Time, dryRun bool) (int64, error) {
+	f.auditBefore = t
+	return 5, f.auditErr
+}
+
+func (f *fakeStore) DeleteExpiredTokens(_ context.Context, dryRun bool) (int64, error) {
+	f.dryRun = dryRun
+	return 7, nil
+}
+
+func (f *fakeStore) DeleteExpiredGeocodes(_ context.Context, dryRun bool) (int64, error) {
+	return 2, nil
+}
+
+func (f *fakeStore) DeleteBookingEventsBefore(_ context.Context, t time.Time, dryRun bool) (int64, error) {
+	f.eventsBefore = t
+	return 11, nil
+}
+
+func (f *fakeStore) DeleteLocationsBefore(_ context.Context, t time.Time, dryRun bool) (int64, error) {
+	f.locationsBefore = t
+	return 13, nil
+}
+
+func mapValue(m *expvar.Map, key string) int64 {
+	if v, ok := m.Get(key).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+func newTestJob(t *testing.T, store Store, cfg config.RetentionConfig) *Job {
+	t.Helper()
+	job, err := New(store, cfg, logger.NewLogger())
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	job.now = func() time.Time { return time.Date(2026, time.March, 31, 12, 0, 0, 0, time.UTC) }
+	return job
+}
+
+func TestRunOnceAppliesPolicyPerClass(t *testing.T) {
+	store := &fakeStore{}
+	cfg := config.Defaults().Retention
+	job := newTestJob(t, store, cfg)
+
+	before := mapValue(purgedRows, config.RetentionBookingPII)
+	counts := job.RunOnce(context.Background())
+
+	if store.bookRidesBefore != "2024-03-31" {
+		t.Errorf("Expected bookings before 2024-03-31 to be anonymised, got %s", store.bookRidesBefore)
+	}
+	if want := time.Date(2025, time.March, 31, 12, 0, 0, 0, time.UTC); !store.auditBefore.Equal(want) {
+		t.Errorf("Expected audit cutoff %v, got %v", want, store.auditBefore)
+	}
+	if want := time.Date(2026, time.March, 24, 12, 0, 0, 0, time.UTC); !store.eventsBefore.Equal(want) {
+		t.Errorf("Expected booking event cutoff %v, got %v", want, store.eventsBefore)
+	}
+	if want := time.Date(2026, time.March, 1, 12, 0, 0, 0, time.UTC); !store.locationsBefore.Equal(want) {
+		t.Errorf("Expected location cutoff %v, got %v", want, store.locationsBefore)
+	}
+	if counts[config.RetentionBookingPII] != 3 || counts[config.RetentionLoginAudit] != 5 || counts[ExpiredTokens] != 7 ||
+		counts[ExpiredGeocodes] != 2 || counts[ExpiredBookingEvents] != 11 || counts[ExpiredLocations] != 13 {
+		t.Errorf("Unexpected counts: %v", counts)
+	}
+	if store.dryRun {
+		t.Errorf("Expected a real run")
+	}
+	if got := mapValue(purgedRows, config.RetentionBookingPII); got != before+3 {
+		t.Errorf("Expected purged rows metric to grow by 3, got %d -> %d", before, got)
+	}
+}
+
+func TestRunOnceDryRunAndFailures(t *testing.T) {
+	store := &fakeStore{auditErr: errors.New("boom")}
+	cfg := config.Defaults().Retention
+	cfg.DryRun = true
+	cfg.KeepMonths = map[string]int{config.RetentionBookingPII: 0, config.RetentionLoginAudit: 6}
+	job := newTestJob(t, store, cfg)
+
+	failuresBefore := failures.Value()
+	counts := job.RunOnce(context.Background())
+
+	if store.bookRidesBefore != "" {
+		t.Errorf("Expected booking PII to be kept forever when months is 0")
+	}
+	if _, ok := counts[config.RetentionLoginAudit]; ok {
+		t.Errorf("Expected failed class to be missing from counts")
+	}
+	if counts[ExpiredTokens] != 7 {
+		t.Errorf("Expected other classes to run after a failure, got %v", counts)
+	}
+	if !store.dryRun {
+		t.Errorf("Expected the store to be called in dry-run mode")
+	}
+	if got := mapValue(dryRunRows, ExpiredTokens); got != 7 {
+		t.Errorf("Expected dry run metric to be 7, got %d", got)
+	}
+	if failures.Value() != failuresBefore+1 {
+		t.Errorf("Expected failure to be counted")
+	}
+}
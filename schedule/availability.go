@@ -0,0 +1,131 @@
+package schedule
+
+import (
+	"fmt"
+	"luxsuv-backend/data"
+	"strings"
+	"time"
+)
+
+// Slot is a pickup time offered to riders.
+type Slot struct {
+	Time   string    `json:"time"` // HH:MM in the scheduling time zone
+	Pickup time.Time `json:"pickup"`
+}
+
+// SlotWindow returns the interval that driver schedules and unassigned
+// bookings must cover to compute the slots on date for a booking like ride:
+// the whole day plus the time a booking picked up at its end occupies.
+func (s *Scheduler) SlotWindow(date string, ride *data.BookRide) (data.TimeRange, error) {
+	day, err := s.day(date)
+	if err != nil {
+		return data.TimeRange{}, err
+	}
+	next := day.AddDate(0, 0, 1)
+	return data.TimeRange{Start: day, End: next.Add(s.tripDuration(ride) + s.turnaround)}, nil
+}
+
+// Slots returns the pickup times on date, every slot interval, that a
+// booking like ride could be made for; its Date and Time are ignored. Times
+// before now and times skipped by daylight saving are left out.
+func (s *Scheduler) Slots(date string, ride *data.BookRide, drivers []data.DriverSchedule, unassigned []data.TimeRange, now time.Time) ([]Slot, error) {
+	day, err := s.day(date)
+	if err != nil {
+		return nil, err
+	}
+	duration := s.tripDuration(ride)
+	slots := []Slot{}
+	for since := time.Duration(0); since < 24*time.Hour; since += s.slotInterval {
+		clock := fmt.Sprintf("%02d:%02d", int(since/time.Hour), int(since%time.Hour/time.Minute))
+		pickup := atClock(day, since)
+		if pickup.Format("15:04") != clock || pickup.Before(now) {
+			continue
+		}
+		trip := data.TimeRange{Start: pickup, End: pickup.Add(duration)}
+		if s.hasCapacity(trip, ride, drivers, unassigned) {
+			slots = append(slots, Slot{Time: clock, Pickup: pickup})
+		}
+	}
+	return slots, nil
+}
+
+// HasCapacity reports whether a booking can still be taken at its pickup
+// time. drivers and unassigned must cover the booking's occupied interval.
+func (s *Scheduler) HasCapacity(ride *data.BookRide, drivers []data.DriverSchedule, unassigned []data.TimeRange) (bool, error) {
+	trip, err := s.Trip(ride)
+	if err != nil {
+		return false, err
+	}
+	return s.hasCapacity(trip, ride, drivers, unassigned), nil
+}
+
+// CanTake reports whether driver d could take a booking: their default
+// vehicle fits it, the trip is within their working hours and outside their
+// time off, and it does not overlap their other bookings.
+func (s *Scheduler) CanTake(ride *data.BookRide, d data.DriverSchedule) (bool, error) {
+	trip, err := s.Trip(ride)
+	if err != nil {
+		return false, err
+	}
+	return s.driverFree(trip, data.TimeRange{Start: trip.Start, End: trip.End.Add(s.turnaround)}, ride, d), nil
+}
+
+// FleetConfigured reports whether any driver has a default vehicle. Until
+// one does no driver can be counted as free, so capacity is not enforced.
+func FleetConfigured(drivers []data.DriverSchedule) bool {
+	for _, d := range drivers {
+		if d.DefaultVehicleID != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// hasCapacity reports whether more drivers are free for a booking than there
+// are unassigned bookings overlapping it. Every overlapping unassigned
+// booking is counted against the free drivers, which errs on the side of
+// turning riders away rather than overbooking. Without a configured fleet
+// every booking has capacity.
+func (s *Scheduler) hasCapacity(trip data.TimeRange, ride *data.BookRide, drivers []data.DriverSchedule, unassigned []data.TimeRange) bool {
+	if !FleetConfigured(drivers) {
+		return true
+	}
+	occupied := data.TimeRange{Start: trip.Start, End: trip.End.Add(s.turnaround)}
+	free := 0
+	for _, d := range drivers {
+		if s.driverFree(trip, occupied, ride, d) {
+			free++
+		}
+	}
+	for _, u := range unassigned {
+		if u.Overlaps(occupied) {
+			free--
+		}
+	}
+	return free > 0
+}
+
+// driverFree reports whether driver d could take a booking: their default
+// vehicle fits it, the trip is within their working hours and outside their
+// time off, and occupied overlaps none of their bookings.
+func (s *Scheduler) driverFree(trip, occupied data.TimeRange, ride *data.BookRide, d data.DriverSchedule) bool {
+	if d.Vehicle == nil || d.Vehicle.Status != data.VehicleActive ||
+		d.Vehicle.CheckCapacity(ride.NumberOfPassengers, ride.NumberOfLuggage) != nil {
+		return false
+	}
+	for _, busy := range d.Busy {
+		if busy.Overlaps(occupied) {
+			return false
+		}
+	}
+	return s.CheckAvailability(trip, d.WorkingHours, d.TimeOff) == nil
+}
+
+// day parses a booking date as local midnight.
+func (s *Scheduler) day(date string) (time.Time, error) {
+	day, err := time.ParseInLocation(DateLayout, strings.TrimSpace(date), s.loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: date must be YYYY-MM-DD", ErrInvalidPickupTime)
+	}
+	return day, nil
+}
@@ -0,0 +1,110 @@
+package schedule
+
+import (
+	"luxsuv-backend/data"
+	"testing"
+	"time"
+)
+
+func TestSlots(t *testing.T) {
+	s := newTestScheduler(t)
+	la := s.Location()
+	at := func(day, hour, minute int) time.Time { return time.Date(2026, 3, day, hour, minute, 0, 0, la) }
+
+	vehicleIDs := []int64{1, 2}
+	// 2026-03-06 is a Friday. The first driver works the morning in a large
+	// car; the second is always working in a small one but has a booking.
+	drivers := []data.DriverSchedule{
+		{DriverID: 1, DefaultVehicleID: &vehicleIDs[0], Vehicle: &data.Vehicle{Seats: 6, LuggageCapacity: 4, Status: data.VehicleActive},
+			WorkingHours: []data.WorkingHours{{Weekday: 5, Start: "08:00", End: "12:00"}}},
+		{DriverID: 2, DefaultVehicleID: &vehicleIDs[1], Vehicle: &data.Vehicle{Seats: 3, LuggageCapacity: 2, Status: data.VehicleActive},
+			Busy: []data.TimeRange{{Start: at(6, 9, 0), End: at(6, 11, 30)}}},
+		{DriverID: 3}, // no vehicle
+	}
+	unassigned := []data.TimeRange{{Start: at(6, 8, 0), End: at(6, 9, 30)}}
+
+	open := func(passengers int, now time.Time) map[string]bool {
+		t.Helper()
+		ride := &data.BookRide{RideType: "per_ride", NumberOfPassengers: passengers}
+		slots, err := s.Slots("2026-03-06", ride, drivers, unassigned, now)
+		if err != nil {
+			t.Fatalf("Slots failed: %v", err)
+		}
+		times := map[string]bool{}
+		for _, slot := range slots {
+			times[slot.Time] = true
+		}
+		return times
+	}
+
+	small := open(2, at(6, 0, 0))
+	for clock, want := range map[string]bool{
+		"06:00": true,  // the second driver is free
+		"07:00": false, // the second driver is free but needed for the unassigned booking
+		"09:00": false, // the first driver is needed for the unassigned booking
+		"10:00": true,  // the first driver is free
+		"11:30": true,  // the second driver's booking has ended
+	} {
+		if small[clock] != want {
+			t.Errorf("2 passengers at %s: expected open=%v", clock, want)
+		}
+	}
+
+	large := open(5, at(6, 0, 0))
+	if large["06:00"] || !large["10:00"] {
+		t.Errorf("5 passengers: expected only the large car's hours, got %v", large)
+	}
+	if later := open(2, at(6, 10, 0)); later["06:00"] || !later["10:00"] {
+		t.Errorf("Expected times before now to be left out, got %v", later)
+	}
+
+	// Clocks skip 02:00 to 03:00 on 2026-03-08.
+	slots, err := s.Slots("2026-03-08", &data.BookRide{RideType: "per_ride", NumberOfPassengers: 1}, drivers, nil, at(1, 0, 0))
+	if err != nil {
+		t.Fatalf("Slots failed: %v", err)
+	}
+	if len(slots) != 46 {
+		t.Errorf("Expected 46 slots on the day clocks go forward, got %d", len(slots))
+	}
+	for _, slot := range slots {
+		if slot.Time == "02:00" || slot.Time == "02:30" {
+			t.Errorf("Unexpected slot %s", slot.Time)
+		}
+	}
+
+	if _, err := s.Slots("06/03/2026", &data.BookRide{RideType: "per_ride"}, drivers, nil, at(1, 0, 0)); err == nil {
+		t.Error("Expected error for invalid date")
+	}
+}
+
+func TestHasCapacity(t *testing.T) {
+	s := newTestScheduler(t)
+	la := s.Location()
+	vehicleID := int64(1)
+	drivers := []data.DriverSchedule{{DriverID: 1, DefaultVehicleID: &vehicleID, Vehicle: &data.Vehicle{Seats: 6, LuggageCapacity: 4, Status: data.VehicleActive}}}
+	unassigned := []data.TimeRange{{Start: time.Date(2026, 3, 6, 9, 0, 0, 0, la), End: time.Date(2026, 3, 6, 11, 30, 0, 0, la)}}
+
+	ride := &data.BookRide{RideType: "hourly", Date: "2026-03-06", Time: "07:00", NumberOfPassengers: 2}
+	if ok, err := s.HasCapacity(ride, drivers, unassigned); err != nil || ok {
+		t.Errorf("Expected a 2h ride at 07:00 to clash with the unassigned booking, got %v, %v", ok, err)
+	}
+	ride.Time = "11:30"
+	if ok, err := s.HasCapacity(ride, drivers, unassigned); err != nil || !ok {
+		t.Errorf("Expected capacity at 11:30, got %v, %v", ok, err)
+	}
+	drivers[0].Vehicle.Status = data.VehicleMaintenance
+	if ok, _ := s.HasCapacity(ride, drivers, unassigned); ok {
+		t.Error("Expected no capacity without an active vehicle")
+	}
+	if ok, err := s.HasCapacity(ride, []data.DriverSchedule{{DriverID: 1}}, unassigned); err != nil || !ok {
+		t.Errorf("Expected capacity before any driver has a vehicle, got %v, %v", ok, err)
+	}
+
+	window, err := s.SlotWindow("2026-03-06", &data.BookRide{RideType: "per_ride"})
+	if err != nil {
+		t.Fatalf("SlotWindow failed: %v", err)
+	}
+	if !window.Start.Equal(time.Date(2026, 3, 6, 0, 0, 0, 0, la)) || !window.End.Equal(time.Date(2026, 3, 7, 1, 30, 0, 0, la)) {
+		t.Errorf("Unexpected window %v", window)
+	}
+}
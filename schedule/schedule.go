@@ -0,0 +1,229 @@
+// Package schedule works out when bookings keep drivers busy and whether a
+// driver is available for a trip.
+//
+// Booking dates and times and driver working hours are wall-clock values in
+// a single configured time zone; everything else is an absolute time.
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"luxsuv-backend/config"
+	"luxsuv-backend/data"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalidPickupTime is returned for bookings whose date or time
+	// cannot be parsed.
+	ErrInvalidPickupTime = errors.New("invalid pickup date or time")
+	// ErrOutsideWorkingHours is returned for trips outside a driver's
+	// working hours.
+	ErrOutsideWorkingHours = errors.New("driver is not working at that time")
+	// ErrTimeOff is returned for trips during a driver's time off.
+	ErrTimeOff = errors.New("driver has time off at that time")
+)
+
+// DateLayout is the layout of booking dates.
+const DateLayout = "2006-01-02"
+
+// timeLayouts are the accepted layouts of booking times, tried in order
+// against the upper-cased input.
+var timeLayouts = []string{"15:04", "15:04:05", "3:04 PM", "3:04PM"}
+
+// Scheduler computes booking intervals under one configuration.
+type Scheduler struct {
+	loc          *time.Location
+	defaultRide  time.Duration
+	turnaround   time.Duration
+	minimumHours int
+	slotInterval time.Duration
+}
+
+// New returns a Scheduler for cfg, which must have been validated by
+// config.Load.
+func New(cfg config.SchedulingConfig) (*Scheduler, error) {
+	loc, err := time.LoadLocation(cfg.TimeZone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid scheduling time zone: %w", err)
+	}
+	defaultRide, err := time.ParseDuration(cfg.DefaultRideDuration)
+	if err != nil {
+		return nil, fmt.Errorf("invalid default ride duration: %w", err)
+	}
+	turnaround, err := time.ParseDuration(cfg.Turnaround)
+	if err != nil {
+		return nil, fmt.Errorf("invalid turnaround: %w", err)
+	}
+	slotInterval, err := time.ParseDuration(cfg.SlotInterval)
+	if err != nil {
+		return nil, fmt.Errorf("invalid slot interval: %w", err)
+	}
+	return &Scheduler{loc: loc, defaultRide: defaultRide, turnaround: turnaround, minimumHours: cfg.MinimumHours,
+		slotInterval: slotInterval}, nil
+}
+
+// Location returns the scheduling time zone.
+func (s *Scheduler) Location() *time.Location {
+	return s.loc
+}
+
+// MinimumHours returns the shortest hourly booking.
+func (s *Scheduler) MinimumHours() int {
+	return s.minimumHours
+}
+
+// Pickup returns the pickup time of a booking.
+func (s *Scheduler) Pickup(ride *data.BookRide) (time.Time, error) {
+	return ParsePickup(ride.Date, ride.Time, s.loc)
+}
+
+// ParsePickup parses a booking's date (YYYY-MM-DD) and time (HH:MM, with
+// optional seconds or AM/PM) as a wall-clock time in loc.
+func ParsePickup(date, clock string, loc *time.Location) (time.Time, error) {
+	day, err := time.ParseInLocation(DateLayout, strings.TrimSpace(date), loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: date must be YYYY-MM-DD", ErrInvalidPickupTime)
+	}
+	clock = strings.ToUpper(strings.TrimSpace(clock))
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, clock); err == nil {
+			return time.Date(day.Year(), day.Month(), day.Day(), t.Hour(), t.Minute(), t.Second(), 0, loc), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%w: time must be HH:MM", ErrInvalidPickupTime)
+}
+
+// Trip returns when a booking is on the road: from pickup for the booked
+// hours of an hourly ride, or the route estimate of a per_ride trip (the
+// default ride duration without one).
+func (s *Scheduler) Trip(ride *data.BookRide) (data.TimeRange, error) {
+	pickup, err := s.Pickup(ride)
+	if err != nil {
+		return data.TimeRange{}, err
+	}
+	return data.TimeRange{Start: pickup, End: pickup.Add(s.tripDuration(ride))}, nil
+}
+
+func (s *Scheduler) tripDuration(ride *data.BookRide) time.Duration {
+	if ride.RideType == "hourly" {
+		return time.Duration(max(ride.Hours, s.minimumHours)) * time.Hour
+	}
+	if ride.RouteEstimate != nil {
+		return time.Duration(ride.RouteEstimate.DurationSeconds) * time.Second
+	}
+	return s.defaultRide
+}
+
+// Occupied returns when a booking keeps its driver busy: the trip followed by
+// the turnaround.
+func (s *Scheduler) Occupied(ride *data.BookRide) (data.TimeRange, error) {
+	trip, err := s.Trip(ride)
+	if err != nil {
+		return data.TimeRange{}, err
+	}
+	trip.End = trip.End.Add(s.turnaround)
+	return trip, nil
+}
+
+// CheckAvailability returns ErrOutsideWorkingHours if trip is not covered by
+// the driver's working hours, or ErrTimeOff if it overlaps their time off. A
+// driver without any working hours is treated as always working.
+func (s *Scheduler) CheckAvailability(trip data.TimeRange, hours []data.WorkingHours, timeOff []data.TimeOff) error {
+	for _, off := range timeOff {
+		if trip.Overlaps(data.TimeRange{Start: off.Start, End: off.End}) {
+			return ErrTimeOff
+		}
+	}
+	if len(hours) == 0 {
+		return nil
+	}
+	windows, err := s.workingWindows(hours, trip)
+	if err != nil {
+		return err
+	}
+	for _, w := range windows {
+		if !w.Start.After(trip.Start) && !w.End.Before(trip.End) {
+			return nil
+		}
+	}
+	return ErrOutsideWorkingHours
+}
+
+// workingWindows expands weekly working hours into the absolute windows on
+// the days around r, merging windows that touch so shifts across midnight
+// are continuous.
+func (s *Scheduler) workingWindows(hours []data.WorkingHours, r data.TimeRange) ([]data.TimeRange, error) {
+	first := r.Start.In(s.loc)
+	day := time.Date(first.Year(), first.Month(), first.Day()-1, 0, 0, 0, 0, s.loc)
+	last := r.End.In(s.loc)
+	var windows []data.TimeRange
+	for ; !day.After(last); day = day.AddDate(0, 0, 1) {
+		for _, h := range hours {
+			if time.Weekday(h.Weekday) != day.Weekday() {
+				continue
+			}
+			start, err := ParseClock(h.Start)
+			if err != nil {
+				return nil, err
+			}
+			end, err := ParseClock(h.End)
+			if err != nil {
+				return nil, err
+			}
+			windows = append(windows, data.TimeRange{Start: atClock(day, start), End: atClock(day, end)})
+		}
+	}
+
+	sort.Slice(windows, func(i, j int) bool { return windows[i].Start.Before(windows[j].Start) })
+	var merged []data.TimeRange
+	for _, w := range windows {
+		if n := len(merged); n > 0 && !w.Start.After(merged[n-1].End) {
+			if w.End.After(merged[n-1].End) {
+				merged[n-1].End = w.End
+			}
+			continue
+		}
+		merged = append(merged, w)
+	}
+	return merged, nil
+}
+
+// ParseClock parses an HH:MM wall-clock time, 00:00 to 24:00, into the
+// duration since midnight.
+func ParseClock(clock string) (time.Duration, error) {
+	var h, m int
+	if _, err := fmt.Sscanf(clock, "%2d:%2d", &h, &m); err != nil || len(clock) != 5 ||
+		h < 0 || m < 0 || m > 59 || h*60+m > 24*60 {
+		return 0, fmt.Errorf("invalid time %q: must be HH:MM", clock)
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute, nil
+}
+
+// atClock returns the wall-clock time of day on day, which is local midnight.
+func atClock(day time.Time, sinceMidnight time.Duration) time.Time {
+	return time.Date(day.Year(), day.Month(), day.Day(), 0, int(sinceMidnight/time.Minute), 0, 0, day.Location())
+}
+
+// ValidateWorkingHours checks a driver's weekly working hours.
+func ValidateWorkingHours(hours []data.WorkingHours) error {
+	for _, h := range hours {
+		if h.Weekday < 0 || h.Weekday > 6 {
+			return fmt.Errorf("weekday must be between 0 (Sunday) and 6, got %d", h.Weekday)
+		}
+		start, err := ParseClock(h.Start)
+		if err != nil {
+			return err
+		}
+		end, err := ParseClock(h.End)
+		if err != nil {
+			return err
+		}
+		if start >= end {
+			return fmt.Errorf("working hours on weekday %d must end after they start", h.Weekday)
+		}
+	}
+	return nil
+}
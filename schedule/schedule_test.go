@@ -0,0 +1,111 @@
+package schedule
+
+import (
+	"errors"
+	"luxsuv-backend/config"
+	"luxsuv-backend/data"
+	"testing"
+	"time"
+)
+
+func newTestScheduler(t *testing.T) *Scheduler {
+	t.Helper()
+	s, err := New(config.Defaults().Scheduling)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	return s
+}
+
+func TestOccupied(t *testing.T) {
+	s := newTestScheduler(t)
+	la := s.Location()
+
+	perRide := &data.BookRide{RideType: "per_ride", Date: "2026-03-07", Time: "9:30 pm",
+		RouteEstimate: &data.RouteEstimate{DurationSeconds: 45 * 60}}
+	got, err := s.Occupied(perRide)
+	if err != nil {
+		t.Fatalf("Occupied failed: %v", err)
+	}
+	want := data.TimeRange{Start: time.Date(2026, 3, 7, 21, 30, 0, 0, la), End: time.Date(2026, 3, 7, 22, 45, 0, 0, la)}
+	if !got.Start.Equal(want.Start) || !got.End.Equal(want.End) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	// Without an estimate the default ride duration applies.
+	perRide.RouteEstimate = nil
+	if got, _ := s.Occupied(perRide); got.End.Sub(got.Start) != 90*time.Minute {
+		t.Errorf("Expected 1h30m, got %v", got.End.Sub(got.Start))
+	}
+
+	// Hourly rides are booked for at least the minimum hours.
+	hourly := &data.BookRide{RideType: "hourly", Date: "2026-03-07", Time: "10:00", Hours: 1}
+	if got, _ := s.Trip(hourly); got.End.Sub(got.Start) != 2*time.Hour {
+		t.Errorf("Expected minimum of 2h, got %v", got.End.Sub(got.Start))
+	}
+	hourly.Hours = 5
+	if got, _ := s.Trip(hourly); got.End.Sub(got.Start) != 5*time.Hour {
+		t.Errorf("Expected 5h, got %v", got.End.Sub(got.Start))
+	}
+
+	for _, bad := range []*data.BookRide{
+		{RideType: "per_ride", Date: "03/07/2026", Time: "10:00"},
+		{RideType: "per_ride", Date: "2026-03-07", Time: "noon"},
+	} {
+		if _, err := s.Occupied(bad); !errors.Is(err, ErrInvalidPickupTime) {
+			t.Errorf("Expected ErrInvalidPickupTime for %s %s, got %v", bad.Date, bad.Time, err)
+		}
+	}
+}
+
+func TestCheckAvailability(t *testing.T) {
+	s := newTestScheduler(t)
+	la := s.Location()
+	at := func(day, hour, minute int) time.Time { return time.Date(2026, 3, day, hour, minute, 0, 0, la) }
+
+	// 2026-03-06 is a Friday. Friday night shifts run into Saturday morning.
+	hours := []data.WorkingHours{
+		{Weekday: 6, Start: "00:00", End: "02:00"},
+		{Weekday: 5, Start: "18:00", End: "24:00"},
+		{Weekday: 5, Start: "08:00", End: "12:00"},
+	}
+	for _, tc := range []struct {
+		name string
+		trip data.TimeRange
+		want error
+	}{
+		{"morning", data.TimeRange{Start: at(6, 9, 0), End: at(6, 11, 0)}, nil},
+		{"across midnight", data.TimeRange{Start: at(6, 23, 0), End: at(7, 1, 30)}, nil},
+		{"spans a break", data.TimeRange{Start: at(6, 11, 0), End: at(6, 19, 0)}, ErrOutsideWorkingHours},
+		{"past shift end", data.TimeRange{Start: at(7, 1, 0), End: at(7, 3, 0)}, ErrOutsideWorkingHours},
+		{"day off", data.TimeRange{Start: at(8, 9, 0), End: at(8, 10, 0)}, ErrOutsideWorkingHours},
+	} {
+		if err := s.CheckAvailability(tc.trip, hours, nil); err != tc.want {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.want, err)
+		}
+	}
+
+	off := []data.TimeOff{{Start: at(6, 10, 0), End: at(6, 10, 30)}}
+	if err := s.CheckAvailability(data.TimeRange{Start: at(6, 9, 0), End: at(6, 11, 0)}, hours, off); err != ErrTimeOff {
+		t.Errorf("Expected ErrTimeOff, got %v", err)
+	}
+	if err := s.CheckAvailability(data.TimeRange{Start: at(8, 9, 0), End: at(8, 10, 0)}, nil, nil); err != nil {
+		t.Errorf("Expected drivers without working hours to be available, got %v", err)
+	}
+}
+
+func TestValidateWorkingHours(t *testing.T) {
+	if err := ValidateWorkingHours([]data.WorkingHours{{Weekday: 1, Start: "09:00", End: "24:00"}}); err != nil {
+		t.Errorf("Expected valid hours, got %v", err)
+	}
+	for _, bad := range []data.WorkingHours{
+		{Weekday: 7, Start: "09:00", End: "17:00"},
+		{Weekday: 1, Start: "9:00", End: "17:00"},
+		{Weekday: 1, Start: "17:00", End: "09:00"},
+		{Weekday: 1, Start: "09:00", End: "24:30"},
+	} {
+		if err := ValidateWorkingHours([]data.WorkingHours{bad}); err == nil {
+			t.Errorf("Expected error for %+v", bad)
+		}
+	}
+}
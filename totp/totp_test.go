@@ -0,0 +1,55 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// RFC 6238 appendix B test secret ("12345678901234567890"), truncated to the
+// six digits used here.
+var rfcSecret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestCodeMatchesRFC6238Vectors(t *testing.T) {
+	cases := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1111111111: "050471",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for unix, want := range cases {
+		got, err := Code(rfcSecret, Step(time.Unix(unix, 0)))
+		if err != nil {
+			t.Fatalf("Code failed: %v", err)
+		}
+		if got != want {
+			t.Errorf("At %d: expected %s, got %s", unix, want, got)
+		}
+	}
+}
+
+func TestValidateAllowsSkewAndReturnsStep(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	prev, _ := Code(rfcSecret, Step(now)-1)
+	step, ok := Validate(rfcSecret, prev, now)
+	if !ok || step != Step(now)-1 {
+		t.Errorf("Expected previous step code to validate with step %d, got %d %v", Step(now)-1, step, ok)
+	}
+
+	old, _ := Code(rfcSecret, Step(now)-3)
+	if _, ok := Validate(rfcSecret, old, now); ok {
+		t.Errorf("Expected code three steps old to be rejected")
+	}
+	if _, ok := Validate(rfcSecret, "12345", now); ok {
+		t.Errorf("Expected short code to be rejected")
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("LuxSUV", "driver1", "ABC")
+	if !strings.HasPrefix(uri, "otpauth://totp/LuxSUV:driver1?") || !strings.Contains(uri, "secret=ABC") {
+		t.Errorf("Unexpected URI: %s", uri)
+	}
+}
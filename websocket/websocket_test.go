@@ -0,0 +1,165 @@
+package websocket
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dial opens a WebSocket connection to srv the way a client would.
+func dial(t *testing.T, srv *httptest.Server) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(srv.URL, "http://"))
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	key := "dGhlIHNhbXBsZSBub25jZQ=="
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: test\r\nUpgrade: websocket\r\nConnection: keep-alive, Upgrade\r\n"+
+		"Sec-WebSocket-Key: "+key+"\r\nSec-WebSocket-Version: 13\r\n\r\n")
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatalf("ReadResponse failed: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != AcceptKey(key) {
+		t.Fatalf("Unexpected handshake response %d %v", resp.StatusCode, resp.Header)
+	}
+	return conn, br
+}
+
+// writeFrame sends a masked client frame.
+func writeFrame(conn net.Conn, fin bool, opcode int, payload []byte) {
+	b0 := byte(opcode)
+	if fin {
+		b0 |= 0x80
+	}
+	frame := []byte{b0}
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, 0x80|byte(n))
+	default:
+		frame = append(frame, 0x80|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	}
+	mask := [4]byte{1, 2, 3, 4}
+	frame = append(frame, mask[:]...)
+	for i, c := range payload {
+		frame = append(frame, c^mask[i%4])
+	}
+	conn.Write(frame)
+}
+
+// readFrame reads an unmasked server frame.
+func readFrame(t *testing.T, br *bufio.Reader) (int, []byte) {
+	t.Helper()
+	var head [2]byte
+	if _, err := io.ReadFull(br, head[:]); err != nil {
+		t.Fatalf("Failed to read frame: %v", err)
+	}
+	n := int(head[1] & 0x7f)
+	if n == 126 {
+		var ext [2]byte
+		io.ReadFull(br, ext[:])
+		n = int(binary.BigEndian.Uint16(ext[:]))
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(br, payload); err != nil {
+		t.Fatalf("Failed to read payload: %v", err)
+	}
+	return int(head[0] & 0x0f), payload
+}
+
+func TestAcceptKey(t *testing.T) {
+	// The example from RFC 6455, section 1.3.
+	if got := AcceptKey("dGhlIHNhbXBsZSBub25jZQ=="); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("Unexpected accept key %q", got)
+	}
+}
+
+func TestEchoAndClose(t *testing.T) {
+	done := make(chan error, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r, Options{MaxMessageSize: 300})
+		if err != nil {
+			done <- err
+			return
+		}
+		defer conn.Close()
+		for {
+			msgType, msg, err := conn.ReadMessage()
+			if err != nil {
+				done <- err
+				return
+			}
+			conn.WriteMessage(msgType, msg)
+		}
+	}))
+	defer srv.Close()
+	conn, br := dial(t, srv)
+
+	// A fragmented message with a ping in between.
+	writeFrame(conn, false, TextMessage, []byte("hello, "))
+	writeFrame(conn, true, PingMessage, []byte("are you there"))
+	writeFrame(conn, true, continuationFrame, []byte(strings.Repeat("w", 200)))
+	if op, payload := readFrame(t, br); op != PongMessage || string(payload) != "are you there" {
+		t.Errorf("Expected pong, got %d %q", op, payload)
+	}
+	if op, payload := readFrame(t, br); op != TextMessage || string(payload) != "hello, "+strings.Repeat("w", 200) {
+		t.Errorf("Expected the message echoed, got %d %q", op, payload)
+	}
+
+	writeFrame(conn, true, CloseMessage, binary.BigEndian.AppendUint16(nil, CloseGoingAway))
+	if op, payload := readFrame(t, br); op != CloseMessage || binary.BigEndian.Uint16(payload) != CloseGoingAway {
+		t.Errorf("Expected the close echoed, got %d %v", op, payload)
+	}
+	var closeErr *CloseError
+	if err := <-done; !errors.As(err, &closeErr) || closeErr.Code != CloseGoingAway {
+		t.Errorf("Expected CloseError with code %d, got %v", CloseGoingAway, err)
+	}
+}
+
+func TestProtocolViolations(t *testing.T) {
+	for name, tc := range map[string]struct {
+		send func(net.Conn)
+		code int
+	}{
+		"too big":            {func(c net.Conn) { writeFrame(c, true, TextMessage, make([]byte, 400)) }, CloseMessageTooBig},
+		"invalid UTF-8":      {func(c net.Conn) { writeFrame(c, true, TextMessage, []byte{0xff, 0xfe}) }, CloseInvalidPayload},
+		"stray continuation": {func(c net.Conn) { writeFrame(c, true, continuationFrame, []byte("x")) }, CloseProtocolError},
+		"unmasked frame":     {func(c net.Conn) { c.Write([]byte{0x81, 0x01, 'x'}) }, CloseProtocolError},
+	} {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			conn, err := Upgrade(w, r, Options{MaxMessageSize: 300})
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			conn.ReadMessage()
+		}))
+		conn, br := dial(t, srv)
+		tc.send(conn)
+		if op, payload := readFrame(t, br); op != CloseMessage || int(binary.BigEndian.Uint16(payload)) != tc.code {
+			t.Errorf("%s: expected close with code %d, got %d %q", name, tc.code, op, payload)
+		}
+		srv.Close()
+	}
+}
+
+func TestUpgradeRejectsPlainRequests(t *testing.T) {
+	w := httptest.NewRecorder()
+	if _, err := Upgrade(w, httptest.NewRequest(http.MethodGet, "/", nil), Options{}); err == nil {
+		t.Fatal("Expected error for a plain request")
+	}
+	if w.Code != http.StatusUpgradeRequired {
+		t.Errorf("Expected 426, got %d", w.Code)
+	}
+}